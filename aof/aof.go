@@ -0,0 +1,212 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"godis/pkg/logx"
+	"godis/resp/protocol"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type CmdLine = [][]byte
+
+// appendfsync policies
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
+// Persister appends the write commands executed by the server to the append only file.
+// The AOF is a directory holding a base file written by the last rewrite, the incr files
+// appended since and a manifest listing them, new commands go to the last incr file.
+type Persister struct {
+	mu       sync.Mutex
+	dir      string
+	filename string
+	manifest *manifest
+	file     *os.File
+	writer   *bufio.Writer
+	fsync    string
+	// 文件中最后一条SELECT选择的db，-1表示重启后还没有写入过SELECT
+	currentDB int
+
+	// 所有AOF文件的总大小，以及上次重写(或启动)时的大小，用于自动重写
+	size     int64
+	baseSize int64
+
+	closeChan chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewPersister opens the AOF in dir for appending, creating the directory, the manifest and
+// the first incr file when they don't exist. With always every append is flushed and fsynced before
+// it returns, with everysec the buffer is flushed and fsynced once per second, with no it's flushed
+// once per second and the kernel decides when the data hits the disk.
+func NewPersister(dir, filename string, fsync string) (*Persister, error) {
+	switch fsync {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+	default:
+		logx.L().Warnf("invalid appendfsync %s, using %s", fsync, FsyncEverySec)
+		fsync = FsyncEverySec
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := loadManifest(dir, filename)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = &manifest{}
+	}
+	var size int64
+	for _, name := range m.files() {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			size += info.Size()
+		}
+	}
+	if len(m.incrs) == 0 {
+		// 新建的AOF或者刚重写完还没有incr文件
+		seq := m.lastIncrSeq() + 1
+		m.incrs = append(m.incrs, manifestEntry{name: incrName(filename, seq), seq: seq, kind: incrFile})
+		if err := m.save(dir, filename); err != nil {
+			return nil, err
+		}
+	}
+	file, err := openIncr(dir, m.incrs[len(m.incrs)-1].name)
+	if err != nil {
+		return nil, err
+	}
+	p := &Persister{
+		dir:       dir,
+		filename:  filename,
+		manifest:  m,
+		file:      file,
+		writer:    bufio.NewWriter(file),
+		fsync:     fsync,
+		currentDB: -1,
+		size:      size,
+		baseSize:  size,
+		closeChan: make(chan struct{}),
+	}
+	if fsync != FsyncAlways {
+		p.wg.Add(1)
+		go p.flushLoop()
+	}
+	return p, nil
+}
+
+func openIncr(dir, name string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// Upgrade moves a single file AOF written before the multi part layout into dir as its base file.
+// It does nothing once dir has a manifest or when there is no legacy file.
+func Upgrade(legacy, dir, filename string) error {
+	m, err := loadManifest(dir, filename)
+	if err != nil || m != nil {
+		return err
+	}
+	file, err := os.Open(legacy)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// 开启RDB前导时写入的AOF以RDB开头
+	rdbPreamble, err := hasRdbPreamble(file)
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	suffix := aofSuffix
+	if rdbPreamble {
+		suffix = rdbSuffix
+	}
+	base := manifestEntry{name: baseName(filename, 1, suffix), seq: 1, kind: baseFile}
+	if err := os.Rename(legacy, filepath.Join(dir, base.name)); err != nil {
+		return err
+	}
+	logx.L().Infof("moved the append only file %s into %s as the base file", legacy, dir)
+	return (&manifest{base: &base}).save(dir, filename)
+}
+
+// Append writes cmdLines executed on db dbIndex as one batch, a SELECT is inserted when the db changes
+func (p *Persister) Append(dbIndex int, cmdLines ...CmdLine) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if dbIndex != p.currentDB {
+		p.write(CmdLine{[]byte("SELECT"), []byte(strconv.Itoa(dbIndex))})
+		p.currentDB = dbIndex
+	}
+	for _, cmdLine := range cmdLines {
+		p.write(cmdLine)
+	}
+	if p.fsync == FsyncAlways {
+		p.sync()
+	}
+}
+
+func (p *Persister) write(cmdLine CmdLine) {
+	n, err := p.writer.Write(protocol.NewMultiBulkReply(cmdLine).ToBytes())
+	if err != nil {
+		logx.L().Errorf("write aof failed: %v", err)
+	}
+	p.size += int64(n)
+}
+
+// sync flushes the buffer to the file and fsyncs it unless the policy is no, the caller must hold mu
+func (p *Persister) sync() {
+	if err := p.writer.Flush(); err != nil {
+		logx.L().Errorf("flush aof failed: %v", err)
+		return
+	}
+	if p.fsync == FsyncNo {
+		return
+	}
+	if err := p.file.Sync(); err != nil {
+		logx.L().Errorf("fsync aof failed: %v", err)
+	}
+}
+
+func (p *Persister) flushLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			p.sync()
+			p.mu.Unlock()
+		case <-p.closeChan:
+			return
+		}
+	}
+}
+
+// Close flushes everything buffered to the disk and closes the file
+func (p *Persister) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closeChan)
+		p.wg.Wait()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if err := p.writer.Flush(); err != nil {
+			logx.L().Errorf("flush aof failed: %v", err)
+		}
+		if err := p.file.Sync(); err != nil {
+			logx.L().Errorf("fsync aof failed: %v", err)
+		}
+		_ = p.file.Close()
+	})
+}
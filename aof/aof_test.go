@@ -0,0 +1,360 @@
+package aof
+
+import (
+	"bytes"
+	"fmt"
+	"godis/rdb"
+	"godis/resp/protocol"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cmdLine(cmd string) CmdLine {
+	fields := strings.Fields(cmd)
+	line := make(CmdLine, len(fields))
+	for i, f := range fields {
+		line[i] = []byte(f)
+	}
+	return line
+}
+
+func replay(t *testing.T, dir string, loadTruncated bool) ([]string, error) {
+	var cmds []string
+	err := Load(dir, "appendonly.aof", loadTruncated, func(e *rdb.Entry) error {
+		cmds = append(cmds, fmt.Sprintf("rdb %d %s", e.DB, e.Key))
+		return nil
+	}, func(line CmdLine) protocol.Reply {
+		parts := make([]string, len(line))
+		for i, arg := range line {
+			parts[i] = string(arg)
+		}
+		cmds = append(cmds, strings.Join(parts, " "))
+		return protocol.NewOkReply()
+	})
+	return cmds, err
+}
+
+func TestPersister(t *testing.T) {
+	for _, fsync := range []string{FsyncAlways, FsyncEverySec, FsyncNo} {
+		dir := filepath.Join(t.TempDir(), "appendonlydir")
+		p, err := NewPersister(dir, "appendonly.aof", fsync)
+		assert.Nil(t, err)
+		p.Append(0, cmdLine("set a 1"))
+		p.Append(0, cmdLine("set b 2"), cmdLine("del a"))
+		p.Append(3, cmdLine("lpush l x"))
+		p.Close()
+
+		cmds, err := replay(t, dir, false)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"SELECT 0", "set a 1", "set b 2", "del a", "SELECT 3", "lpush l x"}, cmds)
+
+		// 重新打开后追加在文件末尾，并重新写入SELECT
+		p, err = NewPersister(dir, "appendonly.aof", fsync)
+		assert.Nil(t, err)
+		p.Append(3, cmdLine("rpush l y"))
+		p.Close()
+		cmds, err = replay(t, dir, false)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"SELECT 3", "rpush l y"}, cmds[6:])
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	cmds, err := replay(t, filepath.Join(t.TempDir(), "missing"), false)
+	assert.Nil(t, err)
+	assert.Empty(t, cmds)
+}
+
+// writeAof creates an AOF in dir made of the given incr files
+func writeAof(t *testing.T, dir string, incrs ...string) {
+	m := &manifest{}
+	for i, data := range incrs {
+		entry := manifestEntry{name: incrName("appendonly.aof", int64(i+1)), seq: int64(i + 1), kind: incrFile}
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, entry.name), []byte(data), 0644))
+		m.incrs = append(m.incrs, entry)
+	}
+	assert.Nil(t, m.save(dir, "appendonly.aof"))
+}
+
+func TestLoadTruncated(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, incrName("appendonly.aof", 1))
+	complete := "*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n"
+	for _, tail := range []string{"*3\r\n$3\r\nset\r\n$1\r\nb\r\n$1", "*3\r\n$3\r\nse", "*2"} {
+		writeAof(t, dir, complete+tail)
+		_, err := replay(t, dir, false)
+		assert.NotNil(t, err)
+
+		cmds, err := replay(t, dir, true)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"set a 1"}, cmds)
+		data, _ := os.ReadFile(filename)
+		assert.Equal(t, complete, string(data))
+	}
+
+	// 没有EXEC的事务整体截断
+	multi := "*1\r\n$5\r\nmulti\r\n*3\r\n$3\r\nset\r\n$1\r\nb\r\n$1\r\n2\r\n"
+	writeAof(t, dir, complete+multi)
+	_, err := replay(t, dir, true)
+	assert.Nil(t, err)
+	data, _ := os.ReadFile(filename)
+	assert.Equal(t, complete, string(data))
+
+	writeAof(t, dir, complete+"+OK\r\n")
+	_, err = replay(t, dir, true)
+	assert.NotNil(t, err)
+
+	// 解析器会跳过的内容同样是损坏
+	writeAof(t, dir, complete+"\n"+complete)
+	_, err = replay(t, dir, true)
+	assert.ErrorContains(t, err, fmt.Sprintf("offset %d", len(complete)))
+
+	// 只有最后一个文件允许截断
+	writeAof(t, dir, complete+"*2", complete)
+	_, err = replay(t, dir, true)
+	assert.NotNil(t, err)
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	content := "file appendonly.aof.2.base.aof seq 2 type b\n" +
+		"file appendonly.aof.3.incr.aof seq 3 type i\n" +
+		"file appendonly.aof.4.incr.aof seq 4 type i\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"), []byte(content), 0644))
+	m, err := loadManifest(dir, "appendonly.aof")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"appendonly.aof.2.base.aof", "appendonly.aof.3.incr.aof", "appendonly.aof.4.incr.aof"}, m.files())
+
+	assert.Nil(t, m.save(dir, "appendonly.aof"))
+	data, _ := os.ReadFile(filepath.Join(dir, "appendonly.aof.manifest"))
+	assert.Equal(t, content, string(data))
+
+	for _, bad := range []string{"file a seq 1", "file ../a seq 1 type i\n", "file a seq x type i\n",
+		"file a seq 2 type i\nfile b seq 1 type i\n", "file a seq 1 type b\nfile b seq 2 type b\n"} {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"), []byte(bad), 0644))
+		_, err := loadManifest(dir, "appendonly.aof")
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	root := t.TempDir()
+	legacy := filepath.Join(root, "appendonly.aof")
+	dir := filepath.Join(root, "appendonlydir")
+	assert.Nil(t, Upgrade(legacy, dir, "appendonly.aof"))
+	_, err := os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, os.WriteFile(legacy, []byte("*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n"), 0644))
+	assert.Nil(t, Upgrade(legacy, dir, "appendonly.aof"))
+	_, err = os.Stat(legacy)
+	assert.True(t, os.IsNotExist(err))
+
+	p, err := NewPersister(dir, "appendonly.aof", FsyncAlways)
+	assert.Nil(t, err)
+	p.Append(0, cmdLine("set b 2"))
+	p.Close()
+	cmds, err := replay(t, dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"set a 1", "SELECT 0", "set b 2"}, cmds)
+}
+
+func TestRewrite(t *testing.T) {
+	dir := t.TempDir()
+	p, err := NewPersister(dir, "appendonly.aof", FsyncAlways)
+	assert.Nil(t, err)
+	defer p.Close()
+	p.Append(0, cmdLine("set a 1"), cmdLine("set a 2"))
+
+	rw, err := p.StartRewrite(false)
+	assert.Nil(t, err)
+	// 重写期间的命令写入新的incr文件
+	p.Append(0, cmdLine("set b 1"))
+	assert.Nil(t, rw.Write(0, cmdLine("set a 2")))
+	assert.Nil(t, rw.Write(1, cmdLine("set c 1")))
+	p.Append(0, cmdLine("set b 2"))
+	// 重写完成前崩溃也不会丢失数据
+	cmds, err := replay(t, dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"SELECT 0", "set a 1", "set a 2", "SELECT 0", "set b 1", "set b 2"}, cmds)
+
+	assert.Nil(t, p.FinishRewrite(rw))
+	p.Append(0, cmdLine("set b 3"))
+	cmds, err = replay(t, dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"SELECT 0", "set a 2", "SELECT 1", "set c 1", "SELECT 0", "set b 1", "set b 2", "set b 3"}, cmds)
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"appendonly.aof.1.base.aof", "appendonly.aof.2.incr.aof", "appendonly.aof.manifest"}, names)
+
+	// 放弃的重写不影响已有文件
+	rw, err = p.StartRewrite(false)
+	assert.Nil(t, err)
+	p.AbortRewrite(rw)
+	p.Append(0, cmdLine("del b"))
+	cmds, err = replay(t, dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"SELECT 0", "del b"}, cmds[len(cmds)-2:])
+
+	// 之后开始的重写先完成时，之前的重写被丢弃
+	stale, err := p.StartRewrite(false)
+	assert.Nil(t, err)
+	assert.Nil(t, stale.Write(0, cmdLine("set a 1")))
+	rw, err = p.StartRewrite(false)
+	assert.Nil(t, err)
+	assert.Nil(t, rw.Write(0, cmdLine("set a 3")))
+	assert.Nil(t, p.FinishRewrite(rw))
+	p.Append(0, cmdLine("set b 4"))
+	assert.ErrorIs(t, p.FinishRewrite(stale), ErrRewriteSuperseded)
+	cmds, err = replay(t, dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"SELECT 0", "set a 3", "SELECT 0", "set b 4"}, cmds)
+	entries, _ = os.ReadDir(dir)
+	names = nil
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"appendonly.aof.2.base.aof", "appendonly.aof.5.incr.aof", "appendonly.aof.manifest"}, names)
+}
+
+func TestNeedsRewrite(t *testing.T) {
+	p, err := NewPersister(t.TempDir(), "appendonly.aof", FsyncNo)
+	assert.Nil(t, err)
+	defer p.Close()
+	assert.False(t, p.NeedsRewrite(100, 1024))
+	for i := 0; i < 100; i++ {
+		p.Append(0, cmdLine("set key value"))
+	}
+	assert.True(t, p.NeedsRewrite(100, 1024))
+	assert.False(t, p.NeedsRewrite(100, 1<<20))
+	assert.False(t, p.NeedsRewrite(0, 1024))
+
+	rw, err := p.StartRewrite(false)
+	assert.Nil(t, err)
+	assert.Nil(t, rw.Write(0, cmdLine("set key value")))
+	assert.Nil(t, p.FinishRewrite(rw))
+	assert.False(t, p.NeedsRewrite(100, 0))
+}
+
+// rdbPreamble returns an RDB file holding a in db 0 and b in db 1
+func rdbPreamble(t *testing.T) []byte {
+	var buf bytes.Buffer
+	enc := rdb.NewEncoder(&buf, true, true)
+	assert.Nil(t, enc.WriteHeader())
+	assert.Nil(t, enc.WriteAux("aof-base", "1"))
+	assert.Nil(t, enc.WriteDB(0, 1, 0))
+	assert.Nil(t, enc.WriteEntry("a", []byte("1"), time.Time{}))
+	assert.Nil(t, enc.WriteDB(1, 1, 0))
+	assert.Nil(t, enc.WriteEntry("b", []byte("2"), time.Time{}))
+	assert.Nil(t, enc.WriteEnd())
+	return buf.Bytes()
+}
+
+func TestLoadRdbPreamble(t *testing.T) {
+	root := t.TempDir()
+	legacy := filepath.Join(root, "appendonly.aof")
+	dir := filepath.Join(root, "appendonlydir")
+	preamble := rdbPreamble(t)
+	commands := "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*3\r\n$3\r\nset\r\n$1\r\nc\r\n$1\r\n3\r\n"
+	assert.Nil(t, os.WriteFile(legacy, append(slices.Clone(preamble), commands...), 0644))
+	assert.Nil(t, Upgrade(legacy, dir, "appendonly.aof"))
+	base := filepath.Join(dir, "appendonly.aof.1.base.rdb")
+	_, err := os.Stat(base)
+	assert.Nil(t, err)
+
+	cmds, err := replay(t, dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rdb 0 a", "rdb 1 b", "SELECT 0", "set c 3"}, cmds)
+
+	// RDB部分损坏时无法加载
+	preamble[len(preamble)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(base, preamble, 0644))
+	_, err = replay(t, dir, true)
+	assert.ErrorContains(t, err, "bad RDB preamble")
+}
+
+func TestRewriteRdbPreamble(t *testing.T) {
+	dir := t.TempDir()
+	p, err := NewPersister(dir, "appendonly.aof", FsyncAlways)
+	assert.Nil(t, err)
+	defer p.Close()
+	p.Append(0, cmdLine("set a 0"))
+
+	rw, err := p.StartRewrite(true)
+	assert.Nil(t, err)
+	assert.True(t, rw.RdbPreamble())
+	p.Append(0, cmdLine("set c 3"))
+	_, err = rw.Writer().Write(rdbPreamble(t))
+	assert.Nil(t, err)
+	assert.Nil(t, p.FinishRewrite(rw))
+
+	cmds, err := replay(t, dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rdb 0 a", "rdb 1 b", "SELECT 0", "set c 3"}, cmds)
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"appendonly.aof.1.base.rdb", "appendonly.aof.2.incr.aof", "appendonly.aof.manifest"}, names)
+}
+
+func TestCheckFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "appendonly.aof")
+	complete := "*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n"
+	multi := "*1\r\n$5\r\nmulti\r\n"
+	preamble := rdbPreamble(t)
+	size, n := int64(len(preamble)), int64(len(complete))
+	tests := []struct {
+		content   string
+		ok        bool
+		rdbSize   int64
+		commands  int
+		valid     int64
+		fixable   bool
+		truncated bool
+	}{
+		{content: complete + multi + complete + "*1\r\n$4\r\nexec\r\n", ok: true, commands: 4, valid: n + 15 + n + 14},
+		{content: complete + "*3\r\n$3\r\nse", commands: 1, valid: n, fixable: true, truncated: true},
+		{content: complete + multi + complete, commands: 3, valid: n, fixable: true, truncated: true},
+		{content: complete + "$3\r\nset\r\n" + complete, commands: 1, valid: n, fixable: true},
+		{content: complete + "garbage\n" + complete, commands: 1, valid: n, fixable: true},
+		{content: string(preamble) + complete, ok: true, rdbSize: size, commands: 1, valid: size + n},
+		{content: string(preamble) + "*3\r\n", rdbSize: size, valid: size, fixable: true, truncated: true},
+		{content: string(preamble[:size-3]), valid: size - 3},
+		{content: "", ok: true},
+	}
+	for _, tt := range tests {
+		assert.Nil(t, os.WriteFile(filename, []byte(tt.content), 0644))
+		result, err := CheckFile(filename)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(tt.content)), result.Size)
+		assert.Equal(t, tt.rdbSize, result.RdbSize, tt.content)
+		assert.Equal(t, tt.commands, result.Commands, tt.content)
+		assert.Equal(t, tt.valid, result.Valid, tt.content)
+		assert.Equal(t, tt.fixable, result.Fixable, tt.content)
+		assert.Equal(t, tt.truncated, result.Truncated, tt.content)
+		assert.Equal(t, tt.ok, result.Err == nil, tt.content)
+	}
+	result, err := CheckFile(filename + ".missing")
+	assert.Nil(t, result)
+	assert.True(t, os.IsNotExist(err))
+
+	// 检查manifest中列出的文件
+	writeAof(t, dir, complete, complete)
+	files, err := ManifestFiles(filepath.Join(dir, "appendonly.aof.manifest"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "appendonly.aof.1.incr.aof"), filepath.Join(dir, "appendonly.aof.2.incr.aof")}, files)
+	_, err = ManifestFiles(filepath.Join(dir, "missing.manifest"))
+	assert.True(t, os.IsNotExist(err))
+}
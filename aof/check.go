@@ -0,0 +1,74 @@
+package aof
+
+import (
+	"errors"
+	"godis/rdb"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CheckResult is what checking an AOF or RDB file found
+type CheckResult struct {
+	Size int64
+	// RDB部分的长度，没有RDB前导时为0
+	RdbSize int64
+	// RDB部分中key的数量以及之后完整命令的数量
+	Keys     int
+	Commands int
+	// 文件完好部分的长度，损坏时即首个损坏的位置
+	Valid int64
+	// 首个损坏的原因，文件完好时为nil
+	Err error
+	// 截断到Valid能否修复文件，RDB部分的损坏无法修复
+	Fixable bool
+	// 文件只是在命令中间结束，通常是写入时崩溃导致
+	Truncated bool
+}
+
+// CheckFile reads the whole file without loading it and reports where it's first corrupted.
+// The file is an AOF file, which may start with an RDB preamble, or an RDB file.
+func CheckFile(filename string) (*CheckResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CheckResult{Size: info.Size()}
+	offset, err := readRdbPreamble(file, func(e *rdb.Entry) error {
+		result.Keys++
+		return nil
+	})
+	if err != nil {
+		result.Valid = offset
+		result.Err = err
+		return result, nil
+	}
+	result.RdbSize = offset
+	result.Valid, result.Commands, result.Err = readCommands(file, offset, func(CmdLine) {})
+	result.Fixable = result.Err != nil
+	result.Truncated = errors.Is(result.Err, errTruncated)
+	return result, nil
+}
+
+// ManifestFiles returns the paths of the files listed in the manifest at path in replay order
+func ManifestFiles(path string) ([]string, error) {
+	dir, name := filepath.Split(path)
+	m, err := loadManifest(dir, strings.TrimSuffix(name, ".manifest"))
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, os.ErrNotExist
+	}
+	var files []string
+	for _, name := range m.files() {
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files, nil
+}
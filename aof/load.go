@@ -0,0 +1,186 @@
+package aof
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"godis/pkg/logx"
+	"godis/rdb"
+	"godis/resp/parser"
+	"godis/resp/protocol"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// errTruncated means a file ends in the middle of a command or of a transaction
+var errTruncated = errors.New("unexpected end of file")
+
+// Load replays the files listed in the manifest in dir, a missing manifest is an empty dataset.
+// A file may start with an RDB preamble whose keys go to loadEntry, the commands after it go to exec.
+// Only the last incr file may be cut off in the middle of a command, usually by a crash during a write,
+// it's truncated to the last complete command when loadTruncated is set, otherwise loading fails.
+// A transaction without its EXEC is cut off as a whole.
+func Load(dir, filename string, loadTruncated bool, loadEntry func(e *rdb.Entry) error, exec func(cmdLine CmdLine) protocol.Reply) error {
+	m, err := loadManifest(dir, filename)
+	if err != nil || m == nil {
+		return err
+	}
+	files := m.files()
+	for i, name := range files {
+		last := i == len(files)-1
+		if err := loadFile(filepath.Join(dir, name), loadTruncated && last, loadEntry, exec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadFile(filename string, loadTruncated bool, loadEntry func(e *rdb.Entry) error, exec func(cmdLine CmdLine) protocol.Reply) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offset, err := readRdbPreamble(file, loadEntry)
+	if err != nil {
+		return fmt.Errorf("bad RDB preamble reading the append only file %s at offset %d: %w", filename, offset, err)
+	}
+	offset, _, err = readCommands(file, offset, func(cmdLine CmdLine) {
+		if reply := exec(cmdLine); protocol.IsErrorReply(reply) {
+			logx.L().Warnf("replaying aof command %s failed: %s", cmdLine[0], reply.ToBytes())
+		}
+	})
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, errTruncated) {
+		return fmt.Errorf("bad file format reading the append only file %s at offset %d: %w", filename, offset, err)
+	}
+	if !loadTruncated {
+		return fmt.Errorf("unexpected end of file reading the append only file %s at offset %d, "+
+			"set aof-load-truncated yes to truncate it", filename, offset)
+	}
+	logx.L().Warnf("!!! Warning: short read while loading the AOF file %s !!!, truncating it to offset %d", filename, offset)
+	return os.Truncate(filename, offset)
+}
+
+// hasRdbPreamble reports whether file starts with the RDB magic, the file offset is moved back to the start
+func hasRdbPreamble(file io.ReadSeeker) (bool, error) {
+	header := make([]byte, len(rdb.Magic))
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return n == len(header) && string(header) == rdb.Magic, nil
+}
+
+// readRdbPreamble hands the keys of the RDB preamble of file to loadEntry and moves the file offset
+// to the commands after it. It returns the size of the preamble, 0 if there is none, or the offset
+// where the preamble is corrupted.
+func readRdbPreamble(file io.ReadSeeker, loadEntry func(e *rdb.Entry) error) (int64, error) {
+	ok, err := hasRdbPreamble(file)
+	if err != nil || !ok {
+		return 0, err
+	}
+	dec := rdb.NewDecoder(file)
+	if err := dec.Decode(loadEntry); err != nil {
+		return dec.Offset(), err
+	}
+	// 解码器会预读，回到RDB结束的位置
+	if _, err := file.Seek(dec.Offset(), io.SeekStart); err != nil {
+		return dec.Offset(), err
+	}
+	return dec.Offset(), nil
+}
+
+// readCommands reads the commands of an AOF starting at offset and hands them to exec. It stops at
+// the first corruption, returning the offset up to which the file is intact: the end of the last
+// complete command, or the start of a transaction missing its EXEC. A file cut off at the end
+// returns errTruncated.
+func readCommands(r io.Reader, offset int64, exec func(cmdLine CmdLine)) (valid int64, commands int, err error) {
+	src := &recordingReader{r: r}
+	ch := parser.ParseStream(src)
+	defer func() {
+		// 提前返回时排空channel，让解析协程退出
+		for range ch {
+		}
+	}()
+	multiOffset := int64(-1)
+	validOffset := func() int64 {
+		if multiOffset >= 0 {
+			return multiOffset
+		}
+		return offset
+	}
+	for payload := range ch {
+		if payload.Err != nil {
+			if !errors.Is(payload.Err, io.EOF) && !errors.Is(payload.Err, io.ErrUnexpectedEOF) {
+				return validOffset(), commands, payload.Err
+			}
+			break
+		}
+		cmd, ok := payload.Data.(*protocol.MultiBulkReply)
+		if !ok || len(cmd.Values) == 0 {
+			return validOffset(), commands, errors.New("expected a command")
+		}
+		// AOF按标准格式写入，重新编码的命令与文件内容不同说明之前有解析器跳过的内容
+		raw := cmd.ToBytes()
+		if !src.consume(raw) {
+			return validOffset(), commands, errors.New("malformed command")
+		}
+		switch strings.ToLower(string(cmd.Values[0])) {
+		case "multi":
+			multiOffset = offset
+		case "exec":
+			multiOffset = -1
+		}
+		offset += int64(len(raw))
+		commands++
+		exec(cmd.Values)
+	}
+	if multiOffset >= 0 || src.pending() > 0 {
+		return validOffset(), commands, errTruncated
+	}
+	return offset, commands, nil
+}
+
+// recordingReader keeps the bytes read by the parser until they are matched with the commands
+// parsed from them, the parser reads ahead in another goroutine
+type recordingReader struct {
+	r   io.Reader
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.mu.Lock()
+	rr.buf = append(rr.buf, p[:n]...)
+	rr.mu.Unlock()
+	return n, err
+}
+
+// consume drops raw from the front of the bytes read, false if they don't start with raw
+func (rr *recordingReader) consume(raw []byte) bool {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if !bytes.HasPrefix(rr.buf, raw) {
+		return false
+	}
+	rr.buf = rr.buf[len(raw):]
+	return true
+}
+
+// pending returns the number of bytes read but not consumed
+func (rr *recordingReader) pending() int {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return len(rr.buf)
+}
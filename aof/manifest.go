@@ -0,0 +1,186 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 与redis 7相同的文件类型标记
+const (
+	baseFile = "b"
+	incrFile = "i"
+)
+
+// manifestEntry is one line of the manifest: file <name> seq <seq> type <b|i>
+type manifestEntry struct {
+	name string
+	seq  int64
+	kind string
+}
+
+// manifest lists the files making up a multi part AOF in replay order: an optional base file
+// written by the last rewrite followed by the incremental files appended since
+type manifest struct {
+	base  *manifestEntry
+	incrs []manifestEntry
+}
+
+func manifestName(filename string) string {
+	return filename + ".manifest"
+}
+
+// 与redis相同，base文件的后缀表明其格式
+const (
+	aofSuffix = ".aof"
+	rdbSuffix = ".rdb"
+)
+
+func baseName(filename string, seq int64, suffix string) string {
+	return fmt.Sprintf("%s.%d.base%s", filename, seq, suffix)
+}
+
+func incrName(filename string, seq int64) string {
+	return fmt.Sprintf("%s.%d.incr.aof", filename, seq)
+}
+
+// loadManifest reads the manifest in dir, nil if there is none yet
+func loadManifest(dir, filename string) (*manifest, error) {
+	file, err := os.Open(filepath.Join(dir, manifestName(filename)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := &manifest{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		entry, err := parseManifestLine(line)
+		if err != nil {
+			return nil, err
+		}
+		switch entry.kind {
+		case baseFile:
+			if m.base != nil {
+				return nil, errors.New("invalid aof manifest: more than one base file")
+			}
+			m.base = &entry
+		case incrFile:
+			if len(m.incrs) > 0 && entry.seq <= m.incrs[len(m.incrs)-1].seq {
+				return nil, errors.New("invalid aof manifest: incr files out of order")
+			}
+			m.incrs = append(m.incrs, entry)
+		}
+		// 其他类型(如history)的文件不参与加载
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func parseManifestLine(line string) (manifestEntry, error) {
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return manifestEntry{}, fmt.Errorf("invalid aof manifest line: %s", line)
+	}
+	var entry manifestEntry
+	for i := 0; i < len(fields); i += 2 {
+		switch fields[i] {
+		case "file":
+			entry.name = fields[i+1]
+		case "seq":
+			seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+			if err != nil {
+				return manifestEntry{}, fmt.Errorf("invalid aof manifest line: %s", line)
+			}
+			entry.seq = seq
+		case "type":
+			entry.kind = fields[i+1]
+		}
+	}
+	if entry.name == "" || entry.kind == "" || strings.ContainsAny(entry.name, `/\`) {
+		return manifestEntry{}, fmt.Errorf("invalid aof manifest line: %s", line)
+	}
+	return entry, nil
+}
+
+// files returns the names of the files to replay in order
+func (m *manifest) files() []string {
+	var names []string
+	if m.base != nil {
+		names = append(names, m.base.name)
+	}
+	for _, incr := range m.incrs {
+		names = append(names, incr.name)
+	}
+	return names
+}
+
+func (m *manifest) lastIncrSeq() int64 {
+	if len(m.incrs) == 0 {
+		return 0
+	}
+	return m.incrs[len(m.incrs)-1].seq
+}
+
+// save replaces the manifest in dir atomically, a crash leaves either the old or the new one
+func (m *manifest) save(dir, filename string) error {
+	var b strings.Builder
+	for _, entry := range append(m.baseEntries(), m.incrs...) {
+		fmt.Fprintf(&b, "file %s seq %d type %s\n", entry.name, entry.seq, entry.kind)
+	}
+	path := filepath.Join(dir, manifestName(filename))
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, []byte(b.String())); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func (m *manifest) baseEntries() []manifestEntry {
+	if m.base == nil {
+		return nil
+	}
+	return []manifestEntry{*m.base}
+}
+
+func writeFileSync(name string, data []byte) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir makes renames in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
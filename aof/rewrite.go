@@ -0,0 +1,197 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"godis/resp/protocol"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// ErrRewriteSuperseded is returned by FinishRewrite when a rewrite started later has already finished
+var ErrRewriteSuperseded = errors.New("a newer rewrite has already finished")
+
+// Rewrite is a background rewrite in progress. The rewriter writes the dataset as it was when
+// the rewrite started into a temp base file, the commands executed meanwhile go to the incr file
+// opened by StartRewrite, so the new base plus that incr file is the whole dataset.
+// The base file is made of commands written by Write, or with the RDB preamble it's an RDB file
+// written to Writer.
+type Rewrite struct {
+	file        *os.File
+	writer      *bufio.Writer
+	currentDB   int
+	rdbPreamble bool
+	// 重写开始时新建的incr文件，重写完成后只保留它及之后的incr文件
+	incrSeq int64
+	// 重写开始时AOF的大小
+	startSize int64
+}
+
+// Write writes cmdLine recreating data of db dbIndex to the new base file
+func (rw *Rewrite) Write(dbIndex int, cmdLine CmdLine) error {
+	if dbIndex != rw.currentDB {
+		if _, err := rw.writer.Write(protocol.NewMultiBulkReply(CmdLine{[]byte("SELECT"), []byte(strconv.Itoa(dbIndex))}).ToBytes()); err != nil {
+			return err
+		}
+		rw.currentDB = dbIndex
+	}
+	_, err := rw.writer.Write(protocol.NewMultiBulkReply(cmdLine).ToBytes())
+	return err
+}
+
+// RdbPreamble reports whether the base file is written as an RDB file
+func (rw *Rewrite) RdbPreamble() bool {
+	return rw.rdbPreamble
+}
+
+// Writer returns the writer of the new base file
+func (rw *Rewrite) Writer() io.Writer {
+	return rw.writer
+}
+
+func (rw *Rewrite) discard() {
+	_ = rw.file.Close()
+	_ = os.Remove(rw.file.Name())
+}
+
+// StartRewrite switches appending to a new incr file and creates the temp base file of a rewrite,
+// written as an RDB file if rdbPreamble is set. The caller must make sure no command is appended
+// while it runs and that the rewriter sees the dataset as it is at this moment.
+func (p *Persister) StartRewrite(rdbPreamble bool) (*Rewrite, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seq := p.manifest.lastIncrSeq() + 1
+	// 同时进行的重写使用各自的临时文件
+	temp, err := os.Create(filepath.Join(p.dir, fmt.Sprintf("temp-rewriteaof-%d-%d.aof", os.Getpid(), seq)))
+	if err != nil {
+		return nil, err
+	}
+	// 之前的incr文件在重写完成前仍然有效，先保证其完整落盘
+	if err := p.writer.Flush(); err == nil {
+		err = p.file.Sync()
+	}
+	if err != nil {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return nil, err
+	}
+	incr := manifestEntry{name: incrName(p.filename, seq), seq: seq, kind: incrFile}
+	file, err := openIncr(p.dir, incr.name)
+	if err == nil {
+		m := &manifest{base: p.manifest.base, incrs: append(slices.Clone(p.manifest.incrs), incr)}
+		if err = m.save(p.dir, p.filename); err == nil {
+			p.manifest = m
+		} else {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}
+	if err != nil {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return nil, err
+	}
+
+	_ = p.file.Close()
+	p.file = file
+	p.writer = bufio.NewWriter(file)
+	p.currentDB = -1
+	return &Rewrite{
+		file:        temp,
+		writer:      bufio.NewWriter(temp),
+		currentDB:   -1,
+		rdbPreamble: rdbPreamble,
+		incrSeq:     seq,
+		startSize:   p.size,
+	}, nil
+}
+
+// FinishRewrite makes the base file written by rw the new base of the AOF. The manifest is
+// replaced atomically, a crash before that still loads the old base and all incr files.
+// A rewrite started before the current base was written is outdated and fails with
+// ErrRewriteSuperseded.
+func (p *Persister) FinishRewrite(rw *Rewrite) error {
+	if err := rw.writer.Flush(); err != nil {
+		rw.discard()
+		return err
+	}
+	if err := rw.file.Sync(); err != nil {
+		rw.discard()
+		return err
+	}
+	info, err := rw.file.Stat()
+	if err != nil {
+		rw.discard()
+		return err
+	}
+	_ = rw.file.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := p.manifest
+	// 之后开始的重写已经完成，rw的incr文件已被删除
+	if !slices.ContainsFunc(old.incrs, func(incr manifestEntry) bool { return incr.seq == rw.incrSeq }) {
+		_ = os.Remove(rw.file.Name())
+		return ErrRewriteSuperseded
+	}
+	var seq int64 = 1
+	if old.base != nil {
+		seq = old.base.seq + 1
+	}
+	suffix := aofSuffix
+	if rw.rdbPreamble {
+		suffix = rdbSuffix
+	}
+	base := manifestEntry{name: baseName(p.filename, seq, suffix), seq: seq, kind: baseFile}
+	if err := os.Rename(rw.file.Name(), filepath.Join(p.dir, base.name)); err != nil {
+		_ = os.Remove(rw.file.Name())
+		return err
+	}
+	m := &manifest{base: &base}
+	for _, incr := range old.incrs {
+		if incr.seq >= rw.incrSeq {
+			m.incrs = append(m.incrs, incr)
+		}
+	}
+	if err := m.save(p.dir, p.filename); err != nil {
+		_ = os.Remove(filepath.Join(p.dir, base.name))
+		return err
+	}
+	p.manifest = m
+
+	// 新的manifest已经生效，旧的文件不再需要
+	keep := m.files()
+	for _, name := range old.files() {
+		if !slices.Contains(keep, name) {
+			_ = os.Remove(filepath.Join(p.dir, name))
+		}
+	}
+	p.size = info.Size() + p.size - rw.startSize
+	p.baseSize = p.size
+	return nil
+}
+
+// AbortRewrite drops the base file written by rw, the AOF stays valid with its old base
+func (p *Persister) AbortRewrite(rw *Rewrite) {
+	rw.discard()
+}
+
+// NeedsRewrite reports whether the AOF is at least minSize bytes and has grown by percentage
+// since the last rewrite or since the server started, a percentage of 0 disables it
+func (p *Persister) NeedsRewrite(percentage int, minSize int64) bool {
+	if percentage <= 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.size < minSize {
+		return false
+	}
+	base := max(p.baseSize, 1)
+	return (p.size-base)*100/base >= int64(percentage)
+}
@@ -0,0 +1,93 @@
+// Command godis-check validates an AOF or RDB file offline, like redis-check-aof and redis-check-rdb.
+// It reports the offset of the first corruption and with --fix truncates a damaged AOF to its last
+// valid command. Given the manifest of a multi part AOF it checks every file listed, only the last
+// one can be fixed since the files after a truncated one would no longer follow it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"godis/aof"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("godis-check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	fix := flags.Bool("fix", false, "truncate a damaged AOF to the last valid command")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: godis-check [--fix] <file.aof|file.rdb|file.manifest>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+	files := []string{path}
+	if strings.HasSuffix(path, ".manifest") {
+		var err error
+		if files, err = aof.ManifestFiles(path); err != nil {
+			fmt.Fprintf(stderr, "Cannot read the manifest %s: %v\n", path, err)
+			return 1
+		}
+	}
+	for i, filename := range files {
+		if !checkFile(filename, *fix && i == len(files)-1, stdout, stderr) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// checkFile checks one file and fixes it if allowed, returns whether the file is valid in the end
+func checkFile(filename string, fix bool, stdout, stderr io.Writer) bool {
+	result, err := aof.CheckFile(filename)
+	if err != nil {
+		fmt.Fprintf(stderr, "Cannot check %s: %v\n", filename, err)
+		return false
+	}
+	if result.RdbSize > 0 {
+		fmt.Fprintf(stdout, "RDB preamble of %s is OK: %d keys in %d bytes\n", filename, result.Keys, result.RdbSize)
+	}
+	fmt.Fprintf(stdout, "File analyzed: filename=%s, size=%d, commands=%d, ok_up_to=%d, diff=%d\n",
+		filename, result.Size, result.Commands, result.Valid, result.Size-result.Valid)
+	if result.Err == nil {
+		fmt.Fprintf(stdout, "%s is valid\n", filename)
+		return true
+	}
+
+	switch {
+	case !result.Fixable:
+		fmt.Fprintf(stdout, "Corrupted RDB data at offset %d: %v\n", result.Valid, result.Err)
+	case result.Truncated:
+		fmt.Fprintf(stdout, "Unexpected end of file at offset %d\n", result.Valid)
+	default:
+		fmt.Fprintf(stdout, "Bad file format at offset %d: %v\n", result.Valid, result.Err)
+	}
+	if !result.Fixable {
+		fmt.Fprintf(stdout, "%s is not valid and can't be fixed\n", filename)
+		return false
+	}
+	if !fix {
+		fmt.Fprintf(stdout, "%s is not valid. Use the --fix option to try fixing it.\n", filename)
+		return false
+	}
+	if err := os.Truncate(filename, result.Valid); err != nil {
+		fmt.Fprintf(stderr, "Failed to truncate %s: %v\n", filename, err)
+		return false
+	}
+	fmt.Fprintf(stdout, "Successfully truncated %s to %d bytes, %d bytes discarded\n",
+		filename, result.Valid, result.Size-result.Valid)
+	return true
+}
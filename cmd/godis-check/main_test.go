@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	complete := "*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n"
+	incr1 := filepath.Join(dir, "appendonly.aof.1.incr.aof")
+	incr2 := filepath.Join(dir, "appendonly.aof.2.incr.aof")
+	manifest := filepath.Join(dir, "appendonly.aof.manifest")
+	assert.Nil(t, os.WriteFile(incr1, []byte(complete), 0644))
+	assert.Nil(t, os.WriteFile(incr2, []byte(complete+"*3\r\n$3\r\nset"), 0644))
+	assert.Nil(t, os.WriteFile(manifest, []byte("file appendonly.aof.1.incr.aof seq 1 type i\n"+
+		"file appendonly.aof.2.incr.aof seq 2 type i\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run(nil, &stdout, &stderr))
+	assert.Equal(t, 0, run([]string{incr1}, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "is valid")
+
+	stdout.Reset()
+	assert.Equal(t, 1, run([]string{manifest}, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "Unexpected end of file at offset 27")
+	assert.Contains(t, stdout.String(), "Use the --fix option")
+
+	// 只修复最后一个文件
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"--fix", manifest}, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "Successfully truncated")
+	data, _ := os.ReadFile(incr2)
+	assert.Equal(t, complete, string(data))
+	assert.Equal(t, 0, run([]string{manifest}, &stdout, &stderr))
+
+	assert.Nil(t, os.WriteFile(incr1, []byte("+OK\r\n"+complete), 0644))
+	stdout.Reset()
+	assert.Equal(t, 1, run([]string{"--fix", manifest}, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "Bad file format at offset 0: expected a command")
+
+	assert.Equal(t, 1, run([]string{filepath.Join(dir, "missing.aof")}, &stdout, &stderr))
+}
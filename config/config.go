@@ -0,0 +1,232 @@
+package config
+
+import (
+	"bufio"
+	"godis/pkg/logx"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ServerProperties 服务配置，字段通过cfg标签与redis.conf中的配置项对应
+type ServerProperties struct {
+	Bind      string `cfg:"bind"`
+	Port      int    `cfg:"port"`
+	Databases int    `cfg:"databases"`
+	// 后台任务每秒执行的次数，与redis的hz配置相同
+	Hz int `cfg:"hz"`
+
+	// 小hash使用紧凑编码的阈值，超过后转换为字典
+	HashMaxListpackEntries int `cfg:"hash-max-listpack-entries"`
+	HashMaxListpackValue   int `cfg:"hash-max-listpack-value"`
+	// 全部成员为整数的set使用intset编码的最大成员数
+	SetMaxIntsetEntries int `cfg:"set-max-intset-entries"`
+	// 小zset使用紧凑编码的阈值，超过后转换为跳表
+	ZSetMaxListpackEntries int `cfg:"zset-max-listpack-entries"`
+	ZSetMaxListpackValue   int `cfg:"zset-max-listpack-value"`
+	// stream每个节点最多容纳的条目数
+	StreamNodeMaxEntries int `cfg:"stream-node-max-entries"`
+	// 稀疏编码的HyperLogLog超过该字节数后转换为密集编码
+	HllSparseMaxBytes int `cfg:"hll-sparse-max-bytes"`
+	// 开启后所有事务中的命令出错时回滚整个事务，否则只有MULTI ROLLBACK开启的事务回滚
+	MultiRollback bool `cfg:"multi-rollback"`
+	// 推送消息在输出缓冲区中积压的最大字节数，超过后断开客户端，0表示不限制
+	PubSubOutputBufferLimit int `cfg:"pubsub-output-buffer-limit"`
+	// 发布的keyspace事件类型，与redis的标志字母相同，为空时不发布
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"`
+
+	// 持久化文件所在的目录
+	Dir string `cfg:"dir"`
+	// RDB文件名
+	DBFilename string `cfg:"dbfilename"`
+	// 自动保存RDB的规则，每两个数字为一组: 距上次保存的秒数与修改次数都达到时执行BGSAVE，为空时不自动保存。
+	// 与redis.conf相同，每条save配置追加一组规则，save ""清空之前的规则
+	Save []string `cfg:"save,append"`
+	// 保存RDB时使用LZF压缩长字符串
+	RdbCompression bool `cfg:"rdbcompression"`
+	// 保存RDB时在文件末尾写入CRC64校验和
+	RdbChecksum bool `cfg:"rdbchecksum"`
+	// 开启AOF持久化，启动时从AOF恢复数据
+	AppendOnly bool `cfg:"appendonly"`
+	// AOF文件名的前缀，base文件、incr文件和manifest都放在dir下的appenddirname目录中
+	AppendFilename string `cfg:"appendfilename"`
+	AppendDirname  string `cfg:"appenddirname"`
+	// AOF刷盘策略: always、everysec或no
+	AppendFsync string `cfg:"appendfsync"`
+	// AOF末尾的命令不完整时截断后继续启动，否则拒绝启动
+	AofLoadTruncated bool `cfg:"aof-load-truncated"`
+	// AOF比上次重写后增长的百分比超过该值且不小于最小大小时自动重写，0表示不自动重写
+	AutoAofRewritePercentage int   `cfg:"auto-aof-rewrite-percentage"`
+	AutoAofRewriteMinSize    int64 `cfg:"auto-aof-rewrite-min-size"`
+	// AOF重写时以RDB格式写入base文件，加载更快体积更小，之后的incr文件仍然是命令
+	AofUseRdbPreamble bool `cfg:"aof-use-rdb-preamble"`
+
+	// 启动时作为从节点复制的主节点，格式为host port
+	ReplicaOf []string `cfg:"replicaof"`
+	// 从节点拒绝客户端的写命令
+	ReplicaReadOnly bool `cfg:"replica-read-only"`
+	// 复制积压缓冲区的大小，从节点断线重连后缺少的数据仍在其中时可以部分同步
+	ReplBacklogSize int `cfg:"repl-backlog-size"`
+	// 主从连接超过该秒数没有数据时视为断开
+	ReplTimeout int `cfg:"repl-timeout"`
+	// 主节点向从节点发送PING的间隔秒数
+	ReplPingReplicaPeriod int `cfg:"repl-ping-replica-period"`
+	// 复制流在从节点输出缓冲区中积压的最大字节数，超过后断开从节点，0表示不限制
+	ReplicaOutputBufferLimit int `cfg:"replica-output-buffer-limit"`
+}
+
+var Properties *ServerProperties
+
+func init() {
+	Properties = &ServerProperties{
+		Bind:      "0.0.0.0",
+		Port:      8888,
+		Databases: 16,
+		Hz:        10,
+
+		HashMaxListpackEntries: 128,
+		HashMaxListpackValue:   64,
+		SetMaxIntsetEntries:    512,
+		ZSetMaxListpackEntries: 128,
+		ZSetMaxListpackValue:   64,
+		StreamNodeMaxEntries:   100,
+		HllSparseMaxBytes:      3000,
+
+		PubSubOutputBufferLimit: 32 * 1024 * 1024,
+
+		Dir:            ".",
+		DBFilename:     "dump.rdb",
+		Save:           []string{"3600", "1", "300", "100", "60", "10000"},
+		RdbCompression: true,
+		RdbChecksum:    true,
+
+		AppendFilename:   "appendonly.aof",
+		AppendDirname:    "appendonlydir",
+		AppendFsync:      "everysec",
+		AofLoadTruncated: true,
+
+		AutoAofRewritePercentage: 100,
+		AutoAofRewriteMinSize:    64 * 1024 * 1024,
+		AofUseRdbPreamble:        true,
+
+		ReplicaReadOnly:          true,
+		ReplBacklogSize:          1024 * 1024,
+		ReplTimeout:              60,
+		ReplPingReplicaPeriod:    10,
+		ReplicaOutputBufferLimit: 256 * 1024 * 1024,
+	}
+}
+
+func parse(src io.Reader) *ServerProperties {
+	config := *Properties
+
+	// 重复的配置项保留所有值
+	rawMap := make(map[string][]string)
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		pivot := strings.IndexAny(line, " \t")
+		if pivot <= 0 {
+			continue
+		}
+		key := strings.ToLower(line[:pivot])
+		value := strings.Trim(strings.TrimSpace(line[pivot+1:]), `"`)
+		rawMap[key] = append(rawMap[key], value)
+	}
+	if err := scanner.Err(); err != nil {
+		logx.L().Fatal(err)
+	}
+
+	t := reflect.TypeOf(&config).Elem()
+	v := reflect.ValueOf(&config).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("cfg")
+		if !ok {
+			continue
+		}
+		// append选项表示重复的配置项依次追加，否则最后一个生效
+		key, option, _ := strings.Cut(tag, ",")
+		values, ok := rawMap[key]
+		if !ok {
+			continue
+		}
+		value := values[len(values)-1]
+		if option == "append" {
+			value = appendValues(values)
+		}
+		if err := setField(v.Field(i), value); err != nil {
+			logx.L().Warnf("invalid config %s %s: %v", key, value, err)
+		}
+	}
+	return &config
+}
+
+// appendValues joins the values of a repeated option, an empty value drops the values before it
+func appendValues(values []string) string {
+	var fields []string
+	for _, value := range values {
+		if value == "" {
+			fields = nil
+			continue
+		}
+		fields = append(fields, strings.Fields(value)...)
+	}
+	return strings.Join(fields, " ")
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := parseMemory(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		field.SetBool(value == "yes" || value == "true")
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String {
+			field.Set(reflect.ValueOf(strings.Fields(value)))
+		}
+	}
+	return nil
+}
+
+// 与redis.conf相同的大小单位，k和kb分别是1000和1024
+var memoryUnits = []struct {
+	suffix string
+	unit   int64
+}{
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+}
+
+// parseMemory parses an integer optionally followed by a size unit such as 64mb
+func parseMemory(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	for _, u := range memoryUnits {
+		if num, ok := strings.CutSuffix(lower, u.suffix); ok {
+			n, err := strconv.ParseInt(num, 10, 64)
+			return n * u.unit, err
+		}
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// SetupConfig 从配置文件加载配置，未配置的项保持默认值
+func SetupConfig(filename string) {
+	file, err := os.Open(filename)
+	if err != nil {
+		logx.L().Fatal(err)
+	}
+	defer file.Close()
+	Properties = parse(file)
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	src := `
+# comment
+bind 127.0.0.1
+port 6399
+databases 4
+hash-max-listpack-entries 32
+unknown-option whatever
+auto-aof-rewrite-min-size 32MB
+`
+	p := parse(strings.NewReader(src))
+	assert.Equal(t, "127.0.0.1", p.Bind)
+	assert.Equal(t, 6399, p.Port)
+	assert.Equal(t, 4, p.Databases)
+	assert.Equal(t, 32, p.HashMaxListpackEntries)
+	assert.Equal(t, 64, p.HashMaxListpackValue)
+	assert.Equal(t, int64(32<<20), p.AutoAofRewriteMinSize)
+	assert.Equal(t, 100, p.AutoAofRewritePercentage)
+}
+
+func TestParseRepeatedSave(t *testing.T) {
+	src := `
+save 3600 1
+save 300 100
+save 60 10000
+`
+	p := parse(strings.NewReader(src))
+	assert.Equal(t, []string{"3600", "1", "300", "100", "60", "10000"}, p.Save)
+
+	// save ""清空之前的规则
+	p = parse(strings.NewReader("save 3600 1\nsave \"\"\nsave 60 10000\n"))
+	assert.Equal(t, []string{"60", "10000"}, p.Save)
+	p = parse(strings.NewReader("save 3600 1\nsave \"\"\n"))
+	assert.Empty(t, p.Save)
+
+	// 其他配置项重复时最后一个生效
+	p = parse(strings.NewReader("replicaof 10.0.0.1 6379\nreplicaof 10.0.0.2 6380\nport 1\nport 2\n"))
+	assert.Equal(t, []string{"10.0.0.2", "6380"}, p.ReplicaOf)
+	assert.Equal(t, 2, p.Port)
+}
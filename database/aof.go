@@ -0,0 +1,115 @@
+package database
+
+import (
+	"godis/aof"
+	"godis/config"
+	"godis/pkg/logx"
+	"godis/rdb"
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// aofDir is the directory holding the base, incr and manifest files of the AOF
+func aofDir() string {
+	return filepath.Join(config.Properties.Dir, config.Properties.AppendDirname)
+}
+
+// loadAof replays the AOF and starts appending to it, it runs before the server accepts any client
+func (s *Server) loadAof() {
+	dir, filename := aofDir(), config.Properties.AppendFilename
+	// 单文件的AOF移入目录作为base文件
+	legacy := filepath.Join(config.Properties.Dir, filename)
+	if err := aof.Upgrade(legacy, dir, filename); err != nil {
+		logx.L().Fatalf("upgrade aof %s failed: %v", legacy, err)
+	}
+	client := connection.NewFakeConn()
+	now := time.Now()
+	loadEntry := func(e *rdb.Entry) error {
+		_, err := loadRdbEntry(s.dbSet, e, now)
+		return err
+	}
+	err := aof.Load(dir, filename, config.Properties.AofLoadTruncated, loadEntry, func(cmdLine CmdLine) protocol.Reply {
+		return s.Exec(client, cmdLine)
+	})
+	if err != nil {
+		logx.L().Fatalf("load aof %s failed: %v", dir, err)
+	}
+	persister, err := aof.NewPersister(dir, filename, config.Properties.AppendFsync)
+	if err != nil {
+		logx.L().Fatalf("open aof %s failed: %v", dir, err)
+	}
+	s.persister = persister
+	for _, db := range s.dbSet {
+		db.persister = persister
+	}
+}
+
+func makeCmdLine(name string, args ...[]byte) CmdLine {
+	cmdLine := make(CmdLine, 0, len(args)+1)
+	cmdLine = append(cmdLine, []byte(name))
+	return append(cmdLine, args...)
+}
+
+// aofLines returns the command lines cmd appends to the AOF after replying reply,
+// read only commands, errors and commands that are going to block append nothing
+func (cmd *command) aofLines(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+	if !cmd.hasFlag(flagWrite) {
+		return nil
+	}
+	switch reply.(type) {
+	case *protocol.ErrReply, *blockReply:
+		return nil
+	}
+	if cmd.rewriteAof != nil {
+		return cmd.rewriteAof(db, args, reply)
+	}
+	return []CmdLine{makeCmdLine(cmd.name, args...)}
+}
+
+// propagate appends a command executed on db to the AOF, the caller must still hold the locks
+// the command ran with so the AOF sees the writes to a key in the order they happened
+func (db *DB) propagate(cmd *command, args [][]byte, reply protocol.Reply) {
+	db.appendAof(cmd.aofLines(db, args, reply)...)
+}
+
+// appendAof appends cmdLines executed on db to the AOF and the replication stream as one batch,
+// they are counted as changes since the last save even when AOF is disabled
+func (db *DB) appendAof(cmdLines ...CmdLine) {
+	if len(cmdLines) == 0 {
+		return
+	}
+	db.dirty.Add(int64(len(cmdLines)))
+	if db.repl != nil {
+		db.repl.feed(db.index, cmdLines)
+	}
+	if db.persister == nil {
+		return
+	}
+	db.persister.Append(db.index, cmdLines...)
+}
+
+// isNullReply reports whether reply is a nil bulk or array, which write commands reply when nothing changed
+func isNullReply(reply protocol.Reply) bool {
+	switch r := reply.(type) {
+	case *protocol.NullArrayReply:
+		return true
+	case *protocol.BulkReply:
+		return r.Value == nil
+	}
+	return false
+}
+
+// aofExpireState describes the expire time key has now with an absolute PEXPIREAT,
+// a PERSIST if it has none and a DEL if the key is gone
+func aofExpireState(db *DB, key string) []CmdLine {
+	if _, ok := db.getEntity(key); !ok {
+		return []CmdLine{makeCmdLine("del", []byte(key))}
+	}
+	if expireAt, ok := db.expireTime(key); ok {
+		return []CmdLine{makeCmdLine("pexpireat", []byte(key), []byte(strconv.FormatInt(expireAt.UnixMilli(), 10)))}
+	}
+	return []CmdLine{makeCmdLine("persist", []byte(key))}
+}
@@ -0,0 +1,381 @@
+package database
+
+import (
+	"godis/config"
+	"godis/datastruct/list"
+	"godis/resp/connection"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withAof(t *testing.T) string {
+	dir := t.TempDir()
+	old := *config.Properties
+	config.Properties.AppendOnly = true
+	config.Properties.Dir = dir
+	config.Properties.AppendFsync = "always"
+	t.Cleanup(func() { *config.Properties = old })
+	// 新建的AOF只有第一个incr文件
+	return filepath.Join(dir, config.Properties.AppendDirname, config.Properties.AppendFilename+".1.incr.aof")
+}
+
+func TestAofRestore(t *testing.T) {
+	filename := withAof(t)
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	exec(s, c, "set str v ex 100")
+	exec(s, c, "set tmp v px 20")
+	exec(s, c, "setex ex 100 v")
+	exec(s, c, "set n 1.5")
+	exec(s, c, "incrbyfloat n 0.1")
+	exec(s, c, "rpush list a b c")
+	exec(s, c, "expire list 100")
+	exec(s, c, "sadd set a b c")
+	exec(s, c, "spop set")
+	exec(s, c, "hset h f1 v f2 v")
+	exec(s, c, "hexpire h 100 fields 1 f1")
+	exec(s, c, "hpexpireat h 1 fields 1 f2")
+	exec(s, c, "xadd st * f v")
+	exec(s, c, "blpop list 0")
+	exec(s, c, "multi")
+	exec(s, c, "incr cnt")
+	exec(s, c, "incr cnt")
+	exec(s, c, "exec")
+	exec(s, c, "select 1")
+	exec(s, c, "set other 1")
+	exec(s, c, "move other 0")
+	exec(s, c, "set flushed 1")
+	exec(s, c, "flushdb")
+	exec(s, c, "select 0")
+	// 跨db的事务，之后的写入仍然在客户端选择的db中重放
+	exec(s, c, "multi")
+	exec(s, c, "select 2")
+	exec(s, c, "set swapped 1")
+	exec(s, c, "swapdb 2 3")
+	exec(s, c, "exec")
+	exec(s, c, "select 0")
+	exec(s, c, "set after 1")
+	time.Sleep(30 * time.Millisecond)
+
+	dump := func(s *Server) []string {
+		c := connection.NewFakeConn()
+		var state []string
+		for _, cmd := range []string{"get str", "exists tmp", "get ex", "get n", "lrange list 0 -1",
+			"smembers set", "hgetall h", "xrange st - +", "get cnt", "get other", "pexpiretime str",
+			"pexpiretime list", "hpexpiretime h fields 1 f1", "get after", "select 3", "get swapped", "select 1", "dbsize"} {
+			state = append(state, string(exec(s, c, cmd).ToBytes()))
+		}
+		return state
+	}
+	expected := dump(s)
+	s.Close()
+
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	aof := string(data)
+	// 相对时间、随机和阻塞命令都被改写
+	for _, cmd := range []string{"setex", "spop", "hexpire\r", "blpop", "$6\r\nexpire\r\n", "$1\r\n*\r\n", "incrbyfloat"} {
+		assert.NotContains(t, aof, cmd)
+	}
+	assert.Contains(t, aof, "PXAT")
+	assert.Contains(t, aof, "pexpireat")
+	assert.Contains(t, aof, "multi")
+
+	restored := newTestServer(t)
+	assert.Equal(t, expected, dump(restored))
+	assert.Equal(t, "$1\r\nv\r\n", expected[0])
+	assert.Equal(t, ":0\r\n", expected[1])
+}
+
+func TestAofBlockedClient(t *testing.T) {
+	withAof(t)
+	s := newTestServer(t)
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assertReply(t, "*2\r\n$4\r\nlist\r\n$1\r\na\r\n", exec(s, c1, "blpop list 0"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	exec(s, c2, "rpush list a b")
+	<-done
+	exec(s, c2, "bzpopmin zset 0.01")
+	s.Close()
+
+	restored := newTestServer(t)
+	assertReply(t, "*1\r\n$1\r\nb\r\n", exec(restored, c2, "lrange list 0 -1"))
+}
+
+func TestAofStreamGroup(t *testing.T) {
+	withAof(t)
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	exec(s, c, "xadd st 1-1 f v")
+	exec(s, c, "xadd st 2-1 f v")
+	exec(s, c, "xgroup create st g 0")
+	exec(s, c, "xreadgroup group g alice block 10 streams st >")
+	time.Sleep(10 * time.Millisecond)
+	exec(s, c, "xclaim st g bob 5 1-1")
+	exec(s, c, "xautoclaim st g carol 0 2-1")
+	exec(s, c, "xadd st 3-1 f v")
+	exec(s, c, "xdel st 3-1")
+	expected := []string{
+		string(exec(s, c, "xpending st g - + 10").ToBytes()),
+		string(exec(s, c, "xinfo groups st").ToBytes()),
+	}
+	s.Close()
+
+	restored := newTestServer(t)
+	actual := []string{
+		string(exec(restored, c, "xpending st g - + 10").ToBytes()),
+		string(exec(restored, c, "xinfo groups st").ToBytes()),
+	}
+	// 投递时间按绝对时间恢复，重启后闲置时间只会更长，比较时忽略
+	idle := regexp.MustCompile(`(\$\d+\r\n[a-z]+\r\n):\d+`)
+	assert.Equal(t, idle.ReplaceAllString(expected[0], "$1"), idle.ReplaceAllString(actual[0], "$1"))
+	assert.Equal(t, expected[1], actual[1])
+}
+
+// waitRewrite waits for the background AOF rewrite of s to finish
+func waitBgJob(t *testing.T, s *Server) {
+	assert.Eventually(t, func() bool {
+		return s.bgJob.Load() == bgJobNone
+	}, 5*time.Second, time.Millisecond)
+}
+
+func aofFiles(t *testing.T) []string {
+	entries, err := os.ReadDir(filepath.Join(config.Properties.Dir, config.Properties.AppendDirname))
+	assert.Nil(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestAofRewrite(t *testing.T) {
+	withAof(t)
+	config.Properties.AofUseRdbPreamble = false
+	c := connection.NewFakeConn()
+	config.Properties.AppendOnly = false
+	disabled := newTestServer(t)
+	assertReply(t, "-ERR Background append only file rewriting is not possible when appendonly is disabled\r\n",
+		exec(disabled, c, "bgrewriteaof"))
+	disabled.Close()
+	config.Properties.AppendOnly = true
+	s := newTestServer(t)
+
+	for i := 0; i < 100; i++ {
+		exec(s, c, "rpush list "+strconv.Itoa(i))
+		exec(s, c, "sadd set m"+strconv.Itoa(i))
+		exec(s, c, "zadd zset "+strconv.Itoa(i)+" m"+strconv.Itoa(i))
+		exec(s, c, "set tmp"+strconv.Itoa(i)+" v")
+		exec(s, c, "del tmp"+strconv.Itoa(i))
+	}
+	exec(s, c, "set str v px 100000")
+	exec(s, c, "set gone v px 1")
+	exec(s, c, "zadd zset -inf low inf high 1.5 frac")
+	exec(s, c, "hset h f1 v f2 v")
+	exec(s, c, "hexpire h 100 fields 1 f1")
+	exec(s, c, "pfadd hll a b c")
+	exec(s, c, "xadd st 1-1 f v")
+	exec(s, c, "xadd st 2-1 f v")
+	exec(s, c, "xadd st 3-1 f v")
+	exec(s, c, "xdel st 3-1")
+	exec(s, c, "xgroup create st g 0")
+	exec(s, c, "xgroup createconsumer st g idle")
+	exec(s, c, "xreadgroup group g alice count 1 streams st >")
+	exec(s, c, "xgroup create empty g $ mkstream")
+	exec(s, c, "select 1")
+	exec(s, c, "set other 1")
+	exec(s, c, "select 0")
+	time.Sleep(10 * time.Millisecond)
+
+	assertReply(t, "+Background append only file rewriting started\r\n", exec(s, c, "bgrewriteaof"))
+	waitBgJob(t, s)
+	s.bgJob.Store(bgJobAofRewrite)
+	assertReply(t, "-ERR Background append only file rewriting already in progress\r\n", exec(s, c, "bgrewriteaof"))
+	s.bgJob.Store(bgJobNone)
+	assert.Equal(t, []string{"appendonly.aof.1.base.aof", "appendonly.aof.2.incr.aof", "appendonly.aof.manifest"}, aofFiles(t))
+	exec(s, c, "rpush list after")
+
+	dump := func(s *Server) []string {
+		c := connection.NewFakeConn()
+		var state []string
+		for _, cmd := range []string{"lrange list 0 -1", "scard set", "sismember set m42", "zrange zset 0 -1 withscores", "get str",
+			"pexpiretime str", "exists gone", "hgetall h", "hpexpiretime h fields 2 f1 f2", "pfcount hll",
+			"xrange st - +", "xinfo groups st", "xinfo stream empty", "select 1", "get other"} {
+			state = append(state, string(exec(s, c, cmd).ToBytes()))
+		}
+		return state
+	}
+	expected := dump(s)
+	s.Close()
+
+	base, err := os.ReadFile(filepath.Join(config.Properties.Dir, config.Properties.AppendDirname, "appendonly.aof.1.base.aof"))
+	assert.Nil(t, err)
+	// 重写后只保留最终状态，每条命令最多64个元素
+	assert.NotContains(t, string(base), "tmp")
+	assert.NotContains(t, string(base), "gone")
+	assert.Equal(t, 2, strings.Count(string(base), "rpush"))
+
+	restored := newTestServer(t)
+	assert.Equal(t, expected, dump(restored))
+	assert.Equal(t, "$1\r\nv\r\n", expected[4])
+	assert.Equal(t, ":0\r\n", expected[6])
+}
+
+func TestAofRewriteRdbPreamble(t *testing.T) {
+	withAof(t)
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	for i := 0; i < 100; i++ {
+		exec(s, c, "rpush list "+strconv.Itoa(i))
+		exec(s, c, "hset h f"+strconv.Itoa(i)+" v")
+	}
+	exec(s, c, "set str v px 100000")
+	exec(s, c, "set gone v px 1")
+	exec(s, c, "hexpire h 100 fields 1 f1")
+	exec(s, c, "xadd st 1-1 f v")
+	exec(s, c, "xgroup create st g 0")
+	exec(s, c, "xreadgroup group g alice streams st >")
+	exec(s, c, "select 1")
+	exec(s, c, "set other 1")
+	time.Sleep(10 * time.Millisecond)
+
+	assertReply(t, "+Background append only file rewriting started\r\n", exec(s, c, "bgrewriteaof"))
+	waitBgJob(t, s)
+	assert.Equal(t, []string{"appendonly.aof.1.base.rdb", "appendonly.aof.2.incr.aof", "appendonly.aof.manifest"}, aofFiles(t))
+	base, err := os.ReadFile(filepath.Join(config.Properties.Dir, config.Properties.AppendDirname, "appendonly.aof.1.base.rdb"))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(base), "REDIS"))
+	// 重写之后的命令追加到incr文件中
+	exec(s, c, "set other 2")
+	exec(s, c, "select 0")
+	exec(s, c, "rpush list after")
+
+	dump := func(s *Server) []string {
+		c := connection.NewFakeConn()
+		var state []string
+		for _, cmd := range []string{"lrange list -2 -1", "hlen h", "hpexpiretime h fields 2 f1 f2", "get str",
+			"pexpiretime str", "exists gone", "xinfo groups st", "select 1", "get other"} {
+			state = append(state, string(exec(s, c, cmd).ToBytes()))
+		}
+		return state
+	}
+	expected := dump(s)
+	s.Close()
+
+	restored := newTestServer(t)
+	assert.Equal(t, expected, dump(restored))
+	assert.Equal(t, "*2\r\n$2\r\n99\r\n$5\r\nafter\r\n", expected[0])
+	assert.Equal(t, ":0\r\n", expected[5])
+	assert.Equal(t, "$1\r\n2\r\n", expected[8])
+}
+
+func TestAofRewriteWhileWriting(t *testing.T) {
+	withAof(t)
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	exec(s, c, "rpush list a b")
+	exec(s, c, "set str v")
+	exec(s, c, "hset h f v")
+	exec(s, c, "set deleted v")
+	exec(s, c, "select 1")
+	exec(s, c, "set flushed v")
+	exec(s, c, "select 2")
+	exec(s, c, "set swapped v")
+	exec(s, c, "select 0")
+
+	// 快照之后的写入进入新的incr文件，重写看到的仍然是开始时的数据
+	rw, snapshots, err := s.beginAofRewrite()
+	assert.Nil(t, err)
+	exec(s, c, "rpush list c")
+	exec(s, c, "set str v2 ex 100")
+	exec(s, c, "hdel h f")
+	exec(s, c, "del deleted")
+	exec(s, c, "set created v")
+	exec(s, c, "select 1")
+	exec(s, c, "flushdb")
+	exec(s, c, "set after-flush v")
+	exec(s, c, "swapdb 2 3")
+	exec(s, c, "select 0")
+	saved := snapshots[0].saved[snapshots[0].data.ShardIndex("list")]["list"]
+	assert.Equal(t, 2, saved.val.(*list.QuickList).Len())
+	s.rewriteAof(rw, snapshots)
+	assert.Nil(t, s.dbSet[0].snapshot)
+	exec(s, c, "rpush list d")
+
+	dump := func(s *Server) []string {
+		c := connection.NewFakeConn()
+		var state []string
+		for _, cmd := range []string{"lrange list 0 -1", "get str", "exists h", "exists deleted", "get created",
+			"select 1", "keys *", "select 2", "dbsize", "select 3", "get swapped"} {
+			state = append(state, string(exec(s, c, cmd).ToBytes()))
+		}
+		return state
+	}
+	expected := dump(s)
+	s.Close()
+	restored := newTestServer(t)
+	assert.Equal(t, expected, dump(restored))
+	assert.Equal(t, "*4\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n", expected[0])
+	assert.Equal(t, "*1\r\n$11\r\nafter-flush\r\n", expected[6])
+	assert.Equal(t, "$1\r\nv\r\n", expected[10])
+}
+
+func TestAofRewriteConcurrent(t *testing.T) {
+	withAof(t)
+	s := newTestServer(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := connection.NewFakeConn()
+			for j := 0; j < 500; j++ {
+				exec(s, c, "incr counter")
+				exec(s, c, "rpush list"+strconv.Itoa(i)+" "+strconv.Itoa(j))
+			}
+		}(i)
+	}
+	c := connection.NewFakeConn()
+	for i := 0; i < 3; i++ {
+		exec(s, c, "bgrewriteaof")
+		waitBgJob(t, s)
+	}
+	wg.Wait()
+	s.Close()
+
+	restored := newTestServer(t)
+	assertReply(t, "$4\r\n2000\r\n", exec(restored, c, "get counter"))
+	for i := 0; i < 4; i++ {
+		assertReply(t, ":500\r\n", exec(restored, c, "llen list"+strconv.Itoa(i)))
+	}
+}
+
+func TestAofAutoRewrite(t *testing.T) {
+	withAof(t)
+	config.Properties.AutoAofRewriteMinSize = 1024
+	config.Properties.AofUseRdbPreamble = false
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	for i := 0; i < 100; i++ {
+		exec(s, c, "set key "+strconv.Itoa(i))
+	}
+	assert.Eventually(t, func() bool {
+		return slices.Contains(aofFiles(t), "appendonly.aof.1.base.aof") && s.bgJob.Load() == bgJobNone
+	}, 5*time.Second, 10*time.Millisecond)
+	// 重写后以新的大小为基准，不会立即再次重写
+	assert.False(t, s.persister.NeedsRewrite(config.Properties.AutoAofRewritePercentage, config.Properties.AutoAofRewriteMinSize))
+}
@@ -0,0 +1,574 @@
+package database
+
+import (
+	"godis/resp/protocol"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerCommand("setbit", execSetBit, 4, flagWrite|flagDenyOOM).keys(1, 1, 1)
+	registerCommand("getbit", execGetBit, 3, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("bitcount", execBitCount, -2, flagReadOnly).keys(1, 1, 1)
+	registerCommand("bitpos", execBitPos, -3, flagReadOnly).keys(1, 1, 1)
+	registerCommand("bitop", execBitOp, -4, flagWrite|flagDenyOOM).withPrepare(prepareBitOp)
+	registerCommand("bitfield", execBitField, -2, flagWrite|flagDenyOOM).keys(1, 1, 1)
+	registerCommand("bitfield_ro", execBitFieldRO, -2, flagReadOnly|flagFast).keys(1, 1, 1)
+}
+
+// 位的编号与redis相同，从第一个字节的最高位开始
+func getBit(buf []byte, offset int64) byte {
+	i := offset >> 3
+	if i >= int64(len(buf)) {
+		return 0
+	}
+	return (buf[i] >> (7 - offset&7)) & 1
+}
+
+func setBit(buf []byte, offset int64, bit byte) {
+	mask := byte(1) << (7 - offset&7)
+	if bit == 1 {
+		buf[offset>>3] |= mask
+	} else {
+		buf[offset>>3] &^= mask
+	}
+}
+
+// parseBitOffset parses a bit offset, a "#" prefix multiplies it by the width of the field when allowed
+func parseBitOffset(arg []byte, hashAllowed bool, width int64) (int64, *protocol.ErrReply) {
+	outOfRange := protocol.NewErrReply("ERR bit offset is not an integer or out of range")
+	s := arg
+	multiply := hashAllowed && len(s) > 0 && s[0] == '#'
+	if multiply {
+		s = s[1:]
+	}
+	offset, ok := parseInt(s)
+	if !ok || offset < 0 {
+		return 0, outOfRange
+	}
+	if multiply {
+		if offset > math.MaxInt64/width {
+			return 0, outOfRange
+		}
+		offset *= width
+	}
+	if offset>>3 >= maxStringSize || (offset+width-1)>>3 >= maxStringSize {
+		return 0, outOfRange
+	}
+	return offset, nil
+}
+
+// growString returns a copy of val at least size bytes long, padded with zeros.
+// Strings are never modified in place, so every bit write works on a copy.
+func growString(val []byte, size int64) []byte {
+	buf := make([]byte, max(int64(len(val)), size))
+	copy(buf, val)
+	return buf
+}
+
+// execSetBit SETBIT key offset value
+func execSetBit(db *DB, args [][]byte) protocol.Reply {
+	offset, errReply := parseBitOffset(args[1], false, 1)
+	if errReply != nil {
+		return errReply
+	}
+	var bit byte
+	switch string(args[2]) {
+	case "0":
+	case "1":
+		bit = 1
+	default:
+		return protocol.NewErrReply("ERR bit is not an integer or out of range")
+	}
+	key := string(args[0])
+	val, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	old := getBit(val, offset)
+	buf := growString(val, offset>>3+1)
+	setBit(buf, offset, bit)
+	db.putEntity(key, buf)
+	db.notify(notifyString, "setbit", key)
+	return protocol.NewIntReply(int64(old))
+}
+
+// execGetBit GETBIT key offset
+func execGetBit(db *DB, args [][]byte) protocol.Reply {
+	offset, errReply := parseBitOffset(args[1], false, 1)
+	if errReply != nil {
+		return errReply
+	}
+	val, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.NewIntReply(int64(getBit(val, offset)))
+}
+
+// parseBitRange parses start end [BYTE | BIT] into an inclusive range of bits like BITCOUNT and BITPOS do,
+// empty is set if the range covers nothing
+func parseBitRange(args [][]byte, size int64) (startBit int64, endBit int64, endGiven bool, empty bool, errReply *protocol.ErrReply) {
+	start, end := int64(0), int64(-1)
+	isBit := false
+	if len(args) > 0 {
+		var ok bool
+		start, ok = parseInt(args[0])
+		if !ok {
+			return 0, 0, false, false, protocol.NewNotIntegerErrReply()
+		}
+	}
+	if len(args) > 1 {
+		var ok bool
+		end, ok = parseInt(args[1])
+		if !ok {
+			return 0, 0, false, false, protocol.NewNotIntegerErrReply()
+		}
+		endGiven = true
+	}
+	if len(args) > 2 {
+		switch strings.ToUpper(string(args[2])) {
+		case "BYTE":
+		case "BIT":
+			isBit = true
+		default:
+			return 0, 0, false, false, protocol.NewSyntaxErrReply()
+		}
+	}
+	if len(args) > 3 {
+		return 0, 0, false, false, protocol.NewSyntaxErrReply()
+	}
+
+	length := size
+	if isBit {
+		length = size * 8
+	}
+	if start < 0 && end < 0 && start > end {
+		return 0, 0, endGiven, true, nil
+	}
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if end < 0 {
+		end = max(length+end, 0)
+	}
+	end = min(end, length-1)
+	if start > end {
+		return 0, 0, endGiven, true, nil
+	}
+	if isBit {
+		return start, end, endGiven, false, nil
+	}
+	return start * 8, end*8 + 7, endGiven, false, nil
+}
+
+// countBits counts the set bits in the inclusive range of bits [startBit, endBit]
+func countBits(buf []byte, startBit, endBit int64) int64 {
+	first, last := startBit>>3, endBit>>3
+	count := 0
+	for _, b := range buf[first : last+1] {
+		count += bits.OnesCount8(b)
+	}
+	// 去掉首字节中startBit之前与末字节中endBit之后的位
+	count -= bits.OnesCount8(buf[first] &^ (0xff >> (startBit & 7)))
+	count -= bits.OnesCount8(buf[last] & (0xff >> (endBit&7 + 1)))
+	return int64(count)
+}
+
+// execBitCount BITCOUNT key [start end [BYTE | BIT]]
+func execBitCount(db *DB, args [][]byte) protocol.Reply {
+	if len(args) == 2 {
+		return protocol.NewSyntaxErrReply()
+	}
+	val, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	startBit, endBit, _, empty, errReply := parseBitRange(args[1:], int64(len(val)))
+	if errReply != nil {
+		return errReply
+	}
+	if empty || len(val) == 0 {
+		return protocol.NewIntReply(0)
+	}
+	return protocol.NewIntReply(countBits(val, startBit, endBit))
+}
+
+// execBitPos BITPOS key bit [start [end [BYTE | BIT]]]
+func execBitPos(db *DB, args [][]byte) protocol.Reply {
+	var bit byte
+	switch string(args[1]) {
+	case "0":
+	case "1":
+		bit = 1
+	default:
+		return protocol.NewErrReply("ERR The bit argument must be 1 or 0.")
+	}
+	val, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	startBit, endBit, endGiven, empty, errReply := parseBitRange(args[2:], int64(len(val)))
+	if errReply != nil {
+		return errReply
+	}
+	if val == nil {
+		// 不存在的key视为全0
+		if bit == 1 {
+			return protocol.NewIntReply(-1)
+		}
+		return protocol.NewIntReply(0)
+	}
+	if empty {
+		return protocol.NewIntReply(-1)
+	}
+
+	// 整字节都不可能匹配时跳过
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for pos := startBit; pos <= endBit; {
+		if pos&7 == 0 && pos+7 <= endBit && val[pos>>3] == skip {
+			pos += 8
+			continue
+		}
+		if getBit(val, pos) == bit {
+			return protocol.NewIntReply(pos)
+		}
+		pos++
+	}
+	// 查找0且未指定end时，字符串右侧视为无限的0
+	if bit == 0 && !endGiven {
+		return protocol.NewIntReply(endBit + 1)
+	}
+	return protocol.NewIntReply(-1)
+}
+
+func prepareBitOp(args [][]byte) ([]string, []string) {
+	if len(args) < 3 {
+		return nil, nil
+	}
+	sources := make([]string, len(args)-2)
+	for i, arg := range args[2:] {
+		sources[i] = string(arg)
+	}
+	return []string{string(args[1])}, sources
+}
+
+// execBitOp BITOP <AND | OR | XOR | NOT> destkey key [key ...]
+func execBitOp(db *DB, args [][]byte) protocol.Reply {
+	op := strings.ToUpper(string(args[0]))
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 3 {
+			return protocol.NewErrReply("ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return protocol.NewSyntaxErrReply()
+	}
+	dest := string(args[1])
+	sources := make([][]byte, len(args)-2)
+	size := 0
+	for i, arg := range args[2:] {
+		val, errReply := db.getAsString(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		sources[i] = val
+		size = max(size, len(val))
+	}
+	if size == 0 {
+		if _, ok := db.removeKey(dest); ok {
+			db.notify(notifyGeneric, "del", dest)
+		}
+		return protocol.NewIntReply(0)
+	}
+
+	// 较短的字符串视为用0补齐
+	result := make([]byte, size)
+	if op == "NOT" {
+		for i := range result {
+			if i < len(sources[0]) {
+				result[i] = ^sources[0][i]
+			} else {
+				result[i] = 0xff
+			}
+		}
+	} else {
+		copy(result, sources[0])
+		for _, src := range sources[1:] {
+			for i := range result {
+				var b byte
+				if i < len(src) {
+					b = src[i]
+				}
+				switch op {
+				case "AND":
+					result[i] &= b
+				case "OR":
+					result[i] |= b
+				case "XOR":
+					result[i] ^= b
+				}
+			}
+		}
+	}
+	db.setString(dest, result)
+	db.notify(notifyString, "set", dest)
+	return protocol.NewIntReply(int64(size))
+}
+
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// bitfieldOp is a subcommand of BITFIELD
+type bitfieldOp struct {
+	op       string
+	signed   bool
+	width    int64
+	offset   int64
+	value    int64
+	overflow int
+}
+
+// parseBitfieldType parses an encoding like i8 or u16, u64 isn't supported like redis
+func parseBitfieldType(arg []byte) (bool, int64, *protocol.ErrReply) {
+	invalid := protocol.NewErrReply("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	if len(arg) < 2 || (arg[0] != 'i' && arg[0] != 'u' && arg[0] != 'I' && arg[0] != 'U') {
+		return false, 0, invalid
+	}
+	signed := arg[0] == 'i' || arg[0] == 'I'
+	width, err := strconv.ParseInt(string(arg[1:]), 10, 64)
+	if err != nil || width < 1 || (signed && width > 64) || (!signed && width > 63) {
+		return false, 0, invalid
+	}
+	return signed, width, nil
+}
+
+func parseBitfieldOps(args [][]byte, readOnly bool) ([]*bitfieldOp, *protocol.ErrReply) {
+	var ops []*bitfieldOp
+	overflow := overflowWrap
+	for i := 0; i < len(args); {
+		op := strings.ToUpper(string(args[i]))
+		remaining := len(args) - i - 1
+		switch op {
+		case "GET":
+			if remaining < 2 {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+		case "SET", "INCRBY":
+			if remaining < 3 {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+		case "OVERFLOW":
+			if remaining < 1 {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			switch strings.ToUpper(string(args[i+1])) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return nil, protocol.NewErrReply("ERR Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		default:
+			return nil, protocol.NewSyntaxErrReply()
+		}
+		if readOnly && op != "GET" {
+			return nil, protocol.NewErrReply("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+
+		signed, width, errReply := parseBitfieldType(args[i+1])
+		if errReply != nil {
+			return nil, errReply
+		}
+		offset, errReply := parseBitOffset(args[i+2], true, width)
+		if errReply != nil {
+			return nil, errReply
+		}
+		bop := &bitfieldOp{op: op, signed: signed, width: width, offset: offset, overflow: overflow}
+		if op != "GET" {
+			value, ok := parseInt(args[i+3])
+			if !ok {
+				return nil, protocol.NewNotIntegerErrReply()
+			}
+			bop.value = value
+			i++
+		}
+		ops = append(ops, bop)
+		i += 3
+	}
+	return ops, nil
+}
+
+// getBitfield reads width bits at offset as an unsigned integer
+func getBitfield(buf []byte, offset int64, width int64) uint64 {
+	var value uint64
+	for i := int64(0); i < width; i++ {
+		value = value<<1 | uint64(getBit(buf, offset+i))
+	}
+	return value
+}
+
+func setBitfield(buf []byte, offset int64, width int64, value uint64) {
+	for i := int64(0); i < width; i++ {
+		setBit(buf, offset+i, byte(value>>(width-1-i)&1))
+	}
+}
+
+// signExtend interprets the lowest width bits of value as a two's complement integer
+func signExtend(value uint64, width int64) int64 {
+	if width == 64 {
+		return int64(value)
+	}
+	if value&(1<<(width-1)) != 0 {
+		return int64(value | math.MaxUint64<<width)
+	}
+	return int64(value &^ (math.MaxUint64 << width))
+}
+
+// checkSignedOverflow computes value+incr in a signed field of width bits like redis,
+// returns false if it overflows and the policy is FAIL
+func checkSignedOverflow(value, incr int64, width int64, overflow int) (int64, bool) {
+	maxValue := int64(math.MaxInt64)
+	if width < 64 {
+		maxValue = 1<<(width-1) - 1
+	}
+	minValue := -maxValue - 1
+	maxIncr, minIncr := maxValue-value, minValue-value
+
+	over := value > maxValue || (width != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr)
+	under := value < minValue || (width != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr)
+	if !over && !under {
+		return value + incr, true
+	}
+	switch overflow {
+	case overflowWrap:
+		return signExtend(uint64(value)+uint64(incr), width), true
+	case overflowSat:
+		if over {
+			return maxValue, true
+		}
+		return minValue, true
+	}
+	return 0, false
+}
+
+// checkUnsignedOverflow computes value+incr in an unsigned field of width bits like redis,
+// returns false if it overflows and the policy is FAIL
+func checkUnsignedOverflow(value uint64, incr int64, width int64, overflow int) (uint64, bool) {
+	maxValue := uint64(1)<<width - 1
+	maxIncr, minIncr := int64(maxValue-value), -int64(value)
+
+	over := value > maxValue || (incr > 0 && incr > maxIncr)
+	under := incr < 0 && incr < minIncr
+	if !over && !under {
+		return value + uint64(incr), true
+	}
+	switch overflow {
+	case overflowWrap:
+		return (value + uint64(incr)) & maxValue, true
+	case overflowSat:
+		if over {
+			return maxValue, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// execBitField BITFIELD key [GET encoding offset | [OVERFLOW <WRAP | SAT | FAIL>] <SET encoding offset value | INCRBY encoding offset increment> ...]
+func execBitField(db *DB, args [][]byte) protocol.Reply {
+	return bitfieldGeneric(db, args, false)
+}
+
+// execBitFieldRO BITFIELD_RO key [GET encoding offset ...]
+func execBitFieldRO(db *DB, args [][]byte) protocol.Reply {
+	return bitfieldGeneric(db, args, true)
+}
+
+func bitfieldGeneric(db *DB, args [][]byte, readOnly bool) protocol.Reply {
+	ops, errReply := parseBitfieldOps(args[1:], readOnly)
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	val, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	// 与redis相同，存在写操作时先把字符串扩展到能容纳最远的写入位置
+	highest := int64(-1)
+	for _, op := range ops {
+		if op.op != "GET" {
+			highest = max(highest, op.offset+op.width-1)
+		}
+	}
+	buf := val
+	if highest >= 0 {
+		buf = growString(val, highest>>3+1)
+	}
+
+	replies := make([]protocol.Reply, len(ops))
+	for i, op := range ops {
+		raw := getBitfield(buf, op.offset, op.width)
+		if op.op == "GET" {
+			if op.signed {
+				replies[i] = protocol.NewIntReply(signExtend(raw, op.width))
+			} else {
+				replies[i] = protocol.NewIntReply(int64(raw))
+			}
+			continue
+		}
+
+		var oldValue, newValue int64
+		var ok bool
+		if op.signed {
+			oldValue = signExtend(raw, op.width)
+			var result int64
+			if op.op == "SET" {
+				result, ok = checkSignedOverflow(op.value, 0, op.width, op.overflow)
+			} else {
+				result, ok = checkSignedOverflow(oldValue, op.value, op.width, op.overflow)
+			}
+			newValue = result
+		} else {
+			oldValue = int64(raw)
+			var result uint64
+			if op.op == "SET" {
+				result, ok = checkUnsignedOverflow(uint64(op.value), 0, op.width, op.overflow)
+			} else {
+				result, ok = checkUnsignedOverflow(raw, op.value, op.width, op.overflow)
+			}
+			newValue = int64(result)
+		}
+		if !ok {
+			replies[i] = protocol.NewNullBulkReply()
+			continue
+		}
+		setBitfield(buf, op.offset, op.width, uint64(newValue))
+		if op.op == "SET" {
+			replies[i] = protocol.NewIntReply(oldValue)
+		} else {
+			replies[i] = protocol.NewIntReply(newValue)
+		}
+	}
+	if highest >= 0 {
+		db.putEntity(key, buf)
+		db.notify(notifyString, "setbit", key)
+	}
+	return protocol.NewArrayReply(replies)
+}
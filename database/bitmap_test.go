@@ -0,0 +1,127 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"testing"
+)
+
+func TestSetBit(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, ":0\r\n", exec(s, c, "setbit b 7 1"))
+	assertReply(t, ":1\r\n", exec(s, c, "setbit b 7 0"))
+	assertReply(t, ":0\r\n", exec(s, c, "setbit b 1 1"))
+	assertReply(t, "$1\r\n@\r\n", exec(s, c, "get b"))
+	assertReply(t, ":0\r\n", exec(s, c, "setbit b 23 1"))
+	assertReply(t, ":3\r\n", exec(s, c, "strlen b"))
+	assertReply(t, ":1\r\n", exec(s, c, "getbit b 23"))
+	assertReply(t, ":0\r\n", exec(s, c, "getbit b 10000"))
+	assertReply(t, ":0\r\n", exec(s, c, "getbit nokey 0"))
+	assertReply(t, "-ERR bit is not an integer or out of range\r\n", exec(s, c, "setbit b 0 2"))
+	assertReply(t, "-ERR bit offset is not an integer or out of range\r\n", exec(s, c, "setbit b -1 1"))
+	assertReply(t, "-ERR bit offset is not an integer or out of range\r\n", exec(s, c, "setbit b 4294967296 1"))
+
+	// SETBIT保留过期时间
+	exec(s, c, "expire b 100")
+	exec(s, c, "setbit b 0 1")
+	assertReply(t, ":100\r\n", exec(s, c, "ttl b"))
+
+	exec(s, c, "lpush l x")
+	assertReply(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "setbit l 0 1"))
+}
+
+func TestBitCount(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "set mykey foobar")
+	assertReply(t, ":26\r\n", exec(s, c, "bitcount mykey"))
+	assertReply(t, ":4\r\n", exec(s, c, "bitcount mykey 0 0"))
+	assertReply(t, ":6\r\n", exec(s, c, "bitcount mykey 1 1"))
+	assertReply(t, ":6\r\n", exec(s, c, "bitcount mykey 1 1 byte"))
+	assertReply(t, ":17\r\n", exec(s, c, "bitcount mykey 5 30 bit"))
+	assertReply(t, ":1\r\n", exec(s, c, "bitcount mykey -2 -1 bit"))
+	assertReply(t, ":0\r\n", exec(s, c, "bitcount mykey -1 -2"))
+	assertReply(t, ":0\r\n", exec(s, c, "bitcount nokey"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "bitcount mykey 0"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "bitcount mykey 0 1 bits"))
+	assertReply(t, "-ERR value is not an integer or out of range\r\n", exec(s, c, "bitcount mykey a 1"))
+}
+
+func TestBitPos(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	s.Exec(c, toCmdLine("set", "mykey", "\xff\xf0\x00"))
+	assertReply(t, ":12\r\n", exec(s, c, "bitpos mykey 0"))
+	s.Exec(c, toCmdLine("set", "mykey", "\x00\xff\xf0"))
+	assertReply(t, ":8\r\n", exec(s, c, "bitpos mykey 1 0"))
+	assertReply(t, ":16\r\n", exec(s, c, "bitpos mykey 1 2"))
+	assertReply(t, ":16\r\n", exec(s, c, "bitpos mykey 1 2 -1 byte"))
+	assertReply(t, ":17\r\n", exec(s, c, "bitpos mykey 1 17 23 bit"))
+	assertReply(t, ":-1\r\n", exec(s, c, "bitpos mykey 1 20 -1 bit"))
+
+	// 全为1时查找0，未指定end返回字符串之后的第一位
+	s.Exec(c, toCmdLine("set", "ones", "\xff\xff"))
+	assertReply(t, ":16\r\n", exec(s, c, "bitpos ones 0"))
+	assertReply(t, ":-1\r\n", exec(s, c, "bitpos ones 0 0 -1"))
+	assertReply(t, ":-1\r\n", exec(s, c, "bitpos nokey 1"))
+	assertReply(t, ":0\r\n", exec(s, c, "bitpos nokey 0"))
+	assertReply(t, "-ERR The bit argument must be 1 or 0.\r\n", exec(s, c, "bitpos mykey 2"))
+}
+
+func TestBitOp(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "set key1 foobar")
+	exec(s, c, "set key2 abcdef")
+	assertReply(t, ":6\r\n", exec(s, c, "bitop and dest key1 key2"))
+	assertReply(t, "$6\r\n`bc`ab\r\n", exec(s, c, "get dest"))
+	assertReply(t, ":6\r\n", exec(s, c, "bitop or dest key1 key2"))
+	assertReply(t, "$6\r\ngoofev\r\n", exec(s, c, "get dest"))
+	s.Exec(c, toCmdLine("set", "short", "\x0f"))
+	assertReply(t, ":6\r\n", exec(s, c, "bitop xor dest short key1 nokey"))
+	assertReply(t, "$6\r\nioobar\r\n", exec(s, c, "get dest"))
+	assertReply(t, ":1\r\n", exec(s, c, "bitop not dest short"))
+	assertReply(t, "$1\r\n\xf0\r\n", exec(s, c, "get dest"))
+
+	// 源key都不存在时删除目标key
+	assertReply(t, ":0\r\n", exec(s, c, "bitop or dest nokey"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists dest"))
+	assertReply(t, "-ERR BITOP NOT must be called with a single source key.\r\n", exec(s, c, "bitop not dest key1 key2"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "bitop nand dest key1"))
+}
+
+func TestBitField(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "*2\r\n:1\r\n:0\r\n", exec(s, c, "bitfield mykey incrby i5 100 1 get u4 0"))
+	assertReply(t, "*2\r\n:0\r\n:100\r\n", exec(s, c, "bitfield bf set u8 #1 100 get u8 8"))
+	assertReply(t, "*1\r\n:100\r\n", exec(s, c, "bitfield_ro bf get u8 #1"))
+
+	// 溢出策略
+	assertReply(t, "*2\r\n:1\r\n:1\r\n", exec(s, c, "bitfield ov incrby u2 100 1 overflow sat incrby u2 102 1"))
+	assertReply(t, "*2\r\n:2\r\n:2\r\n", exec(s, c, "bitfield ov incrby u2 100 1 overflow sat incrby u2 102 1"))
+	assertReply(t, "*2\r\n:3\r\n:3\r\n", exec(s, c, "bitfield ov incrby u2 100 1 overflow sat incrby u2 102 1"))
+	assertReply(t, "*2\r\n:0\r\n:3\r\n", exec(s, c, "bitfield ov incrby u2 100 1 overflow sat incrby u2 102 1"))
+	assertReply(t, "*1\r\n$-1\r\n", exec(s, c, "bitfield ov overflow fail incrby u2 102 1"))
+	assertReply(t, "*1\r\n:-128\r\n", exec(s, c, "bitfield sig incrby i8 0 -128"))
+	assertReply(t, "*1\r\n:127\r\n", exec(s, c, "bitfield sig incrby i8 0 -1"))
+	assertReply(t, "*1\r\n:-128\r\n", exec(s, c, "bitfield sig overflow sat incrby i8 0 -1000"))
+	assertReply(t, "*1\r\n$-1\r\n", exec(s, c, "bitfield sig overflow fail set i8 0 200"))
+	assertReply(t, "*2\r\n:-128\r\n:-1\r\n", exec(s, c, "bitfield sig set i8 0 -1 get i8 0"))
+	assertReply(t, "*2\r\n:0\r\n:-9223372036854775808\r\n", exec(s, c, "bitfield i64 set i64 0 -9223372036854775808 get i64 0"))
+	assertReply(t, "*1\r\n:9223372036854775807\r\n", exec(s, c, "bitfield i64 incrby i64 0 -1"))
+
+	assertReply(t, "-ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.\r\n", exec(s, c, "bitfield bf get u64 0"))
+	assertReply(t, "-ERR Invalid OVERFLOW type specified\r\n", exec(s, c, "bitfield bf overflow nope"))
+	assertReply(t, "-ERR BITFIELD_RO only supports the GET subcommand\r\n", exec(s, c, "bitfield_ro bf set u8 0 1"))
+	assertReply(t, "-ERR bit offset is not an integer or out of range\r\n", exec(s, c, "bitfield bf get u8 -1"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "bitfield bf get u8"))
+	assertReply(t, "*0\r\n", exec(s, c, "bitfield bf"))
+	assertReply(t, "*1\r\n:0\r\n", exec(s, c, "bitfield nokey get i8 0"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists nokey"))
+}
@@ -0,0 +1,352 @@
+package database
+
+import (
+	"container/list"
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// blockReply is returned by the executor of a blocking command when none of its keys can serve it.
+// Outside of MULTI the client gets parked on the keys, inside MULTI the command must not block
+// so the reply is serialized as the timeout reply directly.
+type blockReply struct {
+	keys []string
+	// 只有key变为该类型时才唤醒客户端，类型不对的key不影响阻塞
+	keyType      string
+	timeout      time.Duration
+	timeoutReply protocol.Reply
+	// 非空时用这些参数重新执行命令，例如XREAD需要把$固定为阻塞时的最后一个ID
+	args [][]byte
+}
+
+func (r *blockReply) ToBytes() []byte {
+	return r.timeoutReply.ToBytes()
+}
+
+// parseBlockTimeout parses a timeout in seconds, 0 means blocking forever
+func parseBlockTimeout(arg []byte) (time.Duration, *protocol.ErrReply) {
+	seconds, ok := parseFloat(arg)
+	if !ok || math.IsInf(seconds, 0) || seconds*1000 > math.MaxInt64/float64(time.Millisecond) {
+		return 0, protocol.NewErrReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, protocol.NewErrReply("ERR timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// aofPopFrom writes a served BLPOP style command as the non blocking pop name of the key that served it,
+// the reply starts with the key
+func aofPopFrom(name string) AofFunc {
+	return func(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+		r, ok := reply.(*protocol.MultiBulkReply)
+		if !ok || len(r.Values) == 0 {
+			return nil
+		}
+		return []CmdLine{makeCmdLine(name, r.Values[0])}
+	}
+}
+
+// aofNonBlocking writes a served blocking command as its non blocking counterpart name, which takes the
+// same arguments without the timeout. The timeout is the last argument unless timeoutFirst is set.
+func aofNonBlocking(name string, timeoutFirst bool) AofFunc {
+	return func(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+		if isNullReply(reply) {
+			return nil
+		}
+		if timeoutFirst {
+			return []CmdLine{makeCmdLine(name, args[1:]...)}
+		}
+		return []CmdLine{makeCmdLine(name, args[:len(args)-1]...)}
+	}
+}
+
+// waiter is a client blocked on some keys of a db
+type waiter struct {
+	client connection.Connection
+	db     *DB
+	cmd    *command
+	args   [][]byte
+	block  *blockReply
+
+	// 保护served和cancelled，服务方在持有该锁时重新执行命令
+	mu        sync.Mutex
+	served    bool
+	cancelled bool
+	// 容量为1，被服务或被CLIENT UNBLOCK时写入回复
+	result chan protocol.Reply
+	// key -> 在该key等待队列中的位置
+	elements map[string]*list.Element
+}
+
+// blockingKeys tracks the clients blocked on the keys of a db, each key has a FIFO queue of waiters
+type blockingKeys struct {
+	mu      sync.Mutex
+	waiters map[string]*list.List
+	// 有客户端等待的key数量，没有等待者时signal无需加锁
+	count atomic.Int32
+
+	readyMu   sync.Mutex
+	readyKeys map[string]struct{}
+	hasReady  atomic.Bool
+}
+
+func newBlockingKeys() *blockingKeys {
+	return &blockingKeys{
+		waiters:   make(map[string]*list.List),
+		readyKeys: make(map[string]struct{}),
+	}
+}
+
+func (b *blockingKeys) add(w *waiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w.elements = make(map[string]*list.Element, len(w.block.keys))
+	for _, key := range w.block.keys {
+		if _, ok := w.elements[key]; ok {
+			continue
+		}
+		queue, ok := b.waiters[key]
+		if !ok {
+			queue = list.New()
+			b.waiters[key] = queue
+			b.count.Add(1)
+		}
+		w.elements[key] = queue.PushBack(w)
+	}
+}
+
+func (b *blockingKeys) remove(w *waiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, elem := range w.elements {
+		queue := b.waiters[key]
+		queue.Remove(elem)
+		if queue.Len() == 0 {
+			delete(b.waiters, key)
+			b.count.Add(-1)
+		}
+	}
+	w.elements = nil
+}
+
+// first returns the longest waiting client of key
+func (b *blockingKeys) first(key string) *waiter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, ok := b.waiters[key]
+	if !ok {
+		return nil
+	}
+	return queue.Front().Value.(*waiter)
+}
+
+// signal marks key as ready if some client waits on it, cheap enough to call on every key creation
+func (b *blockingKeys) signal(key string) {
+	if b.count.Load() == 0 {
+		return
+	}
+	b.mu.Lock()
+	_, ok := b.waiters[key]
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+	b.readyMu.Lock()
+	b.readyKeys[key] = struct{}{}
+	b.hasReady.Store(true)
+	b.readyMu.Unlock()
+}
+
+// signalAll marks every waited key as ready, used when the whole keyspace is replaced
+func (b *blockingKeys) signalAll() {
+	b.mu.Lock()
+	keys := make([]string, 0, len(b.waiters))
+	for key := range b.waiters {
+		keys = append(keys, key)
+	}
+	b.mu.Unlock()
+	for _, key := range keys {
+		b.signal(key)
+	}
+}
+
+func (b *blockingKeys) takeReadyKeys() []string {
+	if !b.hasReady.Load() {
+		return nil
+	}
+	b.readyMu.Lock()
+	defer b.readyMu.Unlock()
+	keys := make([]string, 0, len(b.readyKeys))
+	for key := range b.readyKeys {
+		keys = append(keys, key)
+	}
+	b.readyKeys = make(map[string]struct{})
+	b.hasReady.Store(false)
+	return keys
+}
+
+// serve re-executes the blocked command on behalf of the client,
+// returns false if key still can't serve it
+func (w *waiter) serve(key string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.served || w.cancelled {
+		return true
+	}
+	reply, ok := w.db.execIfReady(w.cmd, w.args, key, w.block.keyType)
+	if !ok {
+		return false
+	}
+	w.served = true
+	w.result <- reply
+	return true
+}
+
+// cancel stops waiting, returns the reply if the client has been served in the meantime
+func (w *waiter) cancel() (protocol.Reply, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.served {
+		return <-w.result, true
+	}
+	w.cancelled = true
+	return nil, false
+}
+
+// unblock wakes the client up with reply as if it timed out, used by CLIENT UNBLOCK
+func (w *waiter) unblock(reply protocol.Reply) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.served || w.cancelled {
+		return false
+	}
+	w.served = true
+	w.result <- reply
+	return true
+}
+
+// signalKeyAsReady wakes up clients blocked on key, the caller must hold the shard lock of key
+func (db *DB) signalKeyAsReady(key string) {
+	db.blocking.signal(key)
+}
+
+// handleReadyKeys serves the clients blocked on keys that got data, in FIFO order per key.
+// It must be called without holding any shard lock, after the command that signaled the keys.
+func (db *DB) handleReadyKeys() {
+	for {
+		keys := db.blocking.takeReadyKeys()
+		if len(keys) == 0 {
+			return
+		}
+		for _, key := range keys {
+			for {
+				w := db.blocking.first(key)
+				if w == nil || !w.serve(key) {
+					break
+				}
+				db.blocking.remove(w)
+			}
+		}
+	}
+}
+
+// execIfReady executes a blocked command if key holds a value of keyType and the command doesn't block again
+func (db *DB) execIfReady(cmd *command, args [][]byte, key string, keyType string) (protocol.Reply, bool) {
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
+
+	writeKeys, readKeys := cmd.prepareKeys(args)
+	db.data.RWLocks(writeKeys, readKeys)
+	defer db.data.RWUnlocks(writeKeys, readKeys)
+	db.saveForSnapshot(writeKeys)
+	for _, key := range writeKeys {
+		db.expireIfNeeded(key)
+	}
+
+	val, ok := db.getEntity(key)
+	if !ok || typeOf(val) != keyType {
+		return nil, false
+	}
+	reply := cmd.executor(db, args)
+	if _, ok := reply.(*blockReply); ok {
+		return nil, false
+	}
+	db.addVersion(writeKeys...)
+	db.propagate(cmd, args, reply)
+	return reply, true
+}
+
+// execBlocking runs a blocking command, if it has to block the client is registered as a waiter
+// before the shard locks are released so pushes in between can't be missed
+func (db *DB) execBlocking(client connection.Connection, cmd *command, args [][]byte) (protocol.Reply, *waiter) {
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
+
+	writeKeys, readKeys := cmd.prepareKeys(args)
+	db.data.RWLocks(writeKeys, readKeys)
+	defer db.data.RWUnlocks(writeKeys, readKeys)
+	db.saveForSnapshot(writeKeys)
+	for _, key := range writeKeys {
+		db.expireIfNeeded(key)
+	}
+
+	reply := cmd.executor(db, args)
+	block, ok := reply.(*blockReply)
+	if !ok {
+		db.addVersion(writeKeys...)
+		db.propagate(cmd, args, reply)
+		return reply, nil
+	}
+	if block.args != nil {
+		args = block.args
+	}
+	w := &waiter{
+		client: client,
+		db:     db,
+		cmd:    cmd,
+		args:   args,
+		block:  block,
+		result: make(chan protocol.Reply, 1),
+	}
+	db.blocking.add(w)
+	return nil, w
+}
+
+// execBlockingCommand blocks the client until it's served, times out, is unblocked or goes away
+func (s *Server) execBlockingCommand(client connection.Connection, db *DB, cmd *command, args [][]byte) protocol.Reply {
+	reply, w := db.execBlocking(client, cmd, args)
+	db.handleReadyKeys()
+	if w == nil {
+		return reply
+	}
+
+	s.blockedClients.Store(client.ID(), w)
+	defer s.blockedClients.Delete(client.ID())
+
+	var timeout <-chan time.Time
+	if w.block.timeout > 0 {
+		timer := time.NewTimer(w.block.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case reply = <-w.result:
+		db.blocking.remove(w)
+		return reply
+	case <-timeout:
+	case <-client.Done():
+	case <-s.closeChan:
+	}
+
+	reply, served := w.cancel()
+	db.blocking.remove(w)
+	if served {
+		return reply
+	}
+	return w.block.timeoutReply
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// execAsync runs cmd in another goroutine and waits until the client is blocked
+func execAsync(t *testing.T, s *Server, client connection.Connection, cmd string) <-chan protocol.Reply {
+	t.Helper()
+	ch := make(chan protocol.Reply, 1)
+	go func() {
+		ch <- exec(s, client, cmd)
+	}()
+	assert.Eventually(t, func() bool {
+		_, ok := s.blockedClients.Load(client.ID())
+		return ok
+	}, 5*time.Second, time.Millisecond)
+	return ch
+}
+
+func receive(t *testing.T, ch <-chan protocol.Reply) protocol.Reply {
+	t.Helper()
+	select {
+	case reply := <-ch:
+		return reply
+	case <-time.After(time.Second):
+		t.Fatal("blocked client not woken up")
+		return nil
+	}
+}
+
+func TestBlockingPop(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "rpush l2 x")
+	assertReply(t, "*2\r\n$2\r\nl2\r\n$1\r\nx\r\n", exec(s, c, "blpop l1 l2 0"))
+	assertReply(t, "-ERR timeout is negative\r\n", exec(s, c, "blpop l1 -1"))
+	assertReply(t, "-ERR timeout is not a float or out of range\r\n", exec(s, c, "blpop l1 abc"))
+
+	blocked := connection.NewFakeConn()
+	ch := execAsync(t, s, blocked, "brpop l1 l2 0")
+	assertReply(t, ":2\r\n", exec(s, c, "rpush l2 a b"))
+	assertReply(t, "*2\r\n$2\r\nl2\r\n$1\r\nb\r\n", receive(t, ch))
+	assertReply(t, "*1\r\n$1\r\na\r\n", exec(s, c, "lrange l2 0 -1"))
+
+	start := time.Now()
+	assertReply(t, "*-1\r\n", exec(s, c, "blpop nokey 0.05"))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestBlockingFIFO(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	first, second := connection.NewFakeConn(), connection.NewFakeConn()
+	ch1 := execAsync(t, s, first, "blpop l 0")
+	ch2 := execAsync(t, s, second, "blpop l 0")
+	// 一次推入多个元素，按阻塞顺序依次服务
+	assertReply(t, ":3\r\n", exec(s, c, "rpush l a b c"))
+	assertReply(t, "*2\r\n$1\r\nl\r\n$1\r\na\r\n", receive(t, ch1))
+	assertReply(t, "*2\r\n$1\r\nl\r\n$1\r\nb\r\n", receive(t, ch2))
+	assertReply(t, ":1\r\n", exec(s, c, "llen l"))
+}
+
+func TestBlockingMove(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	blocked := connection.NewFakeConn()
+	ch := execAsync(t, s, blocked, "blmove src dst right left 0")
+	exec(s, c, "rpush src a b")
+	assertReply(t, "$1\r\nb\r\n", receive(t, ch))
+	assertReply(t, "*1\r\n$1\r\nb\r\n", exec(s, c, "lrange dst 0 -1"))
+	assertReply(t, "$-1\r\n", exec(s, c, "brpoplpush nokey dst 0.01"))
+
+	assertReply(t, "*2\r\n$3\r\nsrc\r\n*1\r\n$1\r\na\r\n", exec(s, c, "lmpop 2 nokey src left count 5"))
+	assertReply(t, "*-1\r\n", exec(s, c, "lmpop 1 src left"))
+	assertReply(t, "-ERR numkeys should be greater than 0\r\n", exec(s, c, "lmpop 0 src left"))
+	assertReply(t, "-ERR count should be greater than 0\r\n", exec(s, c, "lmpop 1 src left count 0"))
+
+	ch = execAsync(t, s, blocked, "blmpop 0 2 l1 l2 right count 2")
+	exec(s, c, "rpush l2 a b c")
+	assertReply(t, "*2\r\n$2\r\nl2\r\n*2\r\n$1\r\nc\r\n$1\r\nb\r\n", receive(t, ch))
+}
+
+func TestBlockingWakeUpByOtherKeyCreation(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	blocked := connection.NewFakeConn()
+	ch := execAsync(t, s, blocked, "blpop l 0")
+	// 键被创建但类型不对时，客户端继续阻塞
+	exec(s, c, "set l v")
+	exec(s, c, "del l")
+	exec(s, c, "rpush tmp a")
+	exec(s, c, "rename tmp l")
+	assertReply(t, "*2\r\n$1\r\nl\r\n$1\r\na\r\n", receive(t, ch))
+}
+
+func TestClientUnblock(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	blocked := connection.NewFakeConn()
+	id := string(exec(s, blocked, "client id").ToBytes()[1:])
+	id = id[:len(id)-2]
+
+	ch := execAsync(t, s, blocked, "blpop l 0")
+	assertReply(t, ":1\r\n", exec(s, c, "client unblock "+id))
+	assertReply(t, "*-1\r\n", receive(t, ch))
+	assertReply(t, ":0\r\n", exec(s, c, "client unblock "+id))
+
+	ch = execAsync(t, s, blocked, "blpop l 0")
+	assertReply(t, ":1\r\n", exec(s, c, "client unblock "+id+" error"))
+	assertReply(t, "-UNBLOCKED client unblocked via CLIENT UNBLOCK\r\n", receive(t, ch))
+
+	// 客户端断开后不再占用等待队列
+	ch = execAsync(t, s, blocked, "blpop l 0")
+	_ = blocked.Close()
+	assertReply(t, "*-1\r\n", receive(t, ch))
+	assertReply(t, ":1\r\n", exec(s, c, "rpush l a"))
+	assertReply(t, ":1\r\n", exec(s, c, "llen l"))
+
+	other := connection.NewFakeConn()
+	ch = execAsync(t, s, other, "blpop nokey 0")
+	s.Close()
+	assertReply(t, "*-1\r\n", receive(t, ch))
+}
+
+func TestClientName(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "$-1\r\n", exec(s, c, "client getname"))
+	assertReply(t, "+OK\r\n", exec(s, c, "client setname worker-1"))
+	assertReply(t, "$8\r\nworker-1\r\n", exec(s, c, "client getname"))
+	assertReply(t, "-ERR unknown subcommand 'foo'. Try CLIENT HELP.\r\n", exec(s, c, "client foo"))
+}
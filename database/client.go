@@ -0,0 +1,87 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerSysCommand("client", execClient, -2, flagNoScript)
+}
+
+// execClient CLIENT <ID | GETNAME | SETNAME | UNBLOCK> [arg ...]
+func execClient(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	sub := strings.ToUpper(string(args[0]))
+	args = args[1:]
+	switch sub {
+	case "ID":
+		if len(args) != 0 {
+			return protocol.NewArgNumErrReply("client|id")
+		}
+		return protocol.NewIntReply(client.ID())
+	case "GETNAME":
+		if len(args) != 0 {
+			return protocol.NewArgNumErrReply("client|getname")
+		}
+		if client.Name() == "" {
+			return protocol.NewNullBulkReply()
+		}
+		return protocol.NewBulkReply([]byte(client.Name()))
+	case "SETNAME":
+		if len(args) != 1 {
+			return protocol.NewArgNumErrReply("client|setname")
+		}
+		return execClientSetName(client, args[0])
+	case "UNBLOCK":
+		if len(args) != 1 && len(args) != 2 {
+			return protocol.NewArgNumErrReply("client|unblock")
+		}
+		return execClientUnblock(s, args)
+	}
+	return protocol.NewErrReply("ERR unknown subcommand '" + strings.ToLower(sub) + "'. Try CLIENT HELP.")
+}
+
+func execClientSetName(client connection.Connection, name []byte) protocol.Reply {
+	// 与redis一致，名字中不能包含空格和换行等特殊字符
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return protocol.NewErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+	}
+	client.SetName(string(name))
+	return protocol.NewOkReply()
+}
+
+// execClientUnblock CLIENT UNBLOCK client-id [TIMEOUT | ERROR]
+func execClientUnblock(s *Server, args [][]byte) protocol.Reply {
+	id, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return protocol.NewNotIntegerErrReply()
+	}
+	withError := false
+	if len(args) == 2 {
+		switch strings.ToUpper(string(args[1])) {
+		case "TIMEOUT":
+		case "ERROR":
+			withError = true
+		default:
+			return protocol.NewErrReply("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+		}
+	}
+
+	val, ok := s.blockedClients.Load(id)
+	if !ok {
+		return protocol.NewIntReply(0)
+	}
+	w := val.(*waiter)
+	var reply protocol.Reply = w.block.timeoutReply
+	if withError {
+		reply = protocol.NewErrReply("UNBLOCKED client unblocked via CLIENT UNBLOCK")
+	}
+	if !w.unblock(reply) {
+		return protocol.NewIntReply(0)
+	}
+	return protocol.NewIntReply(1)
+}
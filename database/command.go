@@ -0,0 +1,100 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerSysCommand("command", execCommand, -1, 0)
+}
+
+// execCommand COMMAND [COUNT | INFO name... | GETKEYS cmd arg... | DOCS]
+func execCommand(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	if len(args) == 0 {
+		names := make([]string, 0, len(cmdTable))
+		for name := range cmdTable {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		replies := make([]protocol.Reply, 0, len(names))
+		for _, name := range names {
+			replies = append(replies, cmdTable[name].toInfoReply())
+		}
+		return protocol.NewArrayReply(replies)
+	}
+
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "count":
+		if len(args) != 1 {
+			return protocol.NewErrReply("ERR unknown subcommand or wrong number of arguments for 'count'")
+		}
+		return protocol.NewIntReply(int64(len(cmdTable)))
+	case "info":
+		replies := make([]protocol.Reply, 0, len(args)-1)
+		for _, name := range args[1:] {
+			if cmd, ok := lookupCommand(string(name)); ok {
+				replies = append(replies, cmd.toInfoReply())
+			} else {
+				replies = append(replies, protocol.NewNullArrayReply())
+			}
+		}
+		return protocol.NewArrayReply(replies)
+	case "getkeys":
+		if len(args) < 2 {
+			return protocol.NewErrReply("ERR unknown subcommand or wrong number of arguments for 'getkeys'")
+		}
+		return commandGetKeys(args[1:])
+	case "docs":
+		// 暂不提供文档，返回空结果保证redis-cli等客户端正常启动
+		return protocol.NewEmptyMultiBulkReply()
+	}
+	return protocol.NewErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try COMMAND HELP.")
+}
+
+func commandGetKeys(cmdLine [][]byte) protocol.Reply {
+	cmd, ok := lookupCommand(string(cmdLine[0]))
+	if !ok {
+		return protocol.NewErrReply("ERR Invalid command specified")
+	}
+	if !cmd.validateArity(cmdLine) {
+		return protocol.NewErrReply("ERR Invalid number of arguments specified for command")
+	}
+	writeKeys, readKeys := cmd.prepareKeys(cmdLine[1:])
+	if len(writeKeys)+len(readKeys) == 0 {
+		return protocol.NewErrReply("ERR The command has no key arguments")
+	}
+
+	keys := make([][]byte, 0, len(writeKeys)+len(readKeys))
+	for _, key := range writeKeys {
+		keys = append(keys, []byte(key))
+	}
+	for _, key := range readKeys {
+		keys = append(keys, []byte(key))
+	}
+	return protocol.NewMultiBulkReply(keys)
+}
+
+func (cmd *command) toInfoReply() protocol.Reply {
+	flags := cmd.flagNames()
+	flagReplies := make([]protocol.Reply, 0, len(flags))
+	for _, flag := range flags {
+		flagReplies = append(flagReplies, protocol.NewStatusReply(flag))
+	}
+	return protocol.NewArrayReply([]protocol.Reply{
+		protocol.NewBulkReply([]byte(cmd.name)),
+		protocol.NewIntReply(int64(cmd.arity)),
+		protocol.NewArrayReply(flagReplies),
+		protocol.NewIntReply(int64(cmd.firstKey)),
+		protocol.NewIntReply(int64(cmd.lastKey)),
+		protocol.NewIntReply(int64(cmd.keyStep)),
+		// acl categories, tips, key specs, subcommands
+		protocol.NewEmptyMultiBulkReply(),
+		protocol.NewEmptyMultiBulkReply(),
+		protocol.NewEmptyMultiBulkReply(),
+		protocol.NewEmptyMultiBulkReply(),
+	})
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandArity(t *testing.T) {
+	s := newTestServer(t)
+	client := connection.NewFakeConn()
+
+	assert.Equal(t, "-ERR wrong number of arguments for 'echo' command\r\n",
+		string(exec(s, client, "echo").ToBytes()))
+	assert.Equal(t, "-ERR unknown command 'foobar'\r\n",
+		string(exec(s, client, "FOOBAR a b").ToBytes()))
+	assert.Equal(t, "$2\r\nhi\r\n", string(exec(s, client, "ECHO hi").ToBytes()))
+}
+
+func TestCommandIntrospection(t *testing.T) {
+	s := newTestServer(t)
+	client := connection.NewFakeConn()
+
+	count, ok := exec(s, client, "command count").(*protocol.IntReply)
+	assert.True(t, ok)
+	assert.Equal(t, int64(len(cmdTable)), count.Value)
+
+	info := exec(s, client, "command info ping nosuchcmd").(*protocol.ArrayReply)
+	assert.Len(t, info.Replies, 2)
+	ping := info.Replies[0].(*protocol.ArrayReply)
+	assert.Equal(t, []byte("ping"), ping.Replies[0].(*protocol.BulkReply).Value)
+	assert.Equal(t, int64(-1), ping.Replies[1].(*protocol.IntReply).Value)
+	assert.Equal(t, "*-1\r\n", string(info.Replies[1].ToBytes()))
+
+	assert.Equal(t, "-ERR The command has no key arguments\r\n",
+		string(exec(s, client, "command getkeys ping").ToBytes()))
+	assert.Equal(t, "-ERR Invalid command specified\r\n",
+		string(exec(s, client, "command getkeys nosuchcmd").ToBytes()))
+}
+
+func TestGetKeysByPosition(t *testing.T) {
+	cmd := &command{name: "test", arity: -3, flags: flagWrite}
+	cmd.keys(1, -2, 2)
+	writeKeys, readKeys := cmd.prepareKeys(toCmdLine("k1", "v1", "k2", "v2", "opt"))
+	assert.Equal(t, []string{"k1", "k2"}, writeKeys)
+	assert.Empty(t, readKeys)
+}
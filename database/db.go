@@ -0,0 +1,322 @@
+package database
+
+import (
+	"godis/aof"
+	"godis/datastruct/dict"
+	"godis/datastruct/hash"
+	"godis/resp/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dataDictSize = 1 << 10
+	ttlDictSize  = 1 << 10
+	// 只保存事务执行期间的key
+	txEventsDictSize = 16
+)
+
+// ExecFunc executes a command on a single keyspace, args excludes the command name.
+// The shards of the keys returned by the command's prepare are locked while it runs,
+// so executors must only use the lock free accessors of DB.
+type ExecFunc func(db *DB, args [][]byte) protocol.Reply
+
+// DB is one of the numbered keyspaces of the server
+type DB struct {
+	index int
+	// 执行命令时持有读锁，替换整个keyspace(FLUSHDB、SWAPDB)时持有写锁
+	stopWorld sync.RWMutex
+	data      *dict.ConcurrentDict
+	// key -> time.Time 过期时间，与data使用各自的分片锁
+	ttlMap *dict.ConcurrentDict
+	// key -> 最后一次修改的版本号，WATCH通过比较版本号发现修改
+	versions *dict.ConcurrentDict
+
+	// 阻塞在该db的key上的客户端
+	blocking *blockingKeys
+	// keyspace事件发布到的频道，为nil时不发布
+	events *pubsubHub
+	// 正在执行的事务修改的key -> 事务的eventBuffer，由key的分片锁保护
+	txEvents *dict.ConcurrentDict
+	// 开启AOF后写命令追加到的文件，为nil时不持久化
+	persister *aof.Persister
+	// AOF重写或BGSAVE时该keyspace的快照，修改key前需要先保存，由stopWorld保护
+	snapshot *keyspaceSnapshot
+	// 上次保存RDB之后的修改次数，所有db共用服务器的计数器
+	dirty *atomic.Int64
+	// 写命令同时发送给从节点，为nil时不复制
+	repl *replication
+}
+
+func newDB(index int, events *pubsubHub, dirty *atomic.Int64, repl *replication) *DB {
+	return &DB{
+		index:    index,
+		events:   events,
+		dirty:    dirty,
+		repl:     repl,
+		data:     dict.NewConcurrentDict(dataDictSize),
+		ttlMap:   dict.NewConcurrentDict(ttlDictSize),
+		versions: dict.NewConcurrentDict(dataDictSize),
+		txEvents: dict.NewConcurrentDict(txEventsDictSize),
+		blocking: newBlockingKeys(),
+	}
+}
+
+func registerCommand(name string, executor ExecFunc, arity int, flags int) *command {
+	name = strings.ToLower(name)
+	cmd := &command{
+		name:     name,
+		executor: executor,
+		arity:    arity,
+		flags:    flags,
+	}
+	cmdTable[name] = cmd
+	return cmd
+}
+
+// execCommand runs a keyspace command with the shards of its keys locked
+func (db *DB) execCommand(cmd *command, args [][]byte) protocol.Reply {
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
+
+	writeKeys, readKeys := cmd.prepareKeys(args)
+	db.data.RWLocks(writeKeys, readKeys)
+	defer db.data.RWUnlocks(writeKeys, readKeys)
+	db.saveForSnapshot(writeKeys)
+	// 写命令持有写锁，执行前先删除已过期的key
+	for _, key := range writeKeys {
+		db.expireIfNeeded(key)
+	}
+	reply := cmd.executor(db, args)
+	db.addVersion(writeKeys...)
+	db.propagate(cmd, args, reply)
+	if cmd.hasFlag(flagReadOnly) {
+		db.notifyKeyMiss(readKeys)
+	}
+	return reply
+}
+
+// notifyKeyMiss publishes keymiss events for the keys a read only command found absent
+func (db *DB) notifyKeyMiss(keys []string) {
+	if notifyFlags()&notifyKeyMiss == 0 {
+		return
+	}
+	for _, key := range keys {
+		if _, ok := db.getEntity(key); !ok {
+			db.notify(notifyKeyMiss, "keymiss", key)
+		}
+	}
+}
+
+// versionCounter generates versions for all dbs, so the versions of a key never repeat
+// even after FLUSHDB or SWAPDB replaced its keyspace
+var versionCounter atomic.Uint64
+
+// addVersion marks keys as modified, the caller must hold the shard locks of keys
+func (db *DB) addVersion(keys ...string) {
+	for _, key := range keys {
+		db.versions.Put(key, versionCounter.Add(1))
+	}
+}
+
+// getVersion returns the version of key, 0 if it has never been modified
+func (db *DB) getVersion(key string) uint64 {
+	version, ok := db.versions.Get(key)
+	if !ok {
+		return 0
+	}
+	return version.(uint64)
+}
+
+/* ---- lock free accessors, callers must hold the shard locks ---- */
+
+// getEntity returns the value of key, expired keys are treated as absent
+func (db *DB) getEntity(key string) (any, bool) {
+	val, ok := db.data.GetWithoutLock(key)
+	if !ok || db.isExpired(key) {
+		return nil, false
+	}
+	// 所有字段都已过期的hash等同于不存在
+	if h, ok := val.(*hash.Hash); ok && h.HasExpires() && h.Len() == 0 {
+		return nil, false
+	}
+	return val, true
+}
+
+// putEntity sets the value of key, clients blocked on a newly created key are signaled
+func (db *DB) putEntity(key string, val any) int {
+	result := db.data.PutWithoutLock(key, val)
+	if result > 0 {
+		db.signalKeyAsReady(key)
+		db.notify(notifyNew, "new", key)
+	}
+	return result
+}
+
+func (db *DB) putIfAbsent(key string, val any) int {
+	result := db.data.PutIfAbsentWithoutLock(key, val)
+	if result > 0 {
+		db.signalKeyAsReady(key)
+		db.notify(notifyNew, "new", key)
+	}
+	return result
+}
+
+func (db *DB) putIfExists(key string, val any) int {
+	return db.data.PutIfExistsWithoutLock(key, val)
+}
+
+func (db *DB) removeKey(key string) (any, bool) {
+	val, ok := db.data.RemoveWithoutLock(key)
+	if ok {
+		db.ttlMap.Remove(key)
+	}
+	return val, ok
+}
+
+/* ---- expiration, callers must hold the shard lock of key ---- */
+
+// expire sets the expire time of key
+func (db *DB) expire(key string, expireAt time.Time) {
+	db.ttlMap.Put(key, expireAt)
+}
+
+// persist removes the expire time of key
+func (db *DB) persist(key string) bool {
+	_, result := db.ttlMap.Remove(key)
+	return result > 0
+}
+
+// expireTime returns the expire time of key, false if key has no expire time
+func (db *DB) expireTime(key string) (time.Time, bool) {
+	raw, ok := db.ttlMap.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return raw.(time.Time), true
+}
+
+func (db *DB) isExpired(key string) bool {
+	if db.ttlMap.Len() == 0 {
+		return false
+	}
+	expireAt, ok := db.expireTime(key)
+	return ok && !time.Now().Before(expireAt)
+}
+
+// expireIfNeeded removes key if it has expired, the shard of key must be write locked.
+// Expired fields of a hash are removed as well, the key is removed with its last field.
+func (db *DB) expireIfNeeded(key string) bool {
+	if db.isExpired(key) {
+		db.removeKey(key)
+		db.addVersion(key)
+		db.notify(notifyExpired, "expired", key)
+		return true
+	}
+	val, ok := db.data.GetWithoutLock(key)
+	if !ok {
+		return false
+	}
+	if h, ok := val.(*hash.Hash); ok && h.RemoveExpired() > 0 {
+		db.addVersion(key)
+		db.notify(notifyHash, "hexpired", key)
+		if h.Len() == 0 {
+			db.removeKey(key)
+			db.notify(notifyGeneric, "del", key)
+			return true
+		}
+	}
+	return false
+}
+
+/* ---- whole keyspace operations ---- */
+
+// flush clears the keyspace in place, blocking commands on this db until done
+func (db *DB) flush() {
+	db.stopWorld.Lock()
+	defer db.stopWorld.Unlock()
+	db.clearKeyspace()
+	db.appendAof(makeCmdLine("flushdb"))
+}
+
+// clearKeyspace removes all keys in place, the caller must hold stopWorld
+func (db *DB) clearKeyspace() {
+	if db.snapshot != nil {
+		// 后台任务还在读取旧的keyspace，只能换成新的字典
+		db.resetKeyspace(newKeyspaceDicts())
+		return
+	}
+	db.data.Clear()
+	db.ttlMap.Clear()
+	db.versions.Clear()
+}
+
+// flushAsync swaps in an empty keyspace and leaves the old one to the gc,
+// so the flush never walks the keys on the request goroutine
+func (db *DB) flushAsync() {
+	data, ttlMap, versions := newKeyspaceDicts()
+	db.stopWorld.Lock()
+	db.resetKeyspace(data, ttlMap, versions)
+	db.appendAof(makeCmdLine("flushdb"))
+	db.stopWorld.Unlock()
+}
+
+// flushLocked removes all keys, the caller must hold stopWorld
+func (db *DB) flushLocked(async bool) {
+	if async {
+		db.resetKeyspace(newKeyspaceDicts())
+		return
+	}
+	db.clearKeyspace()
+}
+
+func newKeyspaceDicts() (data, ttlMap, versions *dict.ConcurrentDict) {
+	return dict.NewConcurrentDict(dataDictSize), dict.NewConcurrentDict(ttlDictSize), dict.NewConcurrentDict(dataDictSize)
+}
+
+// resetKeyspace swaps in empty dicts, the caller must hold stopWorld
+func (db *DB) resetKeyspace(data, ttlMap, versions *dict.ConcurrentDict) {
+	db.data = data
+	db.ttlMap = ttlMap
+	db.versions = versions
+	// 旧的keyspace不会再被修改，不需要为后台任务保存key
+	db.snapshot = nil
+}
+
+func (db *DB) size() int {
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
+	return db.data.Len()
+}
+
+// swapDB exchanges the keyspaces of two dbs
+func swapDB(a, b *DB) {
+	if a == b {
+		return
+	}
+	// 按编号顺序加锁，避免并发SWAPDB死锁
+	first, second := a, b
+	if first.index > second.index {
+		first, second = second, first
+	}
+	first.stopWorld.Lock()
+	defer first.stopWorld.Unlock()
+	second.stopWorld.Lock()
+	defer second.stopWorld.Unlock()
+	swapKeyspaces(a, b)
+	a.appendAof(makeCmdLine("swapdb", []byte(strconv.Itoa(a.index)), []byte(strconv.Itoa(b.index))))
+}
+
+// swapKeyspaces exchanges the keyspaces of two dbs, the caller must hold stopWorld of both
+func swapKeyspaces(a, b *DB) {
+	a.data, b.data = b.data, a.data
+	a.ttlMap, b.ttlMap = b.ttlMap, a.ttlMap
+	a.versions, b.versions = b.versions, a.versions
+	a.snapshot, b.snapshot = b.snapshot, a.snapshot
+	// 交换后等待中的key可能已经有数据
+	a.blocking.signalAll()
+	b.blocking.signalAll()
+}
@@ -0,0 +1,15 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"godis/resp/protocol"
+)
+
+type CmdLine = [][]byte
+
+// Engine executes command lines coming from a client connection
+type Engine interface {
+	Exec(client connection.Connection, cmdLine CmdLine) protocol.Reply
+	AfterClientClose(client connection.Connection)
+	Close()
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"time"
+)
+
+// 与redis activeExpireCycle的参数一致
+const (
+	activeExpireKeysPerLoop      = 20 // 每轮采样的key数量
+	activeExpireAcceptableStale  = 10 // 采样中过期key占比低于该百分比时结束本次清理
+	activeExpireSlowTimePercent  = 25 // 每个周期可用于清理的时间占比
+	activeExpireTimeCheckPerLoop = 16 // 每隔多少轮检查一次时间预算
+)
+
+// expireSample samples keys with an expire time and removes the expired ones
+func (db *DB) expireSample(limit int) (sampled int, expired int) {
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
+
+	keys := db.ttlMap.RandomKeys(limit)
+	for _, key := range keys {
+		lockKeys := []string{key}
+		db.data.RWLocks(lockKeys, nil)
+		db.saveForSnapshot(lockKeys)
+		if db.expireIfNeeded(key) {
+			expired++
+		}
+		db.data.RWUnlocks(lockKeys, nil)
+	}
+	return len(keys), expired
+}
+
+// activeExpireCycle keeps sampling while many of the sampled keys turn out to be expired,
+// returns true if it stopped because the deadline was reached
+func (db *DB) activeExpireCycle(deadline time.Time) bool {
+	for loop := 1; ; loop++ {
+		sampled, expired := db.expireSample(activeExpireKeysPerLoop)
+		if sampled == 0 || expired*100 <= sampled*activeExpireAcceptableStale {
+			return false
+		}
+		if loop%activeExpireTimeCheckPerLoop == 0 && time.Now().After(deadline) {
+			return true
+		}
+	}
+}
+
+// activeExpireCycle removes expired keys that are never accessed again, lazy expiration alone
+// would keep them in memory forever
+func (s *Server) activeExpireCycle(period time.Duration) {
+	deadline := time.Now().Add(period * activeExpireSlowTimePercent / 100)
+	dbNum := len(s.dbSet)
+	// 从上次超时的db继续，保证每个db都有机会被清理
+	for i := 0; i < dbNum; i++ {
+		index := (s.expireCursor + i) % dbNum
+		if s.dbSet[index].activeExpireCycle(deadline) {
+			s.expireCursor = (index + 1) % dbNum
+			return
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package database
+
+import (
+	"fmt"
+	"godis/datastruct/sortedset"
+	"godis/pkg/geohash"
+	"godis/resp/protocol"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerCommand("geoadd", execGeoAdd, -5, flagWrite|flagDenyOOM).keys(1, 1, 1)
+	registerCommand("geopos", execGeoPos, -2, flagReadOnly).keys(1, 1, 1)
+	registerCommand("geodist", execGeoDist, -4, flagReadOnly).keys(1, 1, 1)
+	registerCommand("geohash", execGeoHash, -2, flagReadOnly).keys(1, 1, 1)
+	registerCommand("geosearch", execGeoSearch, -7, flagReadOnly).keys(1, 1, 1)
+	registerCommand("geosearchstore", execGeoSearchStore, -8, flagWrite|flagDenyOOM).withPrepare(prepareZRangeStore)
+}
+
+// 地理位置以52位geohash作为分数存储在有序集合中
+
+// parseLongLat parses a longitude latitude pair and checks the range
+func parseLongLat(lonArg, latArg []byte) (float64, float64, *protocol.ErrReply) {
+	longitude, ok := parseFloat(lonArg)
+	if !ok {
+		return 0, 0, protocol.NewErrReply("ERR value is not a valid float")
+	}
+	latitude, ok := parseFloat(latArg)
+	if !ok {
+		return 0, 0, protocol.NewErrReply("ERR value is not a valid float")
+	}
+	if longitude < geohash.LongMin || longitude > geohash.LongMax || latitude < geohash.LatMin || latitude > geohash.LatMax {
+		return 0, 0, protocol.NewErrReply(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+	}
+	return longitude, latitude, nil
+}
+
+// parseGeoUnit returns the meters of one unit
+func parseGeoUnit(arg []byte) (float64, *protocol.ErrReply) {
+	switch strings.ToLower(string(arg)) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, protocol.NewErrReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+// formatDistance formats a distance with 4 decimals like redis
+func formatDistance(distance float64) []byte {
+	return strconv.AppendFloat(nil, distance, 'f', 4, 64)
+}
+
+func formatCoord(coord float64) []byte {
+	return strconv.AppendFloat(nil, coord, 'g', 17, 64)
+}
+
+func coordsReply(longitude, latitude float64) protocol.Reply {
+	return protocol.NewMultiBulkReply([][]byte{formatCoord(longitude), formatCoord(latitude)})
+}
+
+// execGeoAdd GEOADD key [NX | XX] [CH] longitude latitude member [longitude latitude member ...]
+// is translated to ZADD with the geohash of every location as score, like redis does
+func execGeoAdd(db *DB, args [][]byte) protocol.Reply {
+	i := 1
+	nx, xx := false, false
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+		default:
+			break options
+		}
+	}
+	if (len(args)-i)%3 != 0 || (nx && xx) {
+		return protocol.NewSyntaxErrReply()
+	}
+
+	zaddArgs := make([][]byte, 0, i+(len(args)-i)/3*2)
+	zaddArgs = append(zaddArgs, args[:i]...)
+	for j := i; j < len(args); j += 3 {
+		longitude, latitude, errReply := parseLongLat(args[j], args[j+1])
+		if errReply != nil {
+			return errReply
+		}
+		hash, _ := geohash.Encode(longitude, latitude, geohash.StepMax)
+		score := strconv.FormatUint(hash.Align52(), 10)
+		zaddArgs = append(zaddArgs, []byte(score), args[j+2])
+	}
+	return execZAdd(db, zaddArgs)
+}
+
+// execGeoPos GEOPOS key [member ...]
+func execGeoPos(db *DB, args [][]byte) protocol.Reply {
+	z, errReply := db.getAsZSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	replies := make([]protocol.Reply, 0, len(args)-1)
+	for _, member := range args[1:] {
+		if z == nil {
+			replies = append(replies, protocol.NewNullArrayReply())
+			continue
+		}
+		score, ok := z.Get(string(member))
+		if !ok {
+			replies = append(replies, protocol.NewNullArrayReply())
+			continue
+		}
+		replies = append(replies, coordsReply(geohash.DecodeScore(score)))
+	}
+	return protocol.NewArrayReply(replies)
+}
+
+// execGeoDist GEODIST key member1 member2 [M | KM | FT | MI]
+func execGeoDist(db *DB, args [][]byte) protocol.Reply {
+	conversion := 1.0
+	switch len(args) {
+	case 3:
+	case 4:
+		var errReply *protocol.ErrReply
+		if conversion, errReply = parseGeoUnit(args[3]); errReply != nil {
+			return errReply
+		}
+	default:
+		return protocol.NewSyntaxErrReply()
+	}
+	z, errReply := db.getAsZSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return protocol.NewNullBulkReply()
+	}
+	score1, ok1 := z.Get(string(args[1]))
+	score2, ok2 := z.Get(string(args[2]))
+	if !ok1 || !ok2 {
+		return protocol.NewNullBulkReply()
+	}
+	lon1, lat1 := geohash.DecodeScore(score1)
+	lon2, lat2 := geohash.DecodeScore(score2)
+	return protocol.NewBulkReply(formatDistance(geohash.Distance(lon1, lat1, lon2, lat2) / conversion))
+}
+
+// execGeoHash GEOHASH key [member ...]
+func execGeoHash(db *DB, args [][]byte) protocol.Reply {
+	z, errReply := db.getAsZSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	replies := make([]protocol.Reply, 0, len(args)-1)
+	for _, member := range args[1:] {
+		if z == nil {
+			replies = append(replies, protocol.NewNullBulkReply())
+			continue
+		}
+		score, ok := z.Get(string(member))
+		if !ok {
+			replies = append(replies, protocol.NewNullBulkReply())
+			continue
+		}
+		replies = append(replies, protocol.NewBulkReply([]byte(geohash.String(score))))
+	}
+	return protocol.NewArrayReply(replies)
+}
+
+const (
+	geoSortNone = iota
+	geoSortAsc
+	geoSortDesc
+)
+
+type geoSearchSpec struct {
+	fromMember []byte
+	fromLonLat bool
+	shape      geohash.Shape
+	hasShape   bool
+	// 距离单位对应的米数
+	conversion float64
+
+	sort  int
+	count int
+	any   bool
+
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	storeDist bool
+}
+
+// parseGeoSearchArgs parses the options of GEOSEARCH and GEOSEARCHSTORE after the source key
+func parseGeoSearchArgs(cmdName string, args [][]byte, store bool) (*geoSearchSpec, *protocol.ErrReply) {
+	spec := &geoSearchSpec{}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "withdist":
+			spec.withDist = true
+		case "withhash":
+			spec.withHash = true
+		case "withcoord":
+			spec.withCoord = true
+		case "any":
+			spec.any = true
+		case "asc":
+			spec.sort = geoSortAsc
+		case "desc":
+			spec.sort = geoSortDesc
+		case "storedist":
+			if !store {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			spec.storeDist = true
+		case "count":
+			if i+1 >= len(args) {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			count, ok := parseInt(args[i+1])
+			if !ok {
+				return nil, protocol.NewNotIntegerErrReply()
+			}
+			if count <= 0 {
+				return nil, protocol.NewErrReply("ERR COUNT must be > 0")
+			}
+			spec.count = int(min(count, math.MaxInt32))
+			i++
+		case "frommember":
+			if i+1 >= len(args) || spec.fromMember != nil || spec.fromLonLat {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			spec.fromMember = args[i+1]
+			i++
+		case "fromlonlat":
+			if i+2 >= len(args) || spec.fromMember != nil || spec.fromLonLat {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			longitude, latitude, errReply := parseLongLat(args[i+1], args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			spec.shape.Longitude, spec.shape.Latitude = longitude, latitude
+			spec.fromLonLat = true
+			i += 2
+		case "byradius":
+			if i+2 >= len(args) || spec.hasShape {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			radius, ok := parseFloat(args[i+1])
+			if !ok {
+				return nil, protocol.NewErrReply("ERR need numeric radius")
+			}
+			if radius < 0 {
+				return nil, protocol.NewErrReply("ERR radius cannot be negative")
+			}
+			conversion, errReply := parseGeoUnit(args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			spec.shape.Radius = radius * conversion
+			spec.conversion = conversion
+			spec.hasShape = true
+			i += 2
+		case "bybox":
+			if i+3 >= len(args) || spec.hasShape {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			width, ok := parseFloat(args[i+1])
+			if !ok {
+				return nil, protocol.NewErrReply("ERR need numeric width")
+			}
+			height, ok := parseFloat(args[i+2])
+			if !ok {
+				return nil, protocol.NewErrReply("ERR need numeric height")
+			}
+			if width < 0 || height < 0 {
+				return nil, protocol.NewErrReply("ERR height or width cannot be negative")
+			}
+			conversion, errReply := parseGeoUnit(args[i+3])
+			if errReply != nil {
+				return nil, errReply
+			}
+			spec.shape.IsBox = true
+			spec.shape.Width, spec.shape.Height = width*conversion, height*conversion
+			spec.conversion = conversion
+			spec.hasShape = true
+			i += 3
+		default:
+			return nil, protocol.NewSyntaxErrReply()
+		}
+	}
+
+	if store && (spec.withDist || spec.withHash || spec.withCoord) {
+		return nil, protocol.NewErrReply("ERR STORE option in " + cmdName + " is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+	}
+	if spec.fromMember == nil && !spec.fromLonLat {
+		return nil, protocol.NewErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+	}
+	if !spec.hasShape {
+		return nil, protocol.NewErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+	}
+	if spec.any && spec.count == 0 {
+		return nil, protocol.NewErrReply("ERR the ANY argument requires COUNT argument")
+	}
+	// 与redis相同，指定COUNT而未指定排序时按距离升序，以返回最近的位置
+	if spec.count > 0 && spec.sort == geoSortNone && !spec.any {
+		spec.sort = geoSortAsc
+	}
+	return spec, nil
+}
+
+type geoPoint struct {
+	member    string
+	score     float64
+	longitude float64
+	latitude  float64
+	// 以请求的单位表示的距离
+	dist float64
+}
+
+// geoSearch returns the members of z inside the shape, searching the cell of the center
+// and its neighbors by score ranges
+func geoSearch(z *sortedset.SortedSet, spec *geoSearchSpec) []geoPoint {
+	var points []geoPoint
+	areas := spec.shape.SearchAreas()
+	last := -1
+	for i, area := range areas {
+		if area.IsZero() {
+			continue
+		}
+		// 半径很大时相邻的区域可能相同，跳过与上一个区域相同的区域避免重复
+		if last >= 0 && area == areas[last] {
+			continue
+		}
+		if spec.any && len(points) >= spec.count {
+			break
+		}
+		last = i
+		minScore, maxScore := area.ScoreRange()
+		start, stop := z.ScoreRange(sortedset.ScoreBorder{Value: minScore}, sortedset.ScoreBorder{Value: maxScore, Exclude: true})
+		z.ForEachInRange(start, stop, false, func(e sortedset.Element) bool {
+			longitude, latitude := geohash.DecodeScore(e.Score)
+			distance, ok := spec.shape.Contains(longitude, latitude)
+			if !ok {
+				return true
+			}
+			points = append(points, geoPoint{
+				member:    e.Member,
+				score:     e.Score,
+				longitude: longitude,
+				latitude:  latitude,
+				dist:      distance / spec.conversion,
+			})
+			return !spec.any || len(points) < spec.count
+		})
+	}
+
+	switch spec.sort {
+	case geoSortAsc:
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].dist < points[j].dist
+		})
+	case geoSortDesc:
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].dist > points[j].dist
+		})
+	}
+	if spec.count > 0 && len(points) > spec.count {
+		points = points[:spec.count]
+	}
+	return points
+}
+
+// geoSearchGeneric looks up the source sorted set and the center, returns nil points if the source doesn't exist
+func geoSearchGeneric(db *DB, cmdName string, src string, args [][]byte, store bool) ([]geoPoint, *geoSearchSpec, *protocol.ErrReply) {
+	spec, errReply := parseGeoSearchArgs(cmdName, args, store)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	z, errReply := db.getAsZSet(src)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if spec.fromMember != nil {
+		var score float64
+		ok := false
+		if z != nil {
+			score, ok = z.Get(string(spec.fromMember))
+		}
+		if !ok {
+			return nil, nil, protocol.NewErrReply("ERR could not decode requested zset member")
+		}
+		spec.shape.Longitude, spec.shape.Latitude = geohash.DecodeScore(score)
+	}
+	if z == nil {
+		return nil, spec, nil
+	}
+	return geoSearch(z, spec), spec, nil
+}
+
+// execGeoSearch GEOSEARCH key <FROMMEMBER member | FROMLONLAT longitude latitude>
+// <BYRADIUS radius <M | KM | FT | MI> | BYBOX width height <M | KM | FT | MI>>
+// [ASC | DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func execGeoSearch(db *DB, args [][]byte) protocol.Reply {
+	points, spec, errReply := geoSearchGeneric(db, "geosearch", string(args[0]), args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	withOptions := spec.withDist || spec.withHash || spec.withCoord
+	replies := make([]protocol.Reply, 0, len(points))
+	for _, p := range points {
+		if !withOptions {
+			replies = append(replies, protocol.NewBulkReply([]byte(p.member)))
+			continue
+		}
+		item := []protocol.Reply{protocol.NewBulkReply([]byte(p.member))}
+		if spec.withDist {
+			item = append(item, protocol.NewBulkReply(formatDistance(p.dist)))
+		}
+		if spec.withHash {
+			item = append(item, protocol.NewIntReply(int64(p.score)))
+		}
+		if spec.withCoord {
+			item = append(item, coordsReply(p.longitude, p.latitude))
+		}
+		replies = append(replies, protocol.NewArrayReply(item))
+	}
+	return protocol.NewArrayReply(replies)
+}
+
+// execGeoSearchStore GEOSEARCHSTORE destination source <FROMMEMBER member | FROMLONLAT longitude latitude>
+// <BYRADIUS radius <M | KM | FT | MI> | BYBOX width height <M | KM | FT | MI>>
+// [ASC | DESC] [COUNT count [ANY]] [STOREDIST]
+func execGeoSearchStore(db *DB, args [][]byte) protocol.Reply {
+	points, spec, errReply := geoSearchGeneric(db, "geosearchstore", string(args[1]), args[2:], true)
+	if errReply != nil {
+		return errReply
+	}
+	elements := make([]sortedset.Element, len(points))
+	for i, p := range points {
+		elements[i] = sortedset.Element{Member: p.member, Score: p.score}
+		if spec.storeDist {
+			elements[i].Score = p.dist
+		}
+	}
+	return storeZSet(db, string(args[0]), elements, "geosearchstore")
+}
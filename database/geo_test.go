@@ -0,0 +1,111 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"testing"
+)
+
+func addSicily(s *Server, c *connection.FakeConn) {
+	exec(s, c, "geoadd Sicily 13.361389 38.115556 Palermo 15.087269 37.502669 Catania")
+	exec(s, c, "geoadd Sicily 12.758489 38.788135 edge1 17.241510 38.788135 edge2")
+}
+
+func TestGeoAdd(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, ":2\r\n", exec(s, c, "geoadd Sicily 13.361389 38.115556 Palermo 15.087269 37.502669 Catania"))
+	assertReply(t, ":0\r\n", exec(s, c, "geoadd Sicily 13.361389 38.115556 Palermo"))
+	assertReply(t, ":1\r\n", exec(s, c, "geoadd Sicily ch 13.4 38.115556 Palermo"))
+	assertReply(t, ":0\r\n", exec(s, c, "geoadd Sicily xx 1 1 nobody"))
+	assertReply(t, ":0\r\n", exec(s, c, "geoadd Sicily nx 1 1 Palermo"))
+	assertReply(t, ":2\r\n", exec(s, c, "zcard Sicily"))
+	assertReply(t, "$16\r\n3479447370796909\r\n", exec(s, c, "zscore Sicily Catania"))
+
+	assertReply(t, "-ERR invalid longitude,latitude pair 200.000000,100.000000\r\n", exec(s, c, "geoadd Sicily 200 100 bad"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "geoadd Sicily 1 1 a 2"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "geoadd Sicily nx xx 1 1 a"))
+	assertReply(t, "-ERR value is not a valid float\r\n", exec(s, c, "geoadd Sicily a 1 a"))
+	exec(s, c, "set str foo")
+	assertReply(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "geoadd str 1 1 a"))
+}
+
+func TestGeoPosDistHash(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	addSicily(s, c)
+
+	assertReply(t, "*3\r\n*2\r\n$18\r\n13.361389338970184\r\n$18\r\n38.115556395496299\r\n*2\r\n$18\r\n15.087267458438873\r\n$17\r\n37.50266842333162\r\n*-1\r\n",
+		exec(s, c, "geopos Sicily Palermo Catania NonExisting"))
+	assertReply(t, "*1\r\n*-1\r\n", exec(s, c, "geopos nokey a"))
+
+	assertReply(t, "$11\r\n166274.1516\r\n", exec(s, c, "geodist Sicily Palermo Catania"))
+	assertReply(t, "$8\r\n166.2742\r\n", exec(s, c, "geodist Sicily Palermo Catania km"))
+	assertReply(t, "$8\r\n103.3182\r\n", exec(s, c, "geodist Sicily Palermo Catania mi"))
+	assertReply(t, "$-1\r\n", exec(s, c, "geodist Sicily Palermo Nobody"))
+	assertReply(t, "$-1\r\n", exec(s, c, "geodist nokey a b"))
+	assertReply(t, "-ERR unsupported unit provided. please use M, KM, FT, MI\r\n", exec(s, c, "geodist Sicily Palermo Catania yd"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "geodist Sicily Palermo Catania km m"))
+
+	assertReply(t, "*3\r\n$11\r\nsqc8b49rny0\r\n$11\r\nsqdtr74hyu0\r\n$-1\r\n", exec(s, c, "geohash Sicily Palermo Catania Nobody"))
+	assertReply(t, "*1\r\n$-1\r\n", exec(s, c, "geohash nokey a"))
+}
+
+func TestGeoSearch(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	addSicily(s, c)
+
+	assertReply(t, "*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n", exec(s, c, "geosearch Sicily fromlonlat 15 37 byradius 200 km asc"))
+	assertReply(t, "*2\r\n$7\r\nPalermo\r\n$7\r\nCatania\r\n", exec(s, c, "geosearch Sicily fromlonlat 15 37 byradius 200 km desc"))
+	assertReply(t, "*4\r\n"+
+		"*3\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n*2\r\n$18\r\n15.087267458438873\r\n$17\r\n37.50266842333162\r\n"+
+		"*3\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n*2\r\n$18\r\n13.361389338970184\r\n$18\r\n38.115556395496299\r\n"+
+		"*3\r\n$5\r\nedge2\r\n$8\r\n279.7403\r\n*2\r\n$18\r\n17.241510450839996\r\n$18\r\n38.788134516242252\r\n"+
+		"*3\r\n$5\r\nedge1\r\n$8\r\n279.7405\r\n*2\r\n$17\r\n12.75848776102066\r\n$18\r\n38.788134516242252\r\n",
+		exec(s, c, "geosearch Sicily fromlonlat 15 37 bybox 400 400 km asc withcoord withdist"))
+
+	// COUNT未指定排序时返回最近的位置
+	assertReply(t, "*1\r\n$7\r\nCatania\r\n", exec(s, c, "geosearch Sicily fromlonlat 15 37 byradius 1000 km count 1"))
+	assertReply(t, "*1\r\n*2\r\n$7\r\nCatania\r\n:3479447370796909\r\n", exec(s, c, "geosearch Sicily fromlonlat 15 37 byradius 100 km withhash"))
+	assertReply(t, "*3\r\n$7\r\nPalermo\r\n$5\r\nedge1\r\n$7\r\nCatania\r\n", exec(s, c, "geosearch Sicily frommember Palermo byradius 200 km asc"))
+	assertReply(t, "*1\r\n$7\r\nPalermo\r\n", exec(s, c, "geosearch Sicily frommember Palermo bybox 10 10 km"))
+
+	reply := exec(s, c, "geosearch Sicily fromlonlat 15 37 byradius 1000 km count 2 any")
+	if len(reply.ToBytes()) == 0 || reply.ToBytes()[1] != '2' {
+		t.Errorf("expected 2 results, got %q", reply.ToBytes())
+	}
+
+	assertReply(t, "*0\r\n", exec(s, c, "geosearch nokey fromlonlat 15 37 byradius 200 km"))
+	assertReply(t, "-ERR could not decode requested zset member\r\n", exec(s, c, "geosearch Sicily frommember Nobody byradius 200 km"))
+	assertReply(t, "-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch\r\n", exec(s, c, "geosearch Sicily byradius 200 km asc withdist"))
+	assertReply(t, "-ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch\r\n", exec(s, c, "geosearch Sicily fromlonlat 15 37 asc withdist"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "geosearch Sicily fromlonlat 15 37 byradius 200 km bybox 1 1 km"))
+	assertReply(t, "-ERR the ANY argument requires COUNT argument\r\n", exec(s, c, "geosearch Sicily fromlonlat 15 37 byradius 200 km any"))
+	assertReply(t, "-ERR COUNT must be > 0\r\n", exec(s, c, "geosearch Sicily fromlonlat 15 37 byradius 200 km count 0"))
+	assertReply(t, "-ERR radius cannot be negative\r\n", exec(s, c, "geosearch Sicily fromlonlat 15 37 byradius -1 km"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "geosearch Sicily fromlonlat 15 37 byradius 200 km storedist"))
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	addSicily(s, c)
+
+	assertReply(t, ":2\r\n", exec(s, c, "geosearchstore dest Sicily fromlonlat 15 37 byradius 200 km asc"))
+	assertReply(t, "*2\r\n$7\r\nPalermo\r\n$7\r\nCatania\r\n", exec(s, c, "zrange dest 0 -1"))
+	assertReply(t, "$11\r\n166274.1516\r\n", exec(s, c, "geodist dest Palermo Catania"))
+
+	assertReply(t, ":2\r\n", exec(s, c, "geosearchstore dest Sicily fromlonlat 15 37 byradius 200 km count 2 storedist"))
+	assertReply(t, "*4\r\n$7\r\nCatania\r\n$16\r\n56.4412578701582\r\n$7\r\nPalermo\r\n$17\r\n190.4424298477578\r\n", exec(s, c, "zrange dest 0 -1 withscores"))
+
+	// 没有结果或源key不存在时删除目标key
+	assertReply(t, ":0\r\n", exec(s, c, "geosearchstore dest Sicily fromlonlat 0 0 byradius 1 km"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists dest"))
+	exec(s, c, "geosearchstore dest Sicily fromlonlat 15 37 byradius 200 km")
+	assertReply(t, ":0\r\n", exec(s, c, "geosearchstore dest nokey fromlonlat 15 37 byradius 200 km"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists dest"))
+
+	assertReply(t, "-ERR STORE option in geosearchstore is not compatible with WITHDIST, WITHHASH and WITHCOORD options\r\n",
+		exec(s, c, "geosearchstore dest Sicily fromlonlat 15 37 byradius 200 km withdist"))
+}
@@ -0,0 +1,664 @@
+package database
+
+import (
+	"godis/config"
+	"godis/datastruct/hash"
+	"godis/resp/protocol"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand("hset", execHSet, -4, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("hmset", execHMSet, -4, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("hsetnx", execHSetNX, 4, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("hget", execHGet, 3, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("hmget", execHMGet, -3, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("hdel", execHDel, -3, flagWrite|flagFast).keys(1, 1, 1)
+	registerCommand("hexists", execHExists, 3, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("hlen", execHLen, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("hstrlen", execHStrLen, 3, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("hkeys", execHKeys, 2, flagReadOnly).keys(1, 1, 1)
+	registerCommand("hvals", execHVals, 2, flagReadOnly).keys(1, 1, 1)
+	registerCommand("hgetall", execHGetAll, 2, flagReadOnly).keys(1, 1, 1)
+	registerCommand("hincrby", execHIncrBy, 4, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("hincrbyfloat", execHIncrByFloat, 4, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("hrandfield", execHRandField, -2, flagReadOnly).keys(1, 1, 1)
+	registerCommand("hscan", execHScan, -3, flagReadOnly).keys(1, 1, 1)
+	registerCommand("hexpire", execHExpire, -6, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1).withAof(aofHExpire)
+	registerCommand("hpexpire", execHPExpire, -6, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1).withAof(aofHExpire)
+	registerCommand("hexpireat", execHExpireAt, -6, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1).withAof(aofHExpire)
+	registerCommand("hpexpireat", execHPExpireAt, -6, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1).withAof(aofHExpire)
+	registerCommand("httl", execHTTL, -5, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("hpttl", execHPTTL, -5, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("hexpiretime", execHExpireTime, -5, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("hpexpiretime", execHPExpireTime, -5, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("hpersist", execHPersist, -5, flagWrite|flagFast).keys(1, 1, 1)
+}
+
+// getAsHash returns the hash of key, nil if key doesn't exist
+func (db *DB) getAsHash(key string) (*hash.Hash, *protocol.ErrReply) {
+	entity, ok := db.getEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	h, ok := entity.(*hash.Hash)
+	if !ok {
+		return nil, protocol.NewWrongTypeErrReply()
+	}
+	return h, nil
+}
+
+// getOrInitHash returns the hash of key, an empty hash is created if key doesn't exist
+func (db *DB) getOrInitHash(key string) (*hash.Hash, *protocol.ErrReply) {
+	h, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if h == nil {
+		h = hash.New(config.Properties.HashMaxListpackEntries, config.Properties.HashMaxListpackValue)
+		db.putEntity(key, h)
+	}
+	return h, nil
+}
+
+// removeIfEmptyHash deletes key once its hash has no fields left
+func (db *DB) removeIfEmptyHash(key string, h *hash.Hash) {
+	if h.Len() == 0 {
+		db.removeKey(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+}
+
+// execHSet HSET key field value [field value ...]
+func execHSet(db *DB, args [][]byte) protocol.Reply {
+	if len(args)%2 != 1 {
+		return protocol.NewArgNumErrReply("hset")
+	}
+	h, errReply := db.getOrInitHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	created := 0
+	for i := 1; i < len(args); i += 2 {
+		if h.Set(string(args[i]), args[i+1]) {
+			created++
+		}
+	}
+	db.notify(notifyHash, "hset", string(args[0]))
+	return protocol.NewIntReply(int64(created))
+}
+
+// execHMSet HMSET key field value [field value ...]
+func execHMSet(db *DB, args [][]byte) protocol.Reply {
+	if len(args)%2 != 1 {
+		return protocol.NewArgNumErrReply("hmset")
+	}
+	if reply := execHSet(db, args); protocol.IsErrorReply(reply) {
+		return reply
+	}
+	return protocol.NewOkReply()
+}
+
+// execHSetNX HSETNX key field value
+func execHSetNX(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getOrInitHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	field := string(args[1])
+	if _, ok := h.Get(field); ok {
+		return protocol.NewIntReply(0)
+	}
+	h.Set(field, args[2])
+	db.notify(notifyHash, "hset", string(args[0]))
+	return protocol.NewIntReply(1)
+}
+
+// execHGet HGET key field
+func execHGet(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.NewNullBulkReply()
+	}
+	val, ok := h.Get(string(args[1]))
+	if !ok {
+		return protocol.NewNullBulkReply()
+	}
+	return protocol.NewBulkReply(val)
+}
+
+// execHMGet HMGET key field [field ...]
+func execHMGet(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(args)-1)
+	if h != nil {
+		for i, field := range args[1:] {
+			result[i], _ = h.Get(string(field))
+		}
+	}
+	return protocol.NewMultiBulkReply(result)
+}
+
+// execHDel HDEL key field [field ...]
+func execHDel(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	h, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.NewIntReply(0)
+	}
+	deleted := 0
+	for _, field := range args[1:] {
+		if h.Remove(string(field)) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		db.notify(notifyHash, "hdel", key)
+	}
+	db.removeIfEmptyHash(key, h)
+	return protocol.NewIntReply(int64(deleted))
+}
+
+// execHExists HEXISTS key field
+func execHExists(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.NewIntReply(0)
+	}
+	if _, ok := h.Get(string(args[1])); !ok {
+		return protocol.NewIntReply(0)
+	}
+	return protocol.NewIntReply(1)
+}
+
+// execHLen HLEN key
+func execHLen(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.NewIntReply(0)
+	}
+	return protocol.NewIntReply(int64(h.Len()))
+}
+
+// execHStrLen HSTRLEN key field
+func execHStrLen(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.NewIntReply(0)
+	}
+	val, _ := h.Get(string(args[1]))
+	return protocol.NewIntReply(int64(len(val)))
+}
+
+// hashEntries returns the fields and/or values of key as a flat array
+func hashEntries(db *DB, key string, withFields, withValues bool) protocol.Reply {
+	h, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.NewEmptyMultiBulkReply()
+	}
+	result := make([][]byte, 0, h.Len())
+	h.ForEach(func(field string, val []byte) bool {
+		if withFields {
+			result = append(result, []byte(field))
+		}
+		if withValues {
+			result = append(result, val)
+		}
+		return true
+	})
+	return protocol.NewMultiBulkReply(result)
+}
+
+// execHKeys HKEYS key
+func execHKeys(db *DB, args [][]byte) protocol.Reply {
+	return hashEntries(db, string(args[0]), true, false)
+}
+
+// execHVals HVALS key
+func execHVals(db *DB, args [][]byte) protocol.Reply {
+	return hashEntries(db, string(args[0]), false, true)
+}
+
+// execHGetAll HGETALL key
+func execHGetAll(db *DB, args [][]byte) protocol.Reply {
+	return hashEntries(db, string(args[0]), true, true)
+}
+
+// execHIncrBy HINCRBY key field increment
+func execHIncrBy(db *DB, args [][]byte) protocol.Reply {
+	delta, ok := parseInt(args[2])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	h, errReply := db.getOrInitHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	field := string(args[1])
+	var n int64
+	if val, exists := h.Get(field); exists {
+		n, ok = parseInt(val)
+		if !ok {
+			return protocol.NewErrReply("ERR hash value is not an integer")
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return protocol.NewErrReply("ERR increment or decrement would overflow")
+	}
+	n += delta
+	// 与redis相同，自增不影响字段的过期时间
+	h.SetKeepTTL(field, []byte(strconv.FormatInt(n, 10)))
+	db.notify(notifyHash, "hincrby", string(args[0]))
+	return protocol.NewIntReply(n)
+}
+
+// execHIncrByFloat HINCRBYFLOAT key field increment
+func execHIncrByFloat(db *DB, args [][]byte) protocol.Reply {
+	delta, ok := parseFloat(args[2])
+	if !ok {
+		return protocol.NewErrReply("ERR value is not a valid float")
+	}
+	h, errReply := db.getOrInitHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	field := string(args[1])
+	var f float64
+	if val, exists := h.Get(field); exists {
+		f, ok = parseFloat(val)
+		if !ok {
+			return protocol.NewErrReply("ERR hash value is not a float")
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return protocol.NewErrReply("ERR increment would produce NaN or Infinity")
+	}
+	result := []byte(formatFloat(f))
+	h.SetKeepTTL(field, result)
+	db.notify(notifyHash, "hincrbyfloat", string(args[0]))
+	return protocol.NewBulkReply(result)
+}
+
+// execHRandField HRANDFIELD key [count [WITHVALUES]]
+func execHRandField(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 3 {
+		return protocol.NewSyntaxErrReply()
+	}
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 1 {
+		if h == nil {
+			return protocol.NewNullBulkReply()
+		}
+		field, _ := h.RandomField()
+		return protocol.NewBulkReply([]byte(field))
+	}
+
+	count, ok := parseInt(args[1])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	withValues := false
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHVALUES" {
+			return protocol.NewSyntaxErrReply()
+		}
+		withValues = true
+	}
+	if count < -math.MaxInt64/2 {
+		return protocol.NewErrReply("ERR value is out of range")
+	}
+	if h == nil || count == 0 {
+		return protocol.NewEmptyMultiBulkReply()
+	}
+
+	var fields []string
+	if count > 0 {
+		fields = h.RandomDistinctFields(int(min(count, int64(h.Len()))))
+	} else {
+		// 负数时允许重复
+		fields = make([]string, -count)
+		for i := range fields {
+			fields[i], _ = h.RandomField()
+		}
+	}
+	result := make([][]byte, 0, len(fields)*2)
+	for _, field := range fields {
+		result = append(result, []byte(field))
+		if withValues {
+			val, _ := h.Get(field)
+			result = append(result, val)
+		}
+	}
+	return protocol.NewMultiBulkReply(result)
+}
+
+// execHScan HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func execHScan(db *DB, args [][]byte) protocol.Reply {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.NewErrReply("ERR invalid cursor")
+	}
+	pattern, count, noValues, errReply := parseScanOptions(args[2:], true)
+	if errReply != nil {
+		return errReply
+	}
+
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	var fields []string
+	next := 0
+	if h != nil {
+		fields, next = h.Scan(int(cursor), count, pattern)
+		if next < 0 {
+			return protocol.NewErrReply("ERR illegal wildcard")
+		}
+	}
+	result := make([][]byte, 0, len(fields)*2)
+	for _, field := range fields {
+		result = append(result, []byte(field))
+		if !noValues {
+			val, _ := h.Get(field)
+			result = append(result, val)
+		}
+	}
+	return protocol.NewArrayReply([]protocol.Reply{
+		protocol.NewBulkReply([]byte(strconv.Itoa(next))),
+		protocol.NewMultiBulkReply(result),
+	})
+}
+
+// parseFields parses FIELDS numfields field [field ...] at the end of args
+func parseFields(args [][]byte) ([]string, *protocol.ErrReply) {
+	if len(args) < 2 || strings.ToUpper(string(args[0])) != "FIELDS" {
+		return nil, protocol.NewErrReply("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, ok := parseInt(args[1])
+	if !ok {
+		return nil, protocol.NewNotIntegerErrReply()
+	}
+	if n <= 0 {
+		return nil, protocol.NewErrReply("ERR Parameter `numFields` should be greater than 0")
+	}
+	if n != int64(len(args)-2) {
+		return nil, protocol.NewErrReply("ERR The `numfields` parameter must match the number of arguments")
+	}
+	fields := make([]string, n)
+	for i := range fields {
+		fields[i] = string(args[i+2])
+	}
+	return fields, nil
+}
+
+// 字段过期时间的上限，与redis相同为2^48-1毫秒
+const maxFieldExpireTime = 1<<48 - 1
+
+// 字段过期命令对每个字段的返回值
+const (
+	fieldNotExists     = -2
+	fieldNoTTL         = -1
+	fieldConditionFail = 0
+	fieldTTLSet        = 1
+	fieldDeleted       = 2
+)
+
+// hexpireGeneric HEXPIRE key time [NX | XX | GT | LT] FIELDS numfields field [field ...],
+// the time argument is scaled to milliseconds by unit and offset by now when relative is set
+func hexpireGeneric(db *DB, cmdName string, args [][]byte, unit int64, relative bool) protocol.Reply {
+	n, ok := parseInt(args[1])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	rest := args[2:]
+	flags := 0
+	if strings.ToUpper(string(rest[0])) != "FIELDS" {
+		var errReply *protocol.ErrReply
+		flags, errReply = parseExpireFlags(rest[:1])
+		if errReply != nil {
+			return errReply
+		}
+		rest = rest[1:]
+	}
+	fields, errReply := parseFields(rest)
+	if errReply != nil {
+		return errReply
+	}
+
+	if n < 0 {
+		return protocol.NewErrReply("ERR invalid expire time, must be >= 0")
+	}
+	invalid := protocol.NewErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	if n > maxFieldExpireTime/unit {
+		return invalid
+	}
+	now := time.Now().UnixMilli()
+	expireAt := n * unit
+	if relative {
+		expireAt += now
+	}
+	if expireAt > maxFieldExpireTime {
+		return invalid
+	}
+
+	key := string(args[0])
+	h, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]protocol.Reply, len(fields))
+	updated, deleted := false, false
+	for i, field := range fields {
+		n := hexpireField(h, field, expireAt, now, flags)
+		updated = updated || n == fieldTTLSet
+		deleted = deleted || n == fieldDeleted
+		result[i] = protocol.NewIntReply(n)
+	}
+	if updated {
+		db.notify(notifyHash, "hexpire", key)
+	}
+	if deleted {
+		db.notify(notifyHash, "hdel", key)
+	}
+	if h != nil {
+		db.removeIfEmptyHash(key, h)
+	}
+	return protocol.NewArrayReply(result)
+}
+
+// aofHExpire writes the fields whose expire time was set as one absolute HPEXPIREAT
+// and the fields a time in the past deleted as HDEL
+func aofHExpire(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+	result, ok := reply.(*protocol.ArrayReply)
+	if !ok {
+		return nil
+	}
+	// 字段列表位于参数末尾，与回复一一对应
+	fields := args[len(args)-len(result.Replies):]
+	h, _ := db.getAsHash(string(args[0]))
+	var expireAt int64
+	var updated, deleted [][]byte
+	for i, r := range result.Replies {
+		switch r.(*protocol.IntReply).Value {
+		case fieldTTLSet:
+			updated = append(updated, fields[i])
+			expireAt, _ = h.ExpireTime(string(fields[i]))
+		case fieldDeleted:
+			deleted = append(deleted, fields[i])
+		}
+	}
+	var cmdLines []CmdLine
+	if len(updated) > 0 {
+		cmdLine := makeCmdLine("hpexpireat", args[0], []byte(strconv.FormatInt(expireAt, 10)),
+			[]byte("FIELDS"), []byte(strconv.Itoa(len(updated))))
+		cmdLines = append(cmdLines, append(cmdLine, updated...))
+	}
+	if len(deleted) > 0 {
+		cmdLines = append(cmdLines, makeCmdLine("hdel", append([][]byte{args[0]}, deleted...)...))
+	}
+	return cmdLines
+}
+
+func hexpireField(h *hash.Hash, field string, expireAt int64, now int64, flags int) int64 {
+	if h == nil {
+		return fieldNotExists
+	}
+	if _, ok := h.Get(field); !ok {
+		return fieldNotExists
+	}
+	current, hasTTL := h.ExpireTime(field)
+	if flags&expireNX != 0 && hasTTL {
+		return fieldConditionFail
+	}
+	if flags&expireXX != 0 && !hasTTL {
+		return fieldConditionFail
+	}
+	// 没有过期时间视为永不过期
+	if flags&expireGT != 0 && (!hasTTL || expireAt <= current) {
+		return fieldConditionFail
+	}
+	if flags&expireLT != 0 && hasTTL && expireAt >= current {
+		return fieldConditionFail
+	}
+	if expireAt <= now {
+		h.Remove(field)
+		return fieldDeleted
+	}
+	h.SetExpire(field, expireAt)
+	return fieldTTLSet
+}
+
+// execHExpire HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHExpire(db *DB, args [][]byte) protocol.Reply {
+	return hexpireGeneric(db, "hexpire", args, 1000, true)
+}
+
+// execHPExpire HPEXPIRE key milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHPExpire(db *DB, args [][]byte) protocol.Reply {
+	return hexpireGeneric(db, "hpexpire", args, 1, true)
+}
+
+// execHExpireAt HEXPIREAT key unix-time-seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHExpireAt(db *DB, args [][]byte) protocol.Reply {
+	return hexpireGeneric(db, "hexpireat", args, 1000, false)
+}
+
+// execHPExpireAt HPEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHPExpireAt(db *DB, args [][]byte) protocol.Reply {
+	return hexpireGeneric(db, "hpexpireat", args, 1, false)
+}
+
+// httlGeneric replies for each field -2 if it doesn't exist, -1 if it has no expire time,
+// otherwise its expire time converted by format
+func httlGeneric(db *DB, args [][]byte, format func(expireAt int64) int64) protocol.Reply {
+	fields, errReply := parseFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]protocol.Reply, len(fields))
+	for i, field := range fields {
+		n := int64(fieldNotExists)
+		if h != nil {
+			if _, ok := h.Get(field); ok {
+				n = fieldNoTTL
+				if expireAt, ok := h.ExpireTime(field); ok {
+					n = format(expireAt)
+				}
+			}
+		}
+		result[i] = protocol.NewIntReply(n)
+	}
+	return protocol.NewArrayReply(result)
+}
+
+// execHTTL HTTL key FIELDS numfields field [field ...]
+func execHTTL(db *DB, args [][]byte) protocol.Reply {
+	return httlGeneric(db, args, func(expireAt int64) int64 {
+		return (expireAt - time.Now().UnixMilli() + 999) / 1000
+	})
+}
+
+// execHPTTL HPTTL key FIELDS numfields field [field ...]
+func execHPTTL(db *DB, args [][]byte) protocol.Reply {
+	return httlGeneric(db, args, func(expireAt int64) int64 {
+		return expireAt - time.Now().UnixMilli()
+	})
+}
+
+// execHExpireTime HEXPIRETIME key FIELDS numfields field [field ...]
+func execHExpireTime(db *DB, args [][]byte) protocol.Reply {
+	return httlGeneric(db, args, func(expireAt int64) int64 {
+		return expireAt / 1000
+	})
+}
+
+// execHPExpireTime HPEXPIRETIME key FIELDS numfields field [field ...]
+func execHPExpireTime(db *DB, args [][]byte) protocol.Reply {
+	return httlGeneric(db, args, func(expireAt int64) int64 {
+		return expireAt
+	})
+}
+
+// execHPersist HPERSIST key FIELDS numfields field [field ...]
+func execHPersist(db *DB, args [][]byte) protocol.Reply {
+	fields, errReply := parseFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	h, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]protocol.Reply, len(fields))
+	persisted := false
+	for i, field := range fields {
+		n := int64(fieldNotExists)
+		if h != nil {
+			if _, ok := h.Get(field); ok {
+				n = fieldNoTTL
+				if h.Persist(field) {
+					n = fieldTTLSet
+					persisted = true
+				}
+			}
+		}
+		result[i] = protocol.NewIntReply(n)
+	}
+	if persisted {
+		db.notify(notifyHash, "hpersist", key)
+	}
+	return protocol.NewArrayReply(result)
+}
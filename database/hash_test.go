@@ -0,0 +1,100 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"testing"
+	"time"
+)
+
+func TestHashCommands(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, ":2\r\n", exec(s, c, "hset h a 1 b 2"))
+	assertReply(t, ":1\r\n", exec(s, c, "hset h a 10 c 3"))
+	assertReply(t, "-ERR wrong number of arguments for 'hset' command\r\n", exec(s, c, "hset h a 1 b"))
+	assertReply(t, "+hash\r\n", exec(s, c, "type h"))
+	assertReply(t, "$2\r\n10\r\n", exec(s, c, "hget h a"))
+	assertReply(t, "*2\r\n$1\r\n2\r\n$-1\r\n", exec(s, c, "hmget h b nope"))
+	assertReply(t, ":0\r\n", exec(s, c, "hsetnx h a 1"))
+	assertReply(t, ":1\r\n", exec(s, c, "hsetnx h d 4"))
+	assertReply(t, ":4\r\n", exec(s, c, "hlen h"))
+	assertReply(t, ":2\r\n", exec(s, c, "hstrlen h a"))
+	assertReply(t, "*4\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n", exec(s, c, "hkeys h"))
+	assertReply(t, "*4\r\n$2\r\n10\r\n$1\r\n2\r\n$1\r\n3\r\n$1\r\n4\r\n", exec(s, c, "hvals h"))
+	assertReply(t, ":1\r\n", exec(s, c, "hexists h a"))
+	assertReply(t, ":2\r\n", exec(s, c, "hdel h a b nope"))
+	assertReply(t, "*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$1\r\n4\r\n", exec(s, c, "hgetall h"))
+	assertReply(t, ":2\r\n", exec(s, c, "hdel h c d"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists h"))
+	assertReply(t, "*0\r\n", exec(s, c, "hgetall h"))
+
+	exec(s, c, "set str v")
+	assertReply(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "hget str a"))
+}
+
+func TestHashIncr(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, ":5\r\n", exec(s, c, "hincrby h n 5"))
+	assertReply(t, ":2\r\n", exec(s, c, "hincrby h n -3"))
+	assertReply(t, "$3\r\n2.5\r\n", exec(s, c, "hincrbyfloat h n 0.5"))
+	assertReply(t, "-ERR hash value is not an integer\r\n", exec(s, c, "hincrby h n 1"))
+	exec(s, c, "hset h s abc")
+	assertReply(t, "-ERR hash value is not a float\r\n", exec(s, c, "hincrbyfloat h s 1"))
+	exec(s, c, "hset h max 9223372036854775807")
+	assertReply(t, "-ERR increment or decrement would overflow\r\n", exec(s, c, "hincrby h max 1"))
+}
+
+func TestHashRandField(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "$-1\r\n", exec(s, c, "hrandfield h"))
+	assertReply(t, "*0\r\n", exec(s, c, "hrandfield h 3"))
+	exec(s, c, "hset h a 1")
+	assertReply(t, "$1\r\na\r\n", exec(s, c, "hrandfield h"))
+	assertReply(t, "*2\r\n$1\r\na\r\n$1\r\n1\r\n", exec(s, c, "hrandfield h 5 withvalues"))
+	assertReply(t, "*3\r\n$1\r\na\r\n$1\r\na\r\n$1\r\na\r\n", exec(s, c, "hrandfield h -3"))
+}
+
+func TestHashScan(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "hset h a 1 b 2 ab 3")
+	assertReply(t, "*2\r\n$1\r\n0\r\n*4\r\n$1\r\na\r\n$1\r\n1\r\n$2\r\nab\r\n$1\r\n3\r\n", exec(s, c, "hscan h 0 match a*"))
+	assertReply(t, "*2\r\n$1\r\n0\r\n*2\r\n$1\r\na\r\n$2\r\nab\r\n", exec(s, c, "hscan h 0 match a* novalues"))
+	assertReply(t, "*2\r\n$1\r\n0\r\n*0\r\n", exec(s, c, "hscan nokey 0"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "hscan h 0 count 0"))
+}
+
+func TestHashFieldExpire(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "hset h a 1 b 2 c 3")
+	assertReply(t, "*3\r\n:1\r\n:1\r\n:-2\r\n", exec(s, c, "hexpire h 100 fields 3 a b nope"))
+	assertReply(t, "*2\r\n:0\r\n:1\r\n", exec(s, c, "hexpire h 200 nx fields 2 a c"))
+	assertReply(t, "*3\r\n:100\r\n:200\r\n:-2\r\n", exec(s, c, "httl h fields 3 b c nope"))
+	assertReply(t, "*1\r\n:1\r\n", exec(s, c, "hpersist h fields 1 b"))
+	assertReply(t, "*1\r\n:-1\r\n", exec(s, c, "hpersist h fields 1 b"))
+	assertReply(t, "*1\r\n:0\r\n", exec(s, c, "hexpire h 50 gt fields 1 b"))
+	assertReply(t, "*1\r\n:2\r\n", exec(s, c, "hexpireat h 1 fields 1 c"))
+	assertReply(t, "*1\r\n:-2\r\n", exec(s, c, "httl h fields 1 c"))
+	assertReply(t, "*2\r\n:-2\r\n:-2\r\n", exec(s, c, "httl nokey fields 2 a b"))
+
+	assertReply(t, "-ERR Mandatory argument FIELDS is missing or not at the right position\r\n", exec(s, c, "hexpire h 10 xx nope 1 a"))
+	assertReply(t, "-ERR The `numfields` parameter must match the number of arguments\r\n", exec(s, c, "hexpire h 10 fields 2 a"))
+	assertReply(t, "-ERR invalid expire time, must be >= 0\r\n", exec(s, c, "hexpire h -1 fields 1 a"))
+
+	// 字段过期后不可见，最后一个字段过期后key被删除
+	assertReply(t, "*2\r\n:1\r\n:1\r\n", exec(s, c, "hpexpire h 20 fields 2 a b"))
+	assertReply(t, "$1\r\n1\r\n", exec(s, c, "hget h a"))
+	time.Sleep(30 * time.Millisecond)
+	assertReply(t, "$-1\r\n", exec(s, c, "hget h a"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists h"))
+	assertReply(t, ":1\r\n", exec(s, c, "hset h a 1"))
+	assertReply(t, ":1\r\n", exec(s, c, "hlen h"))
+}
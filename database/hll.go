@@ -0,0 +1,226 @@
+package database
+
+import (
+	"bytes"
+	"godis/config"
+	"godis/datastruct/hll"
+	"godis/resp/protocol"
+	"strings"
+)
+
+func init() {
+	registerCommand("pfadd", execPFAdd, -2, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("pfcount", execPFCount, -2, flagReadOnly).withPrepare(preparePFCount)
+	registerCommand("pfmerge", execPFMerge, -2, flagWrite|flagDenyOOM).withPrepare(preparePFMerge)
+	registerCommand("pfdebug", execPFDebug, 3, flagWrite|flagDenyOOM|flagAdmin).keys(2, 2, 1)
+}
+
+// HyperLogLog存储为与redis格式相同的字符串，修改时总是在副本上进行
+
+func newInvalidHLLErrReply() *protocol.ErrReply {
+	return protocol.NewErrReply("WRONGTYPE Key is not a valid HyperLogLog string value.")
+}
+
+func newCorruptedHLLErrReply() *protocol.ErrReply {
+	return protocol.NewErrReply("INVALIDOBJ Corrupted HLL object detected")
+}
+
+// getAsHLL returns the HyperLogLog of key, nil if key doesn't exist.
+// Set unshare to get a copy which may be modified and stored back.
+func (db *DB) getAsHLL(key string, unshare bool) (*hll.HyperLogLog, *protocol.ErrReply) {
+	val, errReply := db.getAsString(key)
+	if errReply != nil || val == nil {
+		return nil, errReply
+	}
+	if unshare {
+		val = bytes.Clone(val)
+	}
+	h, err := hll.Load(val, config.Properties.HllSparseMaxBytes)
+	if err != nil {
+		return nil, newInvalidHLLErrReply()
+	}
+	return h, nil
+}
+
+// execPFAdd PFADD key [element ...]
+func execPFAdd(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	h, errReply := db.getAsHLL(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	updated := false
+	if h == nil {
+		h = hll.New(config.Properties.HllSparseMaxBytes)
+		updated = true
+	}
+	for _, ele := range args[1:] {
+		changed, err := h.Add(ele)
+		if err != nil {
+			return newCorruptedHLLErrReply()
+		}
+		updated = updated || changed
+	}
+	if !updated {
+		return protocol.NewIntReply(0)
+	}
+	db.putEntity(key, h.Bytes())
+	db.notify(notifyString, "pfadd", key)
+	return protocol.NewIntReply(1)
+}
+
+// 单个key时会把基数缓存写回HyperLogLog，因此需要写锁
+func preparePFCount(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	if len(keys) == 1 {
+		return keys, nil
+	}
+	return nil, keys
+}
+
+// execPFCount PFCOUNT key [key ...]
+func execPFCount(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 1 {
+		// 多个key时计算并集的基数，不修改任何key
+		registers := make([]uint8, hll.Registers)
+		for _, arg := range args {
+			h, errReply := db.getAsHLL(string(arg), false)
+			if errReply != nil {
+				return errReply
+			}
+			if h == nil {
+				continue
+			}
+			if err := h.MergeInto(registers); err != nil {
+				return newCorruptedHLLErrReply()
+			}
+		}
+		return protocol.NewIntReply(int64(hll.CountRegisters(registers)))
+	}
+
+	key := string(args[0])
+	h, errReply := db.getAsHLL(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.NewIntReply(0)
+	}
+	if card, ok := h.CachedCount(); ok {
+		return protocol.NewIntReply(int64(card))
+	}
+	card, err := h.Count()
+	if err != nil {
+		return newCorruptedHLLErrReply()
+	}
+	// 缓存失效时在副本上更新缓存
+	h, _ = hll.Load(bytes.Clone(h.Bytes()), config.Properties.HllSparseMaxBytes)
+	h.SetCachedCount(card)
+	db.putEntity(key, h.Bytes())
+	return protocol.NewIntReply(int64(card))
+}
+
+func preparePFMerge(args [][]byte) ([]string, []string) {
+	// 目标key同时也是源key之一，写锁已经覆盖了读
+	sources := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		sources[i] = string(arg)
+	}
+	return []string{string(args[0])}, sources
+}
+
+// execPFMerge PFMERGE destkey [sourcekey ...]
+func execPFMerge(db *DB, args [][]byte) protocol.Reply {
+	registers := make([]uint8, hll.Registers)
+	useDense := false
+	for _, arg := range args {
+		h, errReply := db.getAsHLL(string(arg), false)
+		if errReply != nil {
+			return errReply
+		}
+		if h == nil {
+			continue
+		}
+		if h.Encoding() == "dense" {
+			useDense = true
+		}
+		if err := h.MergeInto(registers); err != nil {
+			return newCorruptedHLLErrReply()
+		}
+	}
+
+	dest := string(args[0])
+	h, _ := db.getAsHLL(dest, true)
+	if h == nil {
+		h = hll.New(config.Properties.HllSparseMaxBytes)
+	}
+	// 与redis相同，任意一个输入为密集编码时结果使用密集编码
+	if useDense {
+		if _, err := h.ToDense(); err != nil {
+			return newCorruptedHLLErrReply()
+		}
+	}
+	if err := h.SetRegisters(registers); err != nil {
+		return newCorruptedHLLErrReply()
+	}
+	db.putEntity(dest, h.Bytes())
+	// 与redis相同，PFMERGE发布pfadd事件
+	db.notify(notifyString, "pfadd", dest)
+	return protocol.NewOkReply()
+}
+
+// execPFDebug PFDEBUG GETREG|DECODE|ENCODING|TODENSE key
+func execPFDebug(db *DB, args [][]byte) protocol.Reply {
+	sub := strings.ToLower(string(args[0]))
+	key := string(args[1])
+	h, errReply := db.getAsHLL(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.NewErrReply("ERR The specified key does not exist")
+	}
+
+	switch sub {
+	case "getreg":
+		// 与redis相同，GETREG会把key转换为密集编码
+		converted, err := h.ToDense()
+		if err != nil {
+			return newCorruptedHLLErrReply()
+		}
+		if converted {
+			db.putEntity(key, h.Bytes())
+		}
+		registers, _ := h.Registers()
+		replies := make([]protocol.Reply, len(registers))
+		for i, val := range registers {
+			replies[i] = protocol.NewIntReply(int64(val))
+		}
+		return protocol.NewArrayReply(replies)
+	case "decode":
+		if h.Encoding() != "sparse" {
+			return protocol.NewErrReply("ERR HLL encoding is not sparse")
+		}
+		decoded, err := h.Decode()
+		if err != nil {
+			return newCorruptedHLLErrReply()
+		}
+		return protocol.NewBulkReply([]byte(decoded))
+	case "encoding":
+		return protocol.NewStatusReply(h.Encoding())
+	case "todense":
+		converted, err := h.ToDense()
+		if err != nil {
+			return newCorruptedHLLErrReply()
+		}
+		if converted {
+			db.putEntity(key, h.Bytes())
+			return protocol.NewIntReply(1)
+		}
+		return protocol.NewIntReply(0)
+	}
+	return protocol.NewErrReply("ERR Unknown PFDEBUG subcommand '" + string(args[0]) + "'")
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPFAdd(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, ":1\r\n", exec(s, c, "pfadd hll foo bar zap"))
+	assertReply(t, ":0\r\n", exec(s, c, "pfadd hll zap zap zap"))
+	assertReply(t, ":0\r\n", exec(s, c, "pfadd hll foo bar"))
+	assertReply(t, ":3\r\n", exec(s, c, "pfcount hll"))
+	assertReply(t, ":1\r\n", exec(s, c, "pfadd some-other-hll 1 2 3"))
+	assertReply(t, ":6\r\n", exec(s, c, "pfcount hll some-other-hll"))
+	assertReply(t, ":6\r\n", exec(s, c, "pfcount hll some-other-hll nokey"))
+	assertReply(t, ":0\r\n", exec(s, c, "pfcount nokey"))
+
+	// 不带元素时创建空的HyperLogLog
+	assertReply(t, ":1\r\n", exec(s, c, "pfadd empty"))
+	assertReply(t, ":0\r\n", exec(s, c, "pfadd empty"))
+	assertReply(t, "$18\r\nHYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff\r\n", exec(s, c, "get empty"))
+
+	exec(s, c, "set str foo")
+	assertReply(t, "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n", exec(s, c, "pfadd str a"))
+	assertReply(t, "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n", exec(s, c, "pfcount hll str"))
+	exec(s, c, "lpush l x")
+	assertReply(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "pfcount l"))
+}
+
+func TestPFCountCache(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "pfadd hll a b c")
+	// 缓存失效的标记位于第15字节的最高位
+	val := string(exec(s, c, "get hll").ToBytes())
+	assert.NotEqual(t, byte(0), val[strings.Index(val, "HYLL")+15]&0x80)
+	assertReply(t, ":3\r\n", exec(s, c, "pfcount hll"))
+	val = string(exec(s, c, "get hll").ToBytes())
+	assert.Equal(t, "\x03\x00\x00\x00\x00\x00\x00\x00", val[strings.Index(val, "HYLL")+8:strings.Index(val, "HYLL")+16])
+
+	// 通过SET写入的HyperLogLog可以直接使用
+	exec(s, c, "pfadd src x y")
+	s.Exec(c, toCmdLine("set", "copy", string(getBulk(t, exec(s, c, "get src").ToBytes()))))
+	assertReply(t, ":2\r\n", exec(s, c, "pfcount copy"))
+	assertReply(t, ":0\r\n", exec(s, c, "pfadd copy x"))
+
+	// 损坏的稀疏编码
+	s.Exec(c, toCmdLine("set", "bad", "HYLL\x01\x00\x00\x00\x00\x00\x00\x80\x00\x00\x00\x80\x7f\xf0"))
+	assertReply(t, "-INVALIDOBJ Corrupted HLL object detected\r\n", exec(s, c, "pfcount bad"))
+}
+
+func getBulk(t *testing.T, raw []byte) []byte {
+	s := string(raw)
+	assert.True(t, strings.HasPrefix(s, "$"))
+	head, body, _ := strings.Cut(s, "\r\n")
+	n, _ := strconv.Atoi(head[1:])
+	return []byte(body[:n])
+}
+
+func TestPFMerge(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "pfadd hll1 foo bar zap a")
+	exec(s, c, "pfadd hll2 a b c foo")
+	assertReply(t, "+OK\r\n", exec(s, c, "pfmerge hll3 hll1 hll2"))
+	assertReply(t, ":6\r\n", exec(s, c, "pfcount hll3"))
+	assertReply(t, "+sparse\r\n", exec(s, c, "pfdebug encoding hll3"))
+
+	// 目标key自身也参与合并
+	exec(s, c, "pfadd hll3 x")
+	assertReply(t, "+OK\r\n", exec(s, c, "pfmerge hll3 hll1"))
+	assertReply(t, ":7\r\n", exec(s, c, "pfcount hll3"))
+	assertReply(t, "+OK\r\n", exec(s, c, "pfmerge created"))
+	assertReply(t, ":0\r\n", exec(s, c, "pfcount created"))
+
+	// 存在密集编码的输入时结果为密集编码
+	exec(s, c, "pfdebug todense hll2")
+	assertReply(t, "+OK\r\n", exec(s, c, "pfmerge hll4 hll1 hll2"))
+	assertReply(t, "+dense\r\n", exec(s, c, "pfdebug encoding hll4"))
+	assertReply(t, ":6\r\n", exec(s, c, "pfcount hll4"))
+
+	exec(s, c, "set str foo")
+	assertReply(t, "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n", exec(s, c, "pfmerge hll4 str"))
+	assertReply(t, "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n", exec(s, c, "pfmerge str hll1"))
+}
+
+func TestPFDebug(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "-ERR The specified key does not exist\r\n", exec(s, c, "pfdebug encoding nokey"))
+	exec(s, c, "pfadd hll")
+	assertReply(t, "$8\r\nXZ:16384\r\n", exec(s, c, "pfdebug decode hll"))
+	assertReply(t, "+sparse\r\n", exec(s, c, "pfdebug encoding hll"))
+	assertReply(t, ":1\r\n", exec(s, c, "pfdebug todense hll"))
+	assertReply(t, ":0\r\n", exec(s, c, "pfdebug todense hll"))
+	assertReply(t, "+dense\r\n", exec(s, c, "pfdebug encoding hll"))
+	assertReply(t, "-ERR HLL encoding is not sparse\r\n", exec(s, c, "pfdebug decode hll"))
+	assertReply(t, ":12304\r\n", exec(s, c, "strlen hll"))
+
+	exec(s, c, "pfadd sparse a")
+	reply := string(exec(s, c, "pfdebug getreg sparse").ToBytes())
+	assert.True(t, strings.HasPrefix(reply, "*16384\r\n"))
+	assert.Equal(t, 16383, strings.Count(reply, ":0\r\n"))
+	assertReply(t, "+dense\r\n", exec(s, c, "pfdebug encoding sparse"))
+	assertReply(t, "-ERR Unknown PFDEBUG subcommand 'foo'\r\n", exec(s, c, "pfdebug foo hll"))
+}
+
+func TestPFAddPromote(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	args := []string{"pfadd", "hll"}
+	for i := 0; i < 5000; i++ {
+		args = append(args, "ele:"+strconv.Itoa(i))
+	}
+	assertReply(t, ":1\r\n", s.Exec(c, toCmdLine(args...)))
+	assertReply(t, "+dense\r\n", exec(s, c, "pfdebug encoding hll"))
+	reply := string(exec(s, c, "pfcount hll").ToBytes())
+	count, _ := strconv.Atoi(strings.TrimSpace(reply[1:]))
+	assert.InDelta(t, 5000, count, 5000*0.0405)
+}
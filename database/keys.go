@@ -0,0 +1,391 @@
+package database
+
+import (
+	"godis/datastruct/hash"
+	"godis/datastruct/list"
+	"godis/datastruct/set"
+	"godis/datastruct/sortedset"
+	"godis/datastruct/stream"
+	"godis/pkg/wildcard"
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerCommand("del", execDel, -2, flagWrite).keys(1, -1, 1)
+	registerCommand("unlink", execUnlink, -2, flagWrite|flagFast).keys(1, -1, 1)
+	registerCommand("exists", execExists, -2, flagReadOnly|flagFast).keys(1, -1, 1)
+	registerCommand("type", execType, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("rename", execRename, 3, flagWrite).keys(1, 2, 1)
+	registerCommand("renamenx", execRenameNX, 3, flagWrite|flagFast).keys(1, 2, 1)
+	registerCommand("touch", execTouch, -2, flagReadOnly|flagFast).keys(1, -1, 1)
+	registerCommand("keys", execKeys, 2, flagReadOnly)
+	registerCommand("randomkey", execRandomKey, 1, flagReadOnly)
+	registerCommand("scan", execScan, -2, flagReadOnly)
+	registerSysCommand("copy", execCopy, -3, flagWrite|flagDenyOOM).keys(1, 2, 1).withPrepare(prepareCopy).withTx(lockCopyInTx, execCopyInTx)
+}
+
+// typeOf returns the type name of a value as reported by TYPE
+func typeOf(val any) string {
+	switch val.(type) {
+	case []byte:
+		return "string"
+	case *list.QuickList:
+		return "list"
+	case *hash.Hash:
+		return "hash"
+	case *set.Set:
+		return "set"
+	case *sortedset.SortedSet:
+		return "zset"
+	case *stream.Stream:
+		return "stream"
+	}
+	return "none"
+}
+
+// copyEntity returns a deep copy of a value, strings are immutable and can be shared
+func copyEntity(val any) any {
+	switch v := val.(type) {
+	case []byte:
+		return v
+	case *list.QuickList:
+		return v.Clone()
+	case *hash.Hash:
+		return v.Clone()
+	case *set.Set:
+		return v.Clone()
+	case *sortedset.SortedSet:
+		return v.Clone()
+	case *stream.Stream:
+		return v.Clone()
+	}
+	return val
+}
+
+// execDel DEL key [key ...]
+func execDel(db *DB, args [][]byte) protocol.Reply {
+	deleted := 0
+	for _, arg := range args {
+		key := string(arg)
+		if _, ok := db.removeKey(key); ok {
+			db.notify(notifyGeneric, "del", key)
+			deleted++
+		}
+	}
+	return protocol.NewIntReply(int64(deleted))
+}
+
+// execUnlink UNLINK key [key ...]
+// 删除时只从keyspace中摘除，值的内存由gc在后台回收，不会阻塞当前请求
+func execUnlink(db *DB, args [][]byte) protocol.Reply {
+	return execDel(db, args)
+}
+
+// execExists EXISTS key [key ...]
+func execExists(db *DB, args [][]byte) protocol.Reply {
+	count := 0
+	for _, arg := range args {
+		if _, exists := db.getEntity(string(arg)); exists {
+			count++
+		}
+	}
+	return protocol.NewIntReply(int64(count))
+}
+
+// execTouch TOUCH key [key ...]
+func execTouch(db *DB, args [][]byte) protocol.Reply {
+	return execExists(db, args)
+}
+
+// execType TYPE key
+func execType(db *DB, args [][]byte) protocol.Reply {
+	val, exists := db.getEntity(string(args[0]))
+	if !exists {
+		return protocol.NewStatusReply("none")
+	}
+	return protocol.NewStatusReply(typeOf(val))
+}
+
+// renameKey moves src to dst together with its expire time, both keys must be write locked
+func (db *DB) renameKey(src, dst string, val any) {
+	expireAt, hasTTL := db.expireTime(src)
+	db.removeKey(src)
+	db.removeKey(dst)
+	db.putEntity(dst, val)
+	if hasTTL {
+		db.expire(dst, expireAt)
+	}
+	db.notify(notifyGeneric, "rename_from", src)
+	db.notify(notifyGeneric, "rename_to", dst)
+}
+
+// execRename RENAME key newkey
+func execRename(db *DB, args [][]byte) protocol.Reply {
+	src, dst := string(args[0]), string(args[1])
+	val, exists := db.getEntity(src)
+	if !exists {
+		return protocol.NewErrReply("ERR no such key")
+	}
+	if src != dst {
+		db.renameKey(src, dst, val)
+	} else {
+		// 与redis相同，改名为自身时也发布事件
+		db.notify(notifyGeneric, "rename_from", src)
+		db.notify(notifyGeneric, "rename_to", dst)
+	}
+	return protocol.NewOkReply()
+}
+
+// execRenameNX RENAMENX key newkey
+func execRenameNX(db *DB, args [][]byte) protocol.Reply {
+	src, dst := string(args[0]), string(args[1])
+	val, exists := db.getEntity(src)
+	if !exists {
+		return protocol.NewErrReply("ERR no such key")
+	}
+	if _, exists := db.getEntity(dst); exists {
+		return protocol.NewIntReply(0)
+	}
+	db.renameKey(src, dst, val)
+	return protocol.NewIntReply(1)
+}
+
+// execKeys KEYS pattern
+func execKeys(db *DB, args [][]byte) protocol.Reply {
+	pattern, err := wildcard.Compile(string(args[0]))
+	if err != nil {
+		return protocol.NewErrReply("ERR illegal wildcard")
+	}
+	result := make([][]byte, 0)
+	db.data.ForEach(func(key string, _ any) bool {
+		if pattern.Match(key) && !db.isExpired(key) {
+			result = append(result, []byte(key))
+		}
+		return true
+	})
+	return protocol.NewMultiBulkReply(result)
+}
+
+// execRandomKey RANDOMKEY
+func execRandomKey(db *DB, args [][]byte) protocol.Reply {
+	// 随机到的key可能已经过期，多尝试几次
+	for i := 0; i < 100; i++ {
+		key, ok := db.data.RandomKey()
+		if !ok {
+			break
+		}
+		if !db.isExpired(key) {
+			return protocol.NewBulkReply([]byte(key))
+		}
+	}
+	return protocol.NewNullBulkReply()
+}
+
+// execScan SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func execScan(db *DB, args [][]byte) protocol.Reply {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return protocol.NewErrReply("ERR invalid cursor")
+	}
+	pattern := "*"
+	count := 10
+	typeName := ""
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return protocol.NewSyntaxErrReply()
+		}
+		value := args[i+1]
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(value)
+		case "COUNT":
+			n, ok := parseInt(value)
+			if !ok {
+				return protocol.NewNotIntegerErrReply()
+			}
+			if n < 1 {
+				return protocol.NewSyntaxErrReply()
+			}
+			count = int(n)
+		case "TYPE":
+			typeName = strings.ToLower(string(value))
+		default:
+			return protocol.NewSyntaxErrReply()
+		}
+	}
+
+	keys, next := db.data.DictScan(int(cursor), count, pattern)
+	if next < 0 {
+		return protocol.NewErrReply("ERR illegal wildcard")
+	}
+	result := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		val, exists := db.data.Get(string(key))
+		if !exists || db.isExpired(string(key)) {
+			continue
+		}
+		if typeName != "" && typeOf(val) != typeName {
+			continue
+		}
+		result = append(result, key)
+	}
+	return protocol.NewArrayReply([]protocol.Reply{
+		protocol.NewBulkReply([]byte(strconv.Itoa(next))),
+		protocol.NewMultiBulkReply(result),
+	})
+}
+
+// parseScanOptions parses [MATCH pattern] [COUNT count] of the commands scanning a single key,
+// NOVALUES is only accepted by HSCAN
+func parseScanOptions(args [][]byte, allowNoValues bool) (pattern string, count int, noValues bool, errReply *protocol.ErrReply) {
+	pattern = "*"
+	count = 10
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "NOVALUES" && allowNoValues {
+			noValues = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return "", 0, false, protocol.NewSyntaxErrReply()
+		}
+		i++
+		switch option {
+		case "MATCH":
+			pattern = string(args[i])
+		case "COUNT":
+			n, ok := parseInt(args[i])
+			if !ok {
+				return "", 0, false, protocol.NewNotIntegerErrReply()
+			}
+			if n < 1 {
+				return "", 0, false, protocol.NewSyntaxErrReply()
+			}
+			count = int(n)
+		default:
+			return "", 0, false, protocol.NewSyntaxErrReply()
+		}
+	}
+	return pattern, count, noValues, nil
+}
+
+// prepareCopy COPY source destination, source is read and destination is written
+func prepareCopy(args [][]byte) ([]string, []string) {
+	return []string{string(args[1])}, []string{string(args[0])}
+}
+
+// parseCopy returns the destination db and whether REPLACE is given
+func parseCopy(s *Server, srcDB *DB, args [][]byte) (*DB, bool, *protocol.ErrReply) {
+	dstDB := srcDB
+	replace := false
+	var errReply *protocol.ErrReply
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "DB":
+			if i+1 >= len(args) {
+				return nil, false, protocol.NewSyntaxErrReply()
+			}
+			dstDB, errReply = parseDBIndex(s, args[i+1])
+			if errReply != nil {
+				return nil, false, errReply
+			}
+			i++
+		case "REPLACE":
+			replace = true
+		default:
+			return nil, false, protocol.NewSyntaxErrReply()
+		}
+	}
+	if srcDB == dstDB && string(args[0]) == string(args[1]) {
+		return nil, false, protocol.NewErrReply("ERR source and destination objects are the same")
+	}
+	return dstDB, replace, nil
+}
+
+// execCopy COPY source destination [DB destination-db] [REPLACE]
+func execCopy(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	srcDB, errReply := s.selectDB(client.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	dstDB, replace, errReply := parseCopy(s, srcDB, args)
+	if errReply != nil {
+		return errReply
+	}
+
+	src, dst := string(args[0]), string(args[1])
+	var unlock func()
+	if srcDB == dstDB {
+		unlock = lockDBs(dbLock{db: srcDB, writeKeys: []string{dst}, readKeys: []string{src}})
+	} else {
+		unlock = lockDBs(
+			dbLock{db: srcDB, readKeys: []string{src}},
+			dbLock{db: dstDB, writeKeys: []string{dst}},
+		)
+	}
+	defer dstDB.handleReadyKeys()
+	defer unlock()
+	if !copyKey(srcDB, dstDB, src, dst, replace) {
+		return protocol.NewIntReply(0)
+	}
+	dstDB.addVersion(dst)
+	srcDB.appendAof(makeCmdLine("copy", args...))
+	return protocol.NewIntReply(1)
+}
+
+// copyKey copies src of srcDB to dst of dstDB, the caller must hold the shard locks of both keys
+func copyKey(srcDB, dstDB *DB, src, dst string, replace bool) bool {
+	dstDB.expireIfNeeded(dst)
+	val, exists := srcDB.getEntity(src)
+	if !exists {
+		return false
+	}
+	if _, exists := dstDB.getEntity(dst); exists {
+		if !replace {
+			return false
+		}
+		dstDB.removeKey(dst)
+	}
+	dstDB.putEntity(dst, copyEntity(val))
+	if expireAt, ok := srcDB.expireTime(src); ok {
+		dstDB.expire(dst, expireAt)
+	}
+	dstDB.notify(notifyGeneric, "copy_to", dst)
+	return true
+}
+
+func lockCopyInTx(tx *transaction, args [][]byte) {
+	if dstDB, _, errReply := parseCopy(tx.s, tx.db, args); errReply == nil {
+		writeKeys, readKeys := prepareCopy(args)
+		tx.lockKeys(tx.db, nil, readKeys)
+		tx.lockKeys(dstDB, writeKeys, nil)
+	}
+}
+
+func execCopyInTx(tx *transaction, args [][]byte) protocol.Reply {
+	srcDB := tx.db
+	dstDB, replace, errReply := parseCopy(tx.s, srcDB, args)
+	if errReply != nil {
+		return errReply
+	}
+	writeKeys, readKeys := prepareCopy(args)
+	copied := false
+	copyFn := func() {
+		copied = copyKey(srcDB, dstDB, readKeys[0], writeKeys[0], replace)
+	}
+	if srcDB == dstDB {
+		tx.withKeys(srcDB, writeKeys, readKeys, copyFn)
+	} else {
+		tx.withKeys(srcDB, nil, readKeys, func() {
+			tx.withKeys(dstDB, writeKeys, nil, copyFn)
+		})
+	}
+	if !copied {
+		return protocol.NewIntReply(0)
+	}
+	tx.appendAof(srcDB, makeCmdLine("copy", args...))
+	return protocol.NewIntReply(1)
+}
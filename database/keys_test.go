@@ -0,0 +1,83 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelExistsType(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "mset a 1 b 2 c 3")
+	assertReply(t, ":3\r\n", exec(s, c, "exists a b a x"))
+	assertReply(t, "+string\r\n", exec(s, c, "type a"))
+	assertReply(t, "+none\r\n", exec(s, c, "type x"))
+	assertReply(t, ":2\r\n", exec(s, c, "del a b x"))
+	assertReply(t, ":1\r\n", exec(s, c, "unlink c"))
+	assertReply(t, ":0\r\n", exec(s, c, "touch a b c"))
+}
+
+func TestRenameAndCopy(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "-ERR no such key\r\n", exec(s, c, "rename a b"))
+	exec(s, c, "set a 1 ex 100")
+	assertReply(t, "+OK\r\n", exec(s, c, "rename a b"))
+	assertReply(t, ":100\r\n", exec(s, c, "ttl b"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists a"))
+
+	exec(s, c, "set a 2")
+	assertReply(t, ":0\r\n", exec(s, c, "renamenx a b"))
+	assertReply(t, ":0\r\n", exec(s, c, "copy a b"))
+	assertReply(t, ":1\r\n", exec(s, c, "copy a b replace"))
+	assertReply(t, ":-1\r\n", exec(s, c, "ttl b"))
+	assertReply(t, "-ERR source and destination objects are the same\r\n", exec(s, c, "copy a a"))
+
+	assertReply(t, ":1\r\n", exec(s, c, "copy b a db 3"))
+	exec(s, c, "select 3")
+	assertReply(t, "$1\r\n2\r\n", exec(s, c, "get a"))
+}
+
+func TestKeysAndScan(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "$-1\r\n", exec(s, c, "randomkey"))
+	// 空字符串是唯一的key
+	s.Exec(c, toCmdLine("set", "", "v"))
+	assertReply(t, "$0\r\n\r\n", exec(s, c, "randomkey"))
+	s.Exec(c, toCmdLine("del", ""))
+	for i := 0; i < 50; i++ {
+		exec(s, c, "set user:"+strconv.Itoa(i)+" v")
+	}
+	exec(s, c, "set other v")
+
+	keys := exec(s, c, "keys user:*").(*protocol.MultiBulkReply)
+	assert.Len(t, keys.Values, 50)
+	assertReply(t, "*1\r\n$5\r\nother\r\n", exec(s, c, "keys o*"))
+	assert.NotEqual(t, "$-1\r\n", string(exec(s, c, "randomkey").ToBytes()))
+
+	found := make([]string, 0)
+	cursor := "0"
+	for {
+		reply := exec(s, c, "scan "+cursor+" match user:* count 10").(*protocol.ArrayReply)
+		cursor = string(reply.Replies[0].(*protocol.BulkReply).Value)
+		for _, key := range reply.Replies[1].(*protocol.MultiBulkReply).Values {
+			found = append(found, string(key))
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(found)
+	assert.Len(t, found, 50)
+	reply := exec(s, c, "scan 0 match user:* count 100 type list").(*protocol.ArrayReply)
+	assert.Empty(t, reply.Replies[1].(*protocol.MultiBulkReply).Values)
+}
@@ -0,0 +1,335 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerSysCommand("select", execSelect, 2, flagFast).withTx(lockSelectInTx, execSelectInTx)
+	registerSysCommand("swapdb", execSwapDB, 3, flagWrite|flagFast).withTx(lockSwapDBInTx, execSwapDBInTx)
+	registerSysCommand("flushdb", execFlushDB, -1, flagWrite).withTx(lockFlushDBInTx, execFlushDBInTx)
+	registerSysCommand("flushall", execFlushAll, -1, flagWrite).withTx(lockFlushAllInTx, execFlushAllInTx)
+	registerSysCommand("dbsize", execDBSize, 1, flagReadOnly|flagFast).withTx(lockDBSizeInTx, execDBSizeInTx)
+	registerSysCommand("move", execMove, 3, flagWrite|flagFast).keys(1, 1, 1).withTx(lockMoveInTx, execMoveInTx)
+}
+
+func parseDBIndex(s *Server, arg []byte) (*DB, *protocol.ErrReply) {
+	index, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return nil, protocol.NewNotIntegerErrReply()
+	}
+	return s.selectDB(index)
+}
+
+// parseFlushMode FLUSHDB/FLUSHALL [ASYNC | SYNC]
+func parseFlushMode(args [][]byte) (async bool, errReply *protocol.ErrReply) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	if len(args) > 1 {
+		return false, protocol.NewSyntaxErrReply()
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "ASYNC":
+		return true, nil
+	case "SYNC":
+		return false, nil
+	}
+	return false, protocol.NewSyntaxErrReply()
+}
+
+// dbLock describes the keys to lock in one db
+type dbLock struct {
+	db        *DB
+	writeKeys []string
+	readKeys  []string
+	// 锁定整个db，用于替换keyspace的命令，此时不锁定key
+	whole bool
+}
+
+// lockDBs locks keys across dbs ordered by db index so concurrent callers never deadlock,
+// returns the unlock function
+func lockDBs(locks ...dbLock) func() {
+	sorted := make([]dbLock, len(locks))
+	copy(sorted, locks)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].db.index < sorted[j].db.index
+	})
+
+	for _, l := range sorted {
+		if l.whole {
+			l.db.stopWorld.Lock()
+			continue
+		}
+		l.db.stopWorld.RLock()
+		l.db.data.RWLocks(l.writeKeys, l.readKeys)
+		l.db.saveForSnapshot(l.writeKeys)
+	}
+	return func() {
+		for i := len(sorted) - 1; i >= 0; i-- {
+			l := sorted[i]
+			if l.whole {
+				l.db.stopWorld.Unlock()
+				continue
+			}
+			l.db.data.RWUnlocks(l.writeKeys, l.readKeys)
+			l.db.stopWorld.RUnlock()
+		}
+	}
+}
+
+// execSelect SELECT index
+func execSelect(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	index, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return protocol.NewNotIntegerErrReply()
+	}
+	if _, errReply := s.selectDB(index); errReply != nil {
+		return errReply
+	}
+	client.SelectDB(index)
+	return protocol.NewOkReply()
+}
+
+// lockSelectInTx makes the commands after SELECT lock their keys in the selected db
+func lockSelectInTx(tx *transaction, args [][]byte) {
+	if db, errReply := parseDBIndex(tx.s, args[0]); errReply == nil {
+		tx.db = db
+	}
+}
+
+func execSelectInTx(tx *transaction, args [][]byte) protocol.Reply {
+	reply := execSelect(tx.s, tx.client, args)
+	tx.db = tx.s.dbSet[tx.client.GetDBIndex()]
+	return reply
+}
+
+func parseSwapDB(s *Server, args [][]byte) (*DB, *DB, *protocol.ErrReply) {
+	index1, err1 := strconv.Atoi(string(args[0]))
+	index2, err2 := strconv.Atoi(string(args[1]))
+	if err1 != nil {
+		return nil, nil, protocol.NewErrReply("ERR invalid first DB index")
+	}
+	if err2 != nil {
+		return nil, nil, protocol.NewErrReply("ERR invalid second DB index")
+	}
+	db1, errReply := s.selectDB(index1)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	db2, errReply := s.selectDB(index2)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	return db1, db2, nil
+}
+
+// execSwapDB SWAPDB index1 index2
+func execSwapDB(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	db1, db2, errReply := parseSwapDB(s, args)
+	if errReply != nil {
+		return errReply
+	}
+	swapDB(db1, db2)
+	db1.handleReadyKeys()
+	db2.handleReadyKeys()
+	return protocol.NewOkReply()
+}
+
+func lockSwapDBInTx(tx *transaction, args [][]byte) {
+	if db1, db2, errReply := parseSwapDB(tx.s, args); errReply == nil {
+		tx.lockWhole(db1)
+		tx.lockWhole(db2)
+	}
+}
+
+func execSwapDBInTx(tx *transaction, args [][]byte) protocol.Reply {
+	db1, db2, errReply := parseSwapDB(tx.s, args)
+	if errReply != nil {
+		return errReply
+	}
+	if db1 != db2 {
+		swapKeyspaces(db1, db2)
+		tx.appendAof(nil, makeCmdLine("swapdb", args...))
+	}
+	return protocol.NewOkReply()
+}
+
+// execFlushDB FLUSHDB [ASYNC | SYNC]
+func execFlushDB(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	async, errReply := parseFlushMode(args)
+	if errReply != nil {
+		return errReply
+	}
+	db, errReply := s.selectDB(client.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	if async {
+		db.flushAsync()
+	} else {
+		db.flush()
+	}
+	return protocol.NewOkReply()
+}
+
+func lockFlushDBInTx(tx *transaction, args [][]byte) {
+	if _, errReply := parseFlushMode(args); errReply == nil {
+		tx.lockWhole(tx.db)
+	}
+}
+
+func execFlushDBInTx(tx *transaction, args [][]byte) protocol.Reply {
+	async, errReply := parseFlushMode(args)
+	if errReply != nil {
+		return errReply
+	}
+	tx.db.flushLocked(async)
+	tx.appendAof(tx.db, makeCmdLine("flushdb"))
+	return protocol.NewOkReply()
+}
+
+// execFlushAll FLUSHALL [ASYNC | SYNC]
+func execFlushAll(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	async, errReply := parseFlushMode(args)
+	if errReply != nil {
+		return errReply
+	}
+	for _, db := range s.dbSet {
+		if async {
+			db.flushAsync()
+		} else {
+			db.flush()
+		}
+	}
+	return protocol.NewOkReply()
+}
+
+func lockFlushAllInTx(tx *transaction, args [][]byte) {
+	if _, errReply := parseFlushMode(args); errReply == nil {
+		for _, db := range tx.s.dbSet {
+			tx.lockWhole(db)
+		}
+	}
+}
+
+func execFlushAllInTx(tx *transaction, args [][]byte) protocol.Reply {
+	async, errReply := parseFlushMode(args)
+	if errReply != nil {
+		return errReply
+	}
+	for _, db := range tx.s.dbSet {
+		db.flushLocked(async)
+	}
+	tx.appendAof(nil, makeCmdLine("flushall"))
+	return protocol.NewOkReply()
+}
+
+// execDBSize DBSIZE
+func execDBSize(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	db, errReply := s.selectDB(client.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.NewIntReply(int64(db.size()))
+}
+
+// lockDBSizeInTx holds stopWorld of the db so the size includes the writes of the transaction only
+func lockDBSizeInTx(tx *transaction, args [][]byte) {
+	tx.lockOf(tx.db)
+}
+
+func execDBSizeInTx(tx *transaction, args [][]byte) protocol.Reply {
+	return protocol.NewIntReply(int64(tx.db.data.Len()))
+}
+
+func parseMoveDst(s *Server, src *DB, arg []byte) (*DB, *protocol.ErrReply) {
+	dst, errReply := parseDBIndex(s, arg)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if src == dst {
+		return nil, protocol.NewErrReply("ERR source and destination objects are the same")
+	}
+	return dst, nil
+}
+
+// execMove MOVE key db
+func execMove(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	src, errReply := s.selectDB(client.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	dst, errReply := parseMoveDst(s, src, args[1])
+	if errReply != nil {
+		return errReply
+	}
+
+	key := string(args[0])
+	keys := []string{key}
+	unlock := lockDBs(dbLock{db: src, writeKeys: keys}, dbLock{db: dst, writeKeys: keys})
+	defer dst.handleReadyKeys()
+	defer unlock()
+	if !moveKey(src, dst, key) {
+		return protocol.NewIntReply(0)
+	}
+	src.addVersion(key)
+	dst.addVersion(key)
+	src.appendAof(makeCmdLine("move", args...))
+	return protocol.NewIntReply(1)
+}
+
+// moveKey moves key from src to dst unless dst already holds it, the caller must hold the
+// shard locks of key in both dbs
+func moveKey(src, dst *DB, key string) bool {
+	src.expireIfNeeded(key)
+	dst.expireIfNeeded(key)
+	val, ok := src.getEntity(key)
+	if !ok {
+		return false
+	}
+	if _, exists := dst.getEntity(key); exists {
+		return false
+	}
+	dst.putEntity(key, val)
+	if expireAt, ok := src.expireTime(key); ok {
+		dst.expire(key, expireAt)
+	}
+	src.removeKey(key)
+	src.notify(notifyGeneric, "move_from", key)
+	dst.notify(notifyGeneric, "move_to", key)
+	return true
+}
+
+func lockMoveInTx(tx *transaction, args [][]byte) {
+	if dst, errReply := parseMoveDst(tx.s, tx.db, args[1]); errReply == nil {
+		keys := []string{string(args[0])}
+		tx.lockKeys(tx.db, keys, nil)
+		tx.lockKeys(dst, keys, nil)
+	}
+}
+
+func execMoveInTx(tx *transaction, args [][]byte) protocol.Reply {
+	src := tx.db
+	dst, errReply := parseMoveDst(tx.s, src, args[1])
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	keys := []string{key}
+	moved := false
+	tx.withKeys(src, keys, nil, func() {
+		tx.withKeys(dst, keys, nil, func() {
+			moved = moveKey(src, dst, key)
+		})
+	})
+	if !moved {
+		return protocol.NewIntReply(0)
+	}
+	tx.appendAof(src, makeCmdLine("move", args...))
+	return protocol.NewIntReply(1)
+}
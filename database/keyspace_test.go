@@ -0,0 +1,44 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"testing"
+)
+
+func TestSelectAndMove(t *testing.T) {
+	s := newTestServer(t)
+	client := connection.NewFakeConn()
+
+	assertReply(t, "-ERR DB index is out of range\r\n", exec(s, client, "select 16"))
+	assertReply(t, "-ERR value is not an integer or out of range\r\n", exec(s, client, "select a"))
+
+	s.dbSet[0].putEntity("k", []byte("v"))
+	assertReply(t, ":1\r\n", exec(s, client, "dbsize"))
+	assertReply(t, "-ERR source and destination objects are the same\r\n", exec(s, client, "move k 0"))
+	assertReply(t, ":1\r\n", exec(s, client, "move k 1"))
+	assertReply(t, ":0\r\n", exec(s, client, "move k 1"))
+	assertReply(t, ":0\r\n", exec(s, client, "dbsize"))
+
+	assertReply(t, "+OK\r\n", exec(s, client, "select 1"))
+	assertReply(t, ":1\r\n", exec(s, client, "dbsize"))
+}
+
+func TestSwapAndFlush(t *testing.T) {
+	s := newTestServer(t)
+	client := connection.NewFakeConn()
+
+	s.dbSet[0].putEntity("a", []byte("1"))
+	s.dbSet[0].putEntity("b", []byte("2"))
+	s.dbSet[1].putEntity("c", []byte("3"))
+
+	assertReply(t, "+OK\r\n", exec(s, client, "swapdb 0 1"))
+	assertReply(t, ":1\r\n", exec(s, client, "dbsize"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, client, "flushdb lazy"))
+	assertReply(t, "+OK\r\n", exec(s, client, "flushdb async"))
+	assertReply(t, ":0\r\n", exec(s, client, "dbsize"))
+
+	assertReply(t, "+OK\r\n", exec(s, client, "select 1"))
+	assertReply(t, ":2\r\n", exec(s, client, "dbsize"))
+	assertReply(t, "+OK\r\n", exec(s, client, "flushall"))
+	assertReply(t, ":0\r\n", exec(s, client, "dbsize"))
+}
@@ -0,0 +1,697 @@
+package database
+
+import (
+	"bytes"
+	"godis/datastruct/list"
+	"godis/resp/protocol"
+	"strings"
+)
+
+func init() {
+	registerCommand("lpush", execLPush, -3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("rpush", execRPush, -3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("lpushx", execLPushX, -3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("rpushx", execRPushX, -3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("lpop", execLPop, -2, flagWrite|flagFast).keys(1, 1, 1)
+	registerCommand("rpop", execRPop, -2, flagWrite|flagFast).keys(1, 1, 1)
+	registerCommand("llen", execLLen, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("lrange", execLRange, 4, flagReadOnly).keys(1, 1, 1)
+	registerCommand("lindex", execLIndex, 3, flagReadOnly).keys(1, 1, 1)
+	registerCommand("lset", execLSet, 4, flagWrite|flagDenyOOM).keys(1, 1, 1)
+	registerCommand("linsert", execLInsert, 5, flagWrite|flagDenyOOM).keys(1, 1, 1)
+	registerCommand("lrem", execLRem, 4, flagWrite).keys(1, 1, 1)
+	registerCommand("ltrim", execLTrim, 4, flagWrite).keys(1, 1, 1)
+	registerCommand("lpos", execLPos, -3, flagReadOnly).keys(1, 1, 1)
+	registerCommand("lmove", execLMove, 5, flagWrite|flagDenyOOM).keys(1, 2, 1)
+	registerCommand("rpoplpush", execRPopLPush, 3, flagWrite|flagDenyOOM).keys(1, 2, 1)
+	registerCommand("lmpop", execLMPop, -4, flagWrite).withPrepare(prepareLMPop)
+	registerCommand("blpop", execBLPop, -3, flagWrite|flagBlocking).keys(1, -2, 1).withAof(aofPopFrom("lpop"))
+	registerCommand("brpop", execBRPop, -3, flagWrite|flagBlocking).keys(1, -2, 1).withAof(aofPopFrom("rpop"))
+	registerCommand("blmove", execBLMove, 6, flagWrite|flagDenyOOM|flagBlocking).keys(1, 2, 1).withAof(aofNonBlocking("lmove", false))
+	registerCommand("brpoplpush", execBRPopLPush, 4, flagWrite|flagDenyOOM|flagBlocking).keys(1, 2, 1).withAof(aofNonBlocking("rpoplpush", false))
+	registerCommand("blmpop", execBLMPop, -5, flagWrite|flagBlocking).withPrepare(prepareBLMPop).withAof(aofNonBlocking("lmpop", true))
+}
+
+// getAsList returns the list of key, nil if key doesn't exist
+func (db *DB) getAsList(key string) (*list.QuickList, *protocol.ErrReply) {
+	entity, ok := db.getEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	l, ok := entity.(*list.QuickList)
+	if !ok {
+		return nil, protocol.NewWrongTypeErrReply()
+	}
+	return l, nil
+}
+
+// getOrInitList returns the list of key, an empty list is created if key doesn't exist
+func (db *DB) getOrInitList(key string) (*list.QuickList, *protocol.ErrReply) {
+	l, errReply := db.getAsList(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if l == nil {
+		l = list.NewQuickList()
+		db.putEntity(key, l)
+	}
+	return l, nil
+}
+
+// removeIfEmptyList deletes key once its list has no elements left
+func (db *DB) removeIfEmptyList(key string, l *list.QuickList) {
+	if l.Len() == 0 {
+		db.removeKey(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+}
+
+func listPushEvent(front bool) string {
+	if front {
+		return "lpush"
+	}
+	return "rpush"
+}
+
+func listPopEvent(front bool) string {
+	if front {
+		return "lpop"
+	}
+	return "rpop"
+}
+
+// normalizeRange converts redis style inclusive start and stop indexes into [start, stop),
+// ok is false if the range is empty
+func normalizeRange(start, stop int64, size int) (int, int, bool) {
+	n := int64(size)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	return int(start), int(stop) + 1, true
+}
+
+func pushGeneric(db *DB, args [][]byte, front bool, onlyExisting bool) protocol.Reply {
+	key := string(args[0])
+	var l *list.QuickList
+	var errReply *protocol.ErrReply
+	if onlyExisting {
+		l, errReply = db.getAsList(key)
+		if errReply == nil && l == nil {
+			return protocol.NewIntReply(0)
+		}
+	} else {
+		l, errReply = db.getOrInitList(key)
+	}
+	if errReply != nil {
+		return errReply
+	}
+	for _, val := range args[1:] {
+		if front {
+			l.PushFront(val)
+		} else {
+			l.PushBack(val)
+		}
+	}
+	db.notify(notifyList, listPushEvent(front), key)
+	return protocol.NewIntReply(int64(l.Len()))
+}
+
+// execLPush LPUSH key element [element ...]
+func execLPush(db *DB, args [][]byte) protocol.Reply {
+	return pushGeneric(db, args, true, false)
+}
+
+// execRPush RPUSH key element [element ...]
+func execRPush(db *DB, args [][]byte) protocol.Reply {
+	return pushGeneric(db, args, false, false)
+}
+
+// execLPushX LPUSHX key element [element ...]
+func execLPushX(db *DB, args [][]byte) protocol.Reply {
+	return pushGeneric(db, args, true, true)
+}
+
+// execRPushX RPUSHX key element [element ...]
+func execRPushX(db *DB, args [][]byte) protocol.Reply {
+	return pushGeneric(db, args, false, true)
+}
+
+func popGeneric(db *DB, args [][]byte, front bool) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.NewSyntaxErrReply()
+	}
+	count := int64(-1)
+	if len(args) == 2 {
+		n, ok := parseInt(args[1])
+		if !ok || n < 0 {
+			return protocol.NewErrReply("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	key := string(args[0])
+	l, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		if count < 0 {
+			return protocol.NewNullBulkReply()
+		}
+		return protocol.NewNullArrayReply()
+	}
+
+	pop := l.PopBack
+	if front {
+		pop = l.PopFront
+	}
+	if count < 0 {
+		val := pop()
+		db.notify(notifyList, listPopEvent(front), key)
+		db.removeIfEmptyList(key, l)
+		return protocol.NewBulkReply(val)
+	}
+	result := make([][]byte, 0, min(int(count), l.Len()))
+	for i := int64(0); i < count && l.Len() > 0; i++ {
+		result = append(result, pop())
+	}
+	if len(result) > 0 {
+		db.notify(notifyList, listPopEvent(front), key)
+	}
+	db.removeIfEmptyList(key, l)
+	return protocol.NewMultiBulkReply(result)
+}
+
+// execLPop LPOP key [count]
+func execLPop(db *DB, args [][]byte) protocol.Reply {
+	return popGeneric(db, args, true)
+}
+
+// execRPop RPOP key [count]
+func execRPop(db *DB, args [][]byte) protocol.Reply {
+	return popGeneric(db, args, false)
+}
+
+// execLLen LLEN key
+func execLLen(db *DB, args [][]byte) protocol.Reply {
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.NewIntReply(0)
+	}
+	return protocol.NewIntReply(int64(l.Len()))
+}
+
+// execLRange LRANGE key start stop
+func execLRange(db *DB, args [][]byte) protocol.Reply {
+	start, ok1 := parseInt(args[1])
+	stop, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		return protocol.NewNotIntegerErrReply()
+	}
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.NewEmptyMultiBulkReply()
+	}
+	from, to, ok := normalizeRange(start, stop, l.Len())
+	if !ok {
+		return protocol.NewEmptyMultiBulkReply()
+	}
+	return protocol.NewMultiBulkReply(l.Range(from, to))
+}
+
+// normalizeIndex converts a possibly negative index, ok is false if it's out of range
+func normalizeIndex(index int64, size int) (int, bool) {
+	if index < 0 {
+		index += int64(size)
+	}
+	if index < 0 || index >= int64(size) {
+		return 0, false
+	}
+	return int(index), true
+}
+
+// execLIndex LINDEX key index
+func execLIndex(db *DB, args [][]byte) protocol.Reply {
+	index, ok := parseInt(args[1])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.NewNullBulkReply()
+	}
+	i, ok := normalizeIndex(index, l.Len())
+	if !ok {
+		return protocol.NewNullBulkReply()
+	}
+	return protocol.NewBulkReply(l.Get(i))
+}
+
+// execLSet LSET key index element
+func execLSet(db *DB, args [][]byte) protocol.Reply {
+	index, ok := parseInt(args[1])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.NewErrReply("ERR no such key")
+	}
+	i, ok := normalizeIndex(index, l.Len())
+	if !ok {
+		return protocol.NewErrReply("ERR index out of range")
+	}
+	l.Set(i, args[2])
+	db.notify(notifyList, "lset", string(args[0]))
+	return protocol.NewOkReply()
+}
+
+// execLInsert LINSERT key <BEFORE | AFTER> pivot element
+func execLInsert(db *DB, args [][]byte) protocol.Reply {
+	var after bool
+	switch strings.ToUpper(string(args[1])) {
+	case "BEFORE":
+		after = false
+	case "AFTER":
+		after = true
+	default:
+		return protocol.NewSyntaxErrReply()
+	}
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.NewIntReply(0)
+	}
+
+	pivot := -1
+	l.ForEach(func(i int, val []byte) bool {
+		if bytes.Equal(val, args[2]) {
+			pivot = i
+			return false
+		}
+		return true
+	})
+	if pivot < 0 {
+		return protocol.NewIntReply(-1)
+	}
+	if after {
+		pivot++
+	}
+	l.Insert(pivot, args[3])
+	db.notify(notifyList, "linsert", string(args[0]))
+	return protocol.NewIntReply(int64(l.Len()))
+}
+
+// execLRem LREM key count element
+func execLRem(db *DB, args [][]byte) protocol.Reply {
+	count, ok := parseInt(args[1])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	key := string(args[0])
+	l, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.NewIntReply(0)
+	}
+	removed := l.RemoveByVal(args[2], int(count))
+	if removed > 0 {
+		db.notify(notifyList, "lrem", key)
+	}
+	db.removeIfEmptyList(key, l)
+	return protocol.NewIntReply(int64(removed))
+}
+
+// execLTrim LTRIM key start stop
+func execLTrim(db *DB, args [][]byte) protocol.Reply {
+	start, ok1 := parseInt(args[1])
+	stop, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		return protocol.NewNotIntegerErrReply()
+	}
+	key := string(args[0])
+	l, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.NewOkReply()
+	}
+	from, to, ok := normalizeRange(start, stop, l.Len())
+	if ok {
+		l.Trim(from, to)
+	} else {
+		l.Trim(0, 0)
+	}
+	db.notify(notifyList, "ltrim", key)
+	db.removeIfEmptyList(key, l)
+	return protocol.NewOkReply()
+}
+
+// execLPos LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func execLPos(db *DB, args [][]byte) protocol.Reply {
+	rank, count, maxLen := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return protocol.NewSyntaxErrReply()
+		}
+		n, ok := parseInt(args[i+1])
+		if !ok {
+			return protocol.NewNotIntegerErrReply()
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "RANK":
+			if n == 0 {
+				return protocol.NewErrReply("ERR RANK can't be zero: use 1 to start from the first match, " +
+					"2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return protocol.NewErrReply("ERR COUNT can't be negative")
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return protocol.NewErrReply("ERR MAXLEN can't be negative")
+			}
+			maxLen = n
+		default:
+			return protocol.NewSyntaxErrReply()
+		}
+	}
+
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	positions := make([]protocol.Reply, 0)
+	if l != nil {
+		skip := rank - 1
+		if rank < 0 {
+			skip = -rank - 1
+		}
+		scanned := int64(0)
+		visit := func(i int, val []byte) bool {
+			if maxLen > 0 && scanned >= maxLen {
+				return false
+			}
+			scanned++
+			if !bytes.Equal(val, args[1]) {
+				return true
+			}
+			if skip > 0 {
+				skip--
+				return true
+			}
+			positions = append(positions, protocol.NewIntReply(int64(i)))
+			// count为0时返回全部匹配
+			return count == 0 || int64(len(positions)) < max(count, 1)
+		}
+		if rank > 0 {
+			l.ForEach(visit)
+		} else {
+			l.ReverseForEach(visit)
+		}
+	}
+
+	if count < 0 {
+		if len(positions) == 0 {
+			return protocol.NewNullBulkReply()
+		}
+		return positions[0]
+	}
+	return protocol.NewArrayReply(positions)
+}
+
+func parseListSide(arg []byte) (front bool, ok bool) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// moveGeneric pops from the source list and pushes to the destination list, nil if source is empty
+func moveGeneric(db *DB, src, dst string, fromFront, toFront bool) protocol.Reply {
+	srcList, errReply := db.getAsList(src)
+	if errReply != nil {
+		return errReply
+	}
+	if srcList == nil {
+		return protocol.NewNullBulkReply()
+	}
+	dstList, errReply := db.getAsList(dst)
+	if errReply != nil {
+		return errReply
+	}
+
+	var val []byte
+	if fromFront {
+		val = srcList.PopFront()
+	} else {
+		val = srcList.PopBack()
+	}
+	if dstList == nil {
+		dstList = list.NewQuickList()
+		db.putEntity(dst, dstList)
+	}
+	if toFront {
+		dstList.PushFront(val)
+	} else {
+		dstList.PushBack(val)
+	}
+	db.notify(notifyList, listPopEvent(fromFront), src)
+	db.notify(notifyList, listPushEvent(toFront), dst)
+	db.removeIfEmptyList(src, srcList)
+	return protocol.NewBulkReply(val)
+}
+
+// execLMove LMOVE source destination <LEFT | RIGHT> <LEFT | RIGHT>
+func execLMove(db *DB, args [][]byte) protocol.Reply {
+	fromFront, ok1 := parseListSide(args[2])
+	toFront, ok2 := parseListSide(args[3])
+	if !ok1 || !ok2 {
+		return protocol.NewSyntaxErrReply()
+	}
+	return moveGeneric(db, string(args[0]), string(args[1]), fromFront, toFront)
+}
+
+// execRPopLPush RPOPLPUSH source destination
+func execRPopLPush(db *DB, args [][]byte) protocol.Reply {
+	return moveGeneric(db, string(args[0]), string(args[1]), false, true)
+}
+
+// parseNumKeys parses numkeys followed by the keys, returns the keys and the remaining arguments
+func parseNumKeys(args [][]byte) ([]string, [][]byte, *protocol.ErrReply) {
+	n, ok := parseInt(args[0])
+	if !ok {
+		return nil, nil, protocol.NewNotIntegerErrReply()
+	}
+	if n <= 0 {
+		return nil, nil, protocol.NewErrReply("ERR numkeys should be greater than 0")
+	}
+	if n > int64(len(args)-1) {
+		return nil, nil, protocol.NewSyntaxErrReply()
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = string(args[i+1])
+	}
+	return keys, args[n+1:], nil
+}
+
+// parseMPopArgs numkeys key [key ...] <LEFT | RIGHT> [COUNT count]
+func parseMPopArgs(args [][]byte) (keys []string, front bool, count int, errReply *protocol.ErrReply) {
+	keys, rest, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return nil, false, 0, errReply
+	}
+	if len(rest) == 0 {
+		return nil, false, 0, protocol.NewSyntaxErrReply()
+	}
+	front, ok := parseListSide(rest[0])
+	if !ok {
+		return nil, false, 0, protocol.NewSyntaxErrReply()
+	}
+	count = 1
+	rest = rest[1:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "COUNT" {
+			return nil, false, 0, protocol.NewSyntaxErrReply()
+		}
+		n, ok := parseInt(rest[1])
+		if !ok || n <= 0 {
+			return nil, false, 0, protocol.NewErrReply("ERR count should be greater than 0")
+		}
+		count = int(n)
+	}
+	return keys, front, count, nil
+}
+
+func prepareLMPop(args [][]byte) ([]string, []string) {
+	keys, _, _ := parseNumKeys(args)
+	return keys, nil
+}
+
+func prepareBLMPop(args [][]byte) ([]string, []string) {
+	keys, _, _ := parseNumKeys(args[1:])
+	return keys, nil
+}
+
+// mpopGeneric pops up to count elements from the first non empty list, nil if all lists are empty
+func mpopGeneric(db *DB, keys []string, front bool, count int) protocol.Reply {
+	for _, key := range keys {
+		l, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if l == nil {
+			continue
+		}
+		result := make([][]byte, 0, min(count, l.Len()))
+		for len(result) < count && l.Len() > 0 {
+			if front {
+				result = append(result, l.PopFront())
+			} else {
+				result = append(result, l.PopBack())
+			}
+		}
+		db.notify(notifyList, listPopEvent(front), key)
+		db.removeIfEmptyList(key, l)
+		return protocol.NewArrayReply([]protocol.Reply{
+			protocol.NewBulkReply([]byte(key)),
+			protocol.NewMultiBulkReply(result),
+		})
+	}
+	return nil
+}
+
+// execLMPop LMPOP numkeys key [key ...] <LEFT | RIGHT> [COUNT count]
+func execLMPop(db *DB, args [][]byte) protocol.Reply {
+	keys, front, count, errReply := parseMPopArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	if reply := mpopGeneric(db, keys, front, count); reply != nil {
+		return reply
+	}
+	return protocol.NewNullArrayReply()
+}
+
+// execBLMPop BLMPOP timeout numkeys key [key ...] <LEFT | RIGHT> [COUNT count]
+func execBLMPop(db *DB, args [][]byte) protocol.Reply {
+	timeout, errReply := parseBlockTimeout(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	keys, front, count, errReply := parseMPopArgs(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	if reply := mpopGeneric(db, keys, front, count); reply != nil {
+		return reply
+	}
+	return &blockReply{keys: keys, keyType: "list", timeout: timeout, timeoutReply: protocol.NewNullArrayReply()}
+}
+
+func blockingPopGeneric(db *DB, args [][]byte, front bool) protocol.Reply {
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	keys := make([]string, len(args)-1)
+	for i := range keys {
+		keys[i] = string(args[i])
+	}
+	for _, key := range keys {
+		l, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if l == nil {
+			continue
+		}
+		var val []byte
+		if front {
+			val = l.PopFront()
+		} else {
+			val = l.PopBack()
+		}
+		db.notify(notifyList, listPopEvent(front), key)
+		db.removeIfEmptyList(key, l)
+		return protocol.NewMultiBulkReply([][]byte{[]byte(key), val})
+	}
+	return &blockReply{keys: keys, keyType: "list", timeout: timeout, timeoutReply: protocol.NewNullArrayReply()}
+}
+
+// execBLPop BLPOP key [key ...] timeout
+func execBLPop(db *DB, args [][]byte) protocol.Reply {
+	return blockingPopGeneric(db, args, true)
+}
+
+// execBRPop BRPOP key [key ...] timeout
+func execBRPop(db *DB, args [][]byte) protocol.Reply {
+	return blockingPopGeneric(db, args, false)
+}
+
+func blockingMoveGeneric(db *DB, args [][]byte, fromFront, toFront bool, timeoutArg []byte) protocol.Reply {
+	timeout, errReply := parseBlockTimeout(timeoutArg)
+	if errReply != nil {
+		return errReply
+	}
+	src := string(args[0])
+	l, errReply := db.getAsList(src)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return &blockReply{keys: []string{src}, keyType: "list", timeout: timeout, timeoutReply: protocol.NewNullBulkReply()}
+	}
+	return moveGeneric(db, src, string(args[1]), fromFront, toFront)
+}
+
+// execBLMove BLMOVE source destination <LEFT | RIGHT> <LEFT | RIGHT> timeout
+func execBLMove(db *DB, args [][]byte) protocol.Reply {
+	fromFront, ok1 := parseListSide(args[2])
+	toFront, ok2 := parseListSide(args[3])
+	if !ok1 || !ok2 {
+		return protocol.NewSyntaxErrReply()
+	}
+	return blockingMoveGeneric(db, args, fromFront, toFront, args[4])
+}
+
+// execBRPopLPush BRPOPLPUSH source destination timeout
+func execBRPopLPush(db *DB, args [][]byte) protocol.Reply {
+	return blockingMoveGeneric(db, args, false, true, args[2])
+}
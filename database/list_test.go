@@ -0,0 +1,77 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"testing"
+)
+
+func TestListPushPop(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, ":0\r\n", exec(s, c, "lpushx l a"))
+	assertReply(t, ":3\r\n", exec(s, c, "rpush l a b c"))
+	assertReply(t, ":5\r\n", exec(s, c, "lpush l y z"))
+	assertReply(t, "+list\r\n", exec(s, c, "type l"))
+	assertReply(t, "*5\r\n$1\r\nz\r\n$1\r\ny\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", exec(s, c, "lrange l 0 -1"))
+	assertReply(t, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n", exec(s, c, "lrange l -2 100"))
+	assertReply(t, "*0\r\n", exec(s, c, "lrange l 5 10"))
+	assertReply(t, "$1\r\nz\r\n", exec(s, c, "lpop l"))
+	assertReply(t, "*2\r\n$1\r\nc\r\n$1\r\nb\r\n", exec(s, c, "rpop l 2"))
+	assertReply(t, "*2\r\n$1\r\ny\r\n$1\r\na\r\n", exec(s, c, "lpop l 10"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists l"))
+	assertReply(t, "$-1\r\n", exec(s, c, "lpop l"))
+	assertReply(t, "*-1\r\n", exec(s, c, "lpop l 1"))
+
+	exec(s, c, "set str v")
+	assertReply(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "lpush str a"))
+}
+
+func TestListModify(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "rpush l a b c a b c")
+	assertReply(t, "$1\r\nc\r\n", exec(s, c, "lindex l -1"))
+	assertReply(t, "$-1\r\n", exec(s, c, "lindex l 6"))
+	assertReply(t, "+OK\r\n", exec(s, c, "lset l 1 B"))
+	assertReply(t, "-ERR index out of range\r\n", exec(s, c, "lset l 10 x"))
+	assertReply(t, "-ERR no such key\r\n", exec(s, c, "lset nokey 0 x"))
+	assertReply(t, ":7\r\n", exec(s, c, "linsert l after B x"))
+	assertReply(t, ":-1\r\n", exec(s, c, "linsert l before nope x"))
+	assertReply(t, ":1\r\n", exec(s, c, "lrem l -1 a"))
+	assertReply(t, "*6\r\n$1\r\na\r\n$1\r\nB\r\n$1\r\nx\r\n$1\r\nc\r\n$1\r\nb\r\n$1\r\nc\r\n", exec(s, c, "lrange l 0 -1"))
+	assertReply(t, "+OK\r\n", exec(s, c, "ltrim l 1 -2"))
+	assertReply(t, "*4\r\n$1\r\nB\r\n$1\r\nx\r\n$1\r\nc\r\n$1\r\nb\r\n", exec(s, c, "lrange l 0 -1"))
+	assertReply(t, "+OK\r\n", exec(s, c, "ltrim l 5 10"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists l"))
+}
+
+func TestListPos(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "rpush l a b c 1 2 3 c c")
+	assertReply(t, ":2\r\n", exec(s, c, "lpos l c"))
+	assertReply(t, ":6\r\n", exec(s, c, "lpos l c rank 2"))
+	assertReply(t, ":7\r\n", exec(s, c, "lpos l c rank -1"))
+	assertReply(t, "*2\r\n:2\r\n:6\r\n", exec(s, c, "lpos l c count 2"))
+	assertReply(t, "*3\r\n:2\r\n:6\r\n:7\r\n", exec(s, c, "lpos l c count 0"))
+	assertReply(t, "*2\r\n:7\r\n:6\r\n", exec(s, c, "lpos l c rank -1 count 2"))
+	assertReply(t, "$-1\r\n", exec(s, c, "lpos l c maxlen 2"))
+	assertReply(t, "*0\r\n", exec(s, c, "lpos l x count 1"))
+}
+
+func TestListMove(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "rpush src a b c")
+	assertReply(t, "$1\r\nc\r\n", exec(s, c, "rpoplpush src dst"))
+	assertReply(t, "$1\r\na\r\n", exec(s, c, "lmove src dst left right"))
+	assertReply(t, "*2\r\n$1\r\nc\r\n$1\r\na\r\n", exec(s, c, "lrange dst 0 -1"))
+	assertReply(t, "$1\r\nb\r\n", exec(s, c, "lmove src src left right"))
+	assertReply(t, "$1\r\nb\r\n", exec(s, c, "lmove src dst left left"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists src"))
+	assertReply(t, "$-1\r\n", exec(s, c, "rpoplpush src dst"))
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"errors"
+	"godis/config"
+	"godis/pkg/logx"
+	"strconv"
+	"sync/atomic"
+)
+
+// keyspace event classes, each one is a flag letter of notify-keyspace-events
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+	notifyStream               // t
+	notifyKeyMiss              // m
+	notifyNew                  // n
+
+	// A 不包含m和n
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZSet | notifyExpired | notifyEvicted | notifyStream
+)
+
+var notifyFlagLetters = map[byte]int{
+	'K': notifyKeyspace,
+	'E': notifyKeyevent,
+	'g': notifyGeneric,
+	'$': notifyString,
+	'l': notifyList,
+	's': notifySet,
+	'h': notifyHash,
+	'z': notifyZSet,
+	'x': notifyExpired,
+	'e': notifyEvicted,
+	't': notifyStream,
+	'm': notifyKeyMiss,
+	'n': notifyNew,
+	'A': notifyAll,
+}
+
+// parseNotifyFlags parses the value of notify-keyspace-events
+func parseNotifyFlags(src string) (int, error) {
+	flags := 0
+	for i := 0; i < len(src); i++ {
+		flag, ok := notifyFlagLetters[src[i]]
+		if !ok {
+			return 0, errors.New("invalid event class '" + string(src[i]) + "'")
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+type parsedNotifyFlags struct {
+	src   string
+	flags int
+}
+
+// 缓存解析结果，配置不变时每次通知只需比较字符串
+var notifyFlagsCache atomic.Pointer[parsedNotifyFlags]
+
+func notifyFlags() int {
+	src := config.Properties.NotifyKeyspaceEvents
+	if cached := notifyFlagsCache.Load(); cached != nil && cached.src == src {
+		return cached.flags
+	}
+	flags, err := parseNotifyFlags(src)
+	if err != nil {
+		logx.L().Warnf("invalid notify-keyspace-events %s: %v", src, err)
+	}
+	notifyFlagsCache.Store(&parsedNotifyFlags{src: src, flags: flags})
+	return flags
+}
+
+// notify publishes a keyspace event of key if its class is enabled by notify-keyspace-events,
+// to __keyspace@<db>__:<key> with the event as message and to __keyevent@<db>__:<event>
+// with the key as message. Executors call it after modifying a key, with the shard locks held.
+// The events of a key written by a running transaction are buffered until it commits.
+func (db *DB) notify(class int, event string, key string) {
+	if db.events == nil {
+		return
+	}
+	flags := notifyFlags()
+	if flags&class == 0 {
+		return
+	}
+	publish := db.events.publish
+	if buf, ok := db.txEvents.Get(key); ok {
+		publish = buf.(*eventBuffer).publish
+	}
+	prefix := "@" + strconv.Itoa(db.index) + "__:"
+	if flags&notifyKeyspace != 0 {
+		publish("__keyspace"+prefix+key, []byte(event))
+	}
+	if flags&notifyKeyevent != 0 {
+		publish("__keyevent"+prefix+event, []byte(key))
+	}
+}
+
+// eventBuffer holds the keyspace events of a transaction, they are published once it commits
+// and dropped if it's rolled back
+type eventBuffer struct {
+	hub      *pubsubHub
+	channels []string
+	messages [][]byte
+}
+
+func newEventBuffer(hub *pubsubHub) *eventBuffer {
+	return &eventBuffer{hub: hub}
+}
+
+func (b *eventBuffer) publish(channel string, message []byte) int {
+	b.channels = append(b.channels, channel)
+	b.messages = append(b.messages, message)
+	return 0
+}
+
+func (b *eventBuffer) flush() {
+	for i, channel := range b.channels {
+		b.hub.publish(channel, b.messages[i])
+	}
+}
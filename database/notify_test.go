@@ -0,0 +1,182 @@
+package database
+
+import (
+	"godis/config"
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNotifyFlags(t *testing.T) {
+	flags, err := parseNotifyFlags("KEA")
+	assert.Nil(t, err)
+	assert.Equal(t, notifyKeyspace|notifyKeyevent|notifyAll, flags)
+	assert.Zero(t, flags&notifyKeyMiss)
+	assert.Zero(t, flags&notifyNew)
+
+	flags, err = parseNotifyFlags("Kg$lshzxetmn")
+	assert.Nil(t, err)
+	assert.Equal(t, notifyKeyspace|notifyAll|notifyKeyMiss|notifyNew, flags)
+
+	_, err = parseNotifyFlags("KQ")
+	assert.NotNil(t, err)
+}
+
+func withNotifyEvents(t *testing.T, events string) {
+	config.Properties.NotifyKeyspaceEvents = events
+	t.Cleanup(func() { config.Properties.NotifyKeyspaceEvents = "" })
+}
+
+func pmessage(pattern, channel, message string) string {
+	return string(protocol.NewMultiBulkReply([][]byte{
+		[]byte("pmessage"), []byte(pattern), []byte(channel), []byte(message),
+	}).ToBytes())
+}
+
+func TestKeyspaceEvents(t *testing.T) {
+	withNotifyEvents(t, "KA")
+	s := newTestServer(t)
+	sub := connection.NewFakeConn()
+	c := connection.NewFakeConn()
+	pattern := "__keyspace@0__:*"
+	exec(s, sub, "psubscribe "+pattern)
+	subscribed := len(sub.Bytes())
+
+	exec(s, c, "set a 1 ex 100")
+	exec(s, c, "get a")
+	exec(s, c, "rpush list x")
+	exec(s, c, "lpop list")
+	exec(s, c, "sadd set m")
+	exec(s, c, "sadd set m")
+	exec(s, c, "rename a b")
+	exec(s, c, "del b nokey")
+	exec(s, c, "hset h f v")
+	exec(s, c, "zadd z 1 m")
+	exec(s, c, "incr n")
+	exec(s, c, "xadd s 1-1 f v")
+	// 其他db的事件发布到各自的频道
+	exec(s, c, "select 1")
+	exec(s, c, "set a 1")
+
+	events := []string{
+		"a", "set", "a", "expire",
+		"list", "rpush", "list", "lpop", "list", "del",
+		"set", "sadd",
+		"a", "rename_from", "b", "rename_to",
+		"b", "del",
+		"h", "hset", "z", "zadd", "n", "incrby", "s", "xadd",
+	}
+	var expected strings.Builder
+	for i := 0; i < len(events); i += 2 {
+		expected.WriteString(pmessage(pattern, "__keyspace@0__:"+events[i], events[i+1]))
+	}
+	assert.Equal(t, expected.String(), string(sub.Bytes()[subscribed:]))
+}
+
+func TestKeyeventEvents(t *testing.T) {
+	withNotifyEvents(t, "Ex")
+	s := newTestServer(t)
+	sub := connection.NewFakeConn()
+	c := connection.NewFakeConn()
+	exec(s, sub, "subscribe __keyevent@0__:expired __keyevent@0__:set")
+	subscribed := len(sub.Bytes())
+
+	exec(s, c, "set a 1 px 10")
+	time.Sleep(50 * time.Millisecond)
+	// 写命令执行前删除过期的key，只开启了x类事件
+	exec(s, c, "set a 2")
+	assert.Equal(t, "*3\r\n$7\r\nmessage\r\n$22\r\n__keyevent@0__:expired\r\n$1\r\na\r\n", string(sub.Bytes()[subscribed:]))
+}
+
+func TestKeyMissAndNewEvents(t *testing.T) {
+	withNotifyEvents(t, "Emn")
+	s := newTestServer(t)
+	sub := connection.NewFakeConn()
+	c := connection.NewFakeConn()
+	exec(s, sub, "psubscribe __keyevent@0__:*")
+	subscribed := len(sub.Bytes())
+
+	exec(s, c, "get a")
+	exec(s, c, "set a 1")
+	exec(s, c, "set a 2")
+	exec(s, c, "get a")
+	assert.Equal(t, pmessage("__keyevent@0__:*", "__keyevent@0__:keymiss", "a")+
+		pmessage("__keyevent@0__:*", "__keyevent@0__:new", "a"), string(sub.Bytes()[subscribed:]))
+}
+
+func TestTransactionEvents(t *testing.T) {
+	withNotifyEvents(t, "KA")
+	s := newTestServer(t)
+	sub := connection.NewFakeConn()
+	c := connection.NewFakeConn()
+	pattern := "__keyspace@*__:*"
+	exec(s, sub, "psubscribe "+pattern)
+	subscribed := len(sub.Bytes())
+
+	// 回滚的事务不发布任何事件
+	exec(s, c, "multi rollback")
+	exec(s, c, "set a 1")
+	exec(s, c, "lpush list x")
+	exec(s, c, "incr list")
+	exec(s, c, "exec")
+	assert.Equal(t, subscribed, len(sub.Bytes()))
+
+	// 提交后按修改顺序发布，之后的修改不再缓存
+	exec(s, c, "multi")
+	exec(s, c, "set a 1")
+	exec(s, c, "move a 1")
+	exec(s, c, "select 1")
+	exec(s, c, "lpush list x")
+	exec(s, c, "exec")
+	exec(s, c, "del a")
+	expected := pmessage(pattern, "__keyspace@0__:a", "set") +
+		pmessage(pattern, "__keyspace@0__:a", "move_from") +
+		pmessage(pattern, "__keyspace@1__:a", "move_to") +
+		pmessage(pattern, "__keyspace@1__:list", "lpush") +
+		pmessage(pattern, "__keyspace@1__:a", "del")
+	assert.Equal(t, expected, string(sub.Bytes()[subscribed:]))
+}
+
+func TestNotifyDisabled(t *testing.T) {
+	s := newTestServer(t)
+	sub := connection.NewFakeConn()
+	c := connection.NewFakeConn()
+	exec(s, sub, "psubscribe *")
+	subscribed := len(sub.Bytes())
+	exec(s, c, "set a 1")
+	exec(s, c, "del a")
+	assert.Equal(t, subscribed, len(sub.Bytes()))
+}
+
+func TestShardPubSub(t *testing.T) {
+	s := newTestServer(t)
+	sub := connection.NewFakeConn()
+	c := connection.NewFakeConn()
+
+	assertReply(t, "*3\r\n$10\r\nssubscribe\r\n$2\r\nch\r\n:1\r\n", exec(s, sub, "ssubscribe ch"))
+	exec(s, sub, "subscribe ch")
+	exec(s, sub, "psubscribe *")
+	assertReply(t, "-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context\r\n",
+		exec(s, sub, "get a"))
+	subscribed := len(sub.Bytes())
+
+	// 分片频道与普通频道互不干扰，模式也不匹配分片频道
+	assertReply(t, ":1\r\n", exec(s, c, "spublish ch hi"))
+	assert.Equal(t, "*3\r\n$8\r\nsmessage\r\n$2\r\nch\r\n$2\r\nhi\r\n", string(sub.Bytes()[subscribed:]))
+	assertReply(t, ":2\r\n", exec(s, c, "publish ch hi"))
+	assertReply(t, ":0\r\n", exec(s, c, "spublish other hi"))
+
+	assertReply(t, "*1\r\n$2\r\nch\r\n", exec(s, c, "pubsub shardchannels"))
+	assertReply(t, "*0\r\n", exec(s, c, "pubsub shardchannels x*"))
+	assertReply(t, "*4\r\n$2\r\nch\r\n:1\r\n$1\r\nx\r\n:0\r\n", exec(s, c, "pubsub shardnumsub ch x"))
+	assertReply(t, "*2\r\n$2\r\nch\r\n$2\r\nxx\r\n", exec(s, c, "command getkeys ssubscribe ch xx"))
+
+	assertReply(t, "*3\r\n$12\r\nsunsubscribe\r\n$2\r\nch\r\n:0\r\n", exec(s, sub, "sunsubscribe"))
+	assertReply(t, ":0\r\n", exec(s, c, "spublish ch hi"))
+	s.AfterClientClose(sub)
+	assertReply(t, ":0\r\n", exec(s, c, "publish ch hi"))
+}
@@ -0,0 +1,315 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"godis/config"
+	"godis/pkg/logx"
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 主节点一侧从节点的状态，与redis的slave state相同
+const (
+	// 发送了REPLCONF，还没有PSYNC
+	replicaHandshake int32 = iota
+	// 等待正在进行的后台任务结束后开始全量同步
+	replicaWaitBgSave
+	// 正在生成RDB，之后的复制流暂存在输出缓冲区中
+	replicaSendRdb
+	replicaOnline
+)
+
+var errNoWaitingReplica = errors.New("no replica is waiting for a full sync")
+
+// replicaClient is a replica connected to this server. The replication stream is queued and
+// written by its own goroutine, so a slow replica never blocks the writes feeding the stream.
+type replicaClient struct {
+	conn connection.Connection
+	// 以下字段由replication.mu保护
+	state int32
+	// REPLCONF listening-port报告的端口
+	listeningPort int
+	// 从节点上次REPLCONF ACK的偏移量与时间
+	ackOffset int64
+	ackTime   time.Time
+
+	mu sync.Mutex
+	// 等待写出的复制流，超过replica-output-buffer-limit后断开从节点
+	pending [][]byte
+	size    int
+	closed  bool
+	wake    chan struct{}
+}
+
+func newReplicaClient(conn connection.Connection) *replicaClient {
+	return &replicaClient{
+		conn: conn,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// addr is the address the replica listens on, as shown in the logs
+func (rc *replicaClient) addr() string {
+	addr := rc.conn.RemoteAddr()
+	if i := strings.LastIndexByte(addr, ':'); i >= 0 && rc.listeningPort > 0 {
+		addr = addr[:i+1] + strconv.Itoa(rc.listeningPort)
+	}
+	return addr
+}
+
+// send queues b to be written to the replica
+func (rc *replicaClient) send(b []byte) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return
+	}
+	rc.pending = append(rc.pending, b)
+	rc.size += len(b)
+	if limit := config.Properties.ReplicaOutputBufferLimit; limit > 0 && rc.size > limit {
+		logx.L().Warnf("replica %s scheduled to be closed ASAP for overcoming of output buffer limits", rc.addr())
+		rc.closeLocked()
+		return
+	}
+	select {
+	case rc.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (rc *replicaClient) close() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.closeLocked()
+}
+
+func (rc *replicaClient) closeLocked() {
+	if rc.closed {
+		return
+	}
+	rc.closed = true
+	rc.pending = nil
+	// Close会等待正在写出的数据，不能阻塞写命令
+	go rc.conn.Close()
+}
+
+// startStreaming makes the replica online, first is written before the queued stream. The
+// caller must hold replication.mu.
+func (rc *replicaClient) startStreaming(first []byte) {
+	rc.state = replicaOnline
+	rc.ackTime = time.Now()
+	go rc.writeLoop(first)
+}
+
+func (rc *replicaClient) writeLoop(first []byte) {
+	if _, err := rc.conn.Write(first); err != nil {
+		rc.close()
+		return
+	}
+	for {
+		select {
+		case <-rc.wake:
+		case <-rc.conn.Done():
+			return
+		}
+		rc.mu.Lock()
+		batch := rc.pending
+		rc.pending = nil
+		closed := rc.closed
+		rc.mu.Unlock()
+		if closed {
+			return
+		}
+		written := 0
+		for _, b := range batch {
+			if _, err := rc.conn.Write(b); err != nil {
+				rc.close()
+				return
+			}
+			written += len(b)
+		}
+		rc.mu.Lock()
+		rc.size -= written
+		rc.mu.Unlock()
+	}
+}
+
+// replicaOf returns the replica state of client, creating it on the first REPLCONF or PSYNC.
+// The caller must hold mu.
+func (r *replication) replicaOf(client connection.Connection) *replicaClient {
+	rc, ok := r.replicas[client.ID()]
+	if !ok {
+		rc = newReplicaClient(client)
+		r.replicas[client.ID()] = rc
+	}
+	return rc
+}
+
+// execReplconf REPLCONF option value [option value ...]
+func execReplconf(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	if len(args)%2 != 0 {
+		return protocol.NewSyntaxErrReply()
+	}
+	r := s.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return protocol.NewNotIntegerErrReply()
+			}
+			r.replicaOf(client).listeningPort = port
+		case "capa":
+			// RDB总是以$<length>的格式发送，所有从节点都能读取，不需要记录从节点的能力
+		case "ack":
+			// 从节点定期报告偏移量，不需要回复
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if rc, ok := r.replicas[client.ID()]; ok && err == nil {
+				rc.ackOffset = max(rc.ackOffset, offset)
+				rc.ackTime = time.Now()
+			}
+			return protocol.NewNoReply()
+		case "getack":
+			// 只有主节点发送GETACK，从节点在读取复制流时处理
+			return protocol.NewNoReply()
+		default:
+			return protocol.NewErrReply("ERR Unrecognized REPLCONF option: " + string(args[i]))
+		}
+	}
+	return protocol.NewOkReply()
+}
+
+// execPSync PSYNC replicationid offset
+func execPSync(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.NewNotIntegerErrReply()
+	}
+	r := s.repl
+	r.mu.Lock()
+	if r.master != nil && r.master.state.Load() != replStateConnected {
+		r.mu.Unlock()
+		return protocol.NewErrReply("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+	rc := r.replicaOf(client)
+	if rc.state != replicaHandshake {
+		// 已经在同步的从节点再次PSYNC时忽略
+		r.mu.Unlock()
+		return protocol.NewNoReply()
+	}
+	if r.tryPartialSync(rc, string(args[0]), offset) {
+		r.mu.Unlock()
+		return protocol.NewNoReply()
+	}
+	r.createBacklog()
+	rc.state = replicaWaitBgSave
+	r.mu.Unlock()
+
+	logx.L().Infof("full resync requested by replica %s", rc.addr())
+	// 其他后台任务结束后由replicationCron开始
+	if err := s.startReplSync(); err != nil && !errors.Is(err, errBgJobRunning) {
+		logx.L().Errorf("start full sync with replicas failed: %v", err)
+	}
+	return protocol.NewNoReply()
+}
+
+// tryPartialSync continues the stream of rc from offset if the backlog still holds it and
+// replID is the ID of this history, the caller must hold mu
+func (r *replication) tryPartialSync(rc *replicaClient, replID string, offset int64) bool {
+	if r.backlog == nil {
+		return false
+	}
+	if replID != r.replID && (replID != r.replID2 || offset > r.secondOffset) {
+		return false
+	}
+	start := r.offset - int64(r.backlog.histLen) + 1
+	if offset < start || offset > r.offset+1 {
+		return false
+	}
+	missing := r.backlog.tail(int(r.offset + 1 - offset))
+	rc.startStreaming(append([]byte("+CONTINUE "+r.replID+protocol.CRLF), missing...))
+	logx.L().Infof("partial resynchronization request from %s accepted, sending %d bytes of backlog starting from offset %d",
+		rc.addr(), len(missing), offset)
+	return true
+}
+
+// startReplSync takes the snapshots for the replicas waiting for a full sync and sends them
+// an RDB in the background
+func (s *Server) startReplSync() error {
+	if err := s.startBgJob(bgJobReplSync); err != nil {
+		return err
+	}
+	r := s.repl
+	var replicas []*replicaClient
+	var rsi rdbSaveInfo
+	// 从节点的偏移量在applyMu下随命令更新
+	r.applyMu.Lock()
+	snapshots, err := s.takeSnapshots(func() error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, rc := range r.replicas {
+			if rc.state == replicaWaitBgSave {
+				rc.state = replicaSendRdb
+				replicas = append(replicas, rc)
+			}
+		}
+		if len(replicas) == 0 {
+			return errNoWaitingReplica
+		}
+		rsi = rdbSaveInfo{replID: r.replID, offset: r.offset, streamDB: max(r.streamDB, 0)}
+		if link := r.master; link != nil {
+			// 从节点转发主节点的复制流，其中的命令接着主节点连接选择的db执行
+			rsi.streamDB = link.client.GetDBIndex()
+		}
+		return nil
+	})
+	r.applyMu.Unlock()
+	if err != nil {
+		s.finishBgJob()
+		if errors.Is(err, errNoWaitingReplica) {
+			return nil
+		}
+		return err
+	}
+	go s.replSync(snapshots, replicas, rsi)
+	return nil
+}
+
+// replSync generates the RDB in memory before sending it, so walking the snapshots never
+// waits for a slow replica
+func (s *Server) replSync(snapshots []*keyspaceSnapshot, replicas []*replicaClient, rsi rdbSaveInfo) {
+	defer s.finishBgJob()
+
+	start := time.Now()
+	var buf bytes.Buffer
+	err := s.writeRdb(&buf, snapshots, false, &rsi)
+	s.releaseSnapshots(snapshots)
+	r := s.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		logx.L().Errorf("generating the RDB for replicas failed: %v", err)
+		for _, rc := range replicas {
+			rc.close()
+		}
+		return
+	}
+	header := fmt.Sprintf("+FULLRESYNC %s %d\r\n$%d\r\n", rsi.replID, rsi.offset, buf.Len())
+	payload := append([]byte(header), buf.Bytes()...)
+	for _, rc := range replicas {
+		// 生成期间断开的从节点已经被移除
+		if r.replicas[rc.conn.ID()] == rc && rc.state == replicaSendRdb {
+			rc.startStreaming(payload)
+		}
+	}
+	logx.L().Infof("RDB of %d bytes for %d replicas generated in %v", buf.Len(), len(replicas), time.Since(start))
+}
@@ -0,0 +1,421 @@
+package database
+
+import (
+	"godis/pkg/wildcard"
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"sort"
+	"strings"
+	"sync"
+)
+
+func init() {
+	registerSysCommand("subscribe", execSubscribe, -2, flagPubSub|flagNoScript|flagNoMulti)
+	registerSysCommand("unsubscribe", execUnsubscribe, -1, flagPubSub|flagNoScript|flagNoMulti)
+	registerSysCommand("psubscribe", execPSubscribe, -2, flagPubSub|flagNoScript|flagNoMulti)
+	registerSysCommand("punsubscribe", execPUnsubscribe, -1, flagPubSub|flagNoScript|flagNoMulti)
+	registerSysCommand("publish", execPublish, 3, flagPubSub|flagFast)
+	registerSysCommand("pubsub", execPubSub, -2, flagPubSub)
+	// 分片频道在集群模式下按key路由到频道所在的分片
+	registerSysCommand("ssubscribe", execSSubscribe, -2, flagPubSub|flagNoScript|flagNoMulti).keys(1, -1, 1)
+	registerSysCommand("sunsubscribe", execSUnsubscribe, -1, flagPubSub|flagNoScript|flagNoMulti).keys(1, -1, 1)
+	registerSysCommand("spublish", execSPublish, 3, flagPubSub|flagFast).keys(1, 1, 1)
+}
+
+// 订阅模式下只允许执行的命令
+var subscriberModeCommands = map[string]struct{}{
+	"subscribe":    {},
+	"unsubscribe":  {},
+	"psubscribe":   {},
+	"punsubscribe": {},
+	"ssubscribe":   {},
+	"sunsubscribe": {},
+	"ping":         {},
+}
+
+func inSubscriberMode(client connection.Connection) bool {
+	return client.SubsCount() > 0 || client.ShardSubsCount() > 0
+}
+
+func isSubscriberModeCommand(name string) bool {
+	_, ok := subscriberModeCommands[name]
+	return ok
+}
+
+func newSubscriberModeErrReply(name string) *protocol.ErrReply {
+	return protocol.NewErrReply("ERR Can't execute '" + name +
+		"': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context")
+}
+
+type subscribers map[connection.Connection]struct{}
+
+// channelTable maps channels to their subscribers, channels without subscribers are removed
+type channelTable map[string]subscribers
+
+func (t channelTable) add(client connection.Connection, channel string) {
+	subs, ok := t[channel]
+	if !ok {
+		subs = make(subscribers)
+		t[channel] = subs
+	}
+	subs[client] = struct{}{}
+}
+
+func (t channelTable) remove(client connection.Connection, channel string) {
+	subs, ok := t[channel]
+	if !ok {
+		return
+	}
+	delete(subs, client)
+	if len(subs) == 0 {
+		delete(t, channel)
+	}
+}
+
+// active returns the channels matching pattern in order, all of them if pattern is empty
+func (t channelTable) active(pattern string) []string {
+	var p *wildcard.Pattern
+	if pattern != "" {
+		var err error
+		if p, err = wildcard.Compile(pattern); err != nil {
+			return nil
+		}
+	}
+	channels := make([]string, 0, len(t))
+	for channel := range t {
+		if p == nil || p.Match(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+type patternSubscribers struct {
+	// 无法编译的模式不匹配任何频道
+	pattern *wildcard.Pattern
+	clients subscribers
+}
+
+// pubsubHub maps channels and patterns to their subscribers. Publishing only pushes messages to
+// the output buffers of the subscribers, so a slow subscriber never blocks the publisher.
+// Shard channels are a separate namespace which patterns never match.
+type pubsubHub struct {
+	mu            sync.RWMutex
+	channels      channelTable
+	patterns      map[string]*patternSubscribers
+	shardChannels channelTable
+}
+
+func newPubSubHub() *pubsubHub {
+	return &pubsubHub{
+		channels:      make(channelTable),
+		patterns:      make(map[string]*patternSubscribers),
+		shardChannels: make(channelTable),
+	}
+}
+
+func (h *pubsubHub) subscribe(client connection.Connection, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.channels.add(client, channel)
+}
+
+func (h *pubsubHub) unsubscribe(client connection.Connection, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.channels.remove(client, channel)
+}
+
+func (h *pubsubHub) ssubscribe(client connection.Connection, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.shardChannels.add(client, channel)
+}
+
+func (h *pubsubHub) sunsubscribe(client connection.Connection, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.shardChannels.remove(client, channel)
+}
+
+func (h *pubsubHub) psubscribe(client connection.Connection, pattern string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs, ok := h.patterns[pattern]
+	if !ok {
+		p, _ := wildcard.Compile(pattern)
+		subs = &patternSubscribers{pattern: p, clients: make(subscribers)}
+		h.patterns[pattern] = subs
+	}
+	subs.clients[client] = struct{}{}
+}
+
+func (h *pubsubHub) punsubscribe(client connection.Connection, pattern string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs, ok := h.patterns[pattern]
+	if !ok {
+		return
+	}
+	delete(subs.clients, client)
+	if len(subs.clients) == 0 {
+		delete(h.patterns, pattern)
+	}
+}
+
+// pushAll pushes msg to every subscriber, returns the number of subscribers
+func (subs subscribers) pushAll(msg []byte) int {
+	for client := range subs {
+		// 输出缓冲区溢出的客户端已被断开，由其连接的清理流程退订
+		_ = client.Push(msg)
+	}
+	return len(subs)
+}
+
+// publish pushes message to the subscribers of channel and of the patterns matching it,
+// returns the number of clients received the message
+func (h *pubsubHub) publish(channel string, message []byte) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	received := 0
+	if subs, ok := h.channels[channel]; ok {
+		msg := protocol.NewMultiBulkReply([][]byte{[]byte("message"), []byte(channel), message}).ToBytes()
+		received += subs.pushAll(msg)
+	}
+	for pattern, subs := range h.patterns {
+		if subs.pattern == nil || !subs.pattern.Match(channel) {
+			continue
+		}
+		msg := protocol.NewMultiBulkReply([][]byte{[]byte("pmessage"), []byte(pattern), []byte(channel), message}).ToBytes()
+		received += subs.clients.pushAll(msg)
+	}
+	return received
+}
+
+// spublish pushes message to the subscribers of shard channel
+func (h *pubsubHub) spublish(channel string, message []byte) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	subs, ok := h.shardChannels[channel]
+	if !ok {
+		return 0
+	}
+	msg := protocol.NewMultiBulkReply([][]byte{[]byte("smessage"), []byte(channel), message}).ToBytes()
+	return subs.pushAll(msg)
+}
+
+func (h *pubsubHub) activeChannels(pattern string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.channels.active(pattern)
+}
+
+func (h *pubsubHub) activeShardChannels(pattern string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.shardChannels.active(pattern)
+}
+
+func (h *pubsubHub) numSub(channel string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.channels[channel])
+}
+
+func (h *pubsubHub) shardNumSub(channel string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.shardChannels[channel])
+}
+
+func (h *pubsubHub) numPat() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.patterns)
+}
+
+// unsubscribeAll removes all subscriptions of a closed client
+func (h *pubsubHub) unsubscribeAll(client connection.Connection) {
+	for _, channel := range client.GetChannels() {
+		client.Unsubscribe(channel)
+		h.unsubscribe(client, channel)
+	}
+	for _, pattern := range client.GetPatterns() {
+		client.PUnsubscribe(pattern)
+		h.punsubscribe(client, pattern)
+	}
+	for _, channel := range client.GetShardChannels() {
+		client.SUnsubscribe(channel)
+		h.sunsubscribe(client, channel)
+	}
+}
+
+func makeSubscribeReply(kind string, name []byte, count int) protocol.Reply {
+	return protocol.NewArrayReply([]protocol.Reply{
+		protocol.NewBulkReply([]byte(kind)),
+		protocol.NewBulkReply(name),
+		protocol.NewIntReply(int64(count)),
+	})
+}
+
+// execSubscribe SUBSCRIBE channel [channel ...]
+func execSubscribe(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	replies := make([]protocol.Reply, len(args))
+	for i, arg := range args {
+		channel := string(arg)
+		if client.Subscribe(channel) {
+			s.pubsub.subscribe(client, channel)
+		}
+		replies[i] = makeSubscribeReply("subscribe", arg, client.SubsCount())
+	}
+	return protocol.NewSequenceReply(replies)
+}
+
+// execUnsubscribe UNSUBSCRIBE [channel ...]
+// Without any channel, all channels subscribed by the client are unsubscribed
+func execUnsubscribe(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	channels := make([]string, len(args))
+	for i, arg := range args {
+		channels[i] = string(arg)
+	}
+	if len(args) == 0 {
+		channels = client.GetChannels()
+		if len(channels) == 0 {
+			return makeSubscribeReply("unsubscribe", nil, client.SubsCount())
+		}
+	}
+	replies := make([]protocol.Reply, len(channels))
+	for i, channel := range channels {
+		if client.Unsubscribe(channel) {
+			s.pubsub.unsubscribe(client, channel)
+		}
+		replies[i] = makeSubscribeReply("unsubscribe", []byte(channel), client.SubsCount())
+	}
+	return protocol.NewSequenceReply(replies)
+}
+
+// execPSubscribe PSUBSCRIBE pattern [pattern ...]
+func execPSubscribe(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	replies := make([]protocol.Reply, len(args))
+	for i, arg := range args {
+		pattern := string(arg)
+		if client.PSubscribe(pattern) {
+			s.pubsub.psubscribe(client, pattern)
+		}
+		replies[i] = makeSubscribeReply("psubscribe", arg, client.SubsCount())
+	}
+	return protocol.NewSequenceReply(replies)
+}
+
+// execPUnsubscribe PUNSUBSCRIBE [pattern ...]
+func execPUnsubscribe(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	patterns := make([]string, len(args))
+	for i, arg := range args {
+		patterns[i] = string(arg)
+	}
+	if len(args) == 0 {
+		patterns = client.GetPatterns()
+		if len(patterns) == 0 {
+			return makeSubscribeReply("punsubscribe", nil, client.SubsCount())
+		}
+	}
+	replies := make([]protocol.Reply, len(patterns))
+	for i, pattern := range patterns {
+		if client.PUnsubscribe(pattern) {
+			s.pubsub.punsubscribe(client, pattern)
+		}
+		replies[i] = makeSubscribeReply("punsubscribe", []byte(pattern), client.SubsCount())
+	}
+	return protocol.NewSequenceReply(replies)
+}
+
+// execSSubscribe SSUBSCRIBE shardchannel [shardchannel ...]
+func execSSubscribe(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	replies := make([]protocol.Reply, len(args))
+	for i, arg := range args {
+		channel := string(arg)
+		if client.SSubscribe(channel) {
+			s.pubsub.ssubscribe(client, channel)
+		}
+		replies[i] = makeSubscribeReply("ssubscribe", arg, client.ShardSubsCount())
+	}
+	return protocol.NewSequenceReply(replies)
+}
+
+// execSUnsubscribe SUNSUBSCRIBE [shardchannel ...]
+func execSUnsubscribe(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	channels := make([]string, len(args))
+	for i, arg := range args {
+		channels[i] = string(arg)
+	}
+	if len(args) == 0 {
+		channels = client.GetShardChannels()
+		if len(channels) == 0 {
+			return makeSubscribeReply("sunsubscribe", nil, client.ShardSubsCount())
+		}
+	}
+	replies := make([]protocol.Reply, len(channels))
+	for i, channel := range channels {
+		if client.SUnsubscribe(channel) {
+			s.pubsub.sunsubscribe(client, channel)
+		}
+		replies[i] = makeSubscribeReply("sunsubscribe", []byte(channel), client.ShardSubsCount())
+	}
+	return protocol.NewSequenceReply(replies)
+}
+
+// execSPublish SPUBLISH shardchannel message
+func execSPublish(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	return protocol.NewIntReply(int64(s.pubsub.spublish(string(args[0]), args[1])))
+}
+
+// execPublish PUBLISH channel message
+func execPublish(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	return protocol.NewIntReply(int64(s.pubsub.publish(string(args[0]), args[1])))
+}
+
+// execPubSub PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT |
+// SHARDCHANNELS [pattern] | SHARDNUMSUB [shardchannel ...]
+func execPubSub(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	sub := string(args[0])
+	args = args[1:]
+	switch name := strings.ToLower(sub); name {
+	case "channels", "shardchannels":
+		if len(args) > 1 {
+			return protocol.NewArgNumErrReply("pubsub|" + name)
+		}
+		pattern := ""
+		if len(args) == 1 {
+			pattern = string(args[0])
+		}
+		active := s.pubsub.activeChannels
+		if name == "shardchannels" {
+			active = s.pubsub.activeShardChannels
+		}
+		channels := active(pattern)
+		result := make([][]byte, len(channels))
+		for i, channel := range channels {
+			result[i] = []byte(channel)
+		}
+		return protocol.NewMultiBulkReply(result)
+	case "numsub", "shardnumsub":
+		numSub := s.pubsub.numSub
+		if name == "shardnumsub" {
+			numSub = s.pubsub.shardNumSub
+		}
+		replies := make([]protocol.Reply, 0, len(args)*2)
+		for _, arg := range args {
+			replies = append(replies,
+				protocol.NewBulkReply(arg),
+				protocol.NewIntReply(int64(numSub(string(arg)))))
+		}
+		return protocol.NewArrayReply(replies)
+	case "numpat":
+		if len(args) != 0 {
+			return protocol.NewArgNumErrReply("pubsub|numpat")
+		}
+		return protocol.NewIntReply(int64(s.pubsub.numPat()))
+	}
+	return protocol.NewErrReply("ERR unknown subcommand '" + sub + "'. Try PUBSUB HELP.")
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	s := newTestServer(t)
+	sub := connection.NewFakeConn()
+	pub := connection.NewFakeConn()
+
+	assertReply(t, "*3\r\n$9\r\nsubscribe\r\n$2\r\nch\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$3\r\nch2\r\n:2\r\n",
+		exec(s, sub, "subscribe ch ch2"))
+	assertReply(t, ":1\r\n", exec(s, pub, "publish ch hello"))
+	assertReply(t, ":0\r\n", exec(s, pub, "publish other hello"))
+	assert.Equal(t, "*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$5\r\nhello\r\n", string(sub.Bytes()))
+
+	// 订阅模式下只能执行订阅相关命令和PING
+	assertReply(t, "-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context\r\n",
+		exec(s, sub, "get a"))
+	assertReply(t, "*2\r\n$4\r\npong\r\n$0\r\n\r\n", exec(s, sub, "ping"))
+	assertReply(t, "*2\r\n$4\r\npong\r\n$2\r\nhi\r\n", exec(s, sub, "ping hi"))
+
+	assertReply(t, "*3\r\n$11\r\nunsubscribe\r\n$2\r\nch\r\n:1\r\n", exec(s, sub, "unsubscribe ch"))
+	assertReply(t, ":0\r\n", exec(s, pub, "publish ch hello"))
+	assertReply(t, "*3\r\n$11\r\nunsubscribe\r\n$3\r\nch2\r\n:0\r\n", exec(s, sub, "unsubscribe"))
+	assertReply(t, "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n", exec(s, sub, "unsubscribe"))
+	// 退订全部频道后回到普通模式
+	assertReply(t, "+PONG\r\n", exec(s, sub, "ping"))
+	assertReply(t, "$-1\r\n", exec(s, sub, "get a"))
+}
+
+func TestPSubscribe(t *testing.T) {
+	s := newTestServer(t)
+	sub := connection.NewFakeConn()
+	pub := connection.NewFakeConn()
+
+	assertReply(t, "*3\r\n$10\r\npsubscribe\r\n$6\r\nnews.*\r\n:1\r\n", exec(s, sub, "psubscribe news.*"))
+	exec(s, sub, "subscribe news.tech")
+	// 频道和模式都匹配时收到两条消息
+	assertReply(t, ":2\r\n", exec(s, pub, "publish news.tech go"))
+	assertReply(t, ":1\r\n", exec(s, pub, "publish news.art go"))
+	assertReply(t, ":0\r\n", exec(s, pub, "publish weather go"))
+	assert.Equal(t, "*3\r\n$7\r\nmessage\r\n$9\r\nnews.tech\r\n$2\r\ngo\r\n"+
+		"*4\r\n$8\r\npmessage\r\n$6\r\nnews.*\r\n$9\r\nnews.tech\r\n$2\r\ngo\r\n"+
+		"*4\r\n$8\r\npmessage\r\n$6\r\nnews.*\r\n$8\r\nnews.art\r\n$2\r\ngo\r\n", string(sub.Bytes()))
+
+	assertReply(t, "*3\r\n$12\r\npunsubscribe\r\n$6\r\nnews.*\r\n:1\r\n", exec(s, sub, "punsubscribe"))
+	assertReply(t, ":0\r\n", exec(s, pub, "publish news.art go"))
+}
+
+func TestPubSubIntrospection(t *testing.T) {
+	s := newTestServer(t)
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+	c := connection.NewFakeConn()
+
+	exec(s, c1, "subscribe a b")
+	exec(s, c2, "subscribe b news.1")
+	exec(s, c1, "psubscribe n* x*")
+	exec(s, c2, "psubscribe n*")
+
+	assertReply(t, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$6\r\nnews.1\r\n", exec(s, c, "pubsub channels"))
+	assertReply(t, "*1\r\n$6\r\nnews.1\r\n", exec(s, c, "pubsub CHANNELS n*"))
+	assertReply(t, "*6\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n:2\r\n$1\r\nc\r\n:0\r\n", exec(s, c, "pubsub numsub a b c"))
+	assertReply(t, "*0\r\n", exec(s, c, "pubsub numsub"))
+	assertReply(t, ":2\r\n", exec(s, c, "pubsub numpat"))
+	assertReply(t, "-ERR unknown subcommand 'foo'. Try PUBSUB HELP.\r\n", exec(s, c, "pubsub foo"))
+
+	// 关闭连接后清理全部订阅
+	s.AfterClientClose(c1)
+	assertReply(t, "*2\r\n$1\r\nb\r\n$6\r\nnews.1\r\n", exec(s, c, "pubsub channels"))
+	assertReply(t, ":1\r\n", exec(s, c, "pubsub numpat"))
+	assertReply(t, ":1\r\n", exec(s, c, "publish b x"))
+}
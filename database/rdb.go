@@ -0,0 +1,323 @@
+package database
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"godis/config"
+	"godis/datastruct/hash"
+	"godis/pkg/logx"
+	"godis/rdb"
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// 写入RDB辅助字段的redis版本，godis实现的命令与该版本相同
+	rdbRedisVersion = "7.4.0"
+	// BGSAVE失败后按save规则重试前等待的秒数，与redis的CONFIG_BGSAVE_RETRY_DELAY相同
+	bgSaveRetryDelay = 5
+)
+
+func init() {
+	registerSysCommand("save", execSave, 1, flagAdmin|flagNoScript|flagNoMulti)
+	registerSysCommand("bgsave", execBgSave, -1, flagAdmin|flagNoScript).withTx(lockBgJobInTx, execBgSaveInTx)
+	registerSysCommand("lastsave", execLastSave, 1, flagFast)
+}
+
+// saveParam is a save rule: save once seconds have passed with at least changes changes
+type saveParam struct {
+	seconds int64
+	changes int64
+}
+
+// parseSaveParams parses the seconds and changes pairs of the save config, invalid pairs are ignored
+func parseSaveParams(values []string) []saveParam {
+	var params []saveParam
+	for i := 0; i+1 < len(values); i += 2 {
+		seconds, err1 := strconv.ParseInt(values[i], 10, 64)
+		changes, err2 := strconv.ParseInt(values[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			logx.L().Warnf("invalid save rule %s %s", values[i], values[i+1])
+			continue
+		}
+		params = append(params, saveParam{seconds: seconds, changes: changes})
+	}
+	return params
+}
+
+func rdbFilename() string {
+	return filepath.Join(config.Properties.Dir, config.Properties.DBFilename)
+}
+
+// writeRdb writes the keys of snapshots as an RDB file, aofBase is set for the RDB preamble of an AOF
+// and rsi is the replication state of an RDB sent to replicas
+func (s *Server) writeRdb(w io.Writer, snapshots []*keyspaceSnapshot, aofBase bool, rsi *rdbSaveInfo) error {
+	enc := rdb.NewEncoder(w, config.Properties.RdbCompression, config.Properties.RdbChecksum)
+	if err := enc.WriteHeader(); err != nil {
+		return err
+	}
+	aux := [][2]string{
+		{"redis-ver", rdbRedisVersion},
+		{"redis-bits", strconv.Itoa(strconv.IntSize)},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+		{"aof-base", "0"},
+	}
+	if aofBase {
+		aux[len(aux)-1][1] = "1"
+	}
+	if rsi != nil {
+		aux = append(aux, [2]string{"repl-stream-db", strconv.Itoa(rsi.streamDB)},
+			[2]string{"repl-id", rsi.replID}, [2]string{"repl-offset", strconv.FormatInt(rsi.offset, 10)})
+	}
+	for _, field := range aux {
+		if err := enc.WriteAux(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	// 只为有key的db写入SELECTDB，快照中的key可能已经被删除，所以在写入第一个key时才能确定
+	current := -1
+	err := s.visitSnapshots(snapshots, func(snap *keyspaceSnapshot, key string, val any, expireAt time.Time) error {
+		if snap.index != current {
+			if err := enc.WriteDB(snap.index, snap.data.Len(), snap.ttlMap.Len()); err != nil {
+				return err
+			}
+			current = snap.index
+		}
+		return enc.WriteEntry(key, val, expireAt)
+	})
+	if err != nil {
+		return err
+	}
+	return enc.WriteEnd()
+}
+
+// saveRdb writes the RDB file through the temp file named temp, so the old file stays intact
+// until the new one is complete
+func (s *Server) saveRdb(snapshots []*keyspaceSnapshot, temp string) error {
+	filename := rdbFilename()
+	temp = filepath.Join(filepath.Dir(filename), temp)
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriterSize(file, 64*1024)
+	err = s.writeRdb(writer, snapshots, false, nil)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp, filename)
+	}
+	if err != nil {
+		_ = os.Remove(temp)
+	}
+	return err
+}
+
+// save writes the RDB file on the request goroutine with all commands stopped, like SAVE of redis
+func (s *Server) save() error {
+	for _, db := range s.dbSet {
+		db.stopWorld.Lock()
+	}
+	defer func() {
+		for _, db := range s.dbSet {
+			db.stopWorld.Unlock()
+		}
+	}()
+	// 没有命令执行，直接读取keyspace即可，不能替换可能正在进行的AOF重写的快照
+	snapshots := make([]*keyspaceSnapshot, len(s.dbSet))
+	for i, db := range s.dbSet {
+		snapshots[i] = newKeyspaceSnapshot(db)
+	}
+	dirty := s.dirty.Load()
+	if err := s.saveRdb(snapshots, fmt.Sprintf("temp-%d.rdb", os.Getpid())); err != nil {
+		logx.L().Errorf("failed saving the DB: %v", err)
+		return err
+	}
+	s.dirty.Add(-dirty)
+	s.lastSave.Store(time.Now().Unix())
+	logx.L().Info("DB saved on disk")
+	return nil
+}
+
+// startBgSave starts saving the RDB file in the background
+func (s *Server) startBgSave() error {
+	if err := s.startBgJob(bgJobRdbSave); err != nil {
+		return err
+	}
+	return s.runBgSave(s.takeSnapshots)
+}
+
+// runBgSave takes the snapshots with take and saves them in the background, the BGSAVE job
+// must already be started
+func (s *Server) runBgSave(take snapshotFunc) error {
+	s.lastBgSaveTry.Store(time.Now().Unix())
+	var dirty int64
+	snapshots, err := take(func() error {
+		// 快照之后的修改计入下一次保存
+		dirty = s.dirty.Load()
+		return nil
+	})
+	if err != nil {
+		s.finishBgJob()
+		return err
+	}
+	go s.bgSave(snapshots, dirty)
+	return nil
+}
+
+func (s *Server) bgSave(snapshots []*keyspaceSnapshot, dirty int64) {
+	defer s.finishBgJob()
+
+	start := time.Now()
+	err := s.saveRdb(snapshots, fmt.Sprintf("temp-bgsave-%d.rdb", os.Getpid()))
+	s.releaseSnapshots(snapshots)
+	if err != nil {
+		s.lastBgSaveFailed.Store(true)
+		logx.L().Errorf("background saving failed: %v", err)
+		return
+	}
+	s.lastBgSaveFailed.Store(false)
+	s.dirty.Add(-dirty)
+	s.lastSave.Store(time.Now().Unix())
+	logx.L().Infof("background saving terminated with success in %v", time.Since(start))
+}
+
+// autoSave starts a BGSAVE once a save rule is satisfied, after a failed BGSAVE it waits
+// bgSaveRetryDelay seconds before trying again
+func (s *Server) autoSave() {
+	if s.bgJob.Load() != bgJobNone {
+		return
+	}
+	now := time.Now().Unix()
+	if s.lastBgSaveFailed.Load() && now-s.lastBgSaveTry.Load() <= bgSaveRetryDelay {
+		return
+	}
+	dirty := s.dirty.Load()
+	for _, param := range s.saveParams {
+		if dirty >= param.changes && now-s.lastSave.Load() > param.seconds {
+			logx.L().Infof("%d changes in %d seconds. Saving...", param.changes, param.seconds)
+			if err := s.startBgSave(); err != nil && !errors.Is(err, errBgJobRunning) {
+				logx.L().Errorf("start background save failed: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// loadRdb loads the RDB file if it exists, it runs before the server accepts any client
+func (s *Server) loadRdb() {
+	filename := rdbFilename()
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		logx.L().Fatalf("open rdb %s failed: %v", filename, err)
+	}
+	defer file.Close()
+
+	start := time.Now()
+	loaded := 0
+	err = rdb.NewDecoder(file).Decode(func(e *rdb.Entry) error {
+		ok, err := loadRdbEntry(s.dbSet, e, start)
+		if ok {
+			loaded++
+		}
+		return err
+	})
+	if err != nil {
+		logx.L().Fatalf("load rdb %s failed: %v", filename, err)
+	}
+	logx.L().Infof("DB loaded from disk: %d keys in %v", loaded, time.Since(start))
+}
+
+// loadRdbEntry puts a key read from an RDB file into its db of dbSet, keys expired at now are skipped
+func loadRdbEntry(dbSet []*DB, e *rdb.Entry, now time.Time) (bool, error) {
+	if e.DB >= len(dbSet) {
+		return false, fmt.Errorf("the rdb file was created with more than %d databases", len(dbSet))
+	}
+	// 已过期的key不再加载
+	if !e.ExpireAt.IsZero() && !e.ExpireAt.After(now) {
+		return false, nil
+	}
+	if h, ok := e.Value.(*hash.Hash); ok && h.HasExpires() && h.Len() == 0 {
+		return false, nil
+	}
+	db := dbSet[e.DB]
+	db.data.Put(e.Key, e.Value)
+	if !e.ExpireAt.IsZero() {
+		db.ttlMap.Put(e.Key, e.ExpireAt)
+	}
+	return true, nil
+}
+
+// execSave SAVE
+func execSave(s *Server, _ connection.Connection, _ [][]byte) protocol.Reply {
+	if s.bgJob.Load() == bgJobRdbSave {
+		return protocol.NewErrReply("ERR Background save already in progress")
+	}
+	if err := s.save(); err != nil {
+		return protocol.NewErrReply("ERR " + err.Error())
+	}
+	return protocol.NewOkReply()
+}
+
+// execBgSave BGSAVE [SCHEDULE]
+func execBgSave(s *Server, _ connection.Connection, args [][]byte) protocol.Reply {
+	return bgSave(s, args, s.startBgSave)
+}
+
+// execBgSaveInTx starts the save once the transaction commits, see transaction.startBgJob
+func execBgSaveInTx(tx *transaction, args [][]byte) protocol.Reply {
+	return bgSave(tx.s, args, func() error {
+		return tx.startBgJob(bgJobRdbSave, tx.s.runBgSave)
+	})
+}
+
+// bgSave replies BGSAVE [SCHEDULE] with the result of start
+func bgSave(s *Server, args [][]byte, start func() error) protocol.Reply {
+	if len(args) > 1 {
+		return protocol.NewSyntaxErrReply()
+	}
+	schedule := false
+	if len(args) == 1 {
+		if !strings.EqualFold(string(args[0]), "schedule") {
+			return protocol.NewSyntaxErrReply()
+		}
+		schedule = true
+	}
+	err := start()
+	if errors.Is(err, errBgJobRunning) {
+		if s.bgJob.Load() == bgJobRdbSave {
+			return protocol.NewErrReply("ERR Background save already in progress")
+		}
+		if !schedule {
+			return protocol.NewErrReply("ERR Another child process is active (AOF?): can't BGSAVE right now. " +
+				"Use BGSAVE SCHEDULE in order to schedule a BGSAVE whenever possible.")
+		}
+		s.bgSaveScheduled.Store(true)
+		return protocol.NewStatusReply("Background saving scheduled")
+	} else if err != nil {
+		return protocol.NewErrReply("ERR " + err.Error())
+	}
+	return protocol.NewStatusReply("Background saving started")
+}
+
+// execLastSave LASTSAVE
+func execLastSave(s *Server, _ connection.Connection, _ [][]byte) protocol.Reply {
+	return protocol.NewIntReply(s.lastSave.Load())
+}
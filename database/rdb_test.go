@@ -0,0 +1,186 @@
+package database
+
+import (
+	"godis/config"
+	"godis/resp/connection"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withRdb points the RDB file to a temp dir with AOF disabled, returns the path of the RDB file
+func withRdb(t *testing.T) string {
+	dir := t.TempDir()
+	old := *config.Properties
+	config.Properties.AppendOnly = false
+	config.Properties.Dir = dir
+	t.Cleanup(func() { *config.Properties = old })
+	return filepath.Join(dir, config.Properties.DBFilename)
+}
+
+func TestSave(t *testing.T) {
+	filename := withRdb(t)
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	for i := 0; i < 200; i++ {
+		exec(s, c, "rpush list "+strconv.Itoa(i))
+		exec(s, c, "sadd set m"+strconv.Itoa(i))
+		exec(s, c, "zadd zset "+strconv.Itoa(i)+" m"+strconv.Itoa(i))
+		exec(s, c, "hset big f"+strconv.Itoa(i)+" v")
+	}
+	exec(s, c, "sadd ints 1 2 3")
+	exec(s, c, "set str v px 100000")
+	exec(s, c, "set long aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	exec(s, c, "set gone v px 1")
+	exec(s, c, "zadd zset -inf low inf high 1.5 frac")
+	exec(s, c, "hset h f1 v f2 v f3 v")
+	exec(s, c, "hexpire h 100 fields 1 f1")
+	exec(s, c, "hpexpire h 1 fields 1 f2")
+	exec(s, c, "pfadd hll a b c")
+	exec(s, c, "xadd st 1-1 f v")
+	exec(s, c, "xadd st 2-1 f v g w")
+	exec(s, c, "xadd st 3-1 f v")
+	exec(s, c, "xdel st 3-1")
+	exec(s, c, "xgroup create st g 0")
+	exec(s, c, "xgroup createconsumer st g idle")
+	exec(s, c, "xreadgroup group g alice count 1 streams st >")
+	exec(s, c, "xgroup create empty g $ mkstream")
+	exec(s, c, "select 5")
+	exec(s, c, "set other 1")
+	exec(s, c, "select 0")
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Greater(t, s.dirty.Load(), int64(0))
+	assertReply(t, "+OK\r\n", exec(s, c, "save"))
+	assert.Equal(t, int64(0), s.dirty.Load())
+	assertReply(t, ":"+strconv.FormatInt(time.Now().Unix(), 10)+"\r\n", exec(s, c, "lastsave"))
+	_, err := os.Stat(filename)
+	assert.Nil(t, err)
+
+	dump := func(s *Server) []string {
+		c := connection.NewFakeConn()
+		var state []string
+		for _, cmd := range []string{"lrange list 0 -1", "scard set", "sismember set m42", "smembers ints",
+			"zrange zset 0 -1 withscores", "hlen big", "get str", "pexpiretime str", "get long", "exists gone",
+			"hgetall h", "hpexpiretime h fields 2 f1 f3", "pfcount hll", "xrange st - +", "xinfo groups st",
+			"xpending st g", "xinfo stream empty", "select 5", "get other"} {
+			state = append(state, string(exec(s, c, cmd).ToBytes()))
+		}
+		return state
+	}
+	expected := dump(s)
+	s.Close()
+
+	restored := newTestServer(t)
+	assert.Equal(t, expected, dump(restored))
+	assert.Equal(t, "$1\r\nv\r\n", expected[6])
+	assert.Equal(t, ":0\r\n", expected[9])
+	assert.Equal(t, int64(0), restored.dirty.Load())
+}
+
+func TestBgSaveWhileWriting(t *testing.T) {
+	filename := withRdb(t)
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	exec(s, c, "rpush list a b")
+	exec(s, c, "set str v")
+	exec(s, c, "set deleted v")
+	exec(s, c, "select 1")
+	exec(s, c, "set flushed v")
+	exec(s, c, "select 0")
+
+	// 快照之后的修改不会出现在RDB中，计入下一次保存
+	assert.Nil(t, s.startBgJob(bgJobRdbSave))
+	snapshots, err := s.takeSnapshots(func() error { return nil })
+	assert.Nil(t, err)
+	exec(s, c, "rpush list c")
+	exec(s, c, "set str v2 ex 100")
+	exec(s, c, "del deleted")
+	exec(s, c, "set created v")
+	exec(s, c, "flushall")
+	assertReply(t, "-ERR Background save already in progress\r\n", exec(s, c, "bgsave"))
+	assertReply(t, "-ERR Background save already in progress\r\n", exec(s, c, "save"))
+	dirty := s.dirty.Load()
+	s.bgSave(snapshots, 0)
+	assert.Nil(t, s.dbSet[0].snapshot)
+	assert.Equal(t, dirty, s.dirty.Load())
+	s.Close()
+
+	_, err = os.Stat(filename)
+	assert.Nil(t, err)
+	restored := newTestServer(t)
+	assertReply(t, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", exec(restored, c, "lrange list 0 -1"))
+	assertReply(t, "$1\r\nv\r\n", exec(restored, c, "get str"))
+	assertReply(t, ":-1\r\n", exec(restored, c, "ttl str"))
+	assertReply(t, ":1\r\n", exec(restored, c, "exists deleted"))
+	assertReply(t, ":0\r\n", exec(restored, c, "exists created"))
+	exec(restored, c, "select 1")
+	assertReply(t, "$1\r\nv\r\n", exec(restored, c, "get flushed"))
+}
+
+func TestBgSave(t *testing.T) {
+	filename := withRdb(t)
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	exec(s, c, "set key v")
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "bgsave now"))
+	assertReply(t, "+Background saving started\r\n", exec(s, c, "bgsave"))
+	waitBgJob(t, s)
+	_, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), s.dirty.Load())
+
+	// 与AOF重写互斥，等待中的任务由serverCron开始
+	s.bgJob.Store(bgJobAofRewrite)
+	assertReply(t, "-ERR Another child process is active (AOF?): can't BGSAVE right now. "+
+		"Use BGSAVE SCHEDULE in order to schedule a BGSAVE whenever possible.\r\n", exec(s, c, "bgsave"))
+	assertReply(t, "+Background saving scheduled\r\n", exec(s, c, "bgsave schedule"))
+	assert.Nil(t, os.Remove(filename))
+	s.bgJob.Store(bgJobNone)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filename)
+		return err == nil && !s.bgSaveScheduled.Load()
+	}, 5*time.Second, 10*time.Millisecond)
+	waitBgJob(t, s)
+}
+
+func TestAutoSave(t *testing.T) {
+	filename := withRdb(t)
+	config.Properties.Save = []string{"100", "1", "invalid", "1"}
+	s := newTestServer(t)
+	assert.Equal(t, []saveParam{{seconds: 100, changes: 1}}, s.saveParams)
+	c := connection.NewFakeConn()
+	s.lastSave.Store(time.Now().Unix() - 1000)
+	s.autoSave()
+	waitBgJob(t, s)
+	// 没有修改时不保存
+	_, err := os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+
+	exec(s, c, "set key v")
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filename)
+		return err == nil && s.bgJob.Load() == bgJobNone
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(0), s.dirty.Load())
+	assert.GreaterOrEqual(t, s.lastSave.Load(), time.Now().Unix()-1)
+}
+
+func TestBgRewriteAofScheduled(t *testing.T) {
+	withAof(t)
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	exec(s, c, "set key v")
+	s.bgJob.Store(bgJobRdbSave)
+	assertReply(t, "+Background append only file rewriting scheduled\r\n", exec(s, c, "bgrewriteaof"))
+	s.bgJob.Store(bgJobNone)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(config.Properties.Dir, config.Properties.AppendDirname, "appendonly.aof.1.base.rdb"))
+		return err == nil && !s.aofRewriteScheduled.Load()
+	}, 5*time.Second, 10*time.Millisecond)
+	waitBgJob(t, s)
+}
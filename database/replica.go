@@ -0,0 +1,450 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"godis/config"
+	"godis/datastruct/dict"
+	"godis/pkg/logx"
+	"godis/rdb"
+	"godis/resp/connection"
+	"godis/resp/parser"
+	"godis/resp/protocol"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 从节点与主节点连接的状态，与redis的repl_state相同
+const (
+	// 等待重新连接
+	replStateConnect int32 = iota
+	replStateConnecting
+	// 发送PING、REPLCONF与PSYNC
+	replStateHandshake
+	// 接收全量同步的RDB
+	replStateTransfer
+	replStateConnected
+)
+
+var replStateNames = []string{"connect", "connecting", "handshake", "sync", "connected"}
+
+var errLinkClosed = errors.New("replication link closed")
+
+// masterLink is the connection of a replica with its master. It connects, handshakes with PING
+// and REPLCONF, asks for the stream after its offset with PSYNC, loads the RDB of a full sync and
+// then executes the commands streamed by the master. It reconnects until closed by REPLICAOF.
+type masterLink struct {
+	host string
+	port int
+	// 执行主节点命令的客户端，部分同步时保留其选择的db与事务状态
+	client *connection.FakeConn
+	state  atomic.Int32
+
+	mu      sync.Mutex
+	conn    net.Conn
+	stopped bool
+	stop    chan struct{}
+	// 串行化向主节点的写入
+	writeMu sync.Mutex
+}
+
+func newMasterLink(host string, port int) *masterLink {
+	return &masterLink{
+		host:   host,
+		port:   port,
+		client: connection.NewFakeConn(),
+		stop:   make(chan struct{}),
+	}
+}
+
+func (l *masterLink) addr() string {
+	return net.JoinHostPort(l.host, strconv.Itoa(l.port))
+}
+
+func (l *masterLink) stateName() string {
+	return replStateNames[l.state.Load()]
+}
+
+// close stops the link, the commands read after it are no longer executed
+func (l *masterLink) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return
+	}
+	l.stopped = true
+	close(l.stop)
+	if l.conn != nil {
+		_ = l.conn.Close()
+	}
+}
+
+func (l *masterLink) isStopped() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stopped
+}
+
+func (l *masterLink) setConn(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		_ = conn.Close()
+		return false
+	}
+	l.conn = conn
+	return true
+}
+
+// send writes a command to the master
+func (l *masterLink) send(conn net.Conn, args ...string) error {
+	cmdLine := make(CmdLine, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	_ = conn.SetWriteDeadline(time.Now().Add(replTimeout()))
+	_, err := conn.Write(protocol.NewMultiBulkReply(cmdLine).ToBytes())
+	return err
+}
+
+// request sends a command during the handshake and reads the reply line, an error reply is
+// returned as the line
+func (l *masterLink) request(conn net.Conn, r *bufio.Reader, args ...string) (string, error) {
+	if err := l.send(conn, args...); err != nil {
+		return "", err
+	}
+	return readReplLine(r)
+}
+
+// readReplLine reads a line sent by the master, skipping the newlines it sends to keep the
+// connection alive while preparing the RDB
+func readReplLine(r *bufio.Reader) (string, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			return line, nil
+		}
+	}
+}
+
+func replTimeout() time.Duration {
+	if config.Properties.ReplTimeout <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(config.Properties.ReplTimeout) * time.Second
+}
+
+// deadlineReader fails a read once the master has been silent for repl-timeout
+type deadlineReader struct {
+	conn net.Conn
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	_ = r.conn.SetReadDeadline(time.Now().Add(replTimeout()))
+	return r.conn.Read(p)
+}
+
+// runMasterLink keeps syncing with the master until the link is closed
+func (s *Server) runMasterLink(link *masterLink) {
+	defer s.repl.links.Done()
+	for {
+		link.state.Store(replStateConnecting)
+		err := s.syncWithMaster(link)
+		link.state.Store(replStateConnect)
+		if link.isStopped() {
+			return
+		}
+		logx.L().Warnf("connection with master %s lost: %v", link.addr(), err)
+		select {
+		case <-time.After(time.Second):
+		case <-link.stop:
+			return
+		case <-s.closeChan:
+			return
+		}
+	}
+}
+
+func (s *Server) syncWithMaster(link *masterLink) error {
+	conn, err := net.DialTimeout("tcp", link.addr(), replTimeout())
+	if err != nil {
+		return err
+	}
+	if !link.setConn(conn) {
+		return errLinkClosed
+	}
+	defer conn.Close()
+	r := bufio.NewReaderSize(&deadlineReader{conn: conn}, 64*1024)
+
+	link.state.Store(replStateHandshake)
+	reply, err := link.request(conn, r, "PING")
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(reply, "-") {
+		return fmt.Errorf("error reply to PING from master: %s", reply)
+	}
+	reply, err = link.request(conn, r, "REPLCONF", "listening-port", strconv.Itoa(config.Properties.Port))
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(reply, "-") {
+		logx.L().Warnf("master does not understand REPLCONF listening-port: %s", reply)
+	}
+	// 老版本的主节点不支持时忽略
+	if _, err = link.request(conn, r, "REPLCONF", "capa", "eof", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	replID, offset := s.repl.psyncArgs()
+	reply, err = link.request(conn, r, "PSYNC", replID, strconv.FormatInt(offset+1, 10))
+	if err != nil {
+		return err
+	}
+	switch fields := strings.Fields(reply); {
+	case fields[0] == "+FULLRESYNC" && len(fields) == 3:
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad reply to PSYNC from master: %s", reply)
+		}
+		logx.L().Infof("full resync from master: %s:%d", fields[1], masterOffset)
+		link.state.Store(replStateTransfer)
+		if err := s.loadFromMaster(link, r, fields[1], masterOffset); err != nil {
+			return err
+		}
+	case fields[0] == "+CONTINUE":
+		logx.L().Info("successful partial resynchronization with master")
+		masterID := ""
+		if len(fields) > 1 {
+			masterID = fields[1]
+		}
+		s.repl.continueWith(masterID)
+	default:
+		return fmt.Errorf("unexpected reply to PSYNC from master: %s", reply)
+	}
+	link.state.Store(replStateConnected)
+	logx.L().Info("MASTER <-> REPLICA sync: Master accepted a Partial Resynchronization or finished a full one")
+	return s.streamFromMaster(link, conn, r)
+}
+
+// psyncArgs returns the replication ID and offset a replica asks its master to continue from
+func (r *replication) psyncArgs() (string, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.replID, r.offset
+}
+
+// continueWith switches to the replication ID of the master after a partial resync, the history
+// shared with the old ID is kept as replID2 for the replicas of this server
+func (r *replication) continueWith(replID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 之前作为没有从节点的主节点时还没有记录复制流
+	r.createBacklog()
+	if replID == "" || replID == r.replID {
+		return
+	}
+	r.replID2 = r.replID
+	r.secondOffset = r.offset + 1
+	r.replID = replID
+	logx.L().Infof("master replication ID changed to %s", replID)
+	// 从节点需要知道新的复制ID
+	r.disconnectReplicas()
+}
+
+// loadFromMaster loads the RDB of a full sync in place of the whole dataset. The RDB is sent with
+// its length as $<length>, or as $EOF:<mark> followed by the RDB and the mark by a diskless master.
+func (s *Server) loadFromMaster(link *masterLink, r *bufio.Reader, replID string, offset int64) error {
+	header, err := readReplLine(r)
+	if err != nil {
+		return err
+	}
+	var src io.Reader
+	var mark string
+	if rest, ok := strings.CutPrefix(header, "$EOF:"); ok {
+		if len(rest) != replIDLen {
+			return fmt.Errorf("bad EOF mark from master: %s", header)
+		}
+		src, mark = r, rest
+	} else {
+		size, err := strconv.ParseInt(strings.TrimPrefix(header, "$"), 10, 64)
+		if !strings.HasPrefix(header, "$") || err != nil || size < 0 {
+			return fmt.Errorf("bad protocol from master, the first byte is not '$': %s", header)
+		}
+		src = io.LimitReader(r, size)
+	}
+
+	start := time.Now()
+	fresh := make([]*DB, len(s.dbSet))
+	for i := range fresh {
+		fresh[i] = newDB(i, nil, nil, nil)
+	}
+	loaded := 0
+	dec := rdb.NewDecoder(src)
+	err = dec.Decode(func(e *rdb.Entry) error {
+		ok, err := loadRdbEntry(fresh, e, start)
+		if ok {
+			loaded++
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed loading the RDB from master: %w", err)
+	}
+	if mark != "" {
+		buf := make([]byte, len(mark))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		if string(buf) != mark {
+			return errors.New("the EOF mark after the RDB from master doesn't match")
+		}
+	} else if _, err := io.Copy(io.Discard, src); err != nil {
+		return err
+	}
+	streamDB, _ := strconv.Atoi(dec.Aux["repl-stream-db"])
+
+	repl := s.repl
+	repl.applyMu.Lock()
+	defer repl.applyMu.Unlock()
+	if link.isStopped() {
+		return errLinkClosed
+	}
+	aofErr := s.replaceDataset(fresh)
+	repl.mu.Lock()
+	repl.replID = replID
+	repl.offset = offset
+	if aofErr != nil {
+		// AOF与数据不一致时不能在其上追加复制流，换用新的ID使下次同步为全量同步
+		repl.replID = newReplID()
+	}
+	repl.replID2 = ""
+	repl.secondOffset = -1
+	repl.backlog = newReplBacklog(config.Properties.ReplBacklogSize)
+	// 数据已经改变，从节点需要重新同步
+	repl.disconnectReplicas()
+	repl.mu.Unlock()
+	if aofErr != nil {
+		return fmt.Errorf("failed rewriting the AOF after loading the RDB from master: %w", aofErr)
+	}
+
+	link.client.SetMultiState(false)
+	link.client.SelectDB(streamDB)
+	s.dirty.Add(int64(loaded))
+	logx.L().Infof("MASTER <-> REPLICA sync: loaded %d keys in %v", loaded, time.Since(start))
+	return nil
+}
+
+// replaceDataset swaps in the keyspaces loaded from the master like FLUSHALL ASYNC does. The AOF
+// still holds the old dataset, so with appendonly on the loaded keyspaces are written as its new
+// base before any command runs on them.
+func (s *Server) replaceDataset(fresh []*DB) error {
+	for _, db := range s.dbSet {
+		db.stopWorld.Lock()
+	}
+	for i, db := range s.dbSet {
+		db.data = fresh[i].data
+		db.ttlMap = fresh[i].ttlMap
+		db.versions = dict.NewConcurrentDict(dataDictSize)
+		// 后台任务继续读取旧的keyspace
+		db.snapshot = nil
+	}
+	var err error
+	if s.persister != nil {
+		err = s.rewriteAofLocked()
+	}
+	for _, db := range s.dbSet {
+		db.stopWorld.Unlock()
+		db.blocking.signalAll()
+	}
+	return err
+}
+
+// streamFromMaster executes the commands streamed by the master until the connection breaks,
+// reporting the offset every second with REPLCONF ACK
+func (s *Server) streamFromMaster(link *masterLink, conn net.Conn, r *bufio.Reader) error {
+	sendAck := func() error {
+		_, offset := s.repl.psyncArgs()
+		return link.send(conn, "REPLCONF", "ACK", strconv.FormatInt(offset, 10))
+	}
+	if err := sendAck(); err != nil {
+		return err
+	}
+	ackDone := make(chan struct{})
+	defer close(ackDone)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := sendAck(); err != nil {
+					_ = conn.Close()
+					return
+				}
+			case <-ackDone:
+				return
+			}
+		}
+	}()
+
+	ch := parser.ParseStream(r)
+	defer func() {
+		_ = conn.Close()
+		for range ch {
+		}
+	}()
+	for payload := range ch {
+		if payload.Err != nil {
+			return payload.Err
+		}
+		cmd, ok := payload.Data.(*protocol.MultiBulkReply)
+		if !ok || len(cmd.Values) == 0 {
+			return fmt.Errorf("unexpected data from master: %q", payload.Data.ToBytes())
+		}
+		getAck := len(cmd.Values) == 3 && strings.EqualFold(string(cmd.Values[0]), "replconf") &&
+			strings.EqualFold(string(cmd.Values[1]), "getack")
+		if getAck {
+			// 回复GETACK之前收到的偏移量
+			if err := sendAck(); err != nil {
+				return err
+			}
+		}
+		if !s.applyFromMaster(link, cmd.Values, cmd.ToBytes(), !getAck) {
+			return errLinkClosed
+		}
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// applyFromMaster executes a command of the master and forwards its raw bytes to the replicas of
+// this server, so the offsets stay the same along a chain of replicas
+func (s *Server) applyFromMaster(link *masterLink, cmdLine CmdLine, raw []byte, execute bool) bool {
+	r := s.repl
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	if link.isStopped() {
+		return false
+	}
+	if execute {
+		if reply := s.Exec(link.client, cmdLine); protocol.IsErrorReply(reply) {
+			logx.L().Warnf("command %s from master failed: %s", cmdLine[0], bytes.TrimSpace(reply.ToBytes()))
+		}
+	}
+	r.mu.Lock()
+	r.write(raw)
+	r.mu.Unlock()
+	return true
+}
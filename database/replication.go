@@ -0,0 +1,388 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"godis/config"
+	"godis/pkg/logx"
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	registerSysCommand("replicaof", execReplicaOf, 3, flagAdmin|flagNoScript|flagNoMulti)
+	registerSysCommand("slaveof", execReplicaOf, 3, flagAdmin|flagNoScript|flagNoMulti)
+	registerSysCommand("role", execRole, 1, flagNoScript|flagFast)
+	registerSysCommand("replconf", execReplconf, -1, flagAdmin|flagNoScript|flagNoMulti)
+	registerSysCommand("psync", execPSync, 3, flagAdmin|flagNoScript|flagNoMulti)
+}
+
+// replIDLen is the length of a replication ID, 40 hex chars like redis
+const replIDLen = 40
+
+// replication is the replication state of a server. Writes are fed to the replication stream
+// as RESP commands, the offset counts the bytes of the stream since the replication ID was
+// created. A replica keeps the ID and offset of its master and forwards the stream of the
+// master as is, so its own replicas and itself after a failover can continue from the offset.
+type replication struct {
+	mu sync.Mutex
+	// 当前的复制ID与复制流中最后一个字节的偏移量
+	replID string
+	offset int64
+	// 上一个复制ID，从节点提升为主节点后，偏移量不超过secondOffset的从节点仍可以部分同步
+	replID2      string
+	secondOffset int64
+	// 复制流最近的内容，第一个从节点连接前为nil
+	backlog *replBacklog
+	// 复制流中上一条命令所在的db，-1表示下一条命令前需要SELECT
+	streamDB int
+	// client id -> 从节点
+	replicas map[int64]*replicaClient
+	// 作为从节点时与主节点的连接，为nil时是主节点
+	master *masterLink
+	// 与主节点连接的协程，包括已经关闭但还未退出的旧连接
+	links sync.WaitGroup
+	// 上次执行定时任务与向从节点发送PING的时间
+	lastCron time.Time
+	lastPing time.Time
+
+	// 执行主节点发来的命令并更新偏移量时持有，全量同步取快照时也持有，保证快照与偏移量一致
+	applyMu sync.Mutex
+	// 作为从节点时执行主节点命令的客户端id，主节点为0，Exec不加锁读取
+	masterClientID atomic.Int64
+}
+
+func newReplication() *replication {
+	return &replication{
+		replID:       newReplID(),
+		secondOffset: -1,
+		streamDB:     -1,
+		replicas:     make(map[int64]*replicaClient),
+	}
+}
+
+func newReplID() string {
+	buf := make([]byte, replIDLen/2)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// replBacklog is a ring buffer holding the latest bytes of the replication stream
+type replBacklog struct {
+	buf []byte
+	// 下一个字节写入的位置
+	idx int
+	// 缓冲区中有效的字节数
+	histLen int
+}
+
+func newReplBacklog(size int) *replBacklog {
+	if size <= 0 {
+		size = 1024 * 1024
+	}
+	return &replBacklog{buf: make([]byte, size)}
+}
+
+func (b *replBacklog) write(p []byte) {
+	size := len(b.buf)
+	if len(p) >= size {
+		copy(b.buf, p[len(p)-size:])
+		b.idx = 0
+		b.histLen = size
+		return
+	}
+	n := copy(b.buf[b.idx:], p)
+	copy(b.buf, p[n:])
+	b.idx = (b.idx + len(p)) % size
+	b.histLen = min(b.histLen+len(p), size)
+}
+
+// tail returns a copy of the last n bytes written, n must not exceed histLen
+func (b *replBacklog) tail(n int) []byte {
+	out := make([]byte, n)
+	start := (b.idx - n + len(b.buf)) % len(b.buf)
+	copied := copy(out, b.buf[start:])
+	copy(out[copied:], b.buf)
+	return out
+}
+
+// rdbSaveInfo is the replication state written into the RDB of a full sync, the replica continues
+// the stream from offset with streamDB selected
+type rdbSaveInfo struct {
+	replID   string
+	offset   int64
+	streamDB int
+}
+
+// feed appends the command lines executed on dbIndex to the replication stream. A replica
+// forwards the stream of its master instead, see applyFromMaster.
+func (r *replication) feed(dbIndex int, cmdLines []CmdLine) {
+	if r.masterClientID.Load() != 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 没有从节点连接过时不需要记录复制流
+	if r.backlog == nil {
+		return
+	}
+	var buf bytes.Buffer
+	if dbIndex != r.streamDB {
+		buf.Write(protocol.NewMultiBulkReply(makeCmdLine("select", []byte(strconv.Itoa(dbIndex)))).ToBytes())
+		r.streamDB = dbIndex
+	}
+	for _, cmdLine := range cmdLines {
+		buf.Write(protocol.NewMultiBulkReply(cmdLine).ToBytes())
+	}
+	r.write(buf.Bytes())
+}
+
+// write appends b to the replication stream and sends it to the replicas, the caller must hold mu
+func (r *replication) write(b []byte) {
+	r.offset += int64(len(b))
+	r.backlog.write(b)
+	for _, rc := range r.replicas {
+		if rc.state >= replicaSendRdb {
+			rc.send(b)
+		}
+	}
+}
+
+// createBacklog starts recording the stream when the first replica connects, the caller must hold mu.
+// The stream of a master starts over with a new replication ID, since the writes before it were never
+// recorded, a replica that knows the old ID can't continue from them.
+func (r *replication) createBacklog() {
+	if r.backlog != nil {
+		return
+	}
+	r.backlog = newReplBacklog(config.Properties.ReplBacklogSize)
+	if r.master == nil {
+		r.replID = newReplID()
+		r.replID2 = ""
+		r.secondOffset = -1
+	}
+}
+
+// shiftReplID starts a new history after the server stops following its master, replicas that
+// followed the same master can still continue from the old ID. The caller must hold mu.
+func (r *replication) shiftReplID() {
+	r.replID2 = r.replID
+	r.secondOffset = r.offset + 1
+	r.replID = newReplID()
+	logx.L().Infof("setting secondary replication ID to %s, valid up to offset: %d. New replication ID is %s",
+		r.replID2, r.secondOffset, r.replID)
+}
+
+// disconnectReplicas closes the connections of all replicas so they sync again, the caller must hold mu
+func (r *replication) disconnectReplicas() {
+	for id, rc := range r.replicas {
+		rc.close()
+		delete(r.replicas, id)
+	}
+}
+
+func (r *replication) removeReplica(client connection.Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rc, ok := r.replicas[client.ID()]; ok {
+		rc.close()
+		delete(r.replicas, client.ID())
+		if rc.state != replicaHandshake {
+			logx.L().Infof("connection with replica %s lost", rc.addr())
+		}
+	}
+}
+
+// checkReadOnly rejects write commands on a read only replica, except those sent by its master
+func (s *Server) checkReadOnly(client connection.Connection, name string) *protocol.ErrReply {
+	masterID := s.repl.masterClientID.Load()
+	if masterID == 0 || masterID == client.ID() || !config.Properties.ReplicaReadOnly {
+		return nil
+	}
+	cmd, ok := lookupCommand(name)
+	if !ok || !cmd.hasFlag(flagWrite) {
+		return nil
+	}
+	return protocol.NewErrReply("READONLY You can't write against a read only replica.")
+}
+
+// setupReplicaOf follows the master given by the replicaof config
+func (s *Server) setupReplicaOf(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	port, err := strconv.Atoi(args[len(args)-1])
+	if len(args) != 2 || err != nil {
+		logx.L().Warnf("invalid replicaof %s", strings.Join(args, " "))
+		return
+	}
+	s.setMaster(args[0], port)
+}
+
+// setMaster makes the server a replica of host:port. It tries a partial resync with the ID and
+// offset it has, so a master turned into a replica of its former replica only fetches what it missed.
+func (s *Server) setMaster(host string, port int) {
+	r := s.repl
+	link := newMasterLink(host, port)
+	// 旧连接在applyMu下检查是否已关闭，之后不会再执行命令
+	r.applyMu.Lock()
+	r.mu.Lock()
+	old := r.master
+	if old != nil {
+		old.close()
+	}
+	r.master = link
+	r.masterClientID.Store(link.client.ID())
+	// 从节点需要重新同步，之后的复制流来自新的主节点
+	r.disconnectReplicas()
+	r.mu.Unlock()
+	r.applyMu.Unlock()
+	logx.L().Infof("connecting to MASTER %s", link.addr())
+	r.links.Add(1)
+	go s.runMasterLink(link)
+}
+
+// unsetMaster turns a replica into a master
+func (s *Server) unsetMaster() {
+	r := s.repl
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master == nil {
+		return
+	}
+	r.master.close()
+	r.master = nil
+	r.masterClientID.Store(0)
+	r.shiftReplID()
+	r.streamDB = -1
+	r.disconnectReplicas()
+}
+
+// stopMasterLink closes the connection with the master and waits on shutdown for it and for the
+// connections to earlier masters to exit
+func (s *Server) stopMasterLink() {
+	r := s.repl
+	r.mu.Lock()
+	link := r.master
+	r.mu.Unlock()
+	if link != nil {
+		link.close()
+	}
+	r.links.Wait()
+}
+
+// replicationCron starts the full sync waiting for another background job and, once per second,
+// pings the replicas, keeps the replicas waiting for their RDB alive and drops timed out replicas
+func (s *Server) replicationCron() {
+	r := s.repl
+	r.mu.Lock()
+	waiting := false
+	for _, rc := range r.replicas {
+		waiting = waiting || rc.state == replicaWaitBgSave
+	}
+	r.mu.Unlock()
+	if waiting && s.bgJob.Load() == bgJobNone {
+		if err := s.startReplSync(); err != nil && !errors.Is(err, errBgJobRunning) {
+			logx.L().Errorf("start full sync with replicas failed: %v", err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if len(r.replicas) == 0 || now.Sub(r.lastCron) < time.Second {
+		return
+	}
+	r.lastCron = now
+	period := time.Duration(config.Properties.ReplPingReplicaPeriod) * time.Second
+	// 从节点不发送自己的PING，只转发主节点的
+	if r.master == nil && r.backlog != nil && now.Sub(r.lastPing) >= period {
+		r.write(protocol.NewMultiBulkReply(makeCmdLine("ping")).ToBytes())
+		r.lastPing = now
+	}
+	timeout := time.Duration(config.Properties.ReplTimeout) * time.Second
+	for id, rc := range r.replicas {
+		switch rc.state {
+		case replicaWaitBgSave, replicaSendRdb:
+			// 生成RDB期间从节点收不到数据，发送换行避免其超时
+			_ = rc.conn.Push([]byte("\n"))
+		case replicaOnline:
+			if timeout > 0 && now.Sub(rc.ackTime) > timeout {
+				logx.L().Warnf("disconnecting timedout replica %s", rc.addr())
+				rc.close()
+				delete(r.replicas, id)
+			}
+		}
+	}
+}
+
+// execReplicaOf REPLICAOF host port | REPLICAOF NO ONE
+func execReplicaOf(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	if strings.EqualFold(string(args[0]), "no") && strings.EqualFold(string(args[1]), "one") {
+		if s.repl.masterClientID.Load() != 0 {
+			s.unsetMaster()
+			logx.L().Infof("MASTER MODE enabled (user request from 'id=%d addr=%s')", client.ID(), client.RemoteAddr())
+		}
+		return protocol.NewOkReply()
+	}
+	host := string(args[0])
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port < 0 || port > 65535 {
+		return protocol.NewErrReply("ERR Invalid master port")
+	}
+	s.repl.mu.Lock()
+	link := s.repl.master
+	s.repl.mu.Unlock()
+	if link != nil && link.host == host && link.port == port {
+		return protocol.NewStatusReply("OK Already connected to specified master")
+	}
+	s.setMaster(host, port)
+	logx.L().Infof("REPLICAOF %s:%d enabled (user request from 'id=%d addr=%s')", host, port, client.ID(), client.RemoteAddr())
+	return protocol.NewOkReply()
+}
+
+// execRole ROLE
+func execRole(s *Server, _ connection.Connection, _ [][]byte) protocol.Reply {
+	r := s.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if link := r.master; link != nil {
+		return protocol.NewArrayReply([]protocol.Reply{
+			protocol.NewBulkReply([]byte("slave")),
+			protocol.NewBulkReply([]byte(link.host)),
+			protocol.NewIntReply(int64(link.port)),
+			protocol.NewBulkReply([]byte(link.stateName())),
+			protocol.NewIntReply(r.offset),
+		})
+	}
+	replicas := make([]protocol.Reply, 0, len(r.replicas))
+	for _, rc := range r.replicas {
+		if rc.state == replicaHandshake {
+			continue
+		}
+		host, _, err := net.SplitHostPort(rc.conn.RemoteAddr())
+		if err != nil {
+			host = rc.conn.RemoteAddr()
+		}
+		replicas = append(replicas, protocol.NewMultiBulkReply([][]byte{
+			[]byte(host),
+			[]byte(strconv.Itoa(rc.listeningPort)),
+			[]byte(strconv.FormatInt(rc.ackOffset, 10)),
+		}))
+	}
+	return protocol.NewArrayReply([]protocol.Reply{
+		protocol.NewBulkReply([]byte("master")),
+		protocol.NewIntReply(r.offset),
+		protocol.NewArrayReply(replicas),
+	})
+}
@@ -0,0 +1,298 @@
+package database
+
+import (
+	"godis/config"
+	"godis/datastruct/dict"
+	"godis/resp/connection"
+	"godis/resp/parser"
+	"godis/resp/protocol"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serve accepts clients of s on a random port like the RespHandler, returns the host and port
+func serve(t *testing.T, s *Server) (string, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	var clients sync.Map
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			client := connection.NewConn(conn)
+			clients.Store(client, struct{}{})
+			go func() {
+				ch := parser.ParseStream(client)
+				defer func() {
+					_ = client.Close()
+					s.AfterClientClose(client)
+					clients.Delete(client)
+					go func() {
+						for range ch {
+						}
+					}()
+				}()
+				for payload := range ch {
+					if payload.Err != nil {
+						return
+					}
+					cmd, ok := payload.Data.(*protocol.MultiBulkReply)
+					if !ok || len(cmd.Values) == 0 {
+						continue
+					}
+					if _, err := client.Write(s.Exec(client, cmd.Values).ToBytes()); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		_ = l.Close()
+		clients.Range(func(key, _ any) bool {
+			_ = key.(*connection.Conn).Close()
+			return true
+		})
+	})
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	return host, port
+}
+
+// withReplication disables persistence and starts a master serving on a random port
+func withReplication(t *testing.T) (*Server, string, string) {
+	withRdb(t)
+	config.Properties.Save = nil
+	master := newTestServer(t)
+	host, port := serve(t, master)
+	return master, host, port
+}
+
+// waitInSync waits until replica has executed the whole stream of master
+func waitInSync(t *testing.T, master, replica *Server) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		replica.repl.mu.Lock()
+		link := replica.repl.master
+		replica.repl.mu.Unlock()
+		if link == nil || link.state.Load() != replStateConnected {
+			return false
+		}
+		masterID, masterOffset := master.repl.psyncArgs()
+		replID, offset := replica.repl.psyncArgs()
+		return masterID == replID && masterOffset == offset
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+// keyspaceOf returns the dict of db, replaced only by a full sync
+func keyspaceOf(s *Server, index int) *dict.ConcurrentDict {
+	db := s.dbSet[index]
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
+	return db.data
+}
+
+func replDump(s *Server) []string {
+	c := connection.NewFakeConn()
+	var state []string
+	for _, cmd := range []string{"get str", "lrange list 0 -1", "pexpiretime ttl", "get counter", "get a",
+		"exists gone", "select 3", "smembers set", "dbsize"} {
+		state = append(state, string(exec(s, c, cmd).ToBytes()))
+	}
+	return state
+}
+
+func TestReplBacklog(t *testing.T) {
+	b := newReplBacklog(8)
+	assert.Equal(t, "", string(b.tail(0)))
+	b.write([]byte("abc"))
+	assert.Equal(t, 3, b.histLen)
+	assert.Equal(t, "abc", string(b.tail(3)))
+	b.write([]byte("defgh"))
+	assert.Equal(t, "abcdefgh", string(b.tail(8)))
+	b.write([]byte("ij"))
+	assert.Equal(t, 8, b.histLen)
+	assert.Equal(t, "cdefghij", string(b.tail(8)))
+	assert.Equal(t, "hij", string(b.tail(3)))
+	b.write([]byte("0123456789"))
+	assert.Equal(t, "23456789", string(b.tail(8)))
+	b.write([]byte("x"))
+	assert.Equal(t, "3456789x", string(b.tail(8)))
+}
+
+func TestReplication(t *testing.T) {
+	master, host, port := withReplication(t)
+	c := connection.NewFakeConn()
+	exec(master, c, "set str v")
+	exec(master, c, "rpush list a b c")
+	exec(master, c, "set ttl v ex 100")
+	exec(master, c, "set gone v")
+	exec(master, c, "select 3")
+	exec(master, c, "sadd set m")
+	exec(master, c, "select 0")
+
+	replica := newTestServer(t)
+	rc := connection.NewFakeConn()
+	exec(replica, rc, "set stale v")
+	assertReply(t, "-ERR Invalid master port\r\n", exec(replica, rc, "replicaof "+host+" port"))
+	assertReply(t, "+OK\r\n", exec(replica, rc, "replicaof "+host+" "+port))
+	assertReply(t, "+OK Already connected to specified master\r\n", exec(replica, rc, "replicaof "+host+" "+port))
+	waitInSync(t, master, replica)
+	// 全量同步替换了从节点原有的数据
+	assertReply(t, ":0\r\n", exec(replica, rc, "exists stale"))
+	assert.Equal(t, replDump(master), replDump(replica))
+
+	// 之后的写命令通过复制流发送，包括事务和其他db
+	exec(master, c, "incr counter")
+	exec(master, c, "del gone")
+	exec(master, c, "multi")
+	exec(master, c, "set a 1")
+	exec(master, c, "incr a")
+	exec(master, c, "exec")
+	exec(master, c, "select 3")
+	exec(master, c, "sadd set n")
+	exec(master, c, "srem set m")
+	exec(master, c, "select 0")
+	waitInSync(t, master, replica)
+	assert.Equal(t, replDump(master), replDump(replica))
+	assertReply(t, "$1\r\n2\r\n", exec(replica, rc, "get a"))
+
+	// 从节点默认只读
+	assertReply(t, "-READONLY You can't write against a read only replica.\r\n", exec(replica, rc, "set a b"))
+	assertReply(t, "-READONLY You can't write against a read only replica.\r\n", exec(replica, rc, "flushall"))
+	exec(replica, rc, "multi")
+	assertReply(t, "-READONLY You can't write against a read only replica.\r\n", exec(replica, rc, "set a b"))
+	assert.True(t, strings.HasPrefix(string(exec(replica, rc, "exec").ToBytes()), "-EXECABORT"))
+	assertReply(t, "$1\r\n2\r\n", exec(replica, rc, "get a"))
+
+	// 主节点定期发送PING，偏移量可能随时增长
+	bulk := func(s string) string {
+		return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+	}
+	assert.Eventually(t, func() bool {
+		_, offset := replica.repl.psyncArgs()
+		return string(exec(replica, rc, "role").ToBytes()) == "*5\r\n"+bulk("slave")+bulk(host)+":"+port+"\r\n"+
+			bulk("connected")+":"+strconv.FormatInt(offset, 10)+"\r\n"
+	}, 5*time.Second, 10*time.Millisecond)
+	// 从节点每秒报告一次偏移量
+	assert.Eventually(t, func() bool {
+		_, offset := master.repl.psyncArgs()
+		return string(exec(master, c, "role").ToBytes()) == "*3\r\n"+bulk("master")+":"+strconv.FormatInt(offset, 10)+
+			"\r\n*1\r\n*3\r\n"+bulk("127.0.0.1")+bulk(strconv.Itoa(config.Properties.Port))+bulk(strconv.FormatInt(offset, 10))
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPartialResync(t *testing.T) {
+	master, host, port := withReplication(t)
+	c := connection.NewFakeConn()
+	exec(master, c, "set str v")
+	replica := newTestServer(t)
+	exec(replica, c, "replicaof "+host+" "+port)
+	waitInSync(t, master, replica)
+	data := keyspaceOf(replica, 0)
+
+	// 断线期间的写入仍在积压缓冲区中，重连后部分同步
+	link := replica.repl.master
+	link.mu.Lock()
+	_ = link.conn.Close()
+	link.mu.Unlock()
+	exec(master, c, "rpush list a b")
+	exec(master, c, "select 3")
+	exec(master, c, "sadd set m")
+	waitInSync(t, master, replica)
+	assert.Equal(t, replDump(master), replDump(replica))
+	assert.Same(t, data, keyspaceOf(replica, 0))
+
+	// 缺少的数据已经不在积压缓冲区中时全量同步
+	master.repl.mu.Lock()
+	master.repl.backlog = newReplBacklog(16)
+	master.repl.mu.Unlock()
+	link.mu.Lock()
+	_ = link.conn.Close()
+	link.mu.Unlock()
+	exec(master, c, "set str v2")
+	waitInSync(t, master, replica)
+	assert.Equal(t, replDump(master), replDump(replica))
+	assert.NotSame(t, data, keyspaceOf(replica, 0))
+}
+
+func TestReplicaAof(t *testing.T) {
+	// 在主节点启动前设置，所有服务器关闭后才恢复配置
+	withAof(t)
+	master, host, port := withReplication(t)
+	c := connection.NewFakeConn()
+	exec(master, c, "set str v")
+	exec(master, c, "select 3")
+	exec(master, c, "sadd set m")
+
+	// 只在NewServer中读取，不影响运行中的主节点
+	config.Properties.AppendOnly = true
+	replica := newTestServer(t)
+	rc := connection.NewFakeConn()
+	exec(replica, rc, "set stale v")
+	// 其他后台任务运行时同样在接收复制流之前写入新的base
+	replica.bgJob.Store(bgJobRdbSave)
+	exec(replica, rc, "replicaof "+host+" "+port)
+	waitInSync(t, master, replica)
+	replica.bgJob.Store(bgJobNone)
+	assert.Equal(t, []string{"appendonly.aof.1.base.rdb", "appendonly.aof.2.incr.aof", "appendonly.aof.manifest"}, aofFiles(t))
+	exec(master, c, "sadd set n")
+	waitInSync(t, master, replica)
+	replica.Close()
+
+	// 重启后只有主节点的数据
+	restarted := newTestServer(t)
+	assert.Equal(t, replDump(master), replDump(restarted))
+	assertReply(t, ":0\r\n", exec(restarted, rc, "exists stale"))
+}
+
+func TestReplicaOfNoOne(t *testing.T) {
+	master, host, port := withReplication(t)
+	c := connection.NewFakeConn()
+	exec(master, c, "set str v")
+	replica := newTestServer(t)
+	exec(replica, c, "replicaof "+host+" "+port)
+	// 主节点的第一次PING可能在提升之前发出，从节点收到后偏移量才一致
+	master.repl.mu.Lock()
+	master.repl.lastPing = time.Now()
+	master.repl.mu.Unlock()
+	waitInSync(t, master, replica)
+	replID, _ := master.repl.psyncArgs()
+
+	// 提升为主节点后可以写入，原来的复制ID作为replID2保留
+	assertReply(t, "+OK\r\n", exec(replica, c, "replicaof no one"))
+	assertReply(t, "+OK\r\n", exec(replica, c, "replicaof no one"))
+	assertReply(t, "+OK\r\n", exec(replica, c, "set str v2"))
+	assert.Equal(t, replID, replica.repl.replID2)
+	assert.True(t, strings.HasPrefix(string(exec(replica, c, "role").ToBytes()), "*3\r\n$6\r\nmaster\r\n"))
+
+	// 原来的主节点成为新主节点的从节点，只需要部分同步
+	data := keyspaceOf(master, 0)
+	host, port = serve(t, replica)
+	exec(master, c, "replicaof "+host+" "+port)
+	waitInSync(t, replica, master)
+	assertReply(t, "$2\r\nv2\r\n", exec(master, c, "get str"))
+	assert.Same(t, data, keyspaceOf(master, 0))
+	assertReply(t, "-READONLY You can't write against a read only replica.\r\n", exec(master, c, "set str v3"))
+}
+
+func TestReplconf(t *testing.T) {
+	withRdb(t)
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	assertReply(t, "-ERR Unrecognized REPLCONF option: foo\r\n", exec(s, c, "replconf foo bar"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "replconf ack"))
+	assertReply(t, "+OK\r\n", exec(s, c, "replconf listening-port 6380 capa eof"))
+	assertReply(t, "", exec(s, c, "replconf ack 10"))
+
+	// 与主节点断开时不能作为主节点同步其他从节点
+	exec(s, c, "replicaof 127.0.0.1 0")
+	assertReply(t, "-NOMASTERLINK Can't SYNC while not connected with my master\r\n", exec(s, c, "psync ? -1"))
+}
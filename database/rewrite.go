@@ -0,0 +1,335 @@
+package database
+
+import (
+	"errors"
+	"godis/aof"
+	"godis/config"
+	"godis/datastruct/hash"
+	"godis/datastruct/list"
+	"godis/datastruct/set"
+	"godis/datastruct/sortedset"
+	"godis/datastruct/stream"
+	"godis/pkg/logx"
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"strconv"
+	"time"
+)
+
+// 重写的命令每条最多携带的元素数，与redis的AOF_REWRITE_ITEMS_PER_CMD相同
+const aofRewriteItemsPerCmd = 64
+
+func init() {
+	registerSysCommand("bgrewriteaof", execBgRewriteAof, 1, flagAdmin|flagNoScript).withTx(lockBgJobInTx, execBgRewriteAofInTx)
+}
+
+// rewriteKey writes the commands recreating key, expireAt is zero if key has no expire time
+func rewriteKey(write func(CmdLine) error, key string, val any, expireAt time.Time) error {
+	keyArg := []byte(key)
+	written := 0
+	var err error
+	switch v := val.(type) {
+	case []byte:
+		err = write(makeCmdLine("set", keyArg, v))
+		written = 1
+	case *list.QuickList:
+		b := newRewriteBatch(write, "rpush", keyArg)
+		v.ForEach(func(_ int, val []byte) bool {
+			return b.add(val)
+		})
+		written, err = b.finish()
+	case *set.Set:
+		b := newRewriteBatch(write, "sadd", keyArg)
+		v.ForEach(func(member string) bool {
+			return b.add([]byte(member))
+		})
+		written, err = b.finish()
+	case *sortedset.SortedSet:
+		b := newRewriteBatch(write, "zadd", keyArg)
+		v.ForEach(func(e sortedset.Element) bool {
+			return b.add(formatScore(e.Score), []byte(e.Member))
+		})
+		written, err = b.finish()
+	case *hash.Hash:
+		written, err = rewriteHash(write, keyArg, v)
+	case *stream.Stream:
+		written, err = rewriteStream(write, keyArg, v)
+	default:
+		logx.L().Warnf("skipping key %s of unknown type %T in aof rewrite", key, val)
+	}
+	if err != nil || written == 0 || expireAt.IsZero() {
+		return err
+	}
+	return write(makeCmdLine("pexpireat", keyArg, []byte(strconv.FormatInt(expireAt.UnixMilli(), 10))))
+}
+
+// rewriteBatch groups the items of a key into commands of at most aofRewriteItemsPerCmd items
+type rewriteBatch struct {
+	write func(CmdLine) error
+	name  string
+	key   []byte
+	args  [][]byte
+	items int
+	total int
+	err   error
+}
+
+func newRewriteBatch(write func(CmdLine) error, name string, key []byte) *rewriteBatch {
+	return &rewriteBatch{write: write, name: name, key: key}
+}
+
+// add appends one item made of args, returns false once writing failed
+func (b *rewriteBatch) add(args ...[]byte) bool {
+	b.args = append(b.args, args...)
+	b.items++
+	b.total++
+	if b.items == aofRewriteItemsPerCmd {
+		b.flush()
+	}
+	return b.err == nil
+}
+
+func (b *rewriteBatch) flush() {
+	if b.items == 0 || b.err != nil {
+		return
+	}
+	b.err = b.write(makeCmdLine(b.name, append([][]byte{b.key}, b.args...)...))
+	b.args = b.args[:0]
+	b.items = 0
+}
+
+// finish writes the last command, returns the number of items written
+func (b *rewriteBatch) finish() (int, error) {
+	b.flush()
+	return b.total, b.err
+}
+
+// rewriteHash writes the live fields of h followed by the expire times of its fields
+func rewriteHash(write func(CmdLine) error, key []byte, h *hash.Hash) (int, error) {
+	b := newRewriteBatch(write, "hset", key)
+	var ttlFields []string
+	h.ForEach(func(field string, val []byte) bool {
+		if _, ok := h.ExpireTime(field); ok {
+			ttlFields = append(ttlFields, field)
+		}
+		return b.add([]byte(field), val)
+	})
+	written, err := b.finish()
+	for _, field := range ttlFields {
+		if err != nil {
+			break
+		}
+		expireAt, _ := h.ExpireTime(field)
+		err = write(makeCmdLine("hpexpireat", key, []byte(strconv.FormatInt(expireAt, 10)),
+			[]byte("FIELDS"), []byte("1"), []byte(field)))
+	}
+	return written, err
+}
+
+// rewriteStream writes the entries of s, its IDs and counters, then its consumer groups
+// with their consumers and pending entries
+func rewriteStream(write func(CmdLine) error, key []byte, s *stream.Stream) (int, error) {
+	if s.Len() == 0 {
+		// 空的stream通过添加后立即裁剪掉一个条目来创建
+		id := s.LastID()
+		if id.IsZero() {
+			id = stream.ID{Seq: 1}
+		}
+		if err := write(makeCmdLine("xadd", key, []byte("MAXLEN"), []byte("0"), []byte(id.String()),
+			[]byte("x"), []byte("y"))); err != nil {
+			return 0, err
+		}
+	}
+	for start := stream.MinID; ; {
+		entries := s.Range(start, stream.MaxID, aofRewriteItemsPerCmd, false)
+		for _, e := range entries {
+			if err := write(makeCmdLine("xadd", append([][]byte{key, []byte(e.ID.String())}, e.Fields...)...)); err != nil {
+				return 0, err
+			}
+		}
+		if len(entries) < aofRewriteItemsPerCmd {
+			break
+		}
+		next, ok := entries[len(entries)-1].ID.Next()
+		if !ok {
+			break
+		}
+		start = next
+	}
+	err := write(makeCmdLine("xsetid", key, []byte(s.LastID().String()),
+		[]byte("ENTRIESADDED"), []byte(strconv.FormatUint(s.EntriesAdded(), 10)),
+		[]byte("MAXDELETEDID"), []byte(s.MaxDeletedID().String())))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, g := range s.Groups() {
+		name := []byte(g.Name)
+		err := write(makeCmdLine("xgroup", []byte("CREATE"), key, name, []byte(g.LastID.String()),
+			[]byte("ENTRIESREAD"), []byte(strconv.FormatInt(g.EntriesRead, 10))))
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range g.Consumers() {
+			if err := write(makeCmdLine("xgroup", []byte("CREATECONSUMER"), key, name, []byte(c.Name))); err != nil {
+				return 0, err
+			}
+		}
+		// 条目已被删除的pending无法通过XCLAIM恢复，与redis相同直接丢弃
+		for _, pe := range g.PendingRange(stream.MinID, stream.MaxID, 0, nil) {
+			if _, ok := s.Get(pe.ID); !ok {
+				continue
+			}
+			err := write(makeCmdLine("xclaim", key, name, []byte(pe.Consumer.Name), []byte("0"), []byte(pe.ID.String()),
+				[]byte("TIME"), []byte(strconv.FormatInt(pe.DeliveryTime, 10)),
+				[]byte("RETRYCOUNT"), []byte(strconv.FormatInt(pe.DeliveryCount, 10)),
+				[]byte("FORCE"), []byte("JUSTID")))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return 1, nil
+}
+
+// startAofRewrite starts a background rewrite of the AOF
+func (s *Server) startAofRewrite() error {
+	if err := s.startBgJob(bgJobAofRewrite); err != nil {
+		return err
+	}
+	return s.runAofRewrite(s.takeSnapshots)
+}
+
+// beginAofRewrite switches the AOF to a new incr file and takes the snapshots of all keyspaces
+// at the same moment, rewriteAof must be called with the result
+func (s *Server) beginAofRewrite() (*aof.Rewrite, []*keyspaceSnapshot, error) {
+	if err := s.startBgJob(bgJobAofRewrite); err != nil {
+		return nil, nil, err
+	}
+	return s.snapshotForRewrite(s.takeSnapshots)
+}
+
+// runAofRewrite starts rewriting the AOF in the background from the snapshots taken with take,
+// the rewrite job must already be started
+func (s *Server) runAofRewrite(take snapshotFunc) error {
+	rw, snapshots, err := s.snapshotForRewrite(take)
+	if err != nil {
+		return err
+	}
+	go s.rewriteAof(rw, snapshots)
+	return nil
+}
+
+// snapshotForRewrite is beginAofRewrite after the rewrite job has been started, the job is
+// finished again if it fails
+func (s *Server) snapshotForRewrite(take snapshotFunc) (*aof.Rewrite, []*keyspaceSnapshot, error) {
+	var rw *aof.Rewrite
+	// 新的incr文件与快照从同一时刻开始
+	snapshots, err := take(func() (err error) {
+		rw, err = s.persister.StartRewrite(config.Properties.AofUseRdbPreamble)
+		return err
+	})
+	if err != nil {
+		s.finishBgJob()
+		return nil, nil, err
+	}
+	return rw, snapshots, nil
+}
+
+func (s *Server) rewriteAof(rw *aof.Rewrite, snapshots []*keyspaceSnapshot) {
+	defer s.finishBgJob()
+
+	start := time.Now()
+	err := s.writeAofBase(rw, snapshots)
+	// 不再需要保存修改前的状态
+	s.releaseSnapshots(snapshots)
+	if err == nil {
+		err = s.persister.FinishRewrite(rw)
+	} else {
+		s.persister.AbortRewrite(rw)
+	}
+	if err != nil {
+		logx.L().Errorf("background append only file rewriting failed: %v", err)
+		return
+	}
+	logx.L().Infof("background AOF rewrite finished successfully in %v", time.Since(start))
+}
+
+// writeAofBase writes the dataset of snapshots to the new base file of rw
+func (s *Server) writeAofBase(rw *aof.Rewrite, snapshots []*keyspaceSnapshot) error {
+	if rw.RdbPreamble() {
+		return s.writeRdb(rw.Writer(), snapshots, true, nil)
+	}
+	return s.visitSnapshots(snapshots, func(snap *keyspaceSnapshot, key string, val any, expireAt time.Time) error {
+		return rewriteKey(func(cmdLine CmdLine) error {
+			return rw.Write(snap.index, cmdLine)
+		}, key, val, expireAt)
+	})
+}
+
+// rewriteAofLocked rewrites the AOF on the calling goroutine, the caller must hold stopWorld of
+// all dbs until it returns so no command runs meanwhile. A background rewrite still running
+// is superseded and dropped when it finishes.
+func (s *Server) rewriteAofLocked() error {
+	rw, err := s.persister.StartRewrite(config.Properties.AofUseRdbPreamble)
+	if err != nil {
+		return err
+	}
+	// 持有所有db的锁，不会有并发修改，快照不需要保存修改前的状态
+	snapshots := make([]*keyspaceSnapshot, len(s.dbSet))
+	for i, db := range s.dbSet {
+		snapshots[i] = newKeyspaceSnapshot(db)
+	}
+	if err := s.writeAofBase(rw, snapshots); err != nil {
+		s.persister.AbortRewrite(rw)
+		return err
+	}
+	return s.persister.FinishRewrite(rw)
+}
+
+// autoRewriteAof starts a rewrite once the AOF has grown by auto-aof-rewrite-percentage
+// since the last rewrite and is at least auto-aof-rewrite-min-size
+func (s *Server) autoRewriteAof() {
+	if s.persister == nil || s.bgJob.Load() != bgJobNone || s.aofRewriteScheduled.Load() {
+		return
+	}
+	percentage := config.Properties.AutoAofRewritePercentage
+	if !s.persister.NeedsRewrite(percentage, config.Properties.AutoAofRewriteMinSize) {
+		return
+	}
+	logx.L().Infof("starting automatic rewriting of AOF on %d%% growth", percentage)
+	if err := s.startAofRewrite(); err != nil && !errors.Is(err, errBgJobRunning) {
+		logx.L().Errorf("start aof rewrite failed: %v", err)
+	}
+}
+
+// execBgRewriteAof BGREWRITEAOF
+func execBgRewriteAof(s *Server, _ connection.Connection, _ [][]byte) protocol.Reply {
+	return bgRewriteAof(s, s.startAofRewrite)
+}
+
+// execBgRewriteAofInTx starts the rewrite once the transaction commits, see transaction.startBgJob
+func execBgRewriteAofInTx(tx *transaction, _ [][]byte) protocol.Reply {
+	return bgRewriteAof(tx.s, func() error {
+		return tx.startBgJob(bgJobAofRewrite, tx.s.runAofRewrite)
+	})
+}
+
+// bgRewriteAof replies BGREWRITEAOF with the result of start
+func bgRewriteAof(s *Server, start func() error) protocol.Reply {
+	if s.persister == nil {
+		return protocol.NewErrReply("ERR Background append only file rewriting is not possible when appendonly is disabled")
+	}
+	err := start()
+	if errors.Is(err, errBgJobRunning) {
+		if s.bgJob.Load() == bgJobAofRewrite {
+			return protocol.NewErrReply("ERR Background append only file rewriting already in progress")
+		}
+		// BGSAVE结束后由serverCron开始重写
+		s.aofRewriteScheduled.Store(true)
+		return protocol.NewStatusReply("Background append only file rewriting scheduled")
+	} else if err != nil {
+		return protocol.NewErrReply("ERR " + err.Error())
+	}
+	return protocol.NewStatusReply("Background append only file rewriting started")
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"strings"
+)
+
+// SysExecFunc executes commands that work on the server or the connection rather than a single keyspace
+type SysExecFunc func(s *Server, client connection.Connection, args [][]byte) protocol.Reply
+
+// PreFunc returns the keys a command line writes and reads, args excludes the command name
+type PreFunc func(args [][]byte) (writeKeys []string, readKeys []string)
+
+// AofFunc rewrites a successfully executed write command into the command lines appended to the AOF.
+// It runs with the shards of the keys still locked, so it can read the state the command left behind
+// to replace relative times, random choices and blocking with their outcome.
+type AofFunc func(db *DB, args [][]byte, reply protocol.Reply) []CmdLine
+
+// txLockFunc adds the dbs and keys a sys command queued in a transaction touches to the locks of tx,
+// tx.db is the db selected when the command runs
+type txLockFunc func(tx *transaction, args [][]byte)
+
+// txExecFunc executes a sys command queued in a transaction with the locks of tx already held
+type txExecFunc func(tx *transaction, args [][]byte) protocol.Reply
+
+const (
+	flagWrite = 1 << iota
+	flagReadOnly
+	flagDenyOOM
+	flagAdmin
+	flagPubSub
+	flagNoScript
+	flagFast
+	flagBlocking
+	flagNoMulti
+)
+
+var flagNames = []struct {
+	flag int
+	name string
+}{
+	{flagWrite, "write"},
+	{flagReadOnly, "readonly"},
+	{flagDenyOOM, "denyoom"},
+	{flagAdmin, "admin"},
+	{flagPubSub, "pubsub"},
+	{flagNoScript, "noscript"},
+	{flagFast, "fast"},
+	{flagBlocking, "blocking"},
+	{flagNoMulti, "no_multi"},
+}
+
+type command struct {
+	name        string
+	executor    ExecFunc
+	sysExecutor SysExecFunc
+	prepare     PreFunc
+	// 为nil时原样写入AOF
+	rewriteAof AofFunc
+	// 访问keyspace的系统命令在事务中通过它们执行，其他系统命令直接执行
+	txLock txLockFunc
+	txExec txExecFunc
+	// arity > 0 表示参数个数固定，arity < 0 表示最少 -arity 个参数，均包含命令名
+	arity int
+	flags int
+	// 与redis相同，key的位置从命令名开始计数，lastKey为负数时表示从末尾倒数
+	firstKey int
+	lastKey  int
+	keyStep  int
+}
+
+var cmdTable = make(map[string]*command)
+
+func registerSysCommand(name string, executor SysExecFunc, arity int, flags int) *command {
+	name = strings.ToLower(name)
+	cmd := &command{
+		name:        name,
+		sysExecutor: executor,
+		arity:       arity,
+		flags:       flags,
+	}
+	cmdTable[name] = cmd
+	return cmd
+}
+
+// keys sets the key positions, commands without a custom prepare derive their key sets from them
+func (cmd *command) keys(firstKey, lastKey, keyStep int) *command {
+	cmd.firstKey = firstKey
+	cmd.lastKey = lastKey
+	cmd.keyStep = keyStep
+	return cmd
+}
+
+// withPrepare overrides the key extraction for commands whose keys can't be described by positions
+func (cmd *command) withPrepare(prepare PreFunc) *command {
+	cmd.prepare = prepare
+	return cmd
+}
+
+// withAof sets how the command is written to the AOF when replaying it as is wouldn't reproduce its effect
+func (cmd *command) withAof(rewrite AofFunc) *command {
+	cmd.rewriteAof = rewrite
+	return cmd
+}
+
+// withTx sets how a sys command touching the keyspace runs in a transaction, see execTransaction
+func (cmd *command) withTx(lock txLockFunc, exec txExecFunc) *command {
+	cmd.txLock = lock
+	cmd.txExec = exec
+	return cmd
+}
+
+func (cmd *command) hasFlag(flag int) bool {
+	return cmd.flags&flag != 0
+}
+
+func (cmd *command) flagNames() []string {
+	names := make([]string, 0, len(flagNames))
+	for _, f := range flagNames {
+		if cmd.hasFlag(f.flag) {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+func (cmd *command) validateArity(cmdLine [][]byte) bool {
+	argNum := len(cmdLine)
+	if cmd.arity >= 0 {
+		return argNum == cmd.arity
+	}
+	return argNum >= -cmd.arity
+}
+
+// getKeys returns the keys of the command line by key positions, args excludes the command name
+func (cmd *command) getKeys(args [][]byte) []string {
+	if cmd.firstKey <= 0 {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+	if last > len(args) {
+		last = len(args)
+	}
+	step := cmd.keyStep
+	if step <= 0 {
+		step = 1
+	}
+
+	keys := make([]string, 0, (last-cmd.firstKey)/step+1)
+	for i := cmd.firstKey; i <= last; i += step {
+		keys = append(keys, string(args[i-1]))
+	}
+	return keys
+}
+
+// prepareKeys returns the write keys and read keys of the command line
+func (cmd *command) prepareKeys(args [][]byte) ([]string, []string) {
+	if cmd.prepare != nil {
+		return cmd.prepare(args)
+	}
+	keys := cmd.getKeys(args)
+	if cmd.hasFlag(flagWrite) {
+		return keys, nil
+	}
+	return nil, keys
+}
+
+func lookupCommand(name string) (*command, bool) {
+	cmd, ok := cmdTable[strings.ToLower(name)]
+	return cmd, ok
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"fmt"
+	"godis/aof"
+	"godis/config"
+	"godis/pkg/logx"
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Server is the database engine behind the RespHandler
+type Server struct {
+	dbSet []*DB
+
+	// 主动过期下次开始清理的db，只在serverCron中访问
+	expireCursor int
+
+	blockedClients sync.Map // client id -> *waiter
+
+	pubsub *pubsubHub
+	// 开启AOF时不为nil
+	persister *aof.Persister
+	// 正在进行的后台任务，AOF重写与BGSAVE同时只能有一个
+	bgJob atomic.Int32
+	bgWg  sync.WaitGroup
+	// 等待当前后台任务结束后开始的任务
+	aofRewriteScheduled atomic.Bool
+	bgSaveScheduled     atomic.Bool
+
+	// 上次保存RDB之后的修改次数
+	dirty atomic.Int64
+	// 上次成功保存RDB的时间，unix秒
+	lastSave atomic.Int64
+	// 上次尝试BGSAVE的时间与结果，失败后等待一段时间才按save规则重试
+	lastBgSaveTry    atomic.Int64
+	lastBgSaveFailed atomic.Bool
+	saveParams       []saveParam
+
+	repl *replication
+
+	closeChan chan struct{}
+	closeOnce sync.Once
+	// serverCron退出后关闭
+	cronDone chan struct{}
+}
+
+func NewServer() *Server {
+	dbNum := config.Properties.Databases
+	if dbNum <= 0 {
+		dbNum = 16
+	}
+	s := &Server{
+		dbSet:     make([]*DB, dbNum),
+		pubsub:    newPubSubHub(),
+		repl:      newReplication(),
+		closeChan: make(chan struct{}),
+		cronDone:  make(chan struct{}),
+	}
+	for i := range s.dbSet {
+		s.dbSet[i] = newDB(i, s.pubsub, &s.dirty, s.repl)
+	}
+	s.saveParams = parseSaveParams(config.Properties.Save)
+	// 开启AOF时AOF包含完整的数据，不再读取RDB
+	if config.Properties.AppendOnly {
+		s.loadAof()
+	} else {
+		s.loadRdb()
+	}
+	// 加载过程中执行的命令不算作修改
+	s.dirty.Store(0)
+	s.lastSave.Store(time.Now().Unix())
+	s.setupReplicaOf(config.Properties.ReplicaOf)
+	go s.serverCron()
+	return s
+}
+
+// serverCron runs the periodic background tasks hz times per second
+func (s *Server) serverCron() {
+	defer close(s.cronDone)
+	hz := config.Properties.Hz
+	if hz <= 0 {
+		hz = 10
+	}
+	period := time.Second / time.Duration(hz)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.activeExpireCycle(period)
+			s.runScheduledJobs()
+			s.autoSave()
+			s.autoRewriteAof()
+			s.replicationCron()
+		case <-s.closeChan:
+			return
+		}
+	}
+}
+
+func (s *Server) selectDB(index int) (*DB, *protocol.ErrReply) {
+	if index < 0 || index >= len(s.dbSet) {
+		return nil, protocol.NewErrReply("ERR DB index is out of range")
+	}
+	return s.dbSet[index], nil
+}
+
+func (s *Server) Exec(client connection.Connection, cmdLine CmdLine) (result protocol.Reply) {
+	defer func() {
+		if err := recover(); err != nil {
+			logx.L().Error(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
+			result = protocol.NewErrReply("ERR unknown")
+		}
+	}()
+
+	name := strings.ToLower(string(cmdLine[0]))
+	if inSubscriberMode(client) && !isSubscriberModeCommand(name) {
+		return newSubscriberModeErrReply(name)
+	}
+	if errReply := s.checkReadOnly(client, name); errReply != nil {
+		if client.InMultiState() {
+			client.AddTxError(errReply.Error())
+		}
+		return errReply
+	}
+	if client.InMultiState() && !isTxControlCommand(name) {
+		return enqueueCmd(client, cmdLine)
+	}
+	cmd, ok := cmdTable[name]
+	if !ok {
+		return protocol.NewUnknownCommandErrReply(name)
+	}
+	if !cmd.validateArity(cmdLine) {
+		return protocol.NewArgNumErrReply(name)
+	}
+	if cmd.executor != nil {
+		db, errReply := s.selectDB(client.GetDBIndex())
+		if errReply != nil {
+			return errReply
+		}
+		if cmd.hasFlag(flagBlocking) {
+			return s.execBlockingCommand(client, db, cmd, cmdLine[1:])
+		}
+		reply := db.execCommand(cmd, cmdLine[1:])
+		db.handleReadyKeys()
+		return reply
+	}
+	return cmd.sysExecutor(s, client, cmdLine[1:])
+}
+
+func (s *Server) AfterClientClose(client connection.Connection) {
+	s.pubsub.unsubscribeAll(client)
+	s.repl.removeReplica(client)
+}
+
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+		<-s.cronDone
+		s.stopMasterLink()
+		// 未完成的后台任务被放弃，AOF与RDB文件仍然完整
+		s.bgWg.Wait()
+		if s.persister != nil {
+			s.persister.Close()
+		}
+	})
+}
@@ -0,0 +1,519 @@
+package database
+
+import (
+	"godis/config"
+	"godis/datastruct/set"
+	"godis/resp/protocol"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerCommand("sadd", execSAdd, -3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("srem", execSRem, -3, flagWrite|flagFast).keys(1, 1, 1)
+	registerCommand("sismember", execSIsMember, 3, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("smismember", execSMIsMember, -3, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("smembers", execSMembers, 2, flagReadOnly).keys(1, 1, 1)
+	registerCommand("scard", execSCard, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("spop", execSPop, -2, flagWrite|flagFast).keys(1, 1, 1).withAof(aofSPop)
+	registerCommand("srandmember", execSRandMember, -2, flagReadOnly).keys(1, 1, 1)
+	registerCommand("smove", execSMove, 4, flagWrite|flagFast).keys(1, 2, 1)
+	registerCommand("sinter", execSInter, -2, flagReadOnly).keys(1, -1, 1)
+	registerCommand("sintercard", execSInterCard, -3, flagReadOnly).withPrepare(prepareSInterCard)
+	registerCommand("sunion", execSUnion, -2, flagReadOnly).keys(1, -1, 1)
+	registerCommand("sdiff", execSDiff, -2, flagReadOnly).keys(1, -1, 1)
+	registerCommand("sinterstore", execSInterStore, -3, flagWrite|flagDenyOOM).keys(1, -1, 1).withPrepare(prepareSetStore)
+	registerCommand("sunionstore", execSUnionStore, -3, flagWrite|flagDenyOOM).keys(1, -1, 1).withPrepare(prepareSetStore)
+	registerCommand("sdiffstore", execSDiffStore, -3, flagWrite|flagDenyOOM).keys(1, -1, 1).withPrepare(prepareSetStore)
+	registerCommand("sscan", execSScan, -3, flagReadOnly).keys(1, 1, 1)
+}
+
+// getAsSet returns the set of key, nil if key doesn't exist
+func (db *DB) getAsSet(key string) (*set.Set, *protocol.ErrReply) {
+	entity, ok := db.getEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	s, ok := entity.(*set.Set)
+	if !ok {
+		return nil, protocol.NewWrongTypeErrReply()
+	}
+	return s, nil
+}
+
+func newSet() *set.Set {
+	return set.New(config.Properties.SetMaxIntsetEntries)
+}
+
+// getOrInitSet returns the set of key, an empty set is created if key doesn't exist
+func (db *DB) getOrInitSet(key string) (*set.Set, *protocol.ErrReply) {
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if s == nil {
+		s = newSet()
+		db.putEntity(key, s)
+	}
+	return s, nil
+}
+
+// removeIfEmptySet deletes key once its set has no members left
+func (db *DB) removeIfEmptySet(key string, s *set.Set) {
+	if s.Len() == 0 {
+		db.removeKey(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+}
+
+func toMultiBulk(members []string) protocol.Reply {
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
+	}
+	return protocol.NewMultiBulkReply(result)
+}
+
+// execSAdd SADD key member [member ...]
+func execSAdd(db *DB, args [][]byte) protocol.Reply {
+	s, errReply := db.getOrInitSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	added := 0
+	for _, member := range args[1:] {
+		if s.Add(string(member)) {
+			added++
+		}
+	}
+	if added > 0 {
+		db.notify(notifySet, "sadd", string(args[0]))
+	}
+	return protocol.NewIntReply(int64(added))
+}
+
+// execSRem SREM key member [member ...]
+func execSRem(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.NewIntReply(0)
+	}
+	removed := 0
+	for _, member := range args[1:] {
+		if s.Remove(string(member)) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		db.notify(notifySet, "srem", key)
+	}
+	db.removeIfEmptySet(key, s)
+	return protocol.NewIntReply(int64(removed))
+}
+
+// execSIsMember SISMEMBER key member
+func execSIsMember(db *DB, args [][]byte) protocol.Reply {
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil || !s.Has(string(args[1])) {
+		return protocol.NewIntReply(0)
+	}
+	return protocol.NewIntReply(1)
+}
+
+// execSMIsMember SMISMEMBER key member [member ...]
+func execSMIsMember(db *DB, args [][]byte) protocol.Reply {
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]protocol.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		if s != nil && s.Has(string(member)) {
+			result[i] = protocol.NewIntReply(1)
+		} else {
+			result[i] = protocol.NewIntReply(0)
+		}
+	}
+	return protocol.NewArrayReply(result)
+}
+
+// execSMembers SMEMBERS key
+func execSMembers(db *DB, args [][]byte) protocol.Reply {
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.NewEmptyMultiBulkReply()
+	}
+	return toMultiBulk(s.Members())
+}
+
+// execSCard SCARD key
+func execSCard(db *DB, args [][]byte) protocol.Reply {
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.NewIntReply(0)
+	}
+	return protocol.NewIntReply(int64(s.Len()))
+}
+
+// execSPop SPOP key [count]
+func execSPop(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.NewSyntaxErrReply()
+	}
+	key := string(args[0])
+	count := int64(-1)
+	if len(args) == 2 {
+		var ok bool
+		count, ok = parseInt(args[1])
+		if !ok || count < 0 {
+			return protocol.NewErrReply("ERR value is out of range, must be positive")
+		}
+	}
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if count < 0 {
+		if s == nil {
+			return protocol.NewNullBulkReply()
+		}
+		member, _ := s.RandomMember()
+		s.Remove(member)
+		db.notify(notifySet, "spop", key)
+		db.removeIfEmptySet(key, s)
+		return protocol.NewBulkReply([]byte(member))
+	}
+
+	if s == nil || count == 0 {
+		return protocol.NewEmptyMultiBulkReply()
+	}
+	var members []string
+	if count >= int64(s.Len()) {
+		members = s.Members()
+		db.removeKey(key)
+		db.notify(notifySet, "spop", key)
+		db.notify(notifyGeneric, "del", key)
+		return toMultiBulk(members)
+	}
+	members = s.RandomDistinctMembers(int(count))
+	for _, member := range members {
+		s.Remove(member)
+	}
+	db.notify(notifySet, "spop", key)
+	return toMultiBulk(members)
+}
+
+// aofSPop writes the randomly chosen members SPOP removed as SREM
+func aofSPop(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+	var members [][]byte
+	switch r := reply.(type) {
+	case *protocol.BulkReply:
+		if r.Value != nil {
+			members = [][]byte{r.Value}
+		}
+	case *protocol.MultiBulkReply:
+		members = r.Values
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return []CmdLine{makeCmdLine("srem", append([][]byte{args[0]}, members...)...)}
+}
+
+// execSRandMember SRANDMEMBER key [count]
+func execSRandMember(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.NewSyntaxErrReply()
+	}
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 1 {
+		if s == nil {
+			return protocol.NewNullBulkReply()
+		}
+		member, _ := s.RandomMember()
+		return protocol.NewBulkReply([]byte(member))
+	}
+
+	count, ok := parseInt(args[1])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	if s == nil || count == 0 {
+		return protocol.NewEmptyMultiBulkReply()
+	}
+	if count > 0 {
+		return toMultiBulk(s.RandomDistinctMembers(int(min(count, int64(s.Len())))))
+	}
+	// 负数时允许重复，返回恰好-count个成员
+	members := make([]string, -count)
+	for i := range members {
+		members[i], _ = s.RandomMember()
+	}
+	return toMultiBulk(members)
+}
+
+// execSMove SMOVE source destination member
+func execSMove(db *DB, args [][]byte) protocol.Reply {
+	srcKey, dstKey := string(args[0]), string(args[1])
+	member := string(args[2])
+	src, errReply := db.getAsSet(srcKey)
+	if errReply != nil {
+		return errReply
+	}
+	dst, errReply := db.getAsSet(dstKey)
+	if errReply != nil {
+		return errReply
+	}
+	if src == nil || !src.Has(member) {
+		return protocol.NewIntReply(0)
+	}
+	if srcKey == dstKey {
+		return protocol.NewIntReply(1)
+	}
+	src.Remove(member)
+	db.notify(notifySet, "srem", srcKey)
+	db.removeIfEmptySet(srcKey, src)
+	if dst == nil {
+		dst = newSet()
+		db.putEntity(dstKey, dst)
+	}
+	if dst.Add(member) {
+		db.notify(notifySet, "sadd", dstKey)
+	}
+	return protocol.NewIntReply(1)
+}
+
+// getSets returns the sets of keys, missing keys are returned as nil
+func (db *DB) getSets(keys [][]byte) ([]*set.Set, *protocol.ErrReply) {
+	sets := make([]*set.Set, len(keys))
+	for i, key := range keys {
+		s, errReply := db.getAsSet(string(key))
+		if errReply != nil {
+			return nil, errReply
+		}
+		sets[i] = s
+	}
+	return sets, nil
+}
+
+// intersect returns the members of all sets, at most limit members if limit > 0.
+// The smallest set is iterated and the others are probed from the smallest up, like redis does.
+func intersect(sets []*set.Set, limit int) []string {
+	for _, s := range sets {
+		if s == nil {
+			return nil
+		}
+	}
+	sorted := make([]*set.Set, len(sets))
+	copy(sorted, sets)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Len() < sorted[j].Len()
+	})
+
+	var result []string
+	sorted[0].ForEach(func(member string) bool {
+		for _, other := range sorted[1:] {
+			if !other.Has(member) {
+				return true
+			}
+		}
+		result = append(result, member)
+		return limit <= 0 || len(result) < limit
+	})
+	return result
+}
+
+func union(sets []*set.Set) []string {
+	seen := make(map[string]struct{})
+	var result []string
+	for _, s := range sets {
+		if s == nil {
+			continue
+		}
+		s.ForEach(func(member string) bool {
+			if _, ok := seen[member]; !ok {
+				seen[member] = struct{}{}
+				result = append(result, member)
+			}
+			return true
+		})
+	}
+	return result
+}
+
+// diff returns the members of the first set not in any of the others
+func diff(sets []*set.Set) []string {
+	if sets[0] == nil {
+		return nil
+	}
+	var result []string
+	sets[0].ForEach(func(member string) bool {
+		for _, other := range sets[1:] {
+			if other != nil && other.Has(member) {
+				return true
+			}
+		}
+		result = append(result, member)
+		return true
+	})
+	return result
+}
+
+// execSInter SINTER key [key ...]
+func execSInter(db *DB, args [][]byte) protocol.Reply {
+	sets, errReply := db.getSets(args)
+	if errReply != nil {
+		return errReply
+	}
+	return toMultiBulk(intersect(sets, 0))
+}
+
+// execSUnion SUNION key [key ...]
+func execSUnion(db *DB, args [][]byte) protocol.Reply {
+	sets, errReply := db.getSets(args)
+	if errReply != nil {
+		return errReply
+	}
+	return toMultiBulk(union(sets))
+}
+
+// execSDiff SDIFF key [key ...]
+func execSDiff(db *DB, args [][]byte) protocol.Reply {
+	sets, errReply := db.getSets(args)
+	if errReply != nil {
+		return errReply
+	}
+	return toMultiBulk(diff(sets))
+}
+
+func prepareSInterCard(args [][]byte) ([]string, []string) {
+	keys, _, _ := parseNumKeys(args)
+	return nil, keys
+}
+
+// execSInterCard SINTERCARD numkeys key [key ...] [LIMIT limit]
+func execSInterCard(db *DB, args [][]byte) protocol.Reply {
+	keys, rest, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return errReply
+	}
+	limit := int64(0)
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "LIMIT" {
+			return protocol.NewSyntaxErrReply()
+		}
+		var ok bool
+		limit, ok = parseInt(rest[1])
+		if !ok {
+			return protocol.NewNotIntegerErrReply()
+		}
+		if limit < 0 {
+			return protocol.NewErrReply("ERR LIMIT can't be negative")
+		}
+	}
+	sets, errReply := db.getSets(args[1 : len(keys)+1])
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.NewIntReply(int64(len(intersect(sets, int(limit)))))
+}
+
+// prepareSetStore xSTORE destination key [key ...], destination is written and the sources are read
+func prepareSetStore(args [][]byte) ([]string, []string) {
+	readKeys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		readKeys[i] = string(arg)
+	}
+	return []string{string(args[0])}, readKeys
+}
+
+// storeSet replaces destination with a set of members, an empty result deletes destination.
+// event is published for destination, e.g. sinterstore.
+func storeSet(db *DB, dst string, members []string, event string) protocol.Reply {
+	_, existed := db.removeKey(dst)
+	if len(members) == 0 {
+		if existed {
+			db.notify(notifyGeneric, "del", dst)
+		}
+		return protocol.NewIntReply(0)
+	}
+	s := newSet()
+	for _, member := range members {
+		s.Add(member)
+	}
+	db.putEntity(dst, s)
+	db.notify(notifySet, event, dst)
+	return protocol.NewIntReply(int64(s.Len()))
+}
+
+// execSInterStore SINTERSTORE destination key [key ...]
+func execSInterStore(db *DB, args [][]byte) protocol.Reply {
+	sets, errReply := db.getSets(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	return storeSet(db, string(args[0]), intersect(sets, 0), "sinterstore")
+}
+
+// execSUnionStore SUNIONSTORE destination key [key ...]
+func execSUnionStore(db *DB, args [][]byte) protocol.Reply {
+	sets, errReply := db.getSets(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	return storeSet(db, string(args[0]), union(sets), "sunionstore")
+}
+
+// execSDiffStore SDIFFSTORE destination key [key ...]
+func execSDiffStore(db *DB, args [][]byte) protocol.Reply {
+	sets, errReply := db.getSets(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	return storeSet(db, string(args[0]), diff(sets), "sdiffstore")
+}
+
+// execSScan SSCAN key cursor [MATCH pattern] [COUNT count]
+func execSScan(db *DB, args [][]byte) protocol.Reply {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.NewErrReply("ERR invalid cursor")
+	}
+	pattern, count, _, errReply := parseScanOptions(args[2:], false)
+	if errReply != nil {
+		return errReply
+	}
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	var members []string
+	next := 0
+	if s != nil {
+		members, next = s.Scan(int(cursor), count, pattern)
+		if next < 0 {
+			return protocol.NewErrReply("ERR illegal wildcard")
+		}
+	}
+	return protocol.NewArrayReply([]protocol.Reply{
+		protocol.NewBulkReply([]byte(strconv.Itoa(next))),
+		toMultiBulk(members),
+	})
+}
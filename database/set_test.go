@@ -0,0 +1,88 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, ":3\r\n", exec(s, c, "sadd s 3 1 2"))
+	assertReply(t, ":1\r\n", exec(s, c, "sadd s 2 4"))
+	assertReply(t, "+set\r\n", exec(s, c, "type s"))
+	assertReply(t, "*4\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$1\r\n4\r\n", exec(s, c, "smembers s"))
+	assertReply(t, ":4\r\n", exec(s, c, "scard s"))
+	assertReply(t, ":1\r\n", exec(s, c, "sismember s 3"))
+	assertReply(t, "*3\r\n:1\r\n:0\r\n:1\r\n", exec(s, c, "smismember s 1 9 4"))
+	assertReply(t, ":2\r\n", exec(s, c, "srem s 1 2 9"))
+	assertReply(t, ":1\r\n", exec(s, c, "smove s d 3"))
+	assertReply(t, ":0\r\n", exec(s, c, "smove s d 3"))
+	assertReply(t, "*1\r\n$1\r\n3\r\n", exec(s, c, "smembers d"))
+	assertReply(t, "$1\r\n4\r\n", exec(s, c, "spop s"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists s"))
+	assertReply(t, "$-1\r\n", exec(s, c, "spop s"))
+
+	exec(s, c, "sadd p 1 2 3")
+	assertReply(t, "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n", exec(s, c, "spop p 5"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists p"))
+	assertReply(t, "-ERR value is out of range, must be positive\r\n", exec(s, c, "spop p -1"))
+
+	exec(s, c, "set str v")
+	assertReply(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "sadd str a"))
+	assertReply(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "smove d str 3"))
+}
+
+func TestSetRandMember(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "$-1\r\n", exec(s, c, "srandmember s"))
+	assertReply(t, "*0\r\n", exec(s, c, "srandmember s 2"))
+	exec(s, c, "sadd s a")
+	assertReply(t, "*1\r\n$1\r\na\r\n", exec(s, c, "srandmember s 3"))
+	assertReply(t, "*3\r\n$1\r\na\r\n$1\r\na\r\n$1\r\na\r\n", exec(s, c, "srandmember s -3"))
+
+	// 空字符串是唯一的成员
+	s.Exec(c, toCmdLine("sadd", "e", ""))
+	assertReply(t, "$0\r\n\r\n", exec(s, c, "srandmember e"))
+	assertReply(t, "$0\r\n\r\n", exec(s, c, "spop e"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists e"))
+}
+
+func TestSetAlgebra(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "sadd a 1 2 3 4")
+	exec(s, c, "sadd b 2 3 5")
+	exec(s, c, "sadd c 3 4")
+	assertReply(t, "*1\r\n$1\r\n3\r\n", exec(s, c, "sinter a b c"))
+	assertReply(t, "*0\r\n", exec(s, c, "sinter a nokey"))
+	assertReply(t, ":2\r\n", exec(s, c, "sintercard 2 a b"))
+	assertReply(t, ":1\r\n", exec(s, c, "sintercard 2 a b limit 1"))
+	assertReply(t, "-ERR LIMIT can't be negative\r\n", exec(s, c, "sintercard 2 a b limit -1"))
+	assertReply(t, "*1\r\n$1\r\n1\r\n", exec(s, c, "sdiff a b c"))
+
+	assertReply(t, ":5\r\n", exec(s, c, "sunionstore u a b"))
+	assertReply(t, "*5\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$1\r\n4\r\n$1\r\n5\r\n", exec(s, c, "smembers u"))
+	assertReply(t, ":2\r\n", exec(s, c, "sinterstore a a b"))
+	assertReply(t, "*2\r\n$1\r\n2\r\n$1\r\n3\r\n", exec(s, c, "smembers a"))
+	exec(s, c, "expire u 100")
+	assertReply(t, ":0\r\n", exec(s, c, "sdiffstore u nokey b"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists u"))
+
+	exec(s, c, "set str v")
+	assertReply(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "sunion a str"))
+	assertReply(t, "*2\r\n$1\r\nu\r\n$1\r\na\r\n", exec(s, c, "command getkeys sinterstore u a"))
+}
+
+func TestSetScan(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "sadd s a b ab")
+	assertReply(t, "*2\r\n$1\r\n0\r\n*1\r\n$1\r\nb\r\n", exec(s, c, "sscan s 0 match b"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "sscan s 0 novalues"))
+}
@@ -0,0 +1,222 @@
+package database
+
+import (
+	"errors"
+	"godis/datastruct/dict"
+	"godis/datastruct/hash"
+	"godis/pkg/logx"
+	"slices"
+	"time"
+)
+
+// 后台任务的类型，与redis同时只能有一个子进程相同，AOF重写、BGSAVE和全量同步不能同时进行
+const (
+	bgJobNone int32 = iota
+	bgJobAofRewrite
+	bgJobRdbSave
+	bgJobReplSync
+)
+
+var (
+	errBgJobRunning = errors.New("another background job is running")
+	errShuttingDown = errors.New("server is shutting down")
+)
+
+// keyspaceSnapshot is the point in time view of a keyspace read by a background AOF rewrite or
+// BGSAVE. Go can't fork, so the job walks the live dict one shard at a time instead. Until a shard
+// is visited, a writer saves the state of a key before modifying it and the job reads the saved
+// state instead, so the job sees every key as it was when the snapshot was taken.
+type keyspaceSnapshot struct {
+	// 快照时该keyspace所属的db，SWAPDB之后快照随keyspace一起交换
+	index  int
+	data   *dict.ConcurrentDict
+	ttlMap *dict.ConcurrentDict
+	// 每个分片中修改前保存的key，由该分片的锁保护
+	saved []map[string]*undoEntry
+	// 分片是否已经被访问过
+	done []bool
+}
+
+func newKeyspaceSnapshot(db *DB) *keyspaceSnapshot {
+	shards := db.data.ShardCount()
+	return &keyspaceSnapshot{
+		index:  db.index,
+		data:   db.data,
+		ttlMap: db.ttlMap,
+		saved:  make([]map[string]*undoEntry, shards),
+		done:   make([]bool, shards),
+	}
+}
+
+// saveForSnapshot saves the state of keys about to be modified while a background job
+// hasn't visited them yet, the caller must hold the write locks of keys
+func (db *DB) saveForSnapshot(keys []string) {
+	snap := db.snapshot
+	if snap == nil {
+		return
+	}
+	for _, key := range keys {
+		i := snap.data.ShardIndex(key)
+		if snap.done[i] {
+			continue
+		}
+		if snap.saved[i] == nil {
+			snap.saved[i] = make(map[string]*undoEntry)
+		}
+		if _, ok := snap.saved[i][key]; ok {
+			continue
+		}
+		snap.saved[i][key] = db.captureEntry(key)
+	}
+}
+
+// forEachInShard visits the keys of shard i as they were when the snapshot was taken, keys expired
+// at now are skipped and expireAt is zero for keys without an expire time. Writers of the shard
+// wait until it's done.
+func (snap *keyspaceSnapshot) forEachInShard(i int, now time.Time, fn func(key string, val any, expireAt time.Time) error) error {
+	snap.data.RLockShard(i)
+	defer snap.data.RUnlockShard(i)
+
+	visit := func(key string, val any, expireAt time.Time, hasTTL bool) error {
+		if hasTTL && !expireAt.After(now) {
+			return nil
+		}
+		// 所有字段都已过期的hash等同于不存在
+		if h, ok := val.(*hash.Hash); ok && h.HasExpires() && h.Len() == 0 {
+			return nil
+		}
+		if !hasTTL {
+			expireAt = time.Time{}
+		}
+		return fn(key, val, expireAt)
+	}
+	var err error
+	snap.data.ForEachInShardWithoutLock(i, func(key string, val any) bool {
+		// 修改过的key使用保存的状态
+		if _, ok := snap.saved[i][key]; ok {
+			return true
+		}
+		var expireAt time.Time
+		raw, hasTTL := snap.ttlMap.Get(key)
+		if hasTTL {
+			expireAt = raw.(time.Time)
+		}
+		err = visit(key, val, expireAt, hasTTL)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	for key, entry := range snap.saved[i] {
+		if !entry.exists {
+			continue
+		}
+		if err := visit(key, entry.val, entry.expireAt, entry.hasTTL); err != nil {
+			return err
+		}
+	}
+	snap.done[i] = true
+	snap.saved[i] = nil
+	return nil
+}
+
+// snapshotFunc takes the snapshots of all keyspaces, either takeSnapshots or takeSnapshotsLocked
+type snapshotFunc func(start func() error) ([]*keyspaceSnapshot, error)
+
+// takeSnapshots takes the snapshots of all keyspaces at the same moment, start runs while
+// no command executes so whatever it records matches the snapshots
+func (s *Server) takeSnapshots(start func() error) ([]*keyspaceSnapshot, error) {
+	// 暂停所有db上的命令
+	for _, db := range s.dbSet {
+		db.stopWorld.Lock()
+	}
+	defer func() {
+		for _, db := range s.dbSet {
+			db.stopWorld.Unlock()
+		}
+	}()
+	return s.takeSnapshotsLocked(start)
+}
+
+// takeSnapshotsLocked is takeSnapshots for callers already holding stopWorld of all dbs
+func (s *Server) takeSnapshotsLocked(start func() error) ([]*keyspaceSnapshot, error) {
+	if err := start(); err != nil {
+		return nil, err
+	}
+	snapshots := make([]*keyspaceSnapshot, len(s.dbSet))
+	for i, db := range s.dbSet {
+		db.snapshot = newKeyspaceSnapshot(db)
+		snapshots[i] = db.snapshot
+	}
+	return snapshots, nil
+}
+
+// releaseSnapshots stops saving keys for snapshots once the job is done with them
+func (s *Server) releaseSnapshots(snapshots []*keyspaceSnapshot) {
+	for _, db := range s.dbSet {
+		db.stopWorld.Lock()
+		if slices.Contains(snapshots, db.snapshot) {
+			db.snapshot = nil
+		}
+		db.stopWorld.Unlock()
+	}
+}
+
+// visitSnapshots walks the shards of all snapshots in db order, giving up once the server is closed
+func (s *Server) visitSnapshots(snapshots []*keyspaceSnapshot, fn func(snap *keyspaceSnapshot, key string, val any, expireAt time.Time) error) error {
+	now := time.Now()
+	for _, snap := range snapshots {
+		for i := 0; i < snap.data.ShardCount(); i++ {
+			select {
+			case <-s.closeChan:
+				return errShuttingDown
+			default:
+			}
+			err := snap.forEachInShard(i, now, func(key string, val any, expireAt time.Time) error {
+				return fn(snap, key, val, expireAt)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// startBgJob marks job as the running background job, finishBgJob must be called once it's done
+func (s *Server) startBgJob(job int32) error {
+	if !s.bgJob.CompareAndSwap(bgJobNone, job) {
+		return errBgJobRunning
+	}
+	s.bgWg.Add(1)
+	return nil
+}
+
+func (s *Server) finishBgJob() {
+	s.bgJob.Store(bgJobNone)
+	s.bgWg.Done()
+}
+
+// runScheduledJobs starts the AOF rewrite or BGSAVE that had to wait for another job
+func (s *Server) runScheduledJobs() {
+	if s.bgJob.Load() != bgJobNone {
+		return
+	}
+	if s.aofRewriteScheduled.Load() {
+		if err := s.startAofRewrite(); !errors.Is(err, errBgJobRunning) {
+			s.aofRewriteScheduled.Store(false)
+			if err != nil {
+				logx.L().Errorf("start scheduled aof rewrite failed: %v", err)
+			}
+		}
+		return
+	}
+	if s.bgSaveScheduled.Load() {
+		if err := s.startBgSave(); !errors.Is(err, errBgJobRunning) {
+			s.bgSaveScheduled.Store(false)
+			if err != nil {
+				logx.L().Errorf("start scheduled background save failed: %v", err)
+			}
+		}
+	}
+}
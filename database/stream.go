@@ -0,0 +1,1570 @@
+package database
+
+import (
+	"godis/config"
+	"godis/datastruct/stream"
+	"godis/resp/protocol"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand("xadd", execXAdd, -5, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1).withAof(aofXAdd)
+	registerCommand("xrange", execXRange, -4, flagReadOnly).keys(1, 1, 1)
+	registerCommand("xrevrange", execXRevRange, -4, flagReadOnly).keys(1, 1, 1)
+	registerCommand("xlen", execXLen, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("xdel", execXDel, -3, flagWrite|flagFast).keys(1, 1, 1)
+	registerCommand("xtrim", execXTrim, -4, flagWrite).keys(1, 1, 1)
+	registerCommand("xsetid", execXSetID, -3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("xread", execXRead, -4, flagReadOnly|flagBlocking).withPrepare(prepareXRead)
+	registerCommand("xgroup", execXGroup, -2, flagWrite).keys(2, 2, 1)
+	registerCommand("xreadgroup", execXReadGroup, -7, flagWrite|flagBlocking).withPrepare(prepareXReadGroup).withAof(aofXReadGroup)
+	registerCommand("xack", execXAck, -4, flagWrite|flagFast).keys(1, 1, 1)
+	registerCommand("xpending", execXPending, -3, flagReadOnly).keys(1, 1, 1)
+	registerCommand("xclaim", execXClaim, -6, flagWrite|flagFast).keys(1, 1, 1).withAof(aofXClaim)
+	registerCommand("xautoclaim", execXAutoClaim, -6, flagWrite|flagFast).keys(1, 1, 1).withAof(aofXAutoClaim)
+	registerCommand("xinfo", execXInfo, -2, flagReadOnly).keys(2, 2, 1)
+}
+
+// getAsStream returns the stream of key, nil if key doesn't exist
+func (db *DB) getAsStream(key string) (*stream.Stream, *protocol.ErrReply) {
+	entity, ok := db.getEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	s, ok := entity.(*stream.Stream)
+	if !ok {
+		return nil, protocol.NewWrongTypeErrReply()
+	}
+	return s, nil
+}
+
+func newStream() *stream.Stream {
+	return stream.New(config.Properties.StreamNodeMaxEntries)
+}
+
+// getOrCreateConsumer returns the consumer of a group, a consumer created implicitly by reading
+// or claiming publishes xgroup-createconsumer
+func (db *DB) getOrCreateConsumer(key string, g *stream.Group, name string, now int64) *stream.Consumer {
+	if consumer := g.Consumer(name); consumer != nil {
+		return consumer
+	}
+	consumer := g.CreateConsumer(name, now)
+	db.notify(notifyStream, "xgroup-createconsumer", key)
+	return consumer
+}
+
+// getStreamGroup returns the stream of key and its consumer group, a NOGROUP error if either is missing
+func (db *DB) getStreamGroup(key string, group string) (*stream.Stream, *stream.Group, *protocol.ErrReply) {
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if s == nil || s.Group(group) == nil {
+		return nil, nil, protocol.NewErrReply("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+	}
+	return s, s.Group(group), nil
+}
+
+func newInvalidStreamIDErrReply() *protocol.ErrReply {
+	return protocol.NewErrReply("ERR Invalid stream ID specified as stream command argument")
+}
+
+// parseStreamID parses a complete ID, "ms" alone means "ms-0"
+func parseStreamID(arg []byte) (stream.ID, *protocol.ErrReply) {
+	id, err := stream.ParseID(string(arg), 0)
+	if err != nil {
+		return id, newInvalidStreamIDErrReply()
+	}
+	return id, nil
+}
+
+// parseRangeID parses a bound of an ID range. "-" and "+" are the minimum and maximum IDs,
+// "ms" alone covers the whole millisecond and a "(" prefix excludes the bound itself.
+func parseRangeID(arg []byte, start bool) (stream.ID, *protocol.ErrReply) {
+	s := string(arg)
+	switch s {
+	case "-":
+		return stream.MinID, nil
+	case "+":
+		return stream.MaxID, nil
+	}
+	exclude := strings.HasPrefix(s, "(")
+	missingSeq := uint64(0)
+	if !start {
+		missingSeq = math.MaxUint64
+	}
+	id, err := stream.ParseID(strings.TrimPrefix(s, "("), missingSeq)
+	if err != nil {
+		return id, newInvalidStreamIDErrReply()
+	}
+	if !exclude {
+		return id, nil
+	}
+	if start {
+		next, ok := id.Next()
+		if !ok {
+			return id, protocol.NewErrReply("ERR invalid start ID for the interval")
+		}
+		return next, nil
+	}
+	prev, ok := id.Prev()
+	if !ok {
+		return id, protocol.NewErrReply("ERR invalid end ID for the interval")
+	}
+	return prev, nil
+}
+
+func idReply(id stream.ID) protocol.Reply {
+	return protocol.NewBulkReply([]byte(id.String()))
+}
+
+// entryReply replies an entry as [id, [field, value, ...]], fields of a deleted entry are nil
+func entryReply(e stream.Entry) protocol.Reply {
+	var fields protocol.Reply = protocol.NewNullArrayReply()
+	if e.Fields != nil {
+		fields = protocol.NewMultiBulkReply(e.Fields)
+	}
+	return protocol.NewArrayReply([]protocol.Reply{idReply(e.ID), fields})
+}
+
+func entriesReply(entries []stream.Entry) protocol.Reply {
+	replies := make([]protocol.Reply, len(entries))
+	for i, e := range entries {
+		replies[i] = entryReply(e)
+	}
+	return protocol.NewArrayReply(replies)
+}
+
+// trimSpec is the trimming strategy of XADD and XTRIM
+type trimSpec struct {
+	maxLen bool
+	// MAXLEN的阈值
+	threshold int
+	minID     stream.ID
+	approx    bool
+	limit     int
+}
+
+// parseTrimSpec parses <MAXLEN | MINID> [= | ~] threshold [LIMIT count], returns the number of args consumed
+func parseTrimSpec(args [][]byte) (*trimSpec, int, *protocol.ErrReply) {
+	spec := &trimSpec{maxLen: strings.ToUpper(string(args[0])) == "MAXLEN"}
+	i := 1
+	if i < len(args) {
+		switch string(args[i]) {
+		case "~":
+			spec.approx = true
+			i++
+		case "=":
+			i++
+		}
+	}
+	if i >= len(args) {
+		return nil, 0, protocol.NewSyntaxErrReply()
+	}
+	if spec.maxLen {
+		n, ok := parseInt(args[i])
+		if !ok {
+			return nil, 0, protocol.NewNotIntegerErrReply()
+		}
+		if n < 0 {
+			return nil, 0, protocol.NewErrReply("ERR The MAXLEN argument must be >= 0.")
+		}
+		spec.threshold = int(n)
+	} else {
+		id, errReply := parseStreamID(args[i])
+		if errReply != nil {
+			return nil, 0, errReply
+		}
+		spec.minID = id
+	}
+	i++
+
+	// 与redis相同，近似裁剪默认每次最多删除100个节点的条目
+	if spec.approx {
+		spec.limit = 100 * config.Properties.StreamNodeMaxEntries
+	}
+	if i < len(args) && strings.ToUpper(string(args[i])) == "LIMIT" {
+		if i+1 >= len(args) {
+			return nil, 0, protocol.NewSyntaxErrReply()
+		}
+		n, ok := parseInt(args[i+1])
+		if !ok {
+			return nil, 0, protocol.NewNotIntegerErrReply()
+		}
+		if n < 0 {
+			return nil, 0, protocol.NewErrReply("ERR The LIMIT argument must be >= 0.")
+		}
+		if !spec.approx {
+			return nil, 0, protocol.NewErrReply("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		spec.limit = int(n)
+		i += 2
+	}
+	return spec, i, nil
+}
+
+func (spec *trimSpec) trim(s *stream.Stream) int {
+	if spec.maxLen {
+		return s.TrimMaxLen(spec.threshold, spec.approx, spec.limit)
+	}
+	return s.TrimMinID(spec.minID, spec.approx, spec.limit)
+}
+
+// execXAdd XADD key [NOMKSTREAM] [<MAXLEN | MINID> [= | ~] threshold [LIMIT count]] <* | id> field value [field value ...]
+func execXAdd(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	noMkStream := false
+	var spec *trimSpec
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NOMKSTREAM":
+			noMkStream = true
+		case "MAXLEN", "MINID":
+			var n int
+			var errReply *protocol.ErrReply
+			spec, n, errReply = parseTrimSpec(args[i:])
+			if errReply != nil {
+				return errReply
+			}
+			i += n - 1
+		default:
+			break options
+		}
+	}
+	if i >= len(args) || (len(args)-i-1) == 0 || (len(args)-i-1)%2 != 0 {
+		return protocol.NewArgNumErrReply("xadd")
+	}
+
+	// ID可以是*、ms-*或完整ID
+	idArg := string(args[i])
+	autoID, autoSeq := idArg == "*", strings.HasSuffix(idArg, "-*")
+	var id stream.ID
+	if autoSeq {
+		ms, err := strconv.ParseUint(strings.TrimSuffix(idArg, "-*"), 10, 64)
+		if err != nil {
+			return newInvalidStreamIDErrReply()
+		}
+		id.Ms = ms
+	} else if !autoID {
+		var errReply *protocol.ErrReply
+		id, errReply = parseStreamID(args[i])
+		if errReply != nil {
+			return errReply
+		}
+		if id.IsZero() {
+			return protocol.NewErrReply("ERR The ID specified in XADD must be greater than 0-0")
+		}
+	}
+
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		if noMkStream {
+			return protocol.NewNullBulkReply()
+		}
+		s = newStream()
+		db.putEntity(key, s)
+	}
+
+	tooSmall := protocol.NewErrReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	switch {
+	case autoID:
+		var ok bool
+		id, ok = s.NextID(uint64(time.Now().UnixMilli()))
+		if !ok {
+			return protocol.NewErrReply("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+	case autoSeq:
+		var ok bool
+		id, ok = s.NextSeqID(id.Ms)
+		if !ok {
+			return tooSmall
+		}
+	default:
+		if !s.LastID().Less(id) {
+			return tooSmall
+		}
+	}
+
+	s.Append(id, args[i+1:])
+	db.notify(notifyStream, "xadd", key)
+	if spec != nil && spec.trim(s) > 0 {
+		db.notify(notifyStream, "xtrim", key)
+	}
+	// 向已存在的stream追加不会触发putEntity的通知，需要手动唤醒阻塞在该key上的XREAD
+	db.signalKeyAsReady(key)
+	return idReply(id)
+}
+
+// aofXAdd writes the ID XADD generated in place of * or ms-*, so replaying doesn't depend on the clock
+func aofXAdd(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+	if isNullReply(reply) {
+		return nil
+	}
+	i := 1
+	for i < len(args) {
+		opt := strings.ToUpper(string(args[i]))
+		if opt == "NOMKSTREAM" {
+			i++
+			continue
+		}
+		if opt != "MAXLEN" && opt != "MINID" {
+			break
+		}
+		_, n, _ := parseTrimSpec(args[i:])
+		i += n
+	}
+	cmdLine := makeCmdLine("xadd", args...)
+	cmdLine[i+1] = reply.(*protocol.BulkReply).Value
+	return []CmdLine{cmdLine}
+}
+
+// execXRange XRANGE key start end [COUNT count]
+func execXRange(db *DB, args [][]byte) protocol.Reply {
+	return xrangeGeneric(db, args, false)
+}
+
+// execXRevRange XREVRANGE key end start [COUNT count]
+func execXRevRange(db *DB, args [][]byte) protocol.Reply {
+	return xrangeGeneric(db, args, true)
+}
+
+func xrangeGeneric(db *DB, args [][]byte, rev bool) protocol.Reply {
+	startArg, endArg := args[1], args[2]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, errReply := parseRangeID(startArg, true)
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseRangeID(endArg, false)
+	if errReply != nil {
+		return errReply
+	}
+	count := -1
+	if len(args) > 3 {
+		if len(args) != 5 || strings.ToUpper(string(args[3])) != "COUNT" {
+			return protocol.NewSyntaxErrReply()
+		}
+		n, ok := parseInt(args[4])
+		if !ok {
+			return protocol.NewNotIntegerErrReply()
+		}
+		count = int(max(n, 0))
+	}
+
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil || count == 0 {
+		return protocol.NewEmptyMultiBulkReply()
+	}
+	return entriesReply(s.Range(start, end, count, rev))
+}
+
+// execXLen XLEN key
+func execXLen(db *DB, args [][]byte) protocol.Reply {
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.NewIntReply(0)
+	}
+	return protocol.NewIntReply(int64(s.Len()))
+}
+
+func parseStreamIDs(args [][]byte) ([]stream.ID, *protocol.ErrReply) {
+	ids := make([]stream.ID, len(args))
+	for i, arg := range args {
+		id, errReply := parseStreamID(arg)
+		if errReply != nil {
+			return nil, errReply
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// execXDel XDEL key id [id ...]
+func execXDel(db *DB, args [][]byte) protocol.Reply {
+	ids, errReply := parseStreamIDs(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.NewIntReply(0)
+	}
+	deleted := 0
+	for _, id := range ids {
+		if s.Delete(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		db.notify(notifyStream, "xdel", key)
+	}
+	return protocol.NewIntReply(int64(deleted))
+}
+
+// execXSetID XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func execXSetID(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	id, errReply := parseStreamID(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID *stream.ID
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return protocol.NewSyntaxErrReply()
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "ENTRIESADDED":
+			n, ok := parseInt(args[i+1])
+			if !ok || n < 0 {
+				return protocol.NewErrReply("ERR entries_added must be positive")
+			}
+			entriesAdded = n
+		case "MAXDELETEDID":
+			deleted, errReply := parseStreamID(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			maxDeletedID = &deleted
+		default:
+			return protocol.NewSyntaxErrReply()
+		}
+	}
+
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.NewErrReply("ERR no such key")
+	}
+	if maxDeletedID != nil && id.Less(*maxDeletedID) {
+		return protocol.NewErrReply("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+	}
+	if entriesAdded >= 0 && entriesAdded < int64(s.Len()) {
+		return protocol.NewErrReply("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	if last, ok := s.LastEntry(); ok && id.Less(last.ID) {
+		return protocol.NewErrReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	s.SetLastID(id)
+	if entriesAdded >= 0 {
+		s.SetEntriesAdded(uint64(entriesAdded))
+	}
+	if maxDeletedID != nil {
+		s.SetMaxDeletedID(*maxDeletedID)
+	}
+	db.notify(notifyStream, "xsetid", key)
+	return protocol.NewOkReply()
+}
+
+// execXTrim XTRIM key <MAXLEN | MINID> [= | ~] threshold [LIMIT count]
+func execXTrim(db *DB, args [][]byte) protocol.Reply {
+	strategy := strings.ToUpper(string(args[1]))
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return protocol.NewSyntaxErrReply()
+	}
+	spec, n, errReply := parseTrimSpec(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	if 1+n != len(args) {
+		return protocol.NewSyntaxErrReply()
+	}
+	key := string(args[0])
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.NewIntReply(0)
+	}
+	trimmed := spec.trim(s)
+	if trimmed > 0 {
+		db.notify(notifyStream, "xtrim", key)
+	}
+	return protocol.NewIntReply(int64(trimmed))
+}
+
+// xreadOptions holds the arguments shared by XREAD and XREADGROUP
+type xreadOptions struct {
+	group    string
+	consumer string
+	// 0表示不限制
+	count   int
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	keys    []string
+	ids     [][]byte
+	// 第一个ID在参数中的位置，用于阻塞时改写参数
+	idsIndex int
+}
+
+// parseXReadArgs parses [GROUP group consumer] [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func parseXReadArgs(args [][]byte, withGroup bool) (*xreadOptions, *protocol.ErrReply) {
+	name := "xread"
+	if withGroup {
+		name = "xreadgroup"
+	}
+	opts := &xreadOptions{}
+	hasGroup := false
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch strings.ToUpper(string(args[i])) {
+		case "COUNT":
+			if remaining < 1 {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return nil, protocol.NewNotIntegerErrReply()
+			}
+			opts.count = int(max(n, 0))
+			i++
+		case "BLOCK":
+			if remaining < 1 {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			ms, ok := parseInt(args[i+1])
+			if !ok {
+				return nil, protocol.NewErrReply("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return nil, protocol.NewErrReply("ERR timeout is negative")
+			}
+			opts.block = true
+			opts.timeout = time.Duration(min(ms, math.MaxInt64/int64(time.Millisecond))) * time.Millisecond
+			i++
+		case "GROUP":
+			if !withGroup || remaining < 2 {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			hasGroup = true
+			opts.group = string(args[i+1])
+			opts.consumer = string(args[i+2])
+			i += 2
+		case "NOACK":
+			if !withGroup {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			opts.noAck = true
+		case "STREAMS":
+			if remaining == 0 || remaining%2 != 0 {
+				return nil, protocol.NewErrReply("ERR Unbalanced '" + name + "' list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			n := remaining / 2
+			opts.keys = make([]string, n)
+			for j := 0; j < n; j++ {
+				opts.keys[j] = string(args[i+1+j])
+			}
+			opts.idsIndex = i + 1 + n
+			opts.ids = args[opts.idsIndex:]
+			if withGroup && !hasGroup {
+				return nil, protocol.NewErrReply("ERR Missing GROUP option for XREADGROUP")
+			}
+			return opts, nil
+		default:
+			return nil, protocol.NewSyntaxErrReply()
+		}
+	}
+	return nil, protocol.NewSyntaxErrReply()
+}
+
+func prepareXRead(args [][]byte) ([]string, []string) {
+	opts, errReply := parseXReadArgs(args, false)
+	if errReply != nil {
+		return nil, nil
+	}
+	return nil, opts.keys
+}
+
+func prepareXReadGroup(args [][]byte) ([]string, []string) {
+	opts, errReply := parseXReadArgs(args, true)
+	if errReply != nil {
+		return nil, nil
+	}
+	return opts.keys, nil
+}
+
+// execXRead XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func execXRead(db *DB, args [][]byte) protocol.Reply {
+	opts, errReply := parseXReadArgs(args, false)
+	if errReply != nil {
+		return errReply
+	}
+	streams := make([]*stream.Stream, len(opts.keys))
+	ids := make([]stream.ID, len(opts.keys))
+	// $表示只读取之后到达的条目，阻塞时需要固定为当前的最后一个ID再重新执行
+	var rewritten [][]byte
+	for i, key := range opts.keys {
+		s, errReply := db.getAsStream(key)
+		if errReply != nil {
+			return errReply
+		}
+		streams[i] = s
+		if string(opts.ids[i]) == "$" {
+			if s != nil {
+				ids[i] = s.LastID()
+			}
+			if rewritten == nil {
+				rewritten = append([][]byte(nil), args...)
+			}
+			rewritten[opts.idsIndex+i] = []byte(ids[i].String())
+			continue
+		}
+		id, errReply := parseStreamID(opts.ids[i])
+		if errReply != nil {
+			return errReply
+		}
+		ids[i] = id
+	}
+
+	var results []protocol.Reply
+	for i, s := range streams {
+		if s == nil {
+			continue
+		}
+		start, ok := ids[i].Next()
+		if !ok {
+			continue
+		}
+		entries := s.Range(start, stream.MaxID, opts.count, false)
+		if len(entries) == 0 {
+			continue
+		}
+		results = append(results, protocol.NewArrayReply([]protocol.Reply{
+			protocol.NewBulkReply([]byte(opts.keys[i])),
+			entriesReply(entries),
+		}))
+	}
+	if len(results) > 0 {
+		return protocol.NewArrayReply(results)
+	}
+	if !opts.block {
+		return protocol.NewNullArrayReply()
+	}
+	return &blockReply{
+		keys:         opts.keys,
+		keyType:      "stream",
+		timeout:      opts.timeout,
+		timeoutReply: protocol.NewNullArrayReply(),
+		args:         rewritten,
+	}
+}
+
+// deliverNew delivers the entries after the last delivered ID of the group to consumer
+func deliverNew(s *stream.Stream, g *stream.Group, consumer *stream.Consumer, count int, noAck bool, now int64) []stream.Entry {
+	start, ok := g.LastID.Next()
+	if !ok {
+		return nil
+	}
+	entries := s.Range(start, stream.MaxID, count, false)
+	for _, e := range entries {
+		// 中间有条目被删除时entries-read不能简单递增，需要重新估算
+		if g.EntriesRead != -1 && !s.HasTombstones(g.LastID) {
+			g.EntriesRead++
+		} else if s.EntriesAdded() > 0 {
+			g.EntriesRead = s.EstimateEntriesRead(e.ID)
+		}
+		g.LastID = e.ID
+		if !noAck {
+			g.Deliver(e.ID, consumer, now)
+		}
+	}
+	return entries
+}
+
+// pendingHistory returns the pending entries of consumer after id, deleted entries have nil fields
+func pendingHistory(s *stream.Stream, g *stream.Group, consumer *stream.Consumer, id stream.ID, count int) []stream.Entry {
+	start, ok := id.Next()
+	if !ok {
+		return nil
+	}
+	pending := g.PendingRange(start, stream.MaxID, count, consumer)
+	entries := make([]stream.Entry, len(pending))
+	for i, pe := range pending {
+		e, ok := s.Get(pe.ID)
+		if !ok {
+			e = stream.Entry{ID: pe.ID}
+		}
+		entries[i] = e
+	}
+	return entries
+}
+
+// execXReadGroup XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func execXReadGroup(db *DB, args [][]byte) protocol.Reply {
+	opts, errReply := parseXReadArgs(args, true)
+	if errReply != nil {
+		return errReply
+	}
+	ids := make([]stream.ID, len(opts.keys))
+	newOnly := make([]bool, len(opts.keys))
+	for i, arg := range opts.ids {
+		switch string(arg) {
+		case ">":
+			newOnly[i] = true
+		case "$":
+			return protocol.NewErrReply("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		default:
+			id, errReply := parseStreamID(arg)
+			if errReply != nil {
+				return errReply
+			}
+			ids[i] = id
+		}
+	}
+	streams := make([]*stream.Stream, len(opts.keys))
+	for i, key := range opts.keys {
+		s, errReply := db.getAsStream(key)
+		if errReply != nil {
+			return errReply
+		}
+		if s == nil || s.Group(opts.group) == nil {
+			return protocol.NewErrReply("NOGROUP No such key '" + key + "' or consumer group '" + opts.group + "' in XREADGROUP with GROUP option")
+		}
+		streams[i] = s
+	}
+
+	now := time.Now().UnixMilli()
+	var results []protocol.Reply
+	for i, s := range streams {
+		g := s.Group(opts.group)
+		consumer := db.getOrCreateConsumer(opts.keys[i], g, opts.consumer, now)
+		consumer.SeenTime = now
+		var entries []stream.Entry
+		if newOnly[i] {
+			entries = deliverNew(s, g, consumer, opts.count, opts.noAck, now)
+			if len(entries) == 0 {
+				continue
+			}
+			consumer.ActiveTime = now
+		} else {
+			// 读取历史时即使没有待确认条目也返回该stream
+			entries = pendingHistory(s, g, consumer, ids[i], opts.count)
+		}
+		results = append(results, protocol.NewArrayReply([]protocol.Reply{
+			protocol.NewBulkReply([]byte(opts.keys[i])),
+			entriesReply(entries),
+		}))
+	}
+	if len(results) > 0 {
+		return protocol.NewArrayReply(results)
+	}
+	if !opts.block {
+		return protocol.NewNullArrayReply()
+	}
+	return &blockReply{
+		keys:         opts.keys,
+		keyType:      "stream",
+		timeout:      opts.timeout,
+		timeoutReply: protocol.NewNullArrayReply(),
+	}
+}
+
+// aofXReadGroup writes XREADGROUP without BLOCK, which only matters while waiting
+// and would make the replay wait on a stream that has nothing new
+func aofXReadGroup(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+	cmdLine := makeCmdLine("xreadgroup")
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "BLOCK":
+			i++
+		case "COUNT":
+			cmdLine = append(cmdLine, args[i:i+2]...)
+			i++
+		case "GROUP":
+			cmdLine = append(cmdLine, args[i:i+3]...)
+			i += 2
+		case "STREAMS":
+			return []CmdLine{append(cmdLine, args[i:]...)}
+		default:
+			cmdLine = append(cmdLine, args[i])
+		}
+	}
+	return []CmdLine{cmdLine}
+}
+
+// parseEntriesRead parses the argument of ENTRIESREAD, -1 means unknown
+func parseEntriesRead(arg []byte) (int64, *protocol.ErrReply) {
+	n, ok := parseInt(arg)
+	if !ok {
+		return 0, protocol.NewNotIntegerErrReply()
+	}
+	if n < -1 {
+		return 0, protocol.NewErrReply("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return n, nil
+}
+
+// execXGroup XGROUP <CREATE | SETID | DESTROY | CREATECONSUMER | DELCONSUMER> key group [arg ...]
+func execXGroup(db *DB, args [][]byte) protocol.Reply {
+	sub := strings.ToUpper(string(args[0]))
+	args = args[1:]
+	argNumErr := protocol.NewErrReply("ERR unknown subcommand or wrong number of arguments for '" + strings.ToLower(sub) + "'. Try XGROUP HELP.")
+	switch sub {
+	case "CREATE":
+		if len(args) < 3 || len(args) > 6 {
+			return argNumErr
+		}
+		return execXGroupCreate(db, args)
+	case "SETID":
+		if len(args) != 3 && len(args) != 5 {
+			return argNumErr
+		}
+		return execXGroupSetID(db, args)
+	case "DESTROY":
+		if len(args) != 2 {
+			return argNumErr
+		}
+	case "CREATECONSUMER", "DELCONSUMER":
+		if len(args) != 3 {
+			return argNumErr
+		}
+	default:
+		return protocol.NewErrReply("ERR unknown subcommand '" + strings.ToLower(sub) + "'. Try XGROUP HELP.")
+	}
+
+	key, groupName := string(args[0]), string(args[1])
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return newXGroupNoKeyErrReply()
+	}
+	if sub == "DESTROY" {
+		if s.DestroyGroup(groupName) {
+			db.notify(notifyStream, "xgroup-destroy", key)
+			return protocol.NewIntReply(1)
+		}
+		return protocol.NewIntReply(0)
+	}
+	g := s.Group(groupName)
+	if g == nil {
+		return newNoGroupErrReply(key, groupName)
+	}
+	consumer := string(args[2])
+	if sub == "CREATECONSUMER" {
+		if g.CreateConsumer(consumer, time.Now().UnixMilli()) == nil {
+			return protocol.NewIntReply(0)
+		}
+		db.notify(notifyStream, "xgroup-createconsumer", key)
+		return protocol.NewIntReply(1)
+	}
+	pending := g.DeleteConsumer(consumer)
+	if pending >= 0 {
+		db.notify(notifyStream, "xgroup-delconsumer", key)
+	}
+	return protocol.NewIntReply(int64(max(pending, 0)))
+}
+
+func newXGroupNoKeyErrReply() *protocol.ErrReply {
+	return protocol.NewErrReply("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+}
+
+func newNoGroupErrReply(key, group string) *protocol.ErrReply {
+	return protocol.NewErrReply("NOGROUP No such consumer group '" + group + "' for key name '" + key + "'")
+}
+
+// parseGroupID parses the last delivered ID of a group, "$" means the last ID of the stream
+func parseGroupID(arg []byte, s *stream.Stream) (stream.ID, *protocol.ErrReply) {
+	if string(arg) == "$" {
+		if s == nil {
+			return stream.MinID, nil
+		}
+		return s.LastID(), nil
+	}
+	return parseStreamID(arg)
+}
+
+// execXGroupCreate XGROUP CREATE key group <id | $> [MKSTREAM] [ENTRIESREAD entries-read]
+func execXGroupCreate(db *DB, args [][]byte) protocol.Reply {
+	key, groupName := string(args[0]), string(args[1])
+	mkStream := false
+	entriesRead := int64(-1)
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "MKSTREAM":
+			mkStream = true
+		case "ENTRIESREAD":
+			if i+1 >= len(args) {
+				return protocol.NewSyntaxErrReply()
+			}
+			n, errReply := parseEntriesRead(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			entriesRead = n
+			i++
+		default:
+			return protocol.NewSyntaxErrReply()
+		}
+	}
+
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	id, errReply := parseGroupID(args[2], s)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		if !mkStream {
+			return newXGroupNoKeyErrReply()
+		}
+		s = newStream()
+		db.putEntity(key, s)
+	}
+	if s.CreateGroup(groupName, id, entriesRead) == nil {
+		return protocol.NewErrReply("BUSYGROUP Consumer Group name already exists")
+	}
+	db.notify(notifyStream, "xgroup-create", key)
+	return protocol.NewOkReply()
+}
+
+// execXGroupSetID XGROUP SETID key group <id | $> [ENTRIESREAD entries-read]
+func execXGroupSetID(db *DB, args [][]byte) protocol.Reply {
+	key, groupName := string(args[0]), string(args[1])
+	entriesRead := int64(-1)
+	if len(args) == 5 {
+		if strings.ToUpper(string(args[3])) != "ENTRIESREAD" {
+			return protocol.NewSyntaxErrReply()
+		}
+		n, errReply := parseEntriesRead(args[4])
+		if errReply != nil {
+			return errReply
+		}
+		entriesRead = n
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return newXGroupNoKeyErrReply()
+	}
+	g := s.Group(groupName)
+	if g == nil {
+		return newNoGroupErrReply(key, groupName)
+	}
+	id, errReply := parseGroupID(args[2], s)
+	if errReply != nil {
+		return errReply
+	}
+	g.LastID = id
+	g.EntriesRead = entriesRead
+	db.notify(notifyStream, "xgroup-setid", key)
+	return protocol.NewOkReply()
+}
+
+// execXAck XACK key group id [id ...]
+func execXAck(db *DB, args [][]byte) protocol.Reply {
+	ids, errReply := parseStreamIDs(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil || s.Group(string(args[1])) == nil {
+		return protocol.NewIntReply(0)
+	}
+	g := s.Group(string(args[1]))
+	acked := 0
+	for _, id := range ids {
+		if g.Ack(id) {
+			acked++
+		}
+	}
+	return protocol.NewIntReply(int64(acked))
+}
+
+// execXPending XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func execXPending(db *DB, args [][]byte) protocol.Reply {
+	key, groupName := string(args[0]), string(args[1])
+	rest := args[2:]
+	minIdle := int64(-1)
+	if len(rest) > 0 && strings.ToUpper(string(rest[0])) == "IDLE" {
+		if len(rest) < 2 {
+			return protocol.NewSyntaxErrReply()
+		}
+		n, ok := parseInt(rest[1])
+		if !ok {
+			return protocol.NewNotIntegerErrReply()
+		}
+		minIdle = n
+		rest = rest[2:]
+		if len(rest) == 0 {
+			return protocol.NewSyntaxErrReply()
+		}
+	}
+	if len(rest) != 0 && len(rest) != 3 && len(rest) != 4 {
+		return protocol.NewSyntaxErrReply()
+	}
+	var start, end stream.ID
+	count := 0
+	if len(rest) > 0 {
+		var errReply *protocol.ErrReply
+		start, errReply = parseRangeID(rest[0], true)
+		if errReply != nil {
+			return errReply
+		}
+		end, errReply = parseRangeID(rest[1], false)
+		if errReply != nil {
+			return errReply
+		}
+		n, ok := parseInt(rest[2])
+		if !ok {
+			return protocol.NewNotIntegerErrReply()
+		}
+		count = int(max(n, 0))
+	}
+
+	_, g, errReply := db.getStreamGroup(key, groupName)
+	if errReply != nil {
+		return errReply
+	}
+	if len(rest) == 0 {
+		return pendingSummary(g)
+	}
+
+	var consumer *stream.Consumer
+	if len(rest) == 4 {
+		consumer = g.Consumer(string(rest[3]))
+		if consumer == nil {
+			return protocol.NewEmptyMultiBulkReply()
+		}
+	}
+	now := time.Now().UnixMilli()
+	var replies []protocol.Reply
+	if count > 0 {
+		for _, pe := range g.PendingRange(start, end, 0, consumer) {
+			idle := now - pe.DeliveryTime
+			if idle < minIdle {
+				continue
+			}
+			replies = append(replies, protocol.NewArrayReply([]protocol.Reply{
+				idReply(pe.ID),
+				protocol.NewBulkReply([]byte(pe.Consumer.Name)),
+				protocol.NewIntReply(idle),
+				protocol.NewIntReply(pe.DeliveryCount),
+			}))
+			if len(replies) >= count {
+				break
+			}
+		}
+	}
+	return protocol.NewArrayReply(replies)
+}
+
+// pendingSummary replies the number of pending entries, the smallest and greatest pending IDs
+// and the number of pending entries of every consumer owning some
+func pendingSummary(g *stream.Group) protocol.Reply {
+	if g.PendingCount() == 0 {
+		return protocol.NewArrayReply([]protocol.Reply{
+			protocol.NewIntReply(0),
+			protocol.NewNullBulkReply(),
+			protocol.NewNullBulkReply(),
+			protocol.NewNullArrayReply(),
+		})
+	}
+	pending := g.PendingRange(stream.MinID, stream.MaxID, 0, nil)
+	var consumers []protocol.Reply
+	for _, c := range g.Consumers() {
+		if c.PendingCount() == 0 {
+			continue
+		}
+		consumers = append(consumers, protocol.NewMultiBulkReply([][]byte{
+			[]byte(c.Name),
+			[]byte(strconv.Itoa(c.PendingCount())),
+		}))
+	}
+	return protocol.NewArrayReply([]protocol.Reply{
+		protocol.NewIntReply(int64(len(pending))),
+		idReply(pending[0].ID),
+		idReply(pending[len(pending)-1].ID),
+		protocol.NewArrayReply(consumers),
+	})
+}
+
+// claimPending transfers a pending entry to consumer as a new delivery
+func claimPending(g *stream.Group, pe *stream.PendingEntry, consumer *stream.Consumer, deliveryTime int64, retryCount int64, justID bool) {
+	g.Transfer(pe, consumer)
+	pe.DeliveryTime = deliveryTime
+	if retryCount >= 0 {
+		pe.DeliveryCount = retryCount
+	} else if !justID {
+		// JUSTID不算作一次投递
+		pe.DeliveryCount++
+	}
+}
+
+// aofClaimed writes every entry a claim transferred to consumer as an XCLAIM forcing the delivery time
+// and count it ended up with, since whether an entry is idle enough to be claimed depends on when the claim runs.
+// Deleted entries the claim dropped from the PEL are acknowledged.
+func aofClaimed(db *DB, key, groupName, consumer []byte, claimed, deleted [][]byte) []CmdLine {
+	_, g, errReply := db.getStreamGroup(string(key), string(groupName))
+	if errReply != nil {
+		return nil
+	}
+	var cmdLines []CmdLine
+	for _, raw := range claimed {
+		id, err := stream.ParseID(string(raw), 0)
+		if err != nil {
+			continue
+		}
+		pe := g.Pending(id)
+		if pe == nil {
+			continue
+		}
+		cmdLines = append(cmdLines, makeCmdLine("xclaim", key, groupName, consumer, []byte("0"), raw,
+			[]byte("TIME"), []byte(strconv.FormatInt(pe.DeliveryTime, 10)),
+			[]byte("RETRYCOUNT"), []byte(strconv.FormatInt(pe.DeliveryCount, 10)),
+			[]byte("FORCE"), []byte("JUSTID"), []byte("LASTID"), []byte(g.LastID.String())))
+	}
+	if len(cmdLines) == 0 {
+		// 没有认领到条目时消费者仍然会被创建
+		cmdLines = append(cmdLines, makeCmdLine("xgroup", []byte("CREATECONSUMER"), key, groupName, consumer))
+	}
+	if len(deleted) > 0 {
+		cmdLines = append(cmdLines, makeCmdLine("xack", append([][]byte{key, groupName}, deleted...)...))
+	}
+	return cmdLines
+}
+
+// claimedIDs returns the IDs of the entries in a claim reply, which holds IDs with JUSTID and entries otherwise
+func claimedIDs(replies []protocol.Reply) [][]byte {
+	ids := make([][]byte, 0, len(replies))
+	for _, r := range replies {
+		switch v := r.(type) {
+		case *protocol.BulkReply:
+			ids = append(ids, v.Value)
+		case *protocol.ArrayReply:
+			ids = append(ids, v.Replies[0].(*protocol.BulkReply).Value)
+		}
+	}
+	return ids
+}
+
+func aofXClaim(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+	s, _ := db.getAsStream(string(args[0]))
+	// 参数中不在stream里的条目如果在PEL中，已经被XCLAIM移除
+	var deleted [][]byte
+	for _, arg := range args[4:] {
+		id, err := stream.ParseID(string(arg), 0)
+		if err != nil {
+			break
+		}
+		if _, ok := s.Get(id); !ok {
+			deleted = append(deleted, arg)
+		}
+	}
+	claimed := claimedIDs(reply.(*protocol.ArrayReply).Replies)
+	return aofClaimed(db, args[0], args[1], args[2], claimed, deleted)
+}
+
+func aofXAutoClaim(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+	result := reply.(*protocol.ArrayReply).Replies
+	claimed := claimedIDs(result[1].(*protocol.ArrayReply).Replies)
+	deleted := result[2].(*protocol.MultiBulkReply).Values
+	return aofClaimed(db, args[0], args[1], args[2], claimed, deleted)
+}
+
+// execXClaim XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func execXClaim(db *DB, args [][]byte) protocol.Reply {
+	key, groupName, consumerName := string(args[0]), string(args[1]), string(args[2])
+	minIdle, ok := parseInt(args[3])
+	if !ok {
+		return protocol.NewErrReply("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	minIdle = max(minIdle, 0)
+
+	// ID列表之后是可选参数
+	i := 4
+	var ids []stream.ID
+	for ; i < len(args); i++ {
+		id, err := stream.ParseID(string(args[i]), 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return newInvalidStreamIDErrReply()
+	}
+
+	now := time.Now().UnixMilli()
+	deliveryTime := now
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID *stream.ID
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		switch opt {
+		case "FORCE":
+			force = true
+			continue
+		case "JUSTID":
+			justID = true
+			continue
+		case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+		default:
+			return protocol.NewErrReply("ERR Unrecognized XCLAIM option '" + string(args[i]) + "'")
+		}
+		if i+1 >= len(args) {
+			return protocol.NewSyntaxErrReply()
+		}
+		i++
+		if opt == "LASTID" {
+			id, errReply := parseStreamID(args[i])
+			if errReply != nil {
+				return errReply
+			}
+			lastID = &id
+			continue
+		}
+		n, ok := parseInt(args[i])
+		if !ok {
+			return protocol.NewErrReply("ERR Invalid " + opt + " option argument for XCLAIM")
+		}
+		switch opt {
+		case "IDLE":
+			deliveryTime = now - n
+		case "TIME":
+			deliveryTime = n
+		case "RETRYCOUNT":
+			retryCount = n
+		}
+	}
+
+	s, g, errReply := db.getStreamGroup(key, groupName)
+	if errReply != nil {
+		return errReply
+	}
+	if lastID != nil && g.LastID.Less(*lastID) {
+		g.LastID = *lastID
+	}
+	consumer := db.getOrCreateConsumer(key, g, consumerName, now)
+	consumer.SeenTime = now
+
+	var replies []protocol.Reply
+	for _, id := range ids {
+		e, exists := s.Get(id)
+		pe := g.Pending(id)
+		created := false
+		if pe == nil && force && exists {
+			pe = g.Deliver(id, consumer, now)
+			created = true
+		}
+		if pe == nil {
+			continue
+		}
+		// 已被删除的条目直接从PEL中移除
+		if !exists {
+			g.Ack(id)
+			continue
+		}
+		if !created && minIdle > 0 && now-pe.DeliveryTime < minIdle {
+			continue
+		}
+		claimPending(g, pe, consumer, deliveryTime, retryCount, justID)
+		consumer.ActiveTime = now
+		if justID {
+			replies = append(replies, idReply(id))
+		} else {
+			replies = append(replies, entryReply(e))
+		}
+	}
+	return protocol.NewArrayReply(replies)
+}
+
+// execXAutoClaim XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func execXAutoClaim(db *DB, args [][]byte) protocol.Reply {
+	key, groupName, consumerName := string(args[0]), string(args[1]), string(args[2])
+	minIdle, ok := parseInt(args[3])
+	if !ok {
+		return protocol.NewErrReply("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	minIdle = max(minIdle, 0)
+	start, errReply := parseRangeID(args[4], true)
+	if errReply != nil {
+		return errReply
+	}
+	count, justID := 100, false
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return protocol.NewSyntaxErrReply()
+			}
+			// 与redis相同，每认领一个条目最多检查10个待确认条目
+			n, ok := parseInt(args[i+1])
+			if !ok || n < 1 || n > math.MaxInt32/10 {
+				return protocol.NewErrReply("ERR COUNT must be > 0")
+			}
+			count = int(n)
+			i++
+		case "JUSTID":
+			justID = true
+		default:
+			return protocol.NewSyntaxErrReply()
+		}
+	}
+
+	s, g, errReply := db.getStreamGroup(key, groupName)
+	if errReply != nil {
+		return errReply
+	}
+	now := time.Now().UnixMilli()
+	consumer := db.getOrCreateConsumer(key, g, consumerName, now)
+	consumer.SeenTime = now
+
+	attempts := count * 10
+	pending := g.PendingRange(start, stream.MaxID, attempts+1, nil)
+	var claimed []protocol.Reply
+	var deleted [][]byte
+	next := stream.MinID
+	for i, pe := range pending {
+		if i == attempts || len(claimed) == count {
+			next = pe.ID
+			break
+		}
+		e, exists := s.Get(pe.ID)
+		if !exists {
+			g.Ack(pe.ID)
+			deleted = append(deleted, []byte(pe.ID.String()))
+			continue
+		}
+		if now-pe.DeliveryTime < minIdle {
+			continue
+		}
+		claimPending(g, pe, consumer, now, -1, justID)
+		consumer.ActiveTime = now
+		if justID {
+			claimed = append(claimed, idReply(pe.ID))
+		} else {
+			claimed = append(claimed, entryReply(e))
+		}
+	}
+	if deleted == nil {
+		deleted = [][]byte{}
+	}
+	return protocol.NewArrayReply([]protocol.Reply{
+		idReply(next),
+		protocol.NewArrayReply(claimed),
+		protocol.NewMultiBulkReply(deleted),
+	})
+}
+
+// execXInfo XINFO <STREAM key [FULL [COUNT count]] | GROUPS key | CONSUMERS key group>
+func execXInfo(db *DB, args [][]byte) protocol.Reply {
+	sub := strings.ToUpper(string(args[0]))
+	args = args[1:]
+	switch sub {
+	case "STREAM":
+		if len(args) < 1 {
+			return protocol.NewArgNumErrReply("xinfo|stream")
+		}
+	case "GROUPS":
+		if len(args) != 1 {
+			return protocol.NewArgNumErrReply("xinfo|groups")
+		}
+	case "CONSUMERS":
+		if len(args) != 2 {
+			return protocol.NewArgNumErrReply("xinfo|consumers")
+		}
+	default:
+		return protocol.NewErrReply("ERR unknown subcommand '" + strings.ToLower(sub) + "'. Try XINFO HELP.")
+	}
+
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.NewErrReply("ERR no such key")
+	}
+	now := time.Now().UnixMilli()
+	switch sub {
+	case "STREAM":
+		return xinfoStream(s, args[1:], now)
+	case "GROUPS":
+		groups := s.Groups()
+		replies := make([]protocol.Reply, len(groups))
+		for i, g := range groups {
+			replies[i] = protocol.NewArrayReply([]protocol.Reply{
+				protocol.NewBulkReply([]byte("name")), protocol.NewBulkReply([]byte(g.Name)),
+				protocol.NewBulkReply([]byte("consumers")), protocol.NewIntReply(int64(len(g.Consumers()))),
+				protocol.NewBulkReply([]byte("pending")), protocol.NewIntReply(int64(g.PendingCount())),
+				protocol.NewBulkReply([]byte("last-delivered-id")), idReply(g.LastID),
+				protocol.NewBulkReply([]byte("entries-read")), entriesReadReply(g),
+				protocol.NewBulkReply([]byte("lag")), groupLag(s, g),
+			})
+		}
+		return protocol.NewArrayReply(replies)
+	}
+
+	g := s.Group(string(args[1]))
+	if g == nil {
+		return newNoGroupErrReply(string(args[0]), string(args[1]))
+	}
+	consumers := g.Consumers()
+	replies := make([]protocol.Reply, len(consumers))
+	for i, c := range consumers {
+		inactive := int64(-1)
+		if c.ActiveTime >= 0 {
+			inactive = now - c.ActiveTime
+		}
+		replies[i] = protocol.NewArrayReply([]protocol.Reply{
+			protocol.NewBulkReply([]byte("name")), protocol.NewBulkReply([]byte(c.Name)),
+			protocol.NewBulkReply([]byte("pending")), protocol.NewIntReply(int64(c.PendingCount())),
+			protocol.NewBulkReply([]byte("idle")), protocol.NewIntReply(now - c.SeenTime),
+			protocol.NewBulkReply([]byte("inactive")), protocol.NewIntReply(inactive),
+		})
+	}
+	return protocol.NewArrayReply(replies)
+}
+
+func entriesReadReply(g *stream.Group) protocol.Reply {
+	if g.EntriesRead < 0 {
+		return protocol.NewNullBulkReply()
+	}
+	return protocol.NewIntReply(g.EntriesRead)
+}
+
+// groupLag returns the number of entries not yet delivered to the group, nil if it can't be known
+func groupLag(s *stream.Stream, g *stream.Group) protocol.Reply {
+	added := int64(s.EntriesAdded())
+	if added == 0 {
+		return protocol.NewIntReply(0)
+	}
+	if g.EntriesRead >= 0 && !s.HasTombstones(g.LastID) {
+		return protocol.NewIntReply(added - g.EntriesRead)
+	}
+	if read := s.EstimateEntriesRead(g.LastID); read >= 0 {
+		return protocol.NewIntReply(added - read)
+	}
+	return protocol.NewNullBulkReply()
+}
+
+// xinfoStream XINFO STREAM key [FULL [COUNT count]]
+func xinfoStream(s *stream.Stream, args [][]byte, now int64) protocol.Reply {
+	full := false
+	count := 10
+	if len(args) > 0 {
+		if strings.ToUpper(string(args[0])) != "FULL" {
+			return protocol.NewSyntaxErrReply()
+		}
+		full = true
+		if len(args) > 1 {
+			if len(args) != 3 || strings.ToUpper(string(args[1])) != "COUNT" {
+				return protocol.NewSyntaxErrReply()
+			}
+			n, ok := parseInt(args[2])
+			if !ok {
+				return protocol.NewNotIntegerErrReply()
+			}
+			count = int(max(n, 0))
+		}
+	}
+
+	replies := []protocol.Reply{
+		protocol.NewBulkReply([]byte("length")), protocol.NewIntReply(int64(s.Len())),
+		protocol.NewBulkReply([]byte("radix-tree-keys")), protocol.NewIntReply(int64(s.BlockCount())),
+		protocol.NewBulkReply([]byte("radix-tree-nodes")), protocol.NewIntReply(int64(s.BlockCount() + 1)),
+		protocol.NewBulkReply([]byte("last-generated-id")), idReply(s.LastID()),
+		protocol.NewBulkReply([]byte("max-deleted-entry-id")), idReply(s.MaxDeletedID()),
+		protocol.NewBulkReply([]byte("entries-added")), protocol.NewIntReply(int64(s.EntriesAdded())),
+		protocol.NewBulkReply([]byte("recorded-first-entry-id")), idReply(s.FirstID()),
+	}
+	if !full {
+		replies = append(replies, protocol.NewBulkReply([]byte("groups")), protocol.NewIntReply(int64(len(s.Groups()))))
+		for _, field := range []string{"first-entry", "last-entry"} {
+			var e stream.Entry
+			var ok bool
+			if field == "first-entry" {
+				e, ok = s.FirstEntry()
+			} else {
+				e, ok = s.LastEntry()
+			}
+			var reply protocol.Reply = protocol.NewNullBulkReply()
+			if ok {
+				reply = entryReply(e)
+			}
+			replies = append(replies, protocol.NewBulkReply([]byte(field)), reply)
+		}
+		return protocol.NewArrayReply(replies)
+	}
+
+	// FULL模式下COUNT同时限制条目数和每个PEL返回的数量，0表示全部
+	replies = append(replies, protocol.NewBulkReply([]byte("entries")), entriesReply(s.Range(stream.MinID, stream.MaxID, count, false)))
+	groups := s.Groups()
+	groupReplies := make([]protocol.Reply, len(groups))
+	for i, g := range groups {
+		var pelReplies []protocol.Reply
+		for _, pe := range g.PendingRange(stream.MinID, stream.MaxID, count, nil) {
+			pelReplies = append(pelReplies, protocol.NewArrayReply([]protocol.Reply{
+				idReply(pe.ID),
+				protocol.NewBulkReply([]byte(pe.Consumer.Name)),
+				protocol.NewIntReply(pe.DeliveryTime),
+				protocol.NewIntReply(pe.DeliveryCount),
+			}))
+		}
+		consumers := g.Consumers()
+		consumerReplies := make([]protocol.Reply, len(consumers))
+		for j, c := range consumers {
+			var consumerPEL []protocol.Reply
+			for _, pe := range g.PendingRange(stream.MinID, stream.MaxID, count, c) {
+				consumerPEL = append(consumerPEL, protocol.NewArrayReply([]protocol.Reply{
+					idReply(pe.ID),
+					protocol.NewIntReply(pe.DeliveryTime),
+					protocol.NewIntReply(pe.DeliveryCount),
+				}))
+			}
+			consumerReplies[j] = protocol.NewArrayReply([]protocol.Reply{
+				protocol.NewBulkReply([]byte("name")), protocol.NewBulkReply([]byte(c.Name)),
+				protocol.NewBulkReply([]byte("seen-time")), protocol.NewIntReply(c.SeenTime),
+				protocol.NewBulkReply([]byte("active-time")), protocol.NewIntReply(c.ActiveTime),
+				protocol.NewBulkReply([]byte("pel-count")), protocol.NewIntReply(int64(c.PendingCount())),
+				protocol.NewBulkReply([]byte("pending")), protocol.NewArrayReply(consumerPEL),
+			})
+		}
+		groupReplies[i] = protocol.NewArrayReply([]protocol.Reply{
+			protocol.NewBulkReply([]byte("name")), protocol.NewBulkReply([]byte(g.Name)),
+			protocol.NewBulkReply([]byte("last-delivered-id")), idReply(g.LastID),
+			protocol.NewBulkReply([]byte("entries-read")), entriesReadReply(g),
+			protocol.NewBulkReply([]byte("lag")), groupLag(s, g),
+			protocol.NewBulkReply([]byte("pel-count")), protocol.NewIntReply(int64(g.PendingCount())),
+			protocol.NewBulkReply([]byte("pending")), protocol.NewArrayReply(pelReplies),
+			protocol.NewBulkReply([]byte("consumers")), protocol.NewArrayReply(consumerReplies),
+		})
+	}
+	replies = append(replies, protocol.NewBulkReply([]byte("groups")), protocol.NewArrayReply(groupReplies))
+	return protocol.NewArrayReply(replies)
+}
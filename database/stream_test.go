@@ -0,0 +1,274 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXAdd(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "$3\r\n1-1\r\n", exec(s, c, "xadd st 1-1 f v"))
+	assertReply(t, "+stream\r\n", exec(s, c, "type st"))
+	assertReply(t, "$3\r\n1-2\r\n", exec(s, c, "xadd st 1-* f v"))
+	assertReply(t, "$3\r\n2-0\r\n", exec(s, c, "xadd st 2 f v"))
+	assertReply(t, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n", exec(s, c, "xadd st 2-0 f v"))
+	assertReply(t, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n", exec(s, c, "xadd st 1-* f v"))
+	assertReply(t, "-ERR The ID specified in XADD must be greater than 0-0\r\n", exec(s, c, "xadd other 0-0 f v"))
+	assertReply(t, "-ERR Invalid stream ID specified as stream command argument\r\n", exec(s, c, "xadd st abc f v"))
+	assertReply(t, "-ERR wrong number of arguments for 'xadd' command\r\n", exec(s, c, "xadd st * f v x"))
+	assertReply(t, ":3\r\n", exec(s, c, "xlen st"))
+
+	id := exec(s, c, "xadd st * f v")
+	ms := strings.Split(strings.Split(string(id.ToBytes()), "\r\n")[1], "-")[0]
+	assert.InDelta(t, time.Now().UnixMilli(), parseMs(ms), 5000)
+
+	assertReply(t, "$-1\r\n", exec(s, c, "xadd nokey nomkstream * f v"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists nokey"))
+
+	for i := 0; i < 10; i++ {
+		exec(s, c, "xadd capped maxlen 3 * f v")
+	}
+	assertReply(t, ":3\r\n", exec(s, c, "xlen capped"))
+	exec(s, c, "xadd byid 1 f v")
+	exec(s, c, "xadd byid 2 f v")
+	exec(s, c, "xadd byid minid 2 3 f v")
+	assertReply(t, ":2\r\n", exec(s, c, "xlen byid"))
+	assertReply(t, "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n", exec(s, c, "xadd byid maxlen 1 limit 10 * f v"))
+	assertReply(t, "-ERR The MAXLEN argument must be >= 0.\r\n", exec(s, c, "xadd byid maxlen -1 * f v"))
+
+	exec(s, c, "set str x")
+	assertReply(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "xadd str * f v"))
+}
+
+func parseMs(s string) int64 {
+	n, _ := parseInt([]byte(s))
+	return n
+}
+
+func TestXRange(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	for _, id := range []string{"1-0", "1-1", "2-0", "3-0"} {
+		exec(s, c, "xadd st "+id+" f "+id)
+	}
+	assertReply(t, "*2\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$3\r\n1-0\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$3\r\n1-1\r\n",
+		exec(s, c, "xrange st 1 1"))
+	assertReply(t, "*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nf\r\n$3\r\n2-0\r\n", exec(s, c, "xrange st (1-1 (3-0"))
+	assertReply(t, "*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nf\r\n$3\r\n3-0\r\n", exec(s, c, "xrevrange st + - count 1"))
+	assertReply(t, "*0\r\n", exec(s, c, "xrange st - + count 0"))
+	assertReply(t, "*0\r\n", exec(s, c, "xrange nokey - +"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "xrange st - + limit 1"))
+
+	assertReply(t, ":2\r\n", exec(s, c, "xdel st 1-1 2-0 9-9"))
+	assertReply(t, ":2\r\n", exec(s, c, "xlen st"))
+	assertReply(t, "-ERR Invalid stream ID specified as stream command argument\r\n", exec(s, c, "xdel st x"))
+
+	for i := 0; i < 5; i++ {
+		exec(s, c, "xadd st * f v")
+	}
+	assertReply(t, ":4\r\n", exec(s, c, "xtrim st maxlen 3"))
+	assertReply(t, ":3\r\n", exec(s, c, "xlen st"))
+	assertReply(t, ":0\r\n", exec(s, c, "xtrim nokey maxlen 0"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "xtrim st size 3"))
+	assertReply(t, ":3\r\n", exec(s, c, "xtrim st minid = 99999999999999"))
+	// 删空的stream依然存在
+	assertReply(t, ":1\r\n", exec(s, c, "exists st"))
+}
+
+func TestXRead(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "xadd a 1 f a1")
+	exec(s, c, "xadd a 2 f a2")
+	exec(s, c, "xadd b 1 f b1")
+	assertReply(t, "*2\r\n*2\r\n$1\r\na\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nf\r\n$2\r\na2\r\n*2\r\n$1\r\nb\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$2\r\nb1\r\n",
+		exec(s, c, "xread count 1 streams a b 1 0"))
+	assertReply(t, "*-1\r\n", exec(s, c, "xread streams a b $ $"))
+	assertReply(t, "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n", exec(s, c, "xread streams a b 0"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "xread noack streams a 0"))
+
+	blocked := connection.NewFakeConn()
+	ch := execAsync(t, s, blocked, "xread block 0 streams a nokey $ $")
+	// 类型不对的key不会唤醒客户端
+	exec(s, c, "set nokey x")
+	exec(s, c, "del nokey")
+	exec(s, c, "xadd a 3 f a3")
+	assertReply(t, "*1\r\n*2\r\n$1\r\na\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nf\r\n$2\r\na3\r\n", receive(t, ch))
+
+	ch = execAsync(t, s, blocked, "xread block 0 streams nokey 0")
+	exec(s, c, "xadd nokey 5 f v")
+	assertReply(t, "*1\r\n*2\r\n$5\r\nnokey\r\n*1\r\n*2\r\n$3\r\n5-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n", receive(t, ch))
+
+	start := time.Now()
+	assertReply(t, "*-1\r\n", exec(s, c, "xread block 50 streams a $"))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestXGroup(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n",
+		exec(s, c, "xgroup create st g $"))
+	assertReply(t, "+OK\r\n", exec(s, c, "xgroup create st g $ mkstream"))
+	assertReply(t, "-BUSYGROUP Consumer Group name already exists\r\n", exec(s, c, "xgroup create st g 0"))
+	assertReply(t, ":1\r\n", exec(s, c, "xgroup createconsumer st g alice"))
+	assertReply(t, ":0\r\n", exec(s, c, "xgroup createconsumer st g alice"))
+	assertReply(t, "-NOGROUP No such consumer group 'nope' for key name 'st'\r\n", exec(s, c, "xgroup createconsumer st nope alice"))
+	assertReply(t, "+OK\r\n", exec(s, c, "xgroup setid st g 0 entriesread 0"))
+	assertReply(t, "-ERR unknown subcommand 'foo'. Try XGROUP HELP.\r\n", exec(s, c, "xgroup foo st g"))
+	assertReply(t, "-ERR unknown subcommand or wrong number of arguments for 'destroy'. Try XGROUP HELP.\r\n", exec(s, c, "xgroup destroy st"))
+
+	exec(s, c, "xadd st 1 f v1")
+	exec(s, c, "xadd st 2 f v2")
+	assertReply(t, "*1\r\n*2\r\n$2\r\nst\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$2\r\nv1\r\n",
+		exec(s, c, "xreadgroup group g alice count 1 streams st >"))
+	assertReply(t, "*1\r\n*2\r\n$2\r\nst\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nf\r\n$2\r\nv2\r\n",
+		exec(s, c, "xreadgroup group g bob streams st >"))
+	assertReply(t, "*-1\r\n", exec(s, c, "xreadgroup group g bob streams st >"))
+	// 读取历史返回自己的待确认条目
+	assertReply(t, "*1\r\n*2\r\n$2\r\nst\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nf\r\n$2\r\nv2\r\n",
+		exec(s, c, "xreadgroup group g bob streams st 0"))
+	assertReply(t, "*1\r\n*2\r\n$2\r\nst\r\n*0\r\n", exec(s, c, "xreadgroup group g carol streams st 0"))
+	assertReply(t, "-NOGROUP No such key 'st' or consumer group 'nope' in XREADGROUP with GROUP option\r\n",
+		exec(s, c, "xreadgroup group nope alice streams st >"))
+	assertReply(t, "-ERR Missing GROUP option for XREADGROUP\r\n", exec(s, c, "xreadgroup count 1 noack streams st >"))
+
+	assertReply(t, "*4\r\n:2\r\n$3\r\n1-0\r\n$3\r\n2-0\r\n*2\r\n*2\r\n$5\r\nalice\r\n$1\r\n1\r\n*2\r\n$3\r\nbob\r\n$1\r\n1\r\n",
+		exec(s, c, "xpending st g"))
+	assert.Regexp(t, `^\*1\r\n\*4\r\n\$3\r\n2-0\r\n\$3\r\nbob\r\n:\d+\r\n:1\r\n$`, string(exec(s, c, "xpending st g - + 10 bob").ToBytes()))
+	assertReply(t, "*0\r\n", exec(s, c, "xpending st g idle 100000 - + 10"))
+	assertReply(t, ":1\r\n", exec(s, c, "xack st g 1-0 9-0"))
+	assertReply(t, ":0\r\n", exec(s, c, "xack st g 1-0"))
+	assertReply(t, ":1\r\n", exec(s, c, "xgroup delconsumer st g bob"))
+	assertReply(t, "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n", exec(s, c, "xpending st g"))
+	assertReply(t, "-NOGROUP No such key 'st' or consumer group 'nope'\r\n", exec(s, c, "xpending st nope"))
+
+	// NOACK不进入PEL
+	exec(s, c, "xadd st 3 f v3")
+	exec(s, c, "xreadgroup group g alice noack streams st >")
+	assertReply(t, "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n", exec(s, c, "xpending st g"))
+	assertReply(t, ":1\r\n", exec(s, c, "xgroup destroy st g"))
+	assertReply(t, ":0\r\n", exec(s, c, "xgroup destroy st g"))
+}
+
+func TestXClaim(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "xgroup create st g 0 mkstream")
+	for _, id := range []string{"1", "2", "3"} {
+		exec(s, c, "xadd st "+id+" f v"+id)
+	}
+	exec(s, c, "xreadgroup group g alice streams st >")
+
+	// 刚投递的条目未达到最小空闲时间
+	assertReply(t, "*0\r\n", exec(s, c, "xclaim st g bob 100000 1"))
+	assertReply(t, "*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$2\r\nv1\r\n", exec(s, c, "xclaim st g bob 0 1"))
+	assert.Regexp(t, `^\*1\r\n\*4\r\n\$3\r\n1-0\r\n\$3\r\nbob\r\n:\d+\r\n:2\r\n$`, string(exec(s, c, "xpending st g - + 10 bob").ToBytes()))
+	assertReply(t, "*1\r\n$3\r\n2-0\r\n", exec(s, c, "xclaim st g bob 0 2 justid retrycount 5"))
+	assert.Regexp(t, `^\*1\r\n\*4\r\n\$3\r\n2-0\r\n\$3\r\nbob\r\n:\d+\r\n:5\r\n$`, string(exec(s, c, "xpending st g 2 2 1").ToBytes()))
+	assertReply(t, "-ERR Unrecognized XCLAIM option 'foo'\r\n", exec(s, c, "xclaim st g bob 0 2 foo"))
+
+	// 被删除的条目从PEL中移除并单独返回
+	exec(s, c, "xdel st 3")
+	assertReply(t, "*3\r\n$3\r\n0-0\r\n*2\r\n$3\r\n1-0\r\n$3\r\n2-0\r\n*1\r\n$3\r\n3-0\r\n",
+		exec(s, c, "xautoclaim st g carol 0 - justid"))
+	assertReply(t, "*3\r\n$3\r\n2-0\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$2\r\nv1\r\n*0\r\n",
+		exec(s, c, "xautoclaim st g dave 0 - count 1"))
+	assertReply(t, "-ERR COUNT must be > 0\r\n", exec(s, c, "xautoclaim st g dave 0 - count 0"))
+	assertReply(t, "*4\r\n:2\r\n$3\r\n1-0\r\n$3\r\n2-0\r\n*2\r\n*2\r\n$5\r\ncarol\r\n$1\r\n1\r\n*2\r\n$4\r\ndave\r\n$1\r\n1\r\n",
+		exec(s, c, "xpending st g"))
+
+	exec(s, c, "xadd st 4 f v4")
+	assertReply(t, "*1\r\n$3\r\n4-0\r\n", exec(s, c, "xclaim st g erin 0 4 force justid"))
+	assertReply(t, "*0\r\n", exec(s, c, "xclaim st g erin 0 9 force"))
+}
+
+func TestXReadGroupBlocking(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "xgroup create st g $ mkstream")
+	blocked := connection.NewFakeConn()
+	ch := execAsync(t, s, blocked, "xreadgroup group g alice block 0 streams st >")
+	exec(s, c, "xadd st 1 f v")
+	assertReply(t, "*1\r\n*2\r\n$2\r\nst\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n", receive(t, ch))
+	assertReply(t, "*4\r\n:1\r\n$3\r\n1-0\r\n$3\r\n1-0\r\n*1\r\n*2\r\n$5\r\nalice\r\n$1\r\n1\r\n", exec(s, c, "xpending st g"))
+
+	// 同一条目只投递给一个阻塞的消费者
+	ch1 := execAsync(t, s, connection.NewFakeConn(), "xreadgroup group g bob block 0 streams st >")
+	ch2 := execAsync(t, s, connection.NewFakeConn(), "xreadgroup group g carol block 0 streams st >")
+	exec(s, c, "xadd st 2 f v")
+	assertReply(t, "*1\r\n*2\r\n$2\r\nst\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n", receive(t, ch1))
+	select {
+	case <-ch2:
+		t.Fatal("second consumer should keep blocking")
+	case <-time.After(20 * time.Millisecond):
+	}
+	exec(s, c, "xadd st 3 f v")
+	assertReply(t, "*1\r\n*2\r\n$2\r\nst\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n", receive(t, ch2))
+}
+
+func TestXInfo(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "-ERR no such key\r\n", exec(s, c, "xinfo stream st"))
+	exec(s, c, "xadd st 1 f v")
+	exec(s, c, "xadd st 2 f v")
+	exec(s, c, "xgroup create st g 0")
+	exec(s, c, "xreadgroup group g alice count 1 streams st >")
+
+	assertReply(t, "*1\r\n*12\r\n$4\r\nname\r\n$1\r\ng\r\n$9\r\nconsumers\r\n:1\r\n$7\r\npending\r\n:1\r\n"+
+		"$17\r\nlast-delivered-id\r\n$3\r\n1-0\r\n$12\r\nentries-read\r\n:1\r\n$3\r\nlag\r\n:1\r\n",
+		exec(s, c, "xinfo groups st"))
+	reply := string(exec(s, c, "xinfo consumers st g").ToBytes())
+	assert.True(t, strings.HasPrefix(reply, "*1\r\n*8\r\n$4\r\nname\r\n$5\r\nalice\r\n$7\r\npending\r\n:1\r\n"), reply)
+	reply = string(exec(s, c, "xinfo stream st").ToBytes())
+	assert.True(t, strings.HasPrefix(reply, "*20\r\n$6\r\nlength\r\n:2\r\n"), reply)
+	assert.Contains(t, reply, "$10\r\nlast-entry\r\n*2\r\n$3\r\n2-0\r\n")
+	reply = string(exec(s, c, "xinfo stream st full").ToBytes())
+	assert.Contains(t, reply, "$7\r\nentries\r\n*2\r\n")
+	assert.Contains(t, reply, "$9\r\npel-count\r\n:1\r\n")
+	assertReply(t, "-NOGROUP No such consumer group 'nope' for key name 'st'\r\n", exec(s, c, "xinfo consumers st nope"))
+
+	exec(s, c, "xadd st 3 f v")
+	exec(s, c, "xdel st 2")
+	// 未读部分有删除时仍可由entries-added推算
+	assertReply(t, "*1\r\n*12\r\n$4\r\nname\r\n$1\r\ng\r\n$9\r\nconsumers\r\n:1\r\n$7\r\npending\r\n:1\r\n"+
+		"$17\r\nlast-delivered-id\r\n$3\r\n1-0\r\n$12\r\nentries-read\r\n:1\r\n$3\r\nlag\r\n$-1\r\n",
+		exec(s, c, "xinfo groups st"))
+
+	exec(s, c, "copy st st2")
+	assertReply(t, ":2\r\n", exec(s, c, "xlen st2"))
+}
+
+func TestXSetID(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "-ERR no such key\r\n", exec(s, c, "xsetid st 1-0"))
+	exec(s, c, "xadd st 5-0 f v")
+	assertReply(t, "-ERR The ID specified in XSETID is smaller than the target stream top item\r\n", exec(s, c, "xsetid st 4-0"))
+	assertReply(t, "-ERR The entries_added specified in XSETID is smaller than the target stream length\r\n",
+		exec(s, c, "xsetid st 5-0 entriesadded 0"))
+	assertReply(t, "-ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id\r\n",
+		exec(s, c, "xsetid st 5-0 maxdeletedid 6-0"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "xsetid st 5-0 entriesadded"))
+
+	assertReply(t, "+OK\r\n", exec(s, c, "xsetid st 10-0 entriesadded 7 maxdeletedid 3-0"))
+	assertReply(t, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n", exec(s, c, "xadd st 9-0 f v"))
+	reply := string(exec(s, c, "xinfo stream st").ToBytes())
+	assert.Contains(t, reply, "$17\r\nlast-generated-id\r\n$4\r\n10-0\r\n")
+	assert.Contains(t, reply, "$20\r\nmax-deleted-entry-id\r\n$3\r\n3-0\r\n")
+	assert.Contains(t, reply, "$13\r\nentries-added\r\n:7\r\n")
+}
@@ -0,0 +1,664 @@
+package database
+
+import (
+	"godis/resp/protocol"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStringSize 与redis的proto-max-bulk-len默认值一致
+const maxStringSize = 512 * 1024 * 1024
+
+func init() {
+	registerCommand("get", execGet, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("set", execSet, -3, flagWrite|flagDenyOOM).keys(1, 1, 1).withAof(aofSetString)
+	registerCommand("setnx", execSetNX, 3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("setex", execSetEX, 4, flagWrite|flagDenyOOM).keys(1, 1, 1).withAof(aofSetString)
+	registerCommand("psetex", execPSetEX, 4, flagWrite|flagDenyOOM).keys(1, 1, 1).withAof(aofSetString)
+	registerCommand("getset", execGetSet, 3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("getdel", execGetDel, 2, flagWrite|flagFast).keys(1, 1, 1)
+	registerCommand("getex", execGetEX, -2, flagWrite|flagFast).keys(1, 1, 1).withAof(aofGetEX)
+	registerCommand("mget", execMGet, -2, flagReadOnly|flagFast).keys(1, -1, 1)
+	registerCommand("mset", execMSet, -3, flagWrite|flagDenyOOM).keys(1, -1, 2)
+	registerCommand("msetnx", execMSetNX, -3, flagWrite|flagDenyOOM).keys(1, -1, 2)
+	registerCommand("append", execAppend, 3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("strlen", execStrLen, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("getrange", execGetRange, 4, flagReadOnly).keys(1, 1, 1)
+	registerCommand("setrange", execSetRange, 4, flagWrite|flagDenyOOM).keys(1, 1, 1)
+	registerCommand("incr", execIncr, 2, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("decr", execDecr, 2, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("incrby", execIncrBy, 3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("decrby", execDecrBy, 3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("incrbyfloat", execIncrByFloat, 3, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1).withAof(aofSetString)
+	registerCommand("lcs", execLCS, -3, flagReadOnly).keys(1, 2, 1)
+}
+
+// getAsString returns the string value of key, nil if key doesn't exist
+func (db *DB) getAsString(key string) ([]byte, *protocol.ErrReply) {
+	entity, ok := db.getEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	val, ok := entity.([]byte)
+	if !ok {
+		return nil, protocol.NewWrongTypeErrReply()
+	}
+	return val, nil
+}
+
+// setString replaces the value of key and discards its expire time like SET does.
+// String values are never modified in place, so replies may keep referencing them.
+func (db *DB) setString(key string, val []byte) {
+	db.putEntity(key, toStoredBytes(val))
+	db.persist(key)
+}
+
+// aofSetString writes the string key holds now as a SET with an absolute expire time, used by
+// the commands whose expire time is relative to now and by INCRBYFLOAT to avoid float formatting drift
+func aofSetString(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+	key := string(args[0])
+	val, errReply := db.getAsString(key)
+	if errReply != nil {
+		return nil
+	}
+	if val == nil {
+		return []CmdLine{makeCmdLine("del", args[0])}
+	}
+	cmdLine := makeCmdLine("set", args[0], val)
+	if expireAt, ok := db.expireTime(key); ok {
+		cmdLine = append(cmdLine, []byte("PXAT"), []byte(strconv.FormatInt(expireAt.UnixMilli(), 10)))
+	}
+	return []CmdLine{cmdLine}
+}
+
+const (
+	upsertPolicy = iota // default
+	insertPolicy        // NX
+	updatePolicy        // XX
+)
+
+// parseExpireTime parses the argument of EX, PX, EXAT or PXAT into an absolute time
+func parseExpireTime(cmdName string, opt string, arg []byte) (time.Time, *protocol.ErrReply) {
+	invalid := protocol.NewErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	n, ok := parseInt(arg)
+	if !ok {
+		return time.Time{}, protocol.NewNotIntegerErrReply()
+	}
+	if n <= 0 {
+		return time.Time{}, invalid
+	}
+	switch opt {
+	case "EX", "EXAT":
+		if n > math.MaxInt64/1000 {
+			return time.Time{}, invalid
+		}
+		n *= 1000
+	}
+	switch opt {
+	case "EX", "PX":
+		now := time.Now().UnixMilli()
+		if n > math.MaxInt64-now {
+			return time.Time{}, invalid
+		}
+		return time.UnixMilli(now + n), nil
+	}
+	return time.UnixMilli(n), nil
+}
+
+// execGet GET key
+func execGet(db *DB, args [][]byte) protocol.Reply {
+	val, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if val == nil {
+		return protocol.NewNullBulkReply()
+	}
+	return protocol.NewBulkReply(val)
+}
+
+// execSet SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+func execSet(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	value := args[1]
+	policy := upsertPolicy
+	returnOld := false
+	keepTTL := false
+	var expireAt time.Time
+
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		switch opt {
+		case "NX":
+			if policy == updatePolicy {
+				return protocol.NewSyntaxErrReply()
+			}
+			policy = insertPolicy
+		case "XX":
+			if policy == insertPolicy {
+				return protocol.NewSyntaxErrReply()
+			}
+			policy = updatePolicy
+		case "GET":
+			returnOld = true
+		case "KEEPTTL":
+			if !expireAt.IsZero() {
+				return protocol.NewSyntaxErrReply()
+			}
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if keepTTL || !expireAt.IsZero() || i+1 >= len(args) {
+				return protocol.NewSyntaxErrReply()
+			}
+			var errReply *protocol.ErrReply
+			expireAt, errReply = parseExpireTime("set", opt, args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			i++
+		default:
+			return protocol.NewSyntaxErrReply()
+		}
+	}
+
+	old, errReply := db.getAsString(key)
+	if returnOld && errReply != nil {
+		return errReply
+	}
+	_, exists := db.getEntity(key)
+	if (policy == insertPolicy && exists) || (policy == updatePolicy && !exists) {
+		if returnOld {
+			return protocol.NewBulkReply(old)
+		}
+		return protocol.NewNullBulkReply()
+	}
+
+	db.putEntity(key, toStoredBytes(value))
+	if !keepTTL {
+		db.persist(key)
+	}
+	db.notify(notifyString, "set", key)
+	if !expireAt.IsZero() {
+		db.expire(key, expireAt)
+		db.notify(notifyGeneric, "expire", key)
+	}
+
+	if returnOld {
+		return protocol.NewBulkReply(old)
+	}
+	return protocol.NewOkReply()
+}
+
+// execSetNX SETNX key value
+func execSetNX(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	if _, exists := db.getEntity(key); exists {
+		return protocol.NewIntReply(0)
+	}
+	db.setString(key, args[1])
+	db.notify(notifyString, "set", key)
+	return protocol.NewIntReply(1)
+}
+
+func setWithExpire(db *DB, cmdName string, opt string, args [][]byte) protocol.Reply {
+	expireAt, errReply := parseExpireTime(cmdName, opt, args[1])
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	db.setString(key, args[2])
+	db.expire(key, expireAt)
+	db.notify(notifyString, "set", key)
+	db.notify(notifyGeneric, "expire", key)
+	return protocol.NewOkReply()
+}
+
+// execSetEX SETEX key seconds value
+func execSetEX(db *DB, args [][]byte) protocol.Reply {
+	return setWithExpire(db, "setex", "EX", args)
+}
+
+// execPSetEX PSETEX key milliseconds value
+func execPSetEX(db *DB, args [][]byte) protocol.Reply {
+	return setWithExpire(db, "psetex", "PX", args)
+}
+
+// execGetSet GETSET key value
+func execGetSet(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	db.setString(key, args[1])
+	db.notify(notifyString, "set", key)
+	return protocol.NewBulkReply(old)
+}
+
+// execGetDel GETDEL key
+func execGetDel(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if old != nil {
+		db.removeKey(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	return protocol.NewBulkReply(old)
+}
+
+// execGetEX GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
+func execGetEX(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	var expireAt time.Time
+	persist := false
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		switch opt {
+		case "PERSIST":
+			if !expireAt.IsZero() {
+				return protocol.NewSyntaxErrReply()
+			}
+			persist = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if persist || !expireAt.IsZero() || i+1 >= len(args) {
+				return protocol.NewSyntaxErrReply()
+			}
+			var errReply *protocol.ErrReply
+			expireAt, errReply = parseExpireTime("getex", opt, args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			i++
+		default:
+			return protocol.NewSyntaxErrReply()
+		}
+	}
+
+	val, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if val == nil {
+		return protocol.NewNullBulkReply()
+	}
+	if persist {
+		if db.persist(key) {
+			db.notify(notifyGeneric, "persist", key)
+		}
+	} else if !expireAt.IsZero() {
+		db.expire(key, expireAt)
+		db.notify(notifyGeneric, "expire", key)
+	}
+	return protocol.NewBulkReply(val)
+}
+
+// aofGetEX writes the expire time GETEX left as an absolute time, GETEX without options changes nothing
+func aofGetEX(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+	if len(args) == 1 || isNullReply(reply) {
+		return nil
+	}
+	return aofExpireState(db, string(args[0]))
+}
+
+// execMGet MGET key [key ...]
+func execMGet(db *DB, args [][]byte) protocol.Reply {
+	result := make([][]byte, len(args))
+	for i, arg := range args {
+		// 非字符串类型的key返回nil
+		if val, errReply := db.getAsString(string(arg)); errReply == nil {
+			result[i] = val
+		}
+	}
+	return protocol.NewMultiBulkReply(result)
+}
+
+// execMSet MSET key value [key value ...]
+func execMSet(db *DB, args [][]byte) protocol.Reply {
+	if len(args)%2 != 0 {
+		return protocol.NewArgNumErrReply("mset")
+	}
+	for i := 0; i < len(args); i += 2 {
+		key := string(args[i])
+		db.setString(key, args[i+1])
+		db.notify(notifyString, "set", key)
+	}
+	return protocol.NewOkReply()
+}
+
+// execMSetNX MSETNX key value [key value ...]
+func execMSetNX(db *DB, args [][]byte) protocol.Reply {
+	if len(args)%2 != 0 {
+		return protocol.NewArgNumErrReply("msetnx")
+	}
+	for i := 0; i < len(args); i += 2 {
+		if _, exists := db.getEntity(string(args[i])); exists {
+			return protocol.NewIntReply(0)
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		key := string(args[i])
+		db.setString(key, args[i+1])
+		db.notify(notifyString, "set", key)
+	}
+	return protocol.NewIntReply(1)
+}
+
+func checkStringSize(size int64) *protocol.ErrReply {
+	if size > maxStringSize {
+		return protocol.NewErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	return nil
+}
+
+// execAppend APPEND key value
+func execAppend(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if errReply := checkStringSize(int64(len(old) + len(args[1]))); errReply != nil {
+		return errReply
+	}
+	val := make([]byte, 0, len(old)+len(args[1]))
+	val = append(val, old...)
+	val = append(val, args[1]...)
+	db.putEntity(key, val)
+	db.notify(notifyString, "append", key)
+	return protocol.NewIntReply(int64(len(val)))
+}
+
+// execStrLen STRLEN key
+func execStrLen(db *DB, args [][]byte) protocol.Reply {
+	val, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.NewIntReply(int64(len(val)))
+}
+
+// execGetRange GETRANGE key start end
+func execGetRange(db *DB, args [][]byte) protocol.Reply {
+	start, ok1 := parseInt(args[1])
+	end, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		return protocol.NewNotIntegerErrReply()
+	}
+	val, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+
+	size := int64(len(val))
+	if start < 0 && end < 0 && start > end {
+		return protocol.NewEmptyBulkReply()
+	}
+	if start < 0 {
+		start = size + start
+	}
+	if end < 0 {
+		end = size + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end || size == 0 {
+		return protocol.NewEmptyBulkReply()
+	}
+	return protocol.NewBulkReply(val[start : end+1])
+}
+
+// execSetRange SETRANGE key offset value
+func execSetRange(db *DB, args [][]byte) protocol.Reply {
+	offset, ok := parseInt(args[1])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	if offset < 0 {
+		return protocol.NewErrReply("ERR offset is out of range")
+	}
+	key := string(args[0])
+	value := args[2]
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(value) == 0 {
+		return protocol.NewIntReply(int64(len(old)))
+	}
+	if errReply := checkStringSize(offset + int64(len(value))); errReply != nil {
+		return errReply
+	}
+
+	size := max(int64(len(old)), offset+int64(len(value)))
+	val := make([]byte, size)
+	copy(val, old)
+	copy(val[offset:], value)
+	db.putEntity(key, val)
+	db.notify(notifyString, "setrange", key)
+	return protocol.NewIntReply(size)
+}
+
+func incrBy(db *DB, key string, delta int64) protocol.Reply {
+	val, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	if val != nil {
+		var ok bool
+		n, ok = parseInt(val)
+		if !ok {
+			return protocol.NewNotIntegerErrReply()
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return protocol.NewErrReply("ERR increment or decrement would overflow")
+	}
+	n += delta
+	db.putEntity(key, []byte(strconv.FormatInt(n, 10)))
+	// 与redis相同，INCR、DECR和DECRBY都发布incrby事件
+	db.notify(notifyString, "incrby", key)
+	return protocol.NewIntReply(n)
+}
+
+// execIncr INCR key
+func execIncr(db *DB, args [][]byte) protocol.Reply {
+	return incrBy(db, string(args[0]), 1)
+}
+
+// execDecr DECR key
+func execDecr(db *DB, args [][]byte) protocol.Reply {
+	return incrBy(db, string(args[0]), -1)
+}
+
+// execIncrBy INCRBY key increment
+func execIncrBy(db *DB, args [][]byte) protocol.Reply {
+	delta, ok := parseInt(args[1])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	return incrBy(db, string(args[0]), delta)
+}
+
+// execDecrBy DECRBY key decrement
+func execDecrBy(db *DB, args [][]byte) protocol.Reply {
+	delta, ok := parseInt(args[1])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	if delta == math.MinInt64 {
+		return protocol.NewErrReply("ERR decrement would overflow")
+	}
+	return incrBy(db, string(args[0]), -delta)
+}
+
+// execIncrByFloat INCRBYFLOAT key increment
+func execIncrByFloat(db *DB, args [][]byte) protocol.Reply {
+	delta, ok := parseFloat(args[1])
+	if !ok {
+		return protocol.NewErrReply("ERR value is not a valid float")
+	}
+	key := string(args[0])
+	val, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	var f float64
+	if val != nil {
+		f, ok = parseFloat(val)
+		if !ok {
+			return protocol.NewErrReply("ERR value is not a valid float")
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return protocol.NewErrReply("ERR increment would produce NaN or Infinity")
+	}
+	result := []byte(formatFloat(f))
+	db.putEntity(key, result)
+	db.notify(notifyString, "incrbyfloat", key)
+	return protocol.NewBulkReply(result)
+}
+
+// execLCS LCS key1 key2 [LEN] [IDX] [MINMATCHLEN min-match-len] [WITHMATCHLEN]
+func execLCS(db *DB, args [][]byte) protocol.Reply {
+	getLen, getIdx, withMatchLen := false, false, false
+	var minMatchLen int64
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "LEN":
+			getLen = true
+		case "IDX":
+			getIdx = true
+		case "WITHMATCHLEN":
+			withMatchLen = true
+		case "MINMATCHLEN":
+			if i+1 >= len(args) {
+				return protocol.NewSyntaxErrReply()
+			}
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return protocol.NewNotIntegerErrReply()
+			}
+			minMatchLen = max(n, 0)
+			i++
+		default:
+			return protocol.NewSyntaxErrReply()
+		}
+	}
+	if getLen && getIdx {
+		return protocol.NewErrReply("ERR If you want both the length and indexes, please just use IDX.")
+	}
+
+	a, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return protocol.NewErrReply("ERR The specified keys must contain string values")
+	}
+	b, errReply := db.getAsString(string(args[1]))
+	if errReply != nil {
+		return protocol.NewErrReply("ERR The specified keys must contain string values")
+	}
+	if int64(len(a)+1)*int64(len(b)+1) > maxStringSize/4 {
+		return protocol.NewErrReply("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+	}
+
+	// lcs[i][j] 为a[:i]与b[:j]的最长公共子序列长度
+	width := len(b) + 1
+	lcs := make([]uint32, (len(a)+1)*width)
+	at := func(i, j int) uint32 {
+		return lcs[i*width+j]
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				lcs[i*width+j] = at(i-1, j-1) + 1
+			} else {
+				lcs[i*width+j] = max(at(i-1, j), at(i, j-1))
+			}
+		}
+	}
+	total := at(len(a), len(b))
+	if getLen {
+		return protocol.NewIntReply(int64(total))
+	}
+
+	// 从末尾回溯，与redis一致按从后往前的顺序输出匹配区间
+	result := make([]byte, total)
+	matches := make([]protocol.Reply, 0)
+	idx := total
+	aStart, aEnd, bStart, bEnd := len(a), 0, 0, 0
+	i, j := len(a), len(b)
+	for i > 0 && j > 0 {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+			if aStart == len(a) {
+				aStart, aEnd = i-1, i-1
+				bStart, bEnd = j-1, j-1
+			} else if aStart == i && bStart == j {
+				aStart--
+				bStart--
+			} else {
+				emit = true
+			}
+			if aStart == 0 || bStart == 0 {
+				emit = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if at(i-1, j) > at(i, j-1) {
+				i--
+			} else {
+				j--
+			}
+			if aStart != len(a) {
+				emit = true
+			}
+		}
+
+		if emit && getIdx {
+			matchLen := aEnd - aStart + 1
+			if minMatchLen == 0 || int64(matchLen) >= minMatchLen {
+				match := []protocol.Reply{
+					protocol.NewArrayReply([]protocol.Reply{
+						protocol.NewIntReply(int64(aStart)), protocol.NewIntReply(int64(aEnd)),
+					}),
+					protocol.NewArrayReply([]protocol.Reply{
+						protocol.NewIntReply(int64(bStart)), protocol.NewIntReply(int64(bEnd)),
+					}),
+				}
+				if withMatchLen {
+					match = append(match, protocol.NewIntReply(int64(matchLen)))
+				}
+				matches = append(matches, protocol.NewArrayReply(match))
+			}
+		}
+		if emit {
+			aStart = len(a)
+		}
+	}
+
+	if getIdx {
+		return protocol.NewArrayReply([]protocol.Reply{
+			protocol.NewBulkReply([]byte("matches")),
+			protocol.NewArrayReply(matches),
+			protocol.NewBulkReply([]byte("len")),
+			protocol.NewIntReply(int64(total)),
+		})
+	}
+	return protocol.NewBulkReply(result)
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"testing"
+	"time"
+)
+
+func TestSetOptions(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "+OK\r\n", exec(s, c, "set k v"))
+	assertReply(t, "$1\r\nv\r\n", exec(s, c, "get k"))
+	assertReply(t, "$-1\r\n", exec(s, c, "set k v2 nx"))
+	assertReply(t, "$1\r\nv\r\n", exec(s, c, "set k v2 xx get"))
+	assertReply(t, "$-1\r\n", exec(s, c, "set missing v xx"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "set k v nx xx"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "set k v ex 10 keepttl"))
+	assertReply(t, "-ERR invalid expire time in 'set' command\r\n", exec(s, c, "set k v ex 0"))
+
+	assertReply(t, "+OK\r\n", exec(s, c, "set k v px 20"))
+	assertReply(t, "+OK\r\n", exec(s, c, "set k v3 keepttl"))
+	assertReply(t, "$2\r\nv3\r\n", exec(s, c, "get k"))
+	time.Sleep(30 * time.Millisecond)
+	assertReply(t, "$-1\r\n", exec(s, c, "get k"))
+	assertReply(t, ":1\r\n", exec(s, c, "setnx k v"))
+	assertReply(t, ":0\r\n", exec(s, c, "setnx k v"))
+}
+
+func TestMultiKeyStrings(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "+OK\r\n", exec(s, c, "mset a 1 b 2"))
+	assertReply(t, "-ERR wrong number of arguments for 'mset' command\r\n", exec(s, c, "mset a 1 b"))
+	assertReply(t, "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n2\r\n", exec(s, c, "mget a x b"))
+	assertReply(t, ":0\r\n", exec(s, c, "msetnx x 1 a 3"))
+	assertReply(t, "$-1\r\n", exec(s, c, "get x"))
+	assertReply(t, ":1\r\n", exec(s, c, "msetnx x 1 y 2"))
+	assertReply(t, "$1\r\n1\r\n", exec(s, c, "getdel x"))
+	assertReply(t, "$-1\r\n", exec(s, c, "get x"))
+	assertReply(t, "$1\r\n2\r\n", exec(s, c, "getset y 3"))
+}
+
+func TestStringRanges(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, ":5\r\n", exec(s, c, "append k hello"))
+	assertReply(t, ":11\r\n", exec(s, c, "append k _world"))
+	assertReply(t, "$5\r\nhello\r\n", exec(s, c, "getrange k 0 4"))
+	assertReply(t, "$5\r\nworld\r\n", exec(s, c, "getrange k -5 -1"))
+	assertReply(t, "$0\r\n\r\n", exec(s, c, "getrange k 5 2"))
+	assertReply(t, ":11\r\n", exec(s, c, "setrange k 6 WORLD"))
+	assertReply(t, "$11\r\nhello_WORLD\r\n", exec(s, c, "get k"))
+	assertReply(t, ":3\r\n", exec(s, c, "setrange pad 2 x"))
+	assertReply(t, "$3\r\n\x00\x00x\r\n", exec(s, c, "get pad"))
+	assertReply(t, ":11\r\n", exec(s, c, "strlen k"))
+	assertReply(t, ":0\r\n", exec(s, c, "strlen missing"))
+}
+
+func TestIncr(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, ":1\r\n", exec(s, c, "incr n"))
+	assertReply(t, ":11\r\n", exec(s, c, "incrby n 10"))
+	assertReply(t, ":6\r\n", exec(s, c, "decrby n 5"))
+	assertReply(t, ":5\r\n", exec(s, c, "decr n"))
+	assertReply(t, "$3\r\n5.5\r\n", exec(s, c, "incrbyfloat n 0.5"))
+	assertReply(t, "-ERR value is not an integer or out of range\r\n", exec(s, c, "incr n"))
+	assertReply(t, "+OK\r\n", exec(s, c, "set max 9223372036854775807"))
+	assertReply(t, "-ERR increment or decrement would overflow\r\n", exec(s, c, "incr max"))
+	assertReply(t, "-ERR value is not an integer or out of range\r\n", exec(s, c, "incrby n +1"))
+}
+
+func TestLCS(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "mset key1 ohmytext key2 mynewtext")
+	assertReply(t, "$6\r\nmytext\r\n", exec(s, c, "lcs key1 key2"))
+	assertReply(t, ":6\r\n", exec(s, c, "lcs key1 key2 len"))
+	assertReply(t, "*4\r\n$7\r\nmatches\r\n*2\r\n*2\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n*2\r\n*2\r\n:2\r\n:3\r\n*2\r\n:0\r\n:1\r\n$3\r\nlen\r\n:6\r\n",
+		exec(s, c, "lcs key1 key2 idx"))
+	assertReply(t, "*4\r\n$7\r\nmatches\r\n*1\r\n*3\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n:4\r\n$3\r\nlen\r\n:6\r\n",
+		exec(s, c, "lcs key1 key2 idx minmatchlen 4 withmatchlen"))
+}
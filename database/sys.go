@@ -0,0 +1,35 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"godis/resp/protocol"
+)
+
+func init() {
+	registerSysCommand("ping", execPing, -1, flagFast)
+	registerSysCommand("echo", execEcho, 2, flagFast)
+}
+
+// execPing PING [message]
+func execPing(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	if len(args) > 1 {
+		return protocol.NewArgNumErrReply("ping")
+	}
+	// 订阅模式下以数组形式回复
+	if inSubscriberMode(client) {
+		message := []byte{}
+		if len(args) == 1 {
+			message = args[0]
+		}
+		return protocol.NewMultiBulkReply([][]byte{[]byte("pong"), message})
+	}
+	if len(args) == 0 {
+		return protocol.NewPongReply()
+	}
+	return protocol.NewBulkReply(args[0])
+}
+
+// execEcho ECHO message
+func execEcho(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	return protocol.NewBulkReply(args[0])
+}
@@ -0,0 +1,416 @@
+package database
+
+import (
+	"fmt"
+	"godis/config"
+	"godis/pkg/logx"
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerSysCommand("multi", execMulti, -1, flagNoScript|flagFast|flagNoMulti)
+	registerSysCommand("exec", execExec, 1, flagNoScript)
+	registerSysCommand("discard", execDiscard, 1, flagNoScript|flagFast)
+	registerSysCommand("watch", execWatch, -2, flagNoScript|flagFast|flagNoMulti).keys(1, -1, 1)
+	registerSysCommand("unwatch", execUnwatch, 1, flagNoScript|flagFast)
+}
+
+// 处于MULTI状态时这些命令直接执行，其他命令进入队列
+var txControlCommands = map[string]struct{}{
+	"multi":   {},
+	"exec":    {},
+	"discard": {},
+	"watch":   {},
+}
+
+func isTxControlCommand(name string) bool {
+	_, ok := txControlCommands[name]
+	return ok
+}
+
+// 这些命令替换整个keyspace，undo log无法回滚
+var keyspaceReplacingCommands = map[string]struct{}{
+	"flushdb":  {},
+	"flushall": {},
+	"swapdb":   {},
+}
+
+// enqueueCmd validates a command line sent during MULTI and queues it. Errors found here
+// abort the whole transaction on EXEC.
+func enqueueCmd(client connection.Connection, cmdLine CmdLine) protocol.Reply {
+	name := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := lookupCommand(name)
+	if !ok {
+		errReply := protocol.NewUnknownCommandErrReply(name)
+		client.AddTxError(errReply.Error())
+		return errReply
+	}
+	if !cmd.validateArity(cmdLine) {
+		errReply := protocol.NewArgNumErrReply(cmd.name)
+		client.AddTxError(errReply.Error())
+		return errReply
+	}
+	if cmd.hasFlag(flagNoMulti) {
+		errReply := protocol.NewErrReply("ERR Command not allowed inside a transaction")
+		client.AddTxError(errReply.Error())
+		return errReply
+	}
+	if _, ok := keyspaceReplacingCommands[cmd.name]; ok && (client.IsTxRollback() || config.Properties.MultiRollback) {
+		errReply := protocol.NewErrReply("ERR command '" + cmd.name + "' cannot be used in MULTI ROLLBACK")
+		client.AddTxError(errReply.Error())
+		return errReply
+	}
+	client.EnqueueCmd(cmdLine)
+	return protocol.NewQueuedReply()
+}
+
+// execMulti MULTI [ROLLBACK]
+// ROLLBACK makes EXEC undo the whole transaction if any command fails, see undoLog
+func execMulti(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	if client.InMultiState() {
+		return protocol.NewErrReply("ERR MULTI calls can not be nested")
+	}
+	rollback := false
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && strings.EqualFold(string(args[0]), "ROLLBACK"):
+		rollback = true
+	default:
+		return protocol.NewSyntaxErrReply()
+	}
+	client.SetMultiState(true)
+	client.SetTxRollback(rollback)
+	return protocol.NewOkReply()
+}
+
+// execDiscard DISCARD
+func execDiscard(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	if !client.InMultiState() {
+		return protocol.NewErrReply("ERR DISCARD without MULTI")
+	}
+	client.SetMultiState(false)
+	clear(client.GetWatching())
+	return protocol.NewOkReply()
+}
+
+// execWatch WATCH key [key ...]
+func execWatch(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	if client.InMultiState() {
+		return protocol.NewErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	db, errReply := s.selectDB(client.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+
+	unlock := lockDBs(dbLock{db: db, writeKeys: keys})
+	defer unlock()
+	watching := client.GetWatching()
+	for _, key := range keys {
+		// 先删除已经过期的key，之后过期视为被修改
+		db.expireIfNeeded(key)
+		watchKey := connection.WatchKey{DBIndex: db.index, Key: key}
+		if _, ok := watching[watchKey]; ok {
+			continue
+		}
+		watching[watchKey] = db.getVersion(key)
+	}
+	return protocol.NewOkReply()
+}
+
+// execUnwatch UNWATCH
+func execUnwatch(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	clear(client.GetWatching())
+	return protocol.NewOkReply()
+}
+
+// isWatchedKeyModified reports whether a watched key has been modified or has expired since WATCH,
+// the caller must hold the shard lock of key
+func (db *DB) isWatchedKeyModified(key string, version uint64) bool {
+	if db.getVersion(key) != version {
+		return true
+	}
+	_, exists := db.data.GetWithoutLock(key)
+	return exists && db.isExpired(key)
+}
+
+// execExec EXEC
+func execExec(s *Server, client connection.Connection, args [][]byte) protocol.Reply {
+	if !client.InMultiState() {
+		return protocol.NewErrReply("ERR EXEC without MULTI")
+	}
+	defer client.SetMultiState(false)
+	watching := client.GetWatching()
+	defer clear(watching)
+	if len(client.GetTxErrors()) > 0 {
+		return protocol.NewErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	db, errReply := s.selectDB(client.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	rollback := client.IsTxRollback() || config.Properties.MultiRollback
+	return s.execTransaction(newTransaction(s, client, db), client.GetQueuedCmdLine(), watching, rollback)
+}
+
+// transaction is the state of a transaction executed by EXEC
+type transaction struct {
+	s      *Server
+	client connection.Connection
+	// 当前命令所在的db，随SELECT改变
+	db *DB
+	// 按db记录要锁定的key，事务持有其中每个db的stopWorld
+	locks map[int]*dbLock
+	// 回滚模式下记录被修改的key
+	undo *undoLog
+	// 事务修改的key的keyspace事件，提交后才发布
+	events    *eventBuffer
+	eventKeys map[*DB][]string
+	// 事务写入AOF的命令，aofDB是其中最后选择的db
+	aofLines []CmdLine
+	aofDB    int
+	// 事务中BGSAVE或BGREWRITEAOF占用的后台任务，提交后才开始
+	runBgJob func(take snapshotFunc) error
+}
+
+func newTransaction(s *Server, client connection.Connection, db *DB) *transaction {
+	return &transaction{
+		s:      s,
+		client: client,
+		db:     db,
+		locks:  make(map[int]*dbLock),
+		aofDB:  db.index,
+	}
+}
+
+func (tx *transaction) lockOf(db *DB) *dbLock {
+	l, ok := tx.locks[db.index]
+	if !ok {
+		l = &dbLock{db: db}
+		tx.locks[db.index] = l
+	}
+	return l
+}
+
+func (tx *transaction) lockKeys(db *DB, writeKeys, readKeys []string) {
+	l := tx.lockOf(db)
+	l.writeKeys = append(l.writeKeys, writeKeys...)
+	l.readKeys = append(l.readKeys, readKeys...)
+}
+
+// lockWhole locks the whole db for commands replacing its keyspace, the keys of the db are
+// then locked by each command when it runs
+func (tx *transaction) lockWhole(db *DB) {
+	tx.lockOf(db).whole = true
+}
+
+// lock adds the dbs and keys the command touches to the locks, in the order the commands run
+func (tx *transaction) lock(cmd *command, args [][]byte) {
+	if cmd.executor != nil {
+		writeKeys, readKeys := cmd.prepareKeys(args)
+		tx.lockKeys(tx.db, writeKeys, readKeys)
+		return
+	}
+	if cmd.txLock != nil {
+		cmd.txLock(tx, args)
+	}
+}
+
+// lockBgJobInTx locks all dbs for BGSAVE and BGREWRITEAOF, their snapshots are taken when the
+// transaction commits, before other commands can run
+func lockBgJobInTx(tx *transaction, _ [][]byte) {
+	for _, db := range tx.s.dbSet {
+		tx.lockWhole(db)
+	}
+}
+
+// startBgJob reserves job for a background job queued in the transaction. run takes its
+// snapshots once the transaction has committed, so they hold all of its writes and the AOF lines
+// of the transaction go before a new incr file. A rolled back transaction releases the job.
+func (tx *transaction) startBgJob(job int32, run func(take snapshotFunc) error) error {
+	if err := tx.s.startBgJob(job); err != nil {
+		return err
+	}
+	tx.runBgJob = run
+	return nil
+}
+
+// withKeys runs fn writing and reading keys of db, like execCommand does outside a transaction
+func (tx *transaction) withKeys(db *DB, writeKeys, readKeys []string, fn func()) {
+	if tx.lockOf(db).whole {
+		// 其他命令已经被阻止，锁定key是为了与读取快照的后台任务互斥
+		db.data.RWLocks(writeKeys, readKeys)
+		defer db.data.RWUnlocks(writeKeys, readKeys)
+		db.saveForSnapshot(writeKeys)
+	}
+	if tx.events != nil {
+		for _, key := range writeKeys {
+			db.txEvents.Put(key, tx.events)
+		}
+		tx.eventKeys[db] = append(tx.eventKeys[db], writeKeys...)
+	}
+	for _, key := range writeKeys {
+		db.expireIfNeeded(key)
+	}
+	if tx.undo != nil {
+		tx.undo.record(db, writeKeys)
+	}
+	fn()
+	// 回滚模式下事务成功后才修改版本号
+	if tx.undo == nil {
+		db.addVersion(writeKeys...)
+	}
+}
+
+// releaseEvents stops buffering the events of the keys written by the transaction, publishing the
+// buffered events if it has committed. The locks of the transaction must still be held, so the
+// events of a key are published in the order it was modified.
+func (tx *transaction) releaseEvents(commit bool) {
+	if tx.events == nil {
+		return
+	}
+	for db, keys := range tx.eventKeys {
+		for _, key := range keys {
+			db.txEvents.Remove(key)
+		}
+	}
+	if commit {
+		tx.events.flush()
+	}
+}
+
+// appendAof adds the lines written by a command on db to the transaction, db is nil for
+// commands not depending on the selected db
+func (tx *transaction) appendAof(db *DB, lines ...CmdLine) {
+	if len(lines) == 0 {
+		return
+	}
+	if db != nil && db.index != tx.aofDB {
+		tx.aofLines = append(tx.aofLines, makeCmdLine("select", []byte(strconv.Itoa(db.index))))
+		tx.aofDB = db.index
+	}
+	tx.aofLines = append(tx.aofLines, lines...)
+}
+
+// exec executes a queued command with the locks of the transaction held, a panic only fails this command
+func (tx *transaction) exec(cmd *command, args [][]byte) (result protocol.Reply) {
+	defer func() {
+		if err := recover(); err != nil {
+			logx.L().Error(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
+			result = protocol.NewErrReply("ERR unknown")
+		}
+	}()
+	if cmd.executor == nil {
+		if cmd.txExec != nil {
+			return cmd.txExec(tx, args)
+		}
+		// 不访问keyspace的系统命令直接执行
+		return cmd.sysExecutor(tx.s, tx.client, args)
+	}
+	db := tx.db
+	writeKeys, readKeys := cmd.prepareKeys(args)
+	tx.withKeys(db, writeKeys, readKeys, func() {
+		// 阻塞命令在事务中不会阻塞，blockReply序列化为超时的回复
+		result = cmd.executor(db, args)
+		tx.appendAof(db, cmd.aofLines(db, args, result)...)
+	})
+	return result
+}
+
+// execTransaction runs the queued commands of a transaction. The dbs and keys of all commands are
+// computed from the command metadata, following SELECT, and locked at once together with the
+// watched keys, so other clients never observe the transaction half done. Commands replacing a
+// keyspace lock the whole db. In rollback mode the first command replying an error stops the
+// transaction and the keys written so far are restored from the undo log.
+func (s *Server) execTransaction(tx *transaction, cmdLines []CmdLine, watching map[connection.WatchKey]uint64, rollback bool) protocol.Reply {
+	start := tx.db
+	tx.lockOf(start)
+	cmds := make([]*command, len(cmdLines))
+	for i, cmdLine := range cmdLines {
+		cmds[i], _ = lookupCommand(string(cmdLine[0]))
+		tx.lock(cmds[i], cmdLine[1:])
+	}
+	tx.db = start
+	for watchKey := range watching {
+		watchedDB, errReply := s.selectDB(watchKey.DBIndex)
+		if errReply != nil {
+			continue
+		}
+		tx.lockKeys(watchedDB, nil, []string{watchKey.Key})
+	}
+
+	lockList := make([]dbLock, 0, len(tx.locks))
+	for _, l := range tx.locks {
+		lockList = append(lockList, *l)
+	}
+	unlock := lockDBs(lockList...)
+	defer func() {
+		for _, l := range lockList {
+			l.db.handleReadyKeys()
+		}
+	}()
+	defer unlock()
+
+	for watchKey, version := range watching {
+		watchedDB, errReply := s.selectDB(watchKey.DBIndex)
+		if errReply != nil {
+			continue
+		}
+		if watchedDB.isWatchedKeyModified(watchKey.Key, version) {
+			return protocol.NewNullArrayReply()
+		}
+	}
+
+	if rollback {
+		tx.undo = newUndoLog()
+	}
+	if notifyFlags() != 0 {
+		tx.events = newEventBuffer(s.pubsub)
+		tx.eventKeys = make(map[*DB][]string)
+	}
+	replies := make([]protocol.Reply, len(cmdLines))
+	for i, cmdLine := range cmdLines {
+		replies[i] = tx.exec(cmds[i], cmdLine[1:])
+		if tx.undo == nil {
+			continue
+		}
+		if errReply, ok := replies[i].(*protocol.ErrReply); ok {
+			// 回滚后keyspace与执行前相同，不需要修改版本号
+			tx.undo.rollback()
+			tx.releaseEvents(false)
+			if tx.runBgJob != nil {
+				s.finishBgJob()
+			}
+			tx.client.SelectDB(start.index)
+			return protocol.NewErrReply(fmt.Sprintf("EXECABORT Transaction rolled back because command #%d '%s' failed: %s",
+				i+1, cmds[i].name, errReply.Err))
+		}
+	}
+	if tx.undo != nil {
+		tx.undo.addVersions()
+	}
+	tx.releaseEvents(true)
+	if len(tx.aofLines) > 0 {
+		// 重放时整个事务仍然一起执行，最后选择回开始时的db
+		aofLines := append([]CmdLine{makeCmdLine("multi")}, tx.aofLines...)
+		if tx.aofDB != start.index {
+			aofLines = append(aofLines, makeCmdLine("select", []byte(strconv.Itoa(start.index))))
+		}
+		start.appendAof(append(aofLines, makeCmdLine("exec"))...)
+	}
+	if tx.runBgJob != nil {
+		// 仍然持有所有db的锁
+		if err := tx.runBgJob(s.takeSnapshotsLocked); err != nil {
+			logx.L().Errorf("start background job of transaction failed: %v", err)
+		}
+	}
+	return protocol.NewArrayReply(replies)
+}
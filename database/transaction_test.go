@@ -0,0 +1,347 @@
+package database
+
+import (
+	"fmt"
+	"godis/config"
+	"godis/resp/connection"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMulti(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "+OK\r\n", exec(s, c, "multi"))
+	assertReply(t, "-ERR MULTI calls can not be nested\r\n", exec(s, c, "multi"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "set a 1"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "incr a"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "lpush a x"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "get a"))
+	assertReply(t, "*4\r\n+OK\r\n:2\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n$1\r\n2\r\n", exec(s, c, "exec"))
+	assertReply(t, "$1\r\n2\r\n", exec(s, c, "get a"))
+
+	assertReply(t, "+OK\r\n", exec(s, c, "multi"))
+	assertReply(t, "*0\r\n", exec(s, c, "exec"))
+	assertReply(t, "-ERR EXEC without MULTI\r\n", exec(s, c, "exec"))
+	assertReply(t, "-ERR DISCARD without MULTI\r\n", exec(s, c, "discard"))
+
+	assertReply(t, "+OK\r\n", exec(s, c, "multi"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "set a 3"))
+	assertReply(t, "+OK\r\n", exec(s, c, "discard"))
+	assertReply(t, "$1\r\n2\r\n", exec(s, c, "get a"))
+
+	// 阻塞命令在事务中直接返回超时的结果
+	assertReply(t, "+OK\r\n", exec(s, c, "multi"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "blpop nolist 0"))
+	assertReply(t, "*1\r\n*-1\r\n", exec(s, c, "exec"))
+}
+
+func TestExecAbort(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "multi")
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "set a 1"))
+	assertReply(t, "-ERR unknown command 'nosuchcmd'\r\n", exec(s, c, "nosuchcmd"))
+	assertReply(t, "-EXECABORT Transaction discarded because of previous errors.\r\n", exec(s, c, "exec"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists a"))
+
+	exec(s, c, "multi")
+	assertReply(t, "-ERR wrong number of arguments for 'get' command\r\n", exec(s, c, "get"))
+	assertReply(t, "-EXECABORT Transaction discarded because of previous errors.\r\n", exec(s, c, "exec"))
+
+	exec(s, c, "multi")
+	assertReply(t, "-ERR Command not allowed inside a transaction\r\n", exec(s, c, "save"))
+	assertReply(t, "-EXECABORT Transaction discarded because of previous errors.\r\n", exec(s, c, "exec"))
+
+	// 出错后状态被重置
+	exec(s, c, "multi")
+	exec(s, c, "set a 1")
+	assertReply(t, "*1\r\n+OK\r\n", exec(s, c, "exec"))
+}
+
+func TestMultiSysCommands(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "set a 1")
+	exec(s, c, "multi")
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "ping"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "echo hi"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "publish ch msg"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "copy a b db 1"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "move a 2"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "select 9999"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "select 1"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "incr b"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "dbsize"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "unwatch"))
+	assertReply(t, "*10\r\n+PONG\r\n$2\r\nhi\r\n:0\r\n:1\r\n:1\r\n-ERR DB index is out of range\r\n+OK\r\n:2\r\n:1\r\n+OK\r\n",
+		exec(s, c, "exec"))
+	// SELECT在事务之后仍然有效
+	assertReply(t, "$1\r\n2\r\n", exec(s, c, "get b"))
+	exec(s, c, "select 2")
+	assertReply(t, "$1\r\n1\r\n", exec(s, c, "get a"))
+
+	// 替换keyspace的命令锁定整个db
+	exec(s, c, "multi")
+	exec(s, c, "set c 1")
+	exec(s, c, "swapdb 1 2")
+	exec(s, c, "get b")
+	exec(s, c, "flushdb")
+	exec(s, c, "dbsize")
+	assertReply(t, "*5\r\n+OK\r\n+OK\r\n$1\r\n2\r\n+OK\r\n:0\r\n", exec(s, c, "exec"))
+	exec(s, c, "select 1")
+	assertReply(t, ":2\r\n", exec(s, c, "exists a c"))
+	assertReply(t, ":2\r\n", exec(s, c, "dbsize"))
+	exec(s, c, "multi")
+	exec(s, c, "flushall")
+	exec(s, c, "set d 1")
+	exec(s, c, "flushdb async")
+	assertReply(t, "*3\r\n+OK\r\n+OK\r\n+OK\r\n", exec(s, c, "exec"))
+	assertReply(t, ":0\r\n", exec(s, c, "dbsize"))
+
+	// 回滚模式下SELECT与其他db的修改同样被撤销
+	exec(s, c, "select 0")
+	exec(s, c, "set a 1")
+	exec(s, c, "multi rollback")
+	assertReply(t, "-ERR command 'flushdb' cannot be used in MULTI ROLLBACK\r\n", exec(s, c, "flushdb"))
+	exec(s, c, "discard")
+	exec(s, c, "multi rollback")
+	exec(s, c, "move a 1")
+	exec(s, c, "select 1")
+	exec(s, c, "set b 1")
+	exec(s, c, "lpush b x")
+	assert.True(t, strings.HasPrefix(string(exec(s, c, "exec").ToBytes()), "-EXECABORT Transaction rolled back because command #4"))
+	assertReply(t, "$1\r\n1\r\n", exec(s, c, "get a"))
+	exec(s, c, "select 1")
+	assertReply(t, ":0\r\n", exec(s, c, "dbsize"))
+}
+
+func TestMultiBgJobs(t *testing.T) {
+	withAof(t)
+	config.Properties.AofUseRdbPreamble = false
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	// 快照在事务提交后获取，事务的命令不会同时出现在新的base和incr文件中
+	exec(s, c, "multi")
+	exec(s, c, "incr a")
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "bgrewriteaof"))
+	exec(s, c, "incr a")
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "bgsave schedule"))
+	assertReply(t, "*4\r\n:1\r\n+Background append only file rewriting started\r\n:2\r\n+Background saving scheduled\r\n",
+		exec(s, c, "exec"))
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(config.Properties.Dir, config.Properties.DBFilename))
+		return err == nil && s.bgJob.Load() == bgJobNone && !s.bgSaveScheduled.Load()
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"appendonly.aof.1.base.aof", "appendonly.aof.2.incr.aof", "appendonly.aof.manifest"}, aofFiles(t))
+
+	// 回滚的事务释放占用的后台任务
+	exec(s, c, "multi rollback")
+	exec(s, c, "bgsave")
+	exec(s, c, "lpush a x")
+	assert.True(t, strings.HasPrefix(string(exec(s, c, "exec").ToBytes()), "-EXECABORT"))
+	assert.Equal(t, bgJobNone, s.bgJob.Load())
+	s.Close()
+
+	restarted := newTestServer(t)
+	assertReply(t, "$1\r\n2\r\n", exec(restarted, c, "get a"))
+}
+
+func TestWatch(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	other := connection.NewFakeConn()
+
+	exec(s, c, "set a 1")
+	assertReply(t, "+OK\r\n", exec(s, c, "watch a nokey"))
+	exec(s, other, "set a 2")
+	exec(s, c, "multi")
+	assertReply(t, "-ERR WATCH inside MULTI is not allowed\r\n", exec(s, c, "watch b"))
+	exec(s, c, "set a 3")
+	assertReply(t, "*-1\r\n", exec(s, c, "exec"))
+	assertReply(t, "$1\r\n2\r\n", exec(s, c, "get a"))
+
+	// EXEC之后不再监视
+	exec(s, other, "set a 4")
+	exec(s, c, "multi")
+	exec(s, c, "set a 3")
+	assertReply(t, "*1\r\n+OK\r\n", exec(s, c, "exec"))
+
+	// 客户端自己在MULTI之前的修改同样会使事务失败
+	exec(s, c, "watch a")
+	exec(s, c, "set a 5")
+	exec(s, c, "multi")
+	exec(s, c, "get a")
+	assertReply(t, "*-1\r\n", exec(s, c, "exec"))
+
+	exec(s, c, "watch a")
+	assertReply(t, "+OK\r\n", exec(s, c, "unwatch"))
+	exec(s, other, "set a 6")
+	exec(s, c, "multi")
+	exec(s, c, "get a")
+	assertReply(t, "*1\r\n$1\r\n6\r\n", exec(s, c, "exec"))
+
+	// 不存在的key被创建
+	exec(s, c, "watch created")
+	exec(s, other, "lpush created x")
+	exec(s, c, "multi")
+	exec(s, c, "get a")
+	assertReply(t, "*-1\r\n", exec(s, c, "exec"))
+	exec(s, c, "watch created")
+	exec(s, other, "del created")
+	exec(s, c, "multi")
+	exec(s, c, "get a")
+	assertReply(t, "*-1\r\n", exec(s, c, "exec"))
+
+	// 读命令不改变版本
+	exec(s, c, "watch a")
+	exec(s, other, "get a")
+	exec(s, c, "multi")
+	exec(s, c, "get a")
+	assertReply(t, "*1\r\n$1\r\n6\r\n", exec(s, c, "exec"))
+}
+
+func TestWatchKeyspace(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+	other := connection.NewFakeConn()
+
+	// FLUSHDB使存在的key失效，不存在的key不受影响
+	exec(s, c, "set a 1")
+	exec(s, c, "watch a")
+	exec(s, other, "flushdb")
+	exec(s, c, "multi")
+	exec(s, c, "set b 1")
+	assertReply(t, "*-1\r\n", exec(s, c, "exec"))
+	exec(s, c, "watch nokey")
+	exec(s, other, "flushdb")
+	exec(s, c, "multi")
+	exec(s, c, "set b 1")
+	assertReply(t, "*1\r\n+OK\r\n", exec(s, c, "exec"))
+
+	// 监视其他db的key
+	exec(s, c, "select 1")
+	exec(s, c, "watch a")
+	exec(s, c, "select 0")
+	exec(s, other, "select 1")
+	exec(s, other, "set a 1")
+	exec(s, c, "multi")
+	exec(s, c, "set b 2")
+	assertReply(t, "*-1\r\n", exec(s, c, "exec"))
+	assertReply(t, "$1\r\n1\r\n", exec(s, c, "get b"))
+
+	// SWAPDB替换了key所在的keyspace
+	exec(s, c, "watch b")
+	exec(s, other, "swapdb 0 1")
+	exec(s, c, "multi")
+	exec(s, c, "get b")
+	assertReply(t, "*-1\r\n", exec(s, c, "exec"))
+}
+
+func TestWatchExpire(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "set a 1 px 50")
+	exec(s, c, "watch a")
+	time.Sleep(100 * time.Millisecond)
+	exec(s, c, "multi")
+	exec(s, c, "set b 1")
+	assertReply(t, "*-1\r\n", exec(s, c, "exec"))
+
+	// WATCH时已经过期的key视为不存在
+	exec(s, c, "watch a")
+	exec(s, c, "multi")
+	exec(s, c, "set b 1")
+	assertReply(t, "*1\r\n+OK\r\n", exec(s, c, "exec"))
+}
+
+// 并发的INCR与事务交错执行，事务中的两次INCR之间不能插入其他客户端的修改
+func TestMultiIsolation(t *testing.T) {
+	s := newTestServer(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := connection.NewFakeConn()
+			for j := 0; j < 200; j++ {
+				exec(s, c, "multi")
+				exec(s, c, "incr counter")
+				exec(s, c, "incr counter")
+				reply := string(exec(s, c, "exec").ToBytes())
+				var first, second int
+				_, err := fmt.Sscanf(reply, "*2\r\n:%d\r\n:%d\r\n", &first, &second)
+				assert.Nil(t, err)
+				assert.Equal(t, first+1, second)
+			}
+		}()
+	}
+	wg.Wait()
+	assertReply(t, "$4\r\n3200\r\n", exec(s, connection.NewFakeConn(), "get counter"))
+}
+
+func TestMultiRollback(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "set a 1 ex 100")
+	exec(s, c, "rpush list x y")
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "multi norollback"))
+	assertReply(t, "+OK\r\n", exec(s, c, "multi rollback"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "set a 2"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "set b 1"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "rpush list z"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "incr list"))
+	assertReply(t, "+QUEUED\r\n", exec(s, c, "set c 1"))
+	assertReply(t, "-EXECABORT Transaction rolled back because command #4 'incr' failed: "+
+		"WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "exec"))
+
+	// 所有修改都被撤销，包括过期时间
+	assertReply(t, "$1\r\n1\r\n", exec(s, c, "get a"))
+	var ttl int
+	_, err := fmt.Sscanf(string(exec(s, c, "ttl a").ToBytes()), ":%d\r\n", &ttl)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 90)
+	assertReply(t, ":0\r\n", exec(s, c, "exists b c"))
+	assertReply(t, "*2\r\n$1\r\nx\r\n$1\r\ny\r\n", exec(s, c, "lrange list 0 -1"))
+
+	// 回滚模式只对一个事务有效
+	exec(s, c, "multi")
+	exec(s, c, "set b 1")
+	exec(s, c, "incr list")
+	assertReply(t, "*2\r\n+OK\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "exec"))
+	assertReply(t, ":1\r\n", exec(s, c, "exists b"))
+
+	exec(s, c, "multi rollback")
+	exec(s, c, "persist a")
+	exec(s, c, "incr b")
+	assertReply(t, "*2\r\n:1\r\n:2\r\n", exec(s, c, "exec"))
+	assertReply(t, ":-1\r\n", exec(s, c, "ttl a"))
+}
+
+func TestMultiRollbackConfig(t *testing.T) {
+	config.Properties.MultiRollback = true
+	defer func() { config.Properties.MultiRollback = false }()
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "set a 1")
+	exec(s, c, "multi")
+	exec(s, c, "del a")
+	exec(s, c, "set a 2")
+	exec(s, c, "lpop a")
+	assertReply(t, "-EXECABORT Transaction rolled back because command #3 'lpop' failed: "+
+		"WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "exec"))
+	assertReply(t, "$1\r\n1\r\n", exec(s, c, "get a"))
+}
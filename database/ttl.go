@@ -0,0 +1,191 @@
+package database
+
+import (
+	"godis/resp/protocol"
+	"math"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand("expire", execExpire, -3, flagWrite|flagFast).keys(1, 1, 1).withAof(aofExpire)
+	registerCommand("pexpire", execPExpire, -3, flagWrite|flagFast).keys(1, 1, 1).withAof(aofExpire)
+	registerCommand("expireat", execExpireAt, -3, flagWrite|flagFast).keys(1, 1, 1).withAof(aofExpire)
+	registerCommand("pexpireat", execPExpireAt, -3, flagWrite|flagFast).keys(1, 1, 1).withAof(aofExpire)
+	registerCommand("ttl", execTTL, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("pttl", execPTTL, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("expiretime", execExpireTime, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("pexpiretime", execPExpireTime, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("persist", execPersist, 2, flagWrite|flagFast).keys(1, 1, 1)
+}
+
+const (
+	expireNX = 1 << iota
+	expireXX
+	expireGT
+	expireLT
+)
+
+func parseExpireFlags(args [][]byte) (int, *protocol.ErrReply) {
+	flags := 0
+	for _, arg := range args {
+		switch strings.ToUpper(string(arg)) {
+		case "NX":
+			flags |= expireNX
+		case "XX":
+			flags |= expireXX
+		case "GT":
+			flags |= expireGT
+		case "LT":
+			flags |= expireLT
+		default:
+			return 0, protocol.NewErrReply("ERR Unsupported option " + string(arg))
+		}
+	}
+	if flags&expireNX != 0 && flags&(expireXX|expireGT|expireLT) != 0 {
+		return 0, protocol.NewErrReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if flags&expireGT != 0 && flags&expireLT != 0 {
+		return 0, protocol.NewErrReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return flags, nil
+}
+
+// aofExpire writes the expire time a successful EXPIRE family command set as an absolute PEXPIREAT,
+// or a DEL if the time was already in the past
+func aofExpire(db *DB, args [][]byte, reply protocol.Reply) []CmdLine {
+	if n, ok := reply.(*protocol.IntReply); ok && n.Value == 0 {
+		return nil
+	}
+	return aofExpireState(db, string(args[0]))
+}
+
+// expireGeneric sets the expire time of args[0], the time argument is scaled to milliseconds by unit
+// and offset by now when relative is set
+func expireGeneric(db *DB, cmdName string, args [][]byte, unit int64, relative bool) protocol.Reply {
+	n, ok := parseInt(args[1])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	flags, errReply := parseExpireFlags(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+
+	invalid := protocol.NewErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	if n > math.MaxInt64/unit || n < math.MinInt64/unit {
+		return invalid
+	}
+	ms := n * unit
+	if relative {
+		now := time.Now().UnixMilli()
+		if ms > math.MaxInt64-now {
+			return invalid
+		}
+		ms += now
+	}
+	expireAt := time.UnixMilli(ms)
+
+	key := string(args[0])
+	if _, exists := db.getEntity(key); !exists {
+		return protocol.NewIntReply(0)
+	}
+
+	current, hasTTL := db.expireTime(key)
+	if flags&expireNX != 0 && hasTTL {
+		return protocol.NewIntReply(0)
+	}
+	if flags&expireXX != 0 && !hasTTL {
+		return protocol.NewIntReply(0)
+	}
+	// 没有过期时间视为永不过期
+	if flags&expireGT != 0 && (!hasTTL || !expireAt.After(current)) {
+		return protocol.NewIntReply(0)
+	}
+	if flags&expireLT != 0 && hasTTL && !expireAt.Before(current) {
+		return protocol.NewIntReply(0)
+	}
+
+	if !expireAt.After(time.Now()) {
+		db.removeKey(key)
+		db.notify(notifyGeneric, "del", key)
+		return protocol.NewIntReply(1)
+	}
+	db.expire(key, expireAt)
+	db.notify(notifyGeneric, "expire", key)
+	return protocol.NewIntReply(1)
+}
+
+// execExpire EXPIRE key seconds [NX | XX | GT | LT]
+func execExpire(db *DB, args [][]byte) protocol.Reply {
+	return expireGeneric(db, "expire", args, 1000, true)
+}
+
+// execPExpire PEXPIRE key milliseconds [NX | XX | GT | LT]
+func execPExpire(db *DB, args [][]byte) protocol.Reply {
+	return expireGeneric(db, "pexpire", args, 1, true)
+}
+
+// execExpireAt EXPIREAT key unix-time-seconds [NX | XX | GT | LT]
+func execExpireAt(db *DB, args [][]byte) protocol.Reply {
+	return expireGeneric(db, "expireat", args, 1000, false)
+}
+
+// execPExpireAt PEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT]
+func execPExpireAt(db *DB, args [][]byte) protocol.Reply {
+	return expireGeneric(db, "pexpireat", args, 1, false)
+}
+
+// ttlGeneric replies -2 if key doesn't exist, -1 if key has no expire time
+func ttlGeneric(db *DB, key string, format func(expireAt time.Time) int64) protocol.Reply {
+	if _, exists := db.getEntity(key); !exists {
+		return protocol.NewIntReply(-2)
+	}
+	expireAt, ok := db.expireTime(key)
+	if !ok {
+		return protocol.NewIntReply(-1)
+	}
+	return protocol.NewIntReply(format(expireAt))
+}
+
+// execTTL TTL key
+func execTTL(db *DB, args [][]byte) protocol.Reply {
+	return ttlGeneric(db, string(args[0]), func(expireAt time.Time) int64 {
+		ms := time.Until(expireAt).Milliseconds()
+		return max((ms+500)/1000, 0)
+	})
+}
+
+// execPTTL PTTL key
+func execPTTL(db *DB, args [][]byte) protocol.Reply {
+	return ttlGeneric(db, string(args[0]), func(expireAt time.Time) int64 {
+		return max(time.Until(expireAt).Milliseconds(), 0)
+	})
+}
+
+// execExpireTime EXPIRETIME key
+func execExpireTime(db *DB, args [][]byte) protocol.Reply {
+	return ttlGeneric(db, string(args[0]), func(expireAt time.Time) int64 {
+		return expireAt.Unix()
+	})
+}
+
+// execPExpireTime PEXPIRETIME key
+func execPExpireTime(db *DB, args [][]byte) protocol.Reply {
+	return ttlGeneric(db, string(args[0]), func(expireAt time.Time) int64 {
+		return expireAt.UnixMilli()
+	})
+}
+
+// execPersist PERSIST key
+func execPersist(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	if _, exists := db.getEntity(key); !exists {
+		return protocol.NewIntReply(0)
+	}
+	if db.persist(key) {
+		db.notify(notifyGeneric, "persist", key)
+		return protocol.NewIntReply(1)
+	}
+	return protocol.NewIntReply(0)
+}
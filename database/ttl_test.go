@@ -0,0 +1,62 @@
+package database
+
+import (
+	"fmt"
+	"godis/resp/connection"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpireOptions(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, ":0\r\n", exec(s, c, "expire missing 10"))
+	exec(s, c, "set k v")
+	assertReply(t, ":-1\r\n", exec(s, c, "ttl k"))
+	assertReply(t, ":-2\r\n", exec(s, c, "ttl missing"))
+
+	assertReply(t, ":0\r\n", exec(s, c, "expire k 100 xx"))
+	assertReply(t, ":0\r\n", exec(s, c, "expire k 100 gt"))
+	assertReply(t, ":1\r\n", exec(s, c, "expire k 100 nx"))
+	assertReply(t, ":0\r\n", exec(s, c, "expire k 200 nx"))
+	assertReply(t, ":0\r\n", exec(s, c, "expire k 50 gt"))
+	assertReply(t, ":1\r\n", exec(s, c, "expire k 50 lt"))
+	assertReply(t, ":50\r\n", exec(s, c, "ttl k"))
+	assertReply(t, "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n",
+		exec(s, c, "expire k 10 nx gt"))
+	assertReply(t, "-ERR GT and LT options at the same time are not compatible\r\n",
+		exec(s, c, "expire k 10 gt lt"))
+
+	at := time.Now().Add(time.Hour).Unix()
+	assertReply(t, ":1\r\n", exec(s, c, "expireat k "+strconv.FormatInt(at, 10)))
+	assertReply(t, fmt.Sprintf(":%d\r\n", at), exec(s, c, "expiretime k"))
+	assertReply(t, fmt.Sprintf(":%d\r\n", at*1000), exec(s, c, "pexpiretime k"))
+
+	assertReply(t, ":1\r\n", exec(s, c, "persist k"))
+	assertReply(t, ":0\r\n", exec(s, c, "persist k"))
+	assertReply(t, ":-1\r\n", exec(s, c, "pttl k"))
+
+	assertReply(t, ":1\r\n", exec(s, c, "pexpire k -1"))
+	assertReply(t, ":0\r\n", exec(s, c, "dbsize"))
+}
+
+func TestActiveExpire(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	for i := 0; i < 100; i++ {
+		exec(s, c, fmt.Sprintf("set k%d v px 10", i))
+	}
+	exec(s, c, "set keep v ex 100")
+	time.Sleep(20 * time.Millisecond)
+
+	db := s.dbSet[0]
+	db.activeExpireCycle(time.Now().Add(time.Second))
+	// 未被访问的过期key也会被后台清理
+	assert.Less(t, db.size(), 10)
+	assertReply(t, "$1\r\nv\r\n", exec(s, c, "get keep"))
+}
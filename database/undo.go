@@ -0,0 +1,82 @@
+package database
+
+import (
+	"time"
+)
+
+// undoEntry is the state of a key before it was first written by a transaction or while a background job reads a snapshot
+type undoEntry struct {
+	val      any
+	exists   bool
+	expireAt time.Time
+	hasTTL   bool
+}
+
+// undoLog restores the keys written by a rolled back transaction, the shard locks of all
+// keys must be held from the first record until the rollback
+type undoLog struct {
+	entries map[*DB]map[string]*undoEntry
+}
+
+func newUndoLog() *undoLog {
+	return &undoLog{
+		entries: make(map[*DB]map[string]*undoEntry),
+	}
+}
+
+// record saves the current state of keys of db, only the first record of each key is kept
+// so the rollback restores the state before the transaction
+func (u *undoLog) record(db *DB, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	entries, ok := u.entries[db]
+	if !ok {
+		entries = make(map[string]*undoEntry)
+		u.entries[db] = entries
+	}
+	for _, key := range keys {
+		if _, ok := entries[key]; ok {
+			continue
+		}
+		entries[key] = db.captureEntry(key)
+	}
+}
+
+// captureEntry copies the current state of key, the caller must hold the shard lock of key
+func (db *DB) captureEntry(key string) *undoEntry {
+	entry := &undoEntry{}
+	if val, ok := db.getEntity(key); ok {
+		// 大部分类型会被原地修改，必须保存副本
+		entry.val = copyEntity(val)
+		entry.exists = true
+		entry.expireAt, entry.hasTTL = db.expireTime(key)
+	}
+	return entry
+}
+
+func (u *undoLog) rollback() {
+	for db, entries := range u.entries {
+		for key, entry := range entries {
+			if !entry.exists {
+				db.removeKey(key)
+				continue
+			}
+			db.putEntity(key, entry.val)
+			if entry.hasTTL {
+				db.expire(key, entry.expireAt)
+			} else {
+				db.persist(key)
+			}
+		}
+	}
+}
+
+// addVersions marks the recorded keys as modified once the transaction has succeeded
+func (u *undoLog) addVersions() {
+	for db, entries := range u.entries {
+		for key := range entries {
+			db.addVersion(key)
+		}
+	}
+}
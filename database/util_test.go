@@ -0,0 +1,37 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"godis/resp/protocol"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer creates a server closed when the test finishes. Cleanups run in reverse order, so
+// it's closed before fixtures set up earlier like withAof restore the config it reads.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s := NewServer()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func toCmdLine(cmd ...string) [][]byte {
+	args := make([][]byte, len(cmd))
+	for i, s := range cmd {
+		args[i] = []byte(s)
+	}
+	return args
+}
+
+// exec runs a whitespace separated command line
+func exec(s *Server, client connection.Connection, cmd string) protocol.Reply {
+	return s.Exec(client, toCmdLine(strings.Fields(cmd)...))
+}
+
+func assertReply(t *testing.T, expected string, reply protocol.Reply) {
+	t.Helper()
+	assert.Equal(t, expected, string(reply.ToBytes()))
+}
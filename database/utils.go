@@ -0,0 +1,36 @@
+package database
+
+import (
+	"strconv"
+)
+
+// parseInt parses a canonical base 10 integer the way redis does: no sign prefix, spaces or leading zeros
+func parseInt(arg []byte) (int64, bool) {
+	s := string(arg)
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// parseFloat parses a float argument, nan is rejected
+func parseFloat(arg []byte) (float64, bool) {
+	f, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || f != f {
+		return 0, false
+	}
+	return f, true
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// toStoredBytes makes sure stored strings are never nil, nil means absent key in this package
+func toStoredBytes(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
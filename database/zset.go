@@ -0,0 +1,1287 @@
+package database
+
+import (
+	"godis/config"
+	"godis/datastruct/set"
+	"godis/datastruct/sortedset"
+	"godis/resp/protocol"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerCommand("zadd", execZAdd, -4, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("zincrby", execZIncrBy, 4, flagWrite|flagDenyOOM|flagFast).keys(1, 1, 1)
+	registerCommand("zrem", execZRem, -3, flagWrite|flagFast).keys(1, 1, 1)
+	registerCommand("zscore", execZScore, 3, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("zmscore", execZMScore, -3, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("zcard", execZCard, 2, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("zcount", execZCount, 4, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("zlexcount", execZLexCount, 4, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("zrank", execZRank, -3, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("zrevrank", execZRevRank, -3, flagReadOnly|flagFast).keys(1, 1, 1)
+	registerCommand("zrange", execZRange, -4, flagReadOnly).keys(1, 1, 1)
+	registerCommand("zrangestore", execZRangeStore, -5, flagWrite|flagDenyOOM).keys(1, 2, 1).withPrepare(prepareZRangeStore)
+	registerCommand("zrevrange", execZRevRange, -4, flagReadOnly).keys(1, 1, 1)
+	registerCommand("zrangebyscore", execZRangeByScore, -4, flagReadOnly).keys(1, 1, 1)
+	registerCommand("zrevrangebyscore", execZRevRangeByScore, -4, flagReadOnly).keys(1, 1, 1)
+	registerCommand("zrangebylex", execZRangeByLex, -4, flagReadOnly).keys(1, 1, 1)
+	registerCommand("zrevrangebylex", execZRevRangeByLex, -4, flagReadOnly).keys(1, 1, 1)
+	registerCommand("zremrangebyrank", execZRemRangeByRank, 4, flagWrite).keys(1, 1, 1)
+	registerCommand("zremrangebyscore", execZRemRangeByScore, 4, flagWrite).keys(1, 1, 1)
+	registerCommand("zremrangebylex", execZRemRangeByLex, 4, flagWrite).keys(1, 1, 1)
+	registerCommand("zpopmin", execZPopMin, -2, flagWrite|flagFast).keys(1, 1, 1)
+	registerCommand("zpopmax", execZPopMax, -2, flagWrite|flagFast).keys(1, 1, 1)
+	registerCommand("zmpop", execZMPop, -4, flagWrite).withPrepare(prepareLMPop)
+	registerCommand("bzpopmin", execBZPopMin, -3, flagWrite|flagFast|flagBlocking).keys(1, -2, 1).withAof(aofPopFrom("zpopmin"))
+	registerCommand("bzpopmax", execBZPopMax, -3, flagWrite|flagFast|flagBlocking).keys(1, -2, 1).withAof(aofPopFrom("zpopmax"))
+	registerCommand("bzmpop", execBZMPop, -5, flagWrite|flagBlocking).withPrepare(prepareBLMPop).withAof(aofNonBlocking("zmpop", true))
+	registerCommand("zrandmember", execZRandMember, -2, flagReadOnly).keys(1, 1, 1)
+	registerCommand("zunion", execZUnion, -3, flagReadOnly).withPrepare(prepareZSetOp)
+	registerCommand("zinter", execZInter, -3, flagReadOnly).withPrepare(prepareZSetOp)
+	registerCommand("zdiff", execZDiff, -3, flagReadOnly).withPrepare(prepareZSetOp)
+	registerCommand("zunionstore", execZUnionStore, -4, flagWrite|flagDenyOOM).withPrepare(prepareZSetOpStore)
+	registerCommand("zinterstore", execZInterStore, -4, flagWrite|flagDenyOOM).withPrepare(prepareZSetOpStore)
+	registerCommand("zdiffstore", execZDiffStore, -4, flagWrite|flagDenyOOM).withPrepare(prepareZSetOpStore)
+	registerCommand("zintercard", execZInterCard, -3, flagReadOnly).withPrepare(prepareSInterCard)
+	registerCommand("zscan", execZScan, -3, flagReadOnly).keys(1, 1, 1)
+}
+
+// getAsZSet returns the sorted set of key, nil if key doesn't exist
+func (db *DB) getAsZSet(key string) (*sortedset.SortedSet, *protocol.ErrReply) {
+	entity, ok := db.getEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	z, ok := entity.(*sortedset.SortedSet)
+	if !ok {
+		return nil, protocol.NewWrongTypeErrReply()
+	}
+	return z, nil
+}
+
+func newZSet() *sortedset.SortedSet {
+	return sortedset.New(config.Properties.ZSetMaxListpackEntries, config.Properties.ZSetMaxListpackValue)
+}
+
+// removeIfEmptyZSet deletes key once its sorted set has no members left
+func (db *DB) removeIfEmptyZSet(key string, z *sortedset.SortedSet) {
+	if z.Len() == 0 {
+		db.removeKey(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+}
+
+// formatScore formats a score in the shortest form like redis, infinities are "inf" and "-inf"
+func formatScore(score float64) []byte {
+	switch {
+	case math.IsInf(score, 1):
+		return []byte("inf")
+	case math.IsInf(score, -1):
+		return []byte("-inf")
+	}
+	abs := math.Abs(score)
+	if abs != 0 && (abs < 1e-5 || abs >= 1e21) {
+		return strconv.AppendFloat(nil, score, 'g', -1, 64)
+	}
+	return strconv.AppendFloat(nil, score, 'f', -1, 64)
+}
+
+func parseScoreBorder(arg []byte) (sortedset.ScoreBorder, *protocol.ErrReply) {
+	var border sortedset.ScoreBorder
+	if len(arg) > 0 && arg[0] == '(' {
+		border.Exclude = true
+		arg = arg[1:]
+	}
+	score, ok := parseFloat(arg)
+	if !ok {
+		return border, protocol.NewErrReply("ERR min or max is not a float")
+	}
+	border.Value = score
+	return border, nil
+}
+
+func parseLexBorder(arg []byte) (sortedset.LexBorder, *protocol.ErrReply) {
+	var border sortedset.LexBorder
+	switch {
+	case len(arg) == 1 && arg[0] == '-':
+		border.Inf = -1
+	case len(arg) == 1 && arg[0] == '+':
+		border.Inf = 1
+	case len(arg) > 0 && arg[0] == '(':
+		border.Exclude = true
+		border.Value = string(arg[1:])
+	case len(arg) > 0 && arg[0] == '[':
+		border.Value = string(arg[1:])
+	default:
+		return border, protocol.NewErrReply("ERR min or max not valid string range item")
+	}
+	return border, nil
+}
+
+func elementsReply(elements []sortedset.Element, withScores bool) protocol.Reply {
+	size := len(elements)
+	if withScores {
+		size *= 2
+	}
+	result := make([][]byte, 0, size)
+	for _, e := range elements {
+		result = append(result, []byte(e.Member))
+		if withScores {
+			result = append(result, formatScore(e.Score))
+		}
+	}
+	return protocol.NewMultiBulkReply(result)
+}
+
+const (
+	zaddNX = 1 << iota
+	zaddXX
+	zaddGT
+	zaddLT
+	zaddCH
+	zaddIncr
+)
+
+// execZAdd ZADD key [NX | XX] [GT | LT] [CH] [INCR] score member [score member ...]
+func execZAdd(db *DB, args [][]byte) protocol.Reply {
+	flags := 0
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			flags |= zaddNX
+		case "XX":
+			flags |= zaddXX
+		case "GT":
+			flags |= zaddGT
+		case "LT":
+			flags |= zaddLT
+		case "CH":
+			flags |= zaddCH
+		case "INCR":
+			flags |= zaddIncr
+		default:
+			break options
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return protocol.NewSyntaxErrReply()
+	}
+	if flags&zaddNX != 0 && flags&zaddXX != 0 {
+		return protocol.NewErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (flags&zaddGT != 0 && flags&zaddNX != 0) || (flags&zaddLT != 0 && flags&zaddNX != 0) || (flags&zaddGT != 0 && flags&zaddLT != 0) {
+		return protocol.NewErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	incr := flags&zaddIncr != 0
+	if incr && len(pairs) != 2 {
+		return protocol.NewErrReply("ERR INCR option supports a single increment-element pair")
+	}
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		score, ok := parseFloat(pairs[j*2])
+		if !ok {
+			return protocol.NewErrReply("ERR value is not a valid float")
+		}
+		scores[j] = score
+	}
+
+	key := string(args[0])
+	z, errReply := db.getAsZSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		if flags&zaddXX != 0 {
+			if incr {
+				return protocol.NewNullBulkReply()
+			}
+			return protocol.NewIntReply(0)
+		}
+		z = newZSet()
+		db.putEntity(key, z)
+	}
+
+	added, updated := 0, 0
+	var result protocol.Reply = protocol.NewNullBulkReply()
+	for j, score := range scores {
+		member := string(pairs[j*2+1])
+		old, exists := z.Get(member)
+		if !exists {
+			if flags&zaddXX != 0 {
+				continue
+			}
+			z.Add(member, score)
+			added++
+			result = protocol.NewBulkReply(formatScore(score))
+			continue
+		}
+		if flags&zaddNX != 0 {
+			continue
+		}
+		if incr {
+			score += old
+			if math.IsNaN(score) {
+				return protocol.NewErrReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+		if (flags&zaddGT != 0 && score <= old) || (flags&zaddLT != 0 && score >= old) {
+			continue
+		}
+		if score != old {
+			z.Add(member, score)
+			updated++
+		}
+		result = protocol.NewBulkReply(formatScore(score))
+	}
+	if added+updated > 0 {
+		if incr {
+			db.notify(notifyZSet, "zincr", key)
+		} else {
+			db.notify(notifyZSet, "zadd", key)
+		}
+	}
+	db.removeIfEmptyZSet(key, z)
+	if incr {
+		return result
+	}
+	if flags&zaddCH != 0 {
+		return protocol.NewIntReply(int64(added + updated))
+	}
+	return protocol.NewIntReply(int64(added))
+}
+
+// execZIncrBy ZINCRBY key increment member
+func execZIncrBy(db *DB, args [][]byte) protocol.Reply {
+	delta, ok := parseFloat(args[1])
+	if !ok {
+		return protocol.NewErrReply("ERR value is not a valid float")
+	}
+	key := string(args[0])
+	z, errReply := db.getAsZSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		z = newZSet()
+		db.putEntity(key, z)
+	}
+	member := string(args[2])
+	score, _ := z.Get(member)
+	score += delta
+	if math.IsNaN(score) {
+		db.removeIfEmptyZSet(key, z)
+		return protocol.NewErrReply("ERR resulting score is not a number (NaN)")
+	}
+	z.Add(member, score)
+	db.notify(notifyZSet, "zincr", key)
+	return protocol.NewBulkReply(formatScore(score))
+}
+
+// execZRem ZREM key member [member ...]
+func execZRem(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	z, errReply := db.getAsZSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return protocol.NewIntReply(0)
+	}
+	removed := 0
+	for _, member := range args[1:] {
+		if z.Remove(string(member)) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		db.notify(notifyZSet, "zrem", key)
+	}
+	db.removeIfEmptyZSet(key, z)
+	return protocol.NewIntReply(int64(removed))
+}
+
+// execZScore ZSCORE key member
+func execZScore(db *DB, args [][]byte) protocol.Reply {
+	z, errReply := db.getAsZSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return protocol.NewNullBulkReply()
+	}
+	score, ok := z.Get(string(args[1]))
+	if !ok {
+		return protocol.NewNullBulkReply()
+	}
+	return protocol.NewBulkReply(formatScore(score))
+}
+
+// execZMScore ZMSCORE key member [member ...]
+func execZMScore(db *DB, args [][]byte) protocol.Reply {
+	z, errReply := db.getAsZSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(args)-1)
+	if z != nil {
+		for i, member := range args[1:] {
+			if score, ok := z.Get(string(member)); ok {
+				result[i] = formatScore(score)
+			}
+		}
+	}
+	return protocol.NewMultiBulkReply(result)
+}
+
+// execZCard ZCARD key
+func execZCard(db *DB, args [][]byte) protocol.Reply {
+	z, errReply := db.getAsZSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return protocol.NewIntReply(0)
+	}
+	return protocol.NewIntReply(int64(z.Len()))
+}
+
+// execZCount ZCOUNT key min max
+func execZCount(db *DB, args [][]byte) protocol.Reply {
+	minBorder, errReply := parseScoreBorder(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	maxBorder, errReply := parseScoreBorder(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	z, errReply := db.getAsZSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return protocol.NewIntReply(0)
+	}
+	start, stop := z.ScoreRange(minBorder, maxBorder)
+	return protocol.NewIntReply(int64(max(stop-start, 0)))
+}
+
+// execZLexCount ZLEXCOUNT key min max
+func execZLexCount(db *DB, args [][]byte) protocol.Reply {
+	minBorder, errReply := parseLexBorder(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	maxBorder, errReply := parseLexBorder(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	z, errReply := db.getAsZSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return protocol.NewIntReply(0)
+	}
+	start, stop := z.LexRange(minBorder, maxBorder)
+	return protocol.NewIntReply(int64(max(stop-start, 0)))
+}
+
+func rankGeneric(db *DB, args [][]byte, desc bool) protocol.Reply {
+	withScore := false
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHSCORE" {
+			return protocol.NewSyntaxErrReply()
+		}
+		withScore = true
+	} else if len(args) > 3 {
+		return protocol.NewSyntaxErrReply()
+	}
+	notFound := protocol.Reply(protocol.NewNullBulkReply())
+	if withScore {
+		notFound = protocol.NewNullArrayReply()
+	}
+	z, errReply := db.getAsZSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return notFound
+	}
+	member := string(args[1])
+	rank, ok := z.Rank(member, desc)
+	if !ok {
+		return notFound
+	}
+	if !withScore {
+		return protocol.NewIntReply(int64(rank))
+	}
+	score, _ := z.Get(member)
+	return protocol.NewArrayReply([]protocol.Reply{
+		protocol.NewIntReply(int64(rank)),
+		protocol.NewBulkReply(formatScore(score)),
+	})
+}
+
+// execZRank ZRANK key member [WITHSCORE]
+func execZRank(db *DB, args [][]byte) protocol.Reply {
+	return rankGeneric(db, args, false)
+}
+
+// execZRevRank ZREVRANK key member [WITHSCORE]
+func execZRevRank(db *DB, args [][]byte) protocol.Reply {
+	return rankGeneric(db, args, true)
+}
+
+const (
+	zrangeByRank = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// zrangeSpec describes the range of a ZRANGE like command, start and stop are the raw arguments
+type zrangeSpec struct {
+	start, stop []byte
+	mode        int
+	rev         bool
+	withScores  bool
+	hasLimit    bool
+	offset      int64
+	count       int64
+}
+
+// parseZRangeOptions parses [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES],
+// the legacy commands have their mode and direction fixed and only accept LIMIT and WITHSCORES
+func parseZRangeOptions(spec *zrangeSpec, args [][]byte, legacy bool) *protocol.ErrReply {
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "BYSCORE":
+			if legacy {
+				return protocol.NewSyntaxErrReply()
+			}
+			spec.mode = zrangeByScore
+		case "BYLEX":
+			if legacy {
+				return protocol.NewSyntaxErrReply()
+			}
+			spec.mode = zrangeByLex
+		case "REV":
+			if legacy {
+				return protocol.NewSyntaxErrReply()
+			}
+			spec.rev = true
+		case "WITHSCORES":
+			spec.withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return protocol.NewSyntaxErrReply()
+			}
+			offset, ok1 := parseInt(args[i+1])
+			count, ok2 := parseInt(args[i+2])
+			if !ok1 || !ok2 {
+				return protocol.NewNotIntegerErrReply()
+			}
+			spec.hasLimit = true
+			spec.offset, spec.count = offset, count
+			i += 2
+		default:
+			return protocol.NewSyntaxErrReply()
+		}
+	}
+	if spec.hasLimit && spec.mode == zrangeByRank {
+		return protocol.NewErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.mode == zrangeByLex {
+		return protocol.NewErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return nil
+}
+
+// rangeOf returns the elements selected by spec in reply order
+func rangeOf(z *sortedset.SortedSet, spec *zrangeSpec) ([]sortedset.Element, *protocol.ErrReply) {
+	var start, stop int
+	switch spec.mode {
+	case zrangeByRank:
+		first, ok1 := parseInt(spec.start)
+		last, ok2 := parseInt(spec.stop)
+		if !ok1 || !ok2 {
+			return nil, protocol.NewNotIntegerErrReply()
+		}
+		if z == nil {
+			return nil, nil
+		}
+		var ok bool
+		start, stop, ok = normalizeRange(first, last, z.Len())
+		if !ok {
+			return nil, nil
+		}
+		if spec.rev {
+			// 逆序时排名从最大的元素开始计算
+			start, stop = z.Len()-stop, z.Len()-start
+		}
+		return z.Range(start, stop, spec.rev), nil
+	case zrangeByScore:
+		minArg, maxArg := spec.start, spec.stop
+		if spec.rev {
+			minArg, maxArg = maxArg, minArg
+		}
+		minBorder, errReply := parseScoreBorder(minArg)
+		if errReply != nil {
+			return nil, errReply
+		}
+		maxBorder, errReply := parseScoreBorder(maxArg)
+		if errReply != nil {
+			return nil, errReply
+		}
+		if z == nil {
+			return nil, nil
+		}
+		start, stop = z.ScoreRange(minBorder, maxBorder)
+	case zrangeByLex:
+		minArg, maxArg := spec.start, spec.stop
+		if spec.rev {
+			minArg, maxArg = maxArg, minArg
+		}
+		minBorder, errReply := parseLexBorder(minArg)
+		if errReply != nil {
+			return nil, errReply
+		}
+		maxBorder, errReply := parseLexBorder(maxArg)
+		if errReply != nil {
+			return nil, errReply
+		}
+		if z == nil {
+			return nil, nil
+		}
+		start, stop = z.LexRange(minBorder, maxBorder)
+	}
+
+	if spec.hasLimit {
+		if spec.offset < 0 {
+			return nil, nil
+		}
+		offset := int(min(spec.offset, int64(z.Len())))
+		if spec.rev {
+			stop -= offset
+			if spec.count >= 0 {
+				start = max(start, stop-int(min(spec.count, int64(z.Len()))))
+			}
+		} else {
+			start += offset
+			if spec.count >= 0 {
+				stop = min(stop, start+int(min(spec.count, int64(z.Len()))))
+			}
+		}
+	}
+	return z.Range(start, stop, spec.rev), nil
+}
+
+func zrangeGeneric(db *DB, key string, spec *zrangeSpec) protocol.Reply {
+	z, errReply := db.getAsZSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	elements, errReply := rangeOf(z, spec)
+	if errReply != nil {
+		return errReply
+	}
+	return elementsReply(elements, spec.withScores)
+}
+
+// execZRange ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func execZRange(db *DB, args [][]byte) protocol.Reply {
+	spec := &zrangeSpec{start: args[1], stop: args[2]}
+	if errReply := parseZRangeOptions(spec, args[3:], false); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, string(args[0]), spec)
+}
+
+func prepareZRangeStore(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, []string{string(args[1])}
+}
+
+// execZRangeStore ZRANGESTORE dst src min max [BYSCORE | BYLEX] [REV] [LIMIT offset count]
+func execZRangeStore(db *DB, args [][]byte) protocol.Reply {
+	spec := &zrangeSpec{start: args[2], stop: args[3]}
+	if errReply := parseZRangeOptions(spec, args[4:], false); errReply != nil {
+		return errReply
+	}
+	if spec.withScores {
+		return protocol.NewSyntaxErrReply()
+	}
+	src, errReply := db.getAsZSet(string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	elements, errReply := rangeOf(src, spec)
+	if errReply != nil {
+		return errReply
+	}
+	return storeZSet(db, string(args[0]), elements, "zrangestore")
+}
+
+// execZRevRange ZREVRANGE key start stop [WITHSCORES]
+func execZRevRange(db *DB, args [][]byte) protocol.Reply {
+	spec := &zrangeSpec{start: args[1], stop: args[2], rev: true}
+	if errReply := parseZRangeOptions(spec, args[3:], true); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, string(args[0]), spec)
+}
+
+// execZRangeByScore ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func execZRangeByScore(db *DB, args [][]byte) protocol.Reply {
+	spec := &zrangeSpec{start: args[1], stop: args[2], mode: zrangeByScore}
+	if errReply := parseZRangeOptions(spec, args[3:], true); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, string(args[0]), spec)
+}
+
+// execZRevRangeByScore ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func execZRevRangeByScore(db *DB, args [][]byte) protocol.Reply {
+	spec := &zrangeSpec{start: args[1], stop: args[2], mode: zrangeByScore, rev: true}
+	if errReply := parseZRangeOptions(spec, args[3:], true); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, string(args[0]), spec)
+}
+
+// execZRangeByLex ZRANGEBYLEX key min max [LIMIT offset count]
+func execZRangeByLex(db *DB, args [][]byte) protocol.Reply {
+	spec := &zrangeSpec{start: args[1], stop: args[2], mode: zrangeByLex}
+	if errReply := parseZRangeOptions(spec, args[3:], true); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, string(args[0]), spec)
+}
+
+// execZRevRangeByLex ZREVRANGEBYLEX key max min [LIMIT offset count]
+func execZRevRangeByLex(db *DB, args [][]byte) protocol.Reply {
+	spec := &zrangeSpec{start: args[1], stop: args[2], mode: zrangeByLex, rev: true}
+	if errReply := parseZRangeOptions(spec, args[3:], true); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, string(args[0]), spec)
+}
+
+func removeRangeGeneric(db *DB, key string, spec *zrangeSpec, event string) protocol.Reply {
+	z, errReply := db.getAsZSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	elements, errReply := rangeOf(z, spec)
+	if errReply != nil {
+		return errReply
+	}
+	for _, e := range elements {
+		z.Remove(e.Member)
+	}
+	if len(elements) > 0 {
+		db.notify(notifyZSet, event, key)
+	}
+	if z != nil {
+		db.removeIfEmptyZSet(key, z)
+	}
+	return protocol.NewIntReply(int64(len(elements)))
+}
+
+// execZRemRangeByRank ZREMRANGEBYRANK key start stop
+func execZRemRangeByRank(db *DB, args [][]byte) protocol.Reply {
+	return removeRangeGeneric(db, string(args[0]), &zrangeSpec{start: args[1], stop: args[2]}, "zremrangebyrank")
+}
+
+// execZRemRangeByScore ZREMRANGEBYSCORE key min max
+func execZRemRangeByScore(db *DB, args [][]byte) protocol.Reply {
+	return removeRangeGeneric(db, string(args[0]), &zrangeSpec{start: args[1], stop: args[2], mode: zrangeByScore}, "zremrangebyscore")
+}
+
+// execZRemRangeByLex ZREMRANGEBYLEX key min max
+func execZRemRangeByLex(db *DB, args [][]byte) protocol.Reply {
+	return removeRangeGeneric(db, string(args[0]), &zrangeSpec{start: args[1], stop: args[2], mode: zrangeByLex}, "zremrangebylex")
+}
+
+// zpop removes up to count elements with the lowest, or highest if max is set, scores
+func (db *DB) zpop(key string, z *sortedset.SortedSet, count int, highest bool) []sortedset.Element {
+	var elements []sortedset.Element
+	if highest {
+		elements = z.Range(z.Len()-count, z.Len(), true)
+	} else {
+		elements = z.Range(0, count, false)
+	}
+	for _, e := range elements {
+		z.Remove(e.Member)
+	}
+	if len(elements) > 0 {
+		if highest {
+			db.notify(notifyZSet, "zpopmax", key)
+		} else {
+			db.notify(notifyZSet, "zpopmin", key)
+		}
+	}
+	db.removeIfEmptyZSet(key, z)
+	return elements
+}
+
+func popGenericZ(db *DB, args [][]byte, highest bool) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.NewSyntaxErrReply()
+	}
+	count := int64(1)
+	if len(args) == 2 {
+		var ok bool
+		count, ok = parseInt(args[1])
+		if !ok || count < 0 {
+			return protocol.NewErrReply("ERR value is out of range, must be positive")
+		}
+	}
+	key := string(args[0])
+	z, errReply := db.getAsZSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil || count == 0 {
+		return protocol.NewEmptyMultiBulkReply()
+	}
+	return elementsReply(db.zpop(key, z, int(min(count, int64(z.Len()))), highest), true)
+}
+
+// execZPopMin ZPOPMIN key [count]
+func execZPopMin(db *DB, args [][]byte) protocol.Reply {
+	return popGenericZ(db, args, false)
+}
+
+// execZPopMax ZPOPMAX key [count]
+func execZPopMax(db *DB, args [][]byte) protocol.Reply {
+	return popGenericZ(db, args, true)
+}
+
+func blockingPopGenericZ(db *DB, args [][]byte, highest bool) protocol.Reply {
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	keys := make([]string, len(args)-1)
+	for i := range keys {
+		keys[i] = string(args[i])
+	}
+	for _, key := range keys {
+		z, errReply := db.getAsZSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if z == nil {
+			continue
+		}
+		e := db.zpop(key, z, 1, highest)[0]
+		return protocol.NewMultiBulkReply([][]byte{[]byte(key), []byte(e.Member), formatScore(e.Score)})
+	}
+	return &blockReply{keys: keys, keyType: "zset", timeout: timeout, timeoutReply: protocol.NewNullArrayReply()}
+}
+
+// execBZPopMin BZPOPMIN key [key ...] timeout
+func execBZPopMin(db *DB, args [][]byte) protocol.Reply {
+	return blockingPopGenericZ(db, args, false)
+}
+
+// execBZPopMax BZPOPMAX key [key ...] timeout
+func execBZPopMax(db *DB, args [][]byte) protocol.Reply {
+	return blockingPopGenericZ(db, args, true)
+}
+
+// parseZMPopArgs numkeys key [key ...] <MIN | MAX> [COUNT count]
+func parseZMPopArgs(args [][]byte) (keys []string, highest bool, count int, errReply *protocol.ErrReply) {
+	keys, rest, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return nil, false, 0, errReply
+	}
+	if len(rest) == 0 {
+		return nil, false, 0, protocol.NewSyntaxErrReply()
+	}
+	switch strings.ToUpper(string(rest[0])) {
+	case "MIN":
+	case "MAX":
+		highest = true
+	default:
+		return nil, false, 0, protocol.NewSyntaxErrReply()
+	}
+	count = 1
+	rest = rest[1:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "COUNT" {
+			return nil, false, 0, protocol.NewSyntaxErrReply()
+		}
+		n, ok := parseInt(rest[1])
+		if !ok || n <= 0 {
+			return nil, false, 0, protocol.NewErrReply("ERR count should be greater than 0")
+		}
+		count = int(n)
+	}
+	return keys, highest, count, nil
+}
+
+// zmpopGeneric pops up to count elements from the first non empty sorted set, nil if all are empty
+func zmpopGeneric(db *DB, keys []string, highest bool, count int) protocol.Reply {
+	for _, key := range keys {
+		z, errReply := db.getAsZSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if z == nil {
+			continue
+		}
+		elements := db.zpop(key, z, min(count, z.Len()), highest)
+		result := make([]protocol.Reply, len(elements))
+		for i, e := range elements {
+			result[i] = protocol.NewMultiBulkReply([][]byte{[]byte(e.Member), formatScore(e.Score)})
+		}
+		return protocol.NewArrayReply([]protocol.Reply{
+			protocol.NewBulkReply([]byte(key)),
+			protocol.NewArrayReply(result),
+		})
+	}
+	return nil
+}
+
+// execZMPop ZMPOP numkeys key [key ...] <MIN | MAX> [COUNT count]
+func execZMPop(db *DB, args [][]byte) protocol.Reply {
+	keys, highest, count, errReply := parseZMPopArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	if reply := zmpopGeneric(db, keys, highest, count); reply != nil {
+		return reply
+	}
+	return protocol.NewNullArrayReply()
+}
+
+// execBZMPop BZMPOP timeout numkeys key [key ...] <MIN | MAX> [COUNT count]
+func execBZMPop(db *DB, args [][]byte) protocol.Reply {
+	timeout, errReply := parseBlockTimeout(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	keys, highest, count, errReply := parseZMPopArgs(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	if reply := zmpopGeneric(db, keys, highest, count); reply != nil {
+		return reply
+	}
+	return &blockReply{keys: keys, keyType: "zset", timeout: timeout, timeoutReply: protocol.NewNullArrayReply()}
+}
+
+// execZRandMember ZRANDMEMBER key [count [WITHSCORES]]
+func execZRandMember(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 3 {
+		return protocol.NewSyntaxErrReply()
+	}
+	z, errReply := db.getAsZSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 1 {
+		if z == nil {
+			return protocol.NewNullBulkReply()
+		}
+		e, _ := z.RandomElement()
+		return protocol.NewBulkReply([]byte(e.Member))
+	}
+
+	count, ok := parseInt(args[1])
+	if !ok {
+		return protocol.NewNotIntegerErrReply()
+	}
+	withScores := false
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHSCORES" {
+			return protocol.NewSyntaxErrReply()
+		}
+		withScores = true
+	}
+	if count < -math.MaxInt64/2 {
+		return protocol.NewErrReply("ERR value is out of range")
+	}
+	if z == nil || count == 0 {
+		return protocol.NewEmptyMultiBulkReply()
+	}
+	if count > 0 {
+		return elementsReply(z.RandomDistinctElements(int(min(count, int64(z.Len())))), withScores)
+	}
+	// 负数时允许重复
+	elements := make([]sortedset.Element, -count)
+	for i := range elements {
+		elements[i], _ = z.RandomElement()
+	}
+	return elementsReply(elements, withScores)
+}
+
+// zsetOperand is an input of ZUNION and friends, plain sets are accepted with every score being 1
+type zsetOperand struct {
+	zset *sortedset.SortedSet
+	set  *set.Set
+}
+
+func (o zsetOperand) len() int {
+	switch {
+	case o.zset != nil:
+		return o.zset.Len()
+	case o.set != nil:
+		return o.set.Len()
+	}
+	return 0
+}
+
+func (o zsetOperand) get(member string) (float64, bool) {
+	switch {
+	case o.zset != nil:
+		return o.zset.Get(member)
+	case o.set != nil:
+		return 1, o.set.Has(member)
+	}
+	return 0, false
+}
+
+func (o zsetOperand) forEach(consumer func(member string, score float64) bool) {
+	switch {
+	case o.zset != nil:
+		o.zset.ForEach(func(e sortedset.Element) bool {
+			return consumer(e.Member, e.Score)
+		})
+	case o.set != nil:
+		o.set.ForEach(func(member string) bool {
+			return consumer(member, 1)
+		})
+	}
+}
+
+func (db *DB) getZSetOperands(keys []string) ([]zsetOperand, *protocol.ErrReply) {
+	operands := make([]zsetOperand, len(keys))
+	for i, key := range keys {
+		entity, ok := db.getEntity(key)
+		if !ok {
+			continue
+		}
+		switch v := entity.(type) {
+		case *sortedset.SortedSet:
+			operands[i].zset = v
+		case *set.Set:
+			operands[i].set = v
+		default:
+			return nil, protocol.NewWrongTypeErrReply()
+		}
+	}
+	return operands, nil
+}
+
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+func aggregate(mode int, a, b float64) float64 {
+	switch mode {
+	case aggregateMin:
+		return math.Min(a, b)
+	case aggregateMax:
+		return math.Max(a, b)
+	}
+	// 与redis相同，inf与-inf相加得到0
+	if sum := a + b; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// weighted multiplies score by weight, 0 * inf is 0 like in redis
+func weighted(score, weight float64) float64 {
+	if result := score * weight; !math.IsNaN(result) {
+		return result
+	}
+	return 0
+}
+
+type zsetOpSpec struct {
+	keys       []string
+	weights    []float64
+	aggregate  int
+	withScores bool
+}
+
+// parseZSetOp parses numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE <SUM | MIN | MAX>] [WITHSCORES],
+// ZDIFF only accepts WITHSCORES and the STORE variants don't accept WITHSCORES
+func parseZSetOp(cmdName string, args [][]byte, isDiff, isStore bool) (*zsetOpSpec, *protocol.ErrReply) {
+	n, ok := parseInt(args[0])
+	if !ok {
+		return nil, protocol.NewNotIntegerErrReply()
+	}
+	if n < 1 {
+		return nil, protocol.NewErrReply("ERR at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if n > int64(len(args)-1) {
+		return nil, protocol.NewSyntaxErrReply()
+	}
+	spec := &zsetOpSpec{keys: make([]string, n), weights: make([]float64, n)}
+	for i := range spec.keys {
+		spec.keys[i] = string(args[i+1])
+		spec.weights[i] = 1
+	}
+
+	rest := args[n+1:]
+	for i := 0; i < len(rest); i++ {
+		option := strings.ToUpper(string(rest[i]))
+		switch {
+		case option == "WEIGHTS" && !isDiff:
+			if i+int(n) >= len(rest) {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			for j := range spec.weights {
+				w, ok := parseFloat(rest[i+1+j])
+				if !ok {
+					return nil, protocol.NewErrReply("ERR weight value is not a float")
+				}
+				spec.weights[j] = w
+			}
+			i += int(n)
+		case option == "AGGREGATE" && !isDiff:
+			if i+1 >= len(rest) {
+				return nil, protocol.NewSyntaxErrReply()
+			}
+			i++
+			switch strings.ToUpper(string(rest[i])) {
+			case "SUM":
+				spec.aggregate = aggregateSum
+			case "MIN":
+				spec.aggregate = aggregateMin
+			case "MAX":
+				spec.aggregate = aggregateMax
+			default:
+				return nil, protocol.NewSyntaxErrReply()
+			}
+		case option == "WITHSCORES" && !isStore:
+			spec.withScores = true
+		default:
+			return nil, protocol.NewSyntaxErrReply()
+		}
+	}
+	return spec, nil
+}
+
+func prepareZSetOp(args [][]byte) ([]string, []string) {
+	keys, _, _ := parseNumKeys(args)
+	return nil, keys
+}
+
+func prepareZSetOpStore(args [][]byte) ([]string, []string) {
+	keys, _, _ := parseNumKeys(args[1:])
+	return []string{string(args[0])}, keys
+}
+
+const (
+	zsetUnion = iota
+	zsetInter
+	zsetDiff
+)
+
+// zsetOp computes the union, intersection or difference of the operands, sorted by score then member
+func zsetOp(op int, operands []zsetOperand, spec *zsetOpSpec) []sortedset.Element {
+	scores := make(map[string]float64)
+	switch op {
+	case zsetUnion:
+		for i, o := range operands {
+			o.forEach(func(member string, score float64) bool {
+				score = weighted(score, spec.weights[i])
+				if old, ok := scores[member]; ok {
+					score = aggregate(spec.aggregate, old, score)
+				}
+				scores[member] = score
+				return true
+			})
+		}
+	case zsetInter:
+		// 从最小的集合开始遍历
+		order := make([]int, len(operands))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool {
+			return operands[order[a]].len() < operands[order[b]].len()
+		})
+		first := order[0]
+		operands[first].forEach(func(member string, score float64) bool {
+			score = weighted(score, spec.weights[first])
+			for _, i := range order[1:] {
+				other, ok := operands[i].get(member)
+				if !ok {
+					return true
+				}
+				score = aggregate(spec.aggregate, score, weighted(other, spec.weights[i]))
+			}
+			scores[member] = score
+			return true
+		})
+	case zsetDiff:
+		operands[0].forEach(func(member string, score float64) bool {
+			for _, o := range operands[1:] {
+				if _, ok := o.get(member); ok {
+					return true
+				}
+			}
+			scores[member] = score
+			return true
+		})
+	}
+
+	elements := make([]sortedset.Element, 0, len(scores))
+	for member, score := range scores {
+		elements = append(elements, sortedset.Element{Member: member, Score: score})
+	}
+	sort.Slice(elements, func(i, j int) bool {
+		if elements[i].Score != elements[j].Score {
+			return elements[i].Score < elements[j].Score
+		}
+		return elements[i].Member < elements[j].Member
+	})
+	return elements
+}
+
+// storeZSet replaces destination with a sorted set of elements, an empty result deletes destination.
+// event is published for destination, e.g. zunionstore.
+func storeZSet(db *DB, dst string, elements []sortedset.Element, event string) protocol.Reply {
+	_, existed := db.removeKey(dst)
+	if len(elements) == 0 {
+		if existed {
+			db.notify(notifyGeneric, "del", dst)
+		}
+		return protocol.NewIntReply(0)
+	}
+	z := newZSet()
+	for _, e := range elements {
+		z.Add(e.Member, e.Score)
+	}
+	db.putEntity(dst, z)
+	db.notify(notifyZSet, event, dst)
+	return protocol.NewIntReply(int64(z.Len()))
+}
+
+func zsetOpGeneric(db *DB, cmdName string, op int, args [][]byte) protocol.Reply {
+	spec, errReply := parseZSetOp(cmdName, args, op == zsetDiff, false)
+	if errReply != nil {
+		return errReply
+	}
+	operands, errReply := db.getZSetOperands(spec.keys)
+	if errReply != nil {
+		return errReply
+	}
+	return elementsReply(zsetOp(op, operands, spec), spec.withScores)
+}
+
+func zsetOpStoreGeneric(db *DB, cmdName string, op int, args [][]byte) protocol.Reply {
+	spec, errReply := parseZSetOp(cmdName, args[1:], op == zsetDiff, true)
+	if errReply != nil {
+		return errReply
+	}
+	operands, errReply := db.getZSetOperands(spec.keys)
+	if errReply != nil {
+		return errReply
+	}
+	return storeZSet(db, string(args[0]), zsetOp(op, operands, spec), cmdName)
+}
+
+// execZUnion ZUNION numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE <SUM | MIN | MAX>] [WITHSCORES]
+func execZUnion(db *DB, args [][]byte) protocol.Reply {
+	return zsetOpGeneric(db, "zunion", zsetUnion, args)
+}
+
+// execZInter ZINTER numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE <SUM | MIN | MAX>] [WITHSCORES]
+func execZInter(db *DB, args [][]byte) protocol.Reply {
+	return zsetOpGeneric(db, "zinter", zsetInter, args)
+}
+
+// execZDiff ZDIFF numkeys key [key ...] [WITHSCORES]
+func execZDiff(db *DB, args [][]byte) protocol.Reply {
+	return zsetOpGeneric(db, "zdiff", zsetDiff, args)
+}
+
+// execZUnionStore ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE <SUM | MIN | MAX>]
+func execZUnionStore(db *DB, args [][]byte) protocol.Reply {
+	return zsetOpStoreGeneric(db, "zunionstore", zsetUnion, args)
+}
+
+// execZInterStore ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE <SUM | MIN | MAX>]
+func execZInterStore(db *DB, args [][]byte) protocol.Reply {
+	return zsetOpStoreGeneric(db, "zinterstore", zsetInter, args)
+}
+
+// execZDiffStore ZDIFFSTORE destination numkeys key [key ...]
+func execZDiffStore(db *DB, args [][]byte) protocol.Reply {
+	return zsetOpStoreGeneric(db, "zdiffstore", zsetDiff, args)
+}
+
+// execZInterCard ZINTERCARD numkeys key [key ...] [LIMIT limit]
+func execZInterCard(db *DB, args [][]byte) protocol.Reply {
+	keys, rest, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return errReply
+	}
+	limit := int64(0)
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "LIMIT" {
+			return protocol.NewSyntaxErrReply()
+		}
+		var ok bool
+		limit, ok = parseInt(rest[1])
+		if !ok {
+			return protocol.NewNotIntegerErrReply()
+		}
+		if limit < 0 {
+			return protocol.NewErrReply("ERR LIMIT can't be negative")
+		}
+	}
+	operands, errReply := db.getZSetOperands(keys)
+	if errReply != nil {
+		return errReply
+	}
+	spec := &zsetOpSpec{keys: keys, weights: make([]float64, len(keys))}
+	n := int64(len(zsetOp(zsetInter, operands, spec)))
+	if limit > 0 {
+		n = min(n, limit)
+	}
+	return protocol.NewIntReply(n)
+}
+
+// execZScan ZSCAN key cursor [MATCH pattern] [COUNT count]
+func execZScan(db *DB, args [][]byte) protocol.Reply {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.NewErrReply("ERR invalid cursor")
+	}
+	pattern, count, _, errReply := parseScanOptions(args[2:], false)
+	if errReply != nil {
+		return errReply
+	}
+	z, errReply := db.getAsZSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	var elements []sortedset.Element
+	next := 0
+	if z != nil {
+		elements, next = z.Scan(int(cursor), count, pattern)
+		if next < 0 {
+			return protocol.NewErrReply("ERR illegal wildcard")
+		}
+	}
+	return protocol.NewArrayReply([]protocol.Reply{
+		protocol.NewBulkReply([]byte(strconv.Itoa(next))),
+		elementsReply(elements, true),
+	})
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"godis/resp/connection"
+	"testing"
+)
+
+func TestZAdd(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, ":3\r\n", exec(s, c, "zadd z 1 a 2 b 3 c"))
+	assertReply(t, "+zset\r\n", exec(s, c, "type z"))
+	assertReply(t, ":0\r\n", exec(s, c, "zadd z nx 10 a"))
+	assertReply(t, ":1\r\n", exec(s, c, "zadd z ch xx 10 a 5 d"))
+	assertReply(t, ":0\r\n", exec(s, c, "zadd z ch gt 1 a"))
+	assertReply(t, ":1\r\n", exec(s, c, "zadd z ch lt 0.5 a"))
+	assertReply(t, "$3\r\n2.5\r\n", exec(s, c, "zadd z incr 2 a"))
+	assertReply(t, "$-1\r\n", exec(s, c, "zadd z nx incr 2 a"))
+	assertReply(t, "$-1\r\n", exec(s, c, "zadd nokey xx incr 2 a"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists nokey"))
+	assertReply(t, "$3\r\n4.5\r\n", exec(s, c, "zincrby z 2 a"))
+	assertReply(t, "$3\r\n4.5\r\n", exec(s, c, "zscore z a"))
+	assertReply(t, "*2\r\n$1\r\n2\r\n$-1\r\n", exec(s, c, "zmscore z b nope"))
+	assertReply(t, ":3\r\n", exec(s, c, "zcard z"))
+
+	assertReply(t, "-ERR XX and NX options at the same time are not compatible\r\n", exec(s, c, "zadd z nx xx 1 a"))
+	assertReply(t, "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n", exec(s, c, "zadd z gt lt 1 a"))
+	assertReply(t, "-ERR INCR option supports a single increment-element pair\r\n", exec(s, c, "zadd z incr 1 a 2 b"))
+	assertReply(t, "-ERR value is not a valid float\r\n", exec(s, c, "zadd z x a"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "zadd z nx 1"))
+	exec(s, c, "zadd z inf x")
+	assertReply(t, "-ERR resulting score is not a number (NaN)\r\n", exec(s, c, "zincrby z -inf x"))
+	assertReply(t, "$3\r\ninf\r\n", exec(s, c, "zscore z x"))
+
+	assertReply(t, ":2\r\n", exec(s, c, "zrem z a x nope"))
+	assertReply(t, ":2\r\n", exec(s, c, "zrem z b c"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists z"))
+}
+
+func TestZRange(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "zadd z 1 a 2 b 3 c 4 d 5 e")
+	assertReply(t, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n", exec(s, c, "zrange z 1 2"))
+	assertReply(t, "*4\r\n$1\r\nd\r\n$1\r\n4\r\n$1\r\nc\r\n$1\r\n3\r\n", exec(s, c, "zrange z 1 2 rev withscores"))
+	assertReply(t, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n", exec(s, c, "zrange z (1 3 byscore"))
+	assertReply(t, "*2\r\n$1\r\nd\r\n$1\r\nc\r\n", exec(s, c, "zrange z +inf -inf byscore rev limit 1 2"))
+	assertReply(t, "*2\r\n$1\r\nc\r\n$1\r\nd\r\n", exec(s, c, "zrange z -inf +inf byscore limit 2 2"))
+	assertReply(t, "*3\r\n$1\r\nc\r\n$1\r\nd\r\n$1\r\ne\r\n", exec(s, c, "zrange z -inf +inf byscore limit 2 -1"))
+	assertReply(t, "*2\r\n$1\r\ne\r\n$1\r\nd\r\n", exec(s, c, "zrevrange z 0 1"))
+	assertReply(t, "*2\r\n$1\r\nd\r\n$1\r\n4\r\n", exec(s, c, "zrangebyscore z 4 (5 withscores"))
+	assertReply(t, "*1\r\n$1\r\nb\r\n", exec(s, c, "zrevrangebyscore z 2 -inf limit 0 1"))
+	assertReply(t, "-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n", exec(s, c, "zrange z 0 1 limit 0 1"))
+	assertReply(t, "-ERR min or max is not a float\r\n", exec(s, c, "zrange z x 1 byscore"))
+	assertReply(t, ":3\r\n", exec(s, c, "zcount z (1 4"))
+
+	assertReply(t, ":1\r\n", exec(s, c, "zrank z b"))
+	assertReply(t, ":3\r\n", exec(s, c, "zrevrank z b"))
+	assertReply(t, "*2\r\n:1\r\n$1\r\n2\r\n", exec(s, c, "zrank z b withscore"))
+	assertReply(t, "$-1\r\n", exec(s, c, "zrank z nope"))
+	assertReply(t, "*-1\r\n", exec(s, c, "zrank z nope withscore"))
+
+	assertReply(t, ":3\r\n", exec(s, c, "zrangestore dst z 3 +inf byscore"))
+	assertReply(t, "*2\r\n$1\r\nc\r\n$1\r\nd\r\n", exec(s, c, "zrange dst 0 1"))
+	assertReply(t, ":0\r\n", exec(s, c, "zrangestore dst z 10 20 byscore"))
+	assertReply(t, ":0\r\n", exec(s, c, "exists dst"))
+
+	exec(s, c, "zadd lex 0 a 0 b 0 c 0 d")
+	assertReply(t, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n", exec(s, c, "zrange lex [b (d bylex"))
+	assertReply(t, "*2\r\n$1\r\nd\r\n$1\r\nc\r\n", exec(s, c, "zrevrangebylex lex + [c"))
+	assertReply(t, ":4\r\n", exec(s, c, "zlexcount lex - +"))
+	assertReply(t, "-ERR min or max not valid string range item\r\n", exec(s, c, "zlexcount lex a +"))
+	assertReply(t, "-ERR syntax error, WITHSCORES not supported in combination with BYLEX\r\n", exec(s, c, "zrange lex - + bylex withscores"))
+	assertReply(t, ":2\r\n", exec(s, c, "zremrangebylex lex - (c"))
+	assertReply(t, ":2\r\n", exec(s, c, "zremrangebyrank z 0 1"))
+	assertReply(t, ":2\r\n", exec(s, c, "zremrangebyscore z 4 +inf"))
+	assertReply(t, "*1\r\n$1\r\nc\r\n", exec(s, c, "zrange z 0 -1"))
+}
+
+func TestZPop(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "zadd z 1 a 2 b 3 c")
+	assertReply(t, "*2\r\n$1\r\na\r\n$1\r\n1\r\n", exec(s, c, "zpopmin z"))
+	assertReply(t, "*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nb\r\n$1\r\n2\r\n", exec(s, c, "zpopmax z 5"))
+	assertReply(t, "*0\r\n", exec(s, c, "zpopmin z"))
+	assertReply(t, "-ERR value is out of range, must be positive\r\n", exec(s, c, "zpopmin z -1"))
+
+	exec(s, c, "zadd z2 1 a 2 b")
+	assertReply(t, "*2\r\n$2\r\nz2\r\n*2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n", exec(s, c, "zmpop 2 z z2 max count 3"))
+	assertReply(t, "*-1\r\n", exec(s, c, "zmpop 1 z2 min"))
+
+	blocked := connection.NewFakeConn()
+	ch := execAsync(t, s, blocked, "bzpopmin z z2 0")
+	exec(s, c, "zadd z2 5 x 4 y")
+	assertReply(t, "*3\r\n$2\r\nz2\r\n$1\r\ny\r\n$1\r\n4\r\n", receive(t, ch))
+	ch = execAsync(t, s, blocked, "bzmpop 0 1 z max")
+	exec(s, c, "zadd z 1 a")
+	assertReply(t, "*2\r\n$1\r\nz\r\n*1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n", receive(t, ch))
+	assertReply(t, "*-1\r\n", exec(s, c, "bzpopmax nokey 0.01"))
+
+	// 阻塞在同一个key上的客户端按先后顺序唤醒
+	other := connection.NewFakeConn()
+	ch = execAsync(t, s, blocked, "bzpopmax z3 0")
+	ch2 := execAsync(t, s, other, "bzpopmin z3 0")
+	exec(s, c, "zadd z3 1 a 2 b")
+	assertReply(t, "*3\r\n$2\r\nz3\r\n$1\r\nb\r\n$1\r\n2\r\n", receive(t, ch))
+	assertReply(t, "*3\r\n$2\r\nz3\r\n$1\r\na\r\n$1\r\n1\r\n", receive(t, ch2))
+	assertReply(t, ":0\r\n", exec(s, c, "exists z3"))
+}
+
+func TestZSetAlgebra(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	exec(s, c, "zadd a 1 x 2 y 3 z")
+	exec(s, c, "zadd b 10 y 20 z 30 w")
+	exec(s, c, "sadd s x y")
+	assertReply(t, "*4\r\n$1\r\ny\r\n$2\r\n12\r\n$1\r\nz\r\n$2\r\n23\r\n", exec(s, c, "zinter 2 a b withscores"))
+	assertReply(t, "*4\r\n$1\r\ny\r\n$2\r\n10\r\n$1\r\nz\r\n$2\r\n20\r\n", exec(s, c, "zinter 2 a b aggregate max withscores"))
+	assertReply(t, "*4\r\n$1\r\nx\r\n$1\r\n3\r\n$1\r\ny\r\n$1\r\n4\r\n", exec(s, c, "zinter 2 a s weights 1 2 withscores"))
+	assertReply(t, "*4\r\n$1\r\nx\r\n$1\r\ny\r\n$1\r\nz\r\n$1\r\nw\r\n", exec(s, c, "zunion 2 a b"))
+	assertReply(t, "*2\r\n$1\r\nx\r\n$1\r\n1\r\n", exec(s, c, "zdiff 2 a b withscores"))
+	assertReply(t, ":4\r\n", exec(s, c, "zunionstore dst 2 a b aggregate min"))
+	assertReply(t, "*8\r\n$1\r\nx\r\n$1\r\n1\r\n$1\r\ny\r\n$1\r\n2\r\n$1\r\nz\r\n$1\r\n3\r\n$1\r\nw\r\n$2\r\n30\r\n", exec(s, c, "zrange dst 0 -1 withscores"))
+	assertReply(t, ":1\r\n", exec(s, c, "zdiffstore dst 2 a b"))
+	assertReply(t, ":2\r\n", exec(s, c, "zintercard 2 a b"))
+	assertReply(t, ":1\r\n", exec(s, c, "zintercard 2 a b limit 1"))
+	assertReply(t, "-ERR at least 1 input key is needed for 'zunionstore' command\r\n", exec(s, c, "zunionstore dst 0 a"))
+	assertReply(t, "-ERR weight value is not a float\r\n", exec(s, c, "zunion 2 a b weights 1 x"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "zdiff 2 a b weights 1 1"))
+	assertReply(t, "-ERR syntax error\r\n", exec(s, c, "zinterstore dst 2 a b withscores"))
+	exec(s, c, "set str v")
+	assertReply(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", exec(s, c, "zunion 2 a str"))
+	assertReply(t, "*3\r\n$3\r\ndst\r\n$1\r\na\r\n$1\r\nb\r\n", exec(s, c, "command getkeys zunionstore dst 2 a b weights 1 2"))
+}
+
+func TestZRandMemberAndScan(t *testing.T) {
+	s := newTestServer(t)
+	c := connection.NewFakeConn()
+
+	assertReply(t, "$-1\r\n", exec(s, c, "zrandmember z"))
+	exec(s, c, "zadd z 1 a")
+	assertReply(t, "*2\r\n$1\r\na\r\n$1\r\n1\r\n", exec(s, c, "zrandmember z 3 withscores"))
+	assertReply(t, "*2\r\n$1\r\na\r\n$1\r\na\r\n", exec(s, c, "zrandmember z -2"))
+
+	exec(s, c, "zadd z 2 b 3 ab")
+	assertReply(t, "*2\r\n$1\r\n0\r\n*4\r\n$1\r\na\r\n$1\r\n1\r\n$2\r\nab\r\n$1\r\n3\r\n", exec(s, c, "zscan z 0 match a*"))
+}
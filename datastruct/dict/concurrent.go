@@ -2,8 +2,8 @@ package dict
 
 import (
 	"godis/pkg/wildcard"
-	"hash/fnv"
 	"math"
+	"math/bits"
 	"math/rand"
 	"sort"
 	"sync"
@@ -16,10 +16,12 @@ type ConcurrentDict struct {
 	table     []*shard
 	count     int64
 	tableSize int
+	// log2(tableSize)，hash的低shardBits位用于选择分片
+	shardBits int
 }
 
 type shard struct {
-	m  map[string]any
+	m  *hashTable
 	mu sync.RWMutex
 }
 
@@ -43,26 +45,34 @@ func computeCapacity(param int) int {
 
 func NewConcurrentDict(shardCount int) *ConcurrentDict {
 	shardCount = computeCapacity(shardCount)
+	shardBits := bits.TrailingZeros(uint(shardCount))
 	table := make([]*shard, shardCount)
 	for i := 0; i < shardCount; i++ {
 		table[i] = &shard{
-			m: make(map[string]any),
+			m: newHashTable(shardBits),
 		}
 	}
 	return &ConcurrentDict{
 		table:     table,
 		count:     0,
 		tableSize: shardCount,
+		shardBits: shardBits,
 	}
 }
 
-func (dict *ConcurrentDict) fnv32(key string) (uint32, error) {
-	h := fnv.New32()
-	_, err := h.Write([]byte(key))
-	if err != nil {
-		return 0, err
+const (
+	offset32 = 2166136261
+	prime32  = 16777619
+)
+
+// fnv32 is the FNV-1 hash of key, computed inline to avoid allocating a hash.Hash per lookup
+func fnv32(key string) uint32 {
+	hash := uint32(offset32)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
 	}
-	return h.Sum32(), nil
+	return hash
 }
 
 func (dict *ConcurrentDict) Len() int {
@@ -70,103 +80,98 @@ func (dict *ConcurrentDict) Len() int {
 }
 
 func (dict *ConcurrentDict) Get(key string) (val any, exist bool) {
-	shard := dict.getShard(key)
+	hash := fnv32(key)
+	shard := dict.getShard(hash)
 
 	shard.mu.RLock()
 	defer shard.mu.RUnlock()
 
-	val, exist = shard.m[key]
-	return
+	return shard.m.get(key, hash)
 }
 
 func (dict *ConcurrentDict) GetWithoutLock(key string) (val any, exist bool) {
-	shard := dict.getShard(key)
-
-	val, exist = shard.m[key]
-	return
+	hash := fnv32(key)
+	return dict.getShard(hash).m.get(key, hash)
 }
 
 func (dict *ConcurrentDict) Put(key string, val any) int {
-	shard := dict.getShard(key)
+	hash := fnv32(key)
+	shard := dict.getShard(hash)
 
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
-	if _, exist := shard.m[key]; !exist {
-		shard.m[key] = val
-		return 0
-	}
-	dict.addCount()
-	shard.m[key] = val
-	return 1
+	return dict.put(shard, key, hash, val)
 }
 
 func (dict *ConcurrentDict) PutWithoutLock(key string, val any) int {
-	shard := dict.getShard(key)
+	hash := fnv32(key)
+	return dict.put(dict.getShard(hash), key, hash, val)
+}
 
-	if _, exist := shard.m[key]; !exist {
-		shard.m[key] = val
-		return 0
+func (dict *ConcurrentDict) put(shard *shard, key string, hash uint32, val any) int {
+	if shard.m.put(key, hash, val) {
+		dict.addCount()
+		return 1
 	}
-	dict.addCount()
-	shard.m[key] = val
-	return 1
+	return 0
 }
 
 func (dict *ConcurrentDict) PutIfAbsent(key string, val any) int {
-	shard := dict.getShard(key)
+	hash := fnv32(key)
+	shard := dict.getShard(hash)
 
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
-	if _, exist := shard.m[key]; exist {
-		return 0
-	}
-	shard.m[key] = val
-	dict.addCount()
-	return 1
+	return dict.putIfAbsent(shard, key, hash, val)
 }
 
 func (dict *ConcurrentDict) PutIfAbsentWithoutLock(key string, val any) int {
-	shard := dict.getShard(key)
+	hash := fnv32(key)
+	return dict.putIfAbsent(dict.getShard(hash), key, hash, val)
+}
 
-	if _, exist := shard.m[key]; exist {
+func (dict *ConcurrentDict) putIfAbsent(shard *shard, key string, hash uint32, val any) int {
+	if _, exist := shard.m.get(key, hash); exist {
 		return 0
 	}
-	shard.m[key] = val
+	shard.m.put(key, hash, val)
 	dict.addCount()
 	return 1
 }
 
 func (dict *ConcurrentDict) PutIfExists(key string, val any) int {
-	shard := dict.getShard(key)
+	hash := fnv32(key)
+	shard := dict.getShard(hash)
 
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
-	if _, exist := shard.m[key]; !exist {
-		return 0
-	}
-	shard.m[key] = val
-	return 1
+	return dict.putIfExists(shard, key, hash, val)
 }
 
 func (dict *ConcurrentDict) PutIfExistsWithoutLock(key string, val any) int {
-	shard := dict.getShard(key)
-	if _, exist := shard.m[key]; !exist {
+	hash := fnv32(key)
+	return dict.putIfExists(dict.getShard(hash), key, hash, val)
+}
+
+func (dict *ConcurrentDict) putIfExists(shard *shard, key string, hash uint32, val any) int {
+	e := shard.m.find(key, hash)
+	if e == nil {
 		return 0
 	}
-	shard.m[key] = val
+	e.val = val
 	return 1
 }
 
 func (dict *ConcurrentDict) Remove(key string) (any, int) {
-	shard := dict.getShard(key)
+	hash := fnv32(key)
+	shard := dict.getShard(hash)
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
-	val, exist := shard.m[key]
+	val, exist := shard.m.remove(key, hash)
 	if exist {
-		delete(shard.m, key)
 		dict.decreaseCount()
 		return val, 1
 	}
@@ -174,10 +179,9 @@ func (dict *ConcurrentDict) Remove(key string) (any, int) {
 }
 
 func (dict *ConcurrentDict) RemoveWithoutLock(key string) (val any, exist bool) {
-	shard := dict.getShard(key)
-	val, exist = shard.m[key]
+	hash := fnv32(key)
+	val, exist = dict.getShard(hash).m.remove(key, hash)
 	if exist {
-		delete(shard.m, key)
 		dict.decreaseCount()
 	}
 	return
@@ -188,12 +192,7 @@ func (dict *ConcurrentDict) ForEach(consumer Consumer) {
 		shard.mu.RLock()
 		f := func() bool {
 			defer shard.mu.RUnlock()
-			for key, val := range shard.m {
-				if !consumer(key, val) {
-					return false
-				}
-			}
-			return true
+			return shard.m.forEach(consumer)
 		}
 		if !f() {
 			return
@@ -210,17 +209,29 @@ func (dict *ConcurrentDict) Keys() []string {
 	return keys
 }
 
-func (shard *shard) RandomKey() string {
+// RandomKey returns a random key of the shard, false if the shard is empty
+func (shard *shard) RandomKey() (string, bool) {
 	shard.mu.RLock()
 	defer shard.mu.RUnlock()
-	for key := range shard.m {
-		return key
+	return shard.m.randomKey()
+}
+
+// RandomKey returns a random key, false if the dict is empty
+func (dict *ConcurrentDict) RandomKey() (string, bool) {
+	// 空字符串也是合法的key，只能通过ok判断分片是否为空
+	for dict.Len() > 0 {
+		if key, ok := dict.table[rand.Intn(dict.tableSize)].RandomKey(); ok {
+			return key, true
+		}
 	}
-	return "" // Return empty string if no key
+	return "", false
 }
 
 func (dict *ConcurrentDict) RandomKeys(limit int) []string {
 	size := dict.Len()
+	if size == 0 {
+		return nil
+	}
 	if limit > size {
 		return dict.Keys()
 	}
@@ -228,8 +239,7 @@ func (dict *ConcurrentDict) RandomKeys(limit int) []string {
 	result := make([]string, 0, limit)
 	for i := 0; i < limit; {
 		s := dict.table[rand.Intn(dict.tableSize)]
-		key := s.RandomKey()
-		if key != "" {
+		if key, ok := s.RandomKey(); ok {
 			result = append(result, key)
 			i++
 		}
@@ -239,6 +249,9 @@ func (dict *ConcurrentDict) RandomKeys(limit int) []string {
 
 func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 	size := dict.Len()
+	if size == 0 {
+		return nil
+	}
 	if limit > size {
 		return dict.Keys()
 	}
@@ -246,8 +259,7 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 	result := make(map[string]struct{}, limit)
 	for len(result) < limit {
 		s := dict.table[rand.Intn(dict.tableSize)]
-		key := s.RandomKey()
-		if key != "" {
+		if key, ok := s.RandomKey(); ok {
 			if _, exists := result[key]; !exists {
 				result[key] = struct{}{}
 			}
@@ -268,7 +280,7 @@ func (dict *ConcurrentDict) Clear() {
 func (dict *ConcurrentDict) toLockIndices(keys []string, reverse bool) []int {
 	indexMap := make(map[int]struct{})
 	for _, key := range keys {
-		index := dict.spreadKey(key)
+		index := dict.spread(fnv32(key))
 		indexMap[index] = struct{}{}
 	}
 
@@ -286,89 +298,120 @@ func (dict *ConcurrentDict) toLockIndices(keys []string, reverse bool) []int {
 	return indices
 }
 
-func (dict *ConcurrentDict) RWLocks(writeKeys []string, readKeys []string) {
-	wIndices := dict.toLockIndices(writeKeys, false)
-	rIndices := dict.toLockIndices(readKeys, false)
-
-	for _, index := range wIndices {
-		dict.table[index].mu.Lock()
+// toRWLockIndices returns the shards of the given keys sorted by index, the value tells whether the
+// shard must be write locked. A shard holding both write keys and read keys is only write locked.
+func (dict *ConcurrentDict) toRWLockIndices(writeKeys []string, readKeys []string, reverse bool) ([]int, map[int]bool) {
+	writeMap := make(map[int]bool)
+	for _, index := range dict.toLockIndices(readKeys, reverse) {
+		writeMap[index] = false
 	}
-	for _, index := range rIndices {
-		dict.table[index].mu.RLock()
+	for _, index := range dict.toLockIndices(writeKeys, reverse) {
+		writeMap[index] = true
 	}
-}
 
-func (dict *ConcurrentDict) RWUnlocks(writeKeys []string, readKeys []string) {
-	wIndices := dict.toLockIndices(writeKeys, true)
-	rIndices := dict.toLockIndices(readKeys, true)
+	indices := lo.Keys(writeMap)
+	sort.Slice(indices, func(i, j int) bool {
+		if reverse {
+			return indices[i] > indices[j]
+		}
+		return indices[i] < indices[j]
+	})
+	return indices, writeMap
+}
 
-	for _, index := range rIndices {
-		dict.table[index].mu.RUnlock()
+// RWLocks locks the shards of the given keys in ascending shard order so concurrent callers never deadlock
+func (dict *ConcurrentDict) RWLocks(writeKeys []string, readKeys []string) {
+	indices, writeMap := dict.toRWLockIndices(writeKeys, readKeys, false)
+	for _, index := range indices {
+		if writeMap[index] {
+			dict.table[index].mu.Lock()
+		} else {
+			dict.table[index].mu.RLock()
+		}
 	}
-	for _, index := range wIndices {
-		dict.table[index].mu.Unlock()
+}
+
+func (dict *ConcurrentDict) RWUnlocks(writeKeys []string, readKeys []string) {
+	indices, writeMap := dict.toRWLockIndices(writeKeys, readKeys, true)
+	for _, index := range indices {
+		if writeMap[index] {
+			dict.table[index].mu.Unlock()
+		} else {
+			dict.table[index].mu.RUnlock()
+		}
 	}
 }
 
 // DictScan scans the dictionary for keys matching the given pattern.
+// The cursor walks the hash space in reverse binary order like redis dictScan: the low hash bits
+// select the shard and the bits above them select a bucket of the shard's table. Every key present
+// during a whole iteration is returned at least once even if shard tables resize between calls,
+// and each call visits about count keys.
 // return 0 if all keys have been scanned,
 // return -1 if the pattern is invalid,
 // return the next cursor position if there are more keys to scan.
 func (dict *ConcurrentDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
-	// size := dict.Len()
 	result := make([][]byte, 0)
-
-	if pattern == "*" {
-		return lo.Map(dict.Keys(), func(key string, _ int) []byte {
-			return []byte(key)
-		}), 0
+	if cursor < 0 || cursor > math.MaxUint32 {
+		return result, 0
 	}
 
-	exp, err := wildcard.Compile(pattern)
-	if err != nil {
-		return result, -1
+	var exp *wildcard.Pattern
+	if pattern != "*" {
+		var err error
+		exp, err = wildcard.Compile(pattern)
+		if err != nil {
+			return result, -1
+		}
+	}
+	if count <= 0 {
+		count = 10
+	}
+	if dict.Len() == 0 {
+		return result, 0
 	}
 
-	size := dict.tableSize
-	shardIdx := cursor
-
-	for shardIdx < size {
-		shard := dict.table[shardIdx]
+	shardMask := uint32(dict.tableSize - 1)
+	v := uint32(cursor)
+	// 与redis相同，单次调用最多访问count*10个桶，避免大量空桶时耗时过长
+	visited, maxIterations := 0, count*10
+	for {
+		shard := dict.table[v&shardMask]
 		shard.mu.RLock()
-
-		if len(result)+len(shard.m) > count && shardIdx > cursor {
-			// If we have enough results, return them
+		table := shard.m
+		if table.count == 0 {
 			shard.mu.RUnlock()
-			return result, shardIdx
+			// 空的分片一次跳过其余所有桶，不计入访问的桶数
+			v = nextCursor(v&shardMask, shardMask)
+			if v == 0 {
+				break
+			}
+			continue
 		}
-
-		for key := range shard.m {
-			if pattern == "*" || exp.Match(key) {
-				result = append(result, []byte(key))
+		for e := table.buckets[(v>>dict.shardBits)&table.mask]; e != nil; e = e.next {
+			visited++
+			if exp == nil || exp.Match(e.key) {
+				result = append(result, []byte(e.key))
 			}
 		}
-
+		mask := table.mask<<dict.shardBits | shardMask
 		shard.mu.RUnlock()
-		shardIdx++
-	}
 
-	return result, 0
-}
-
-func (dict *ConcurrentDict) getShard(key string) *shard {
-	return dict.table[dict.spreadKey(key)]
+		v = nextCursor(v, mask)
+		maxIterations--
+		if v == 0 || visited >= count || maxIterations <= 0 {
+			break
+		}
+	}
+	return result, int(v)
 }
 
-func (dict *ConcurrentDict) spreadKey(key string) int {
-	hash, err := dict.fnv32(key)
-	if err != nil {
-		panic(err)
-	}
-	return dict.spread(hash)
+func (dict *ConcurrentDict) getShard(hash uint32) *shard {
+	return dict.table[dict.spread(hash)]
 }
 
 func (dict *ConcurrentDict) spread(hashCode uint32) int {
-	return int(hashCode % uint32(dict.tableSize))
+	return int(hashCode & uint32(dict.tableSize-1))
 }
 
 func (dict *ConcurrentDict) addCount() int64 {
@@ -382,3 +425,27 @@ func (dict *ConcurrentDict) decreaseCount() int32 {
 func (dict *ConcurrentDict) addCountBy(n int64) int64 {
 	return atomic.AddInt64(&dict.count, n)
 }
+
+// ShardCount returns the number of shards, shards are numbered from 0
+func (dict *ConcurrentDict) ShardCount() int {
+	return dict.tableSize
+}
+
+// ShardIndex returns the shard key belongs to
+func (dict *ConcurrentDict) ShardIndex(key string) int {
+	return dict.spread(fnv32(key))
+}
+
+// RLockShard locks a whole shard for reading, the keys in it can't be modified until RUnlockShard
+func (dict *ConcurrentDict) RLockShard(index int) {
+	dict.table[index].mu.RLock()
+}
+
+func (dict *ConcurrentDict) RUnlockShard(index int) {
+	dict.table[index].mu.RUnlock()
+}
+
+// ForEachInShardWithoutLock visits the keys of a shard, the caller must hold the shard's lock
+func (dict *ConcurrentDict) ForEachInShardWithoutLock(index int, consumer Consumer) {
+	dict.table[index].m.forEach(consumer)
+}
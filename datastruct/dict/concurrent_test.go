@@ -0,0 +1,158 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrentDictPut(t *testing.T) {
+	d := NewConcurrentDict(16)
+	assert.Equal(t, 1, d.Put("a", 1))
+	assert.Equal(t, 0, d.Put("a", 2))
+	assert.Equal(t, 1, d.PutIfAbsent("b", 1))
+	assert.Equal(t, 0, d.PutIfAbsent("b", 2))
+	assert.Equal(t, 2, d.Len())
+
+	val, _ := d.Get("a")
+	assert.Equal(t, 2, val)
+
+	_, result := d.Remove("a")
+	assert.Equal(t, 1, result)
+	assert.Equal(t, 1, d.Len())
+}
+
+func TestConcurrentDictRWLocks(t *testing.T) {
+	d := NewConcurrentDict(16)
+	keys := make([]string, 0, 64)
+	for i := 0; i < 64; i++ {
+		keys = append(keys, strconv.Itoa(i))
+	}
+	// 同一分片同时出现在读写集合中时不能死锁
+	d.RWLocks(keys[:32], keys)
+	d.RWUnlocks(keys[:32], keys)
+	d.RWLocks(keys, keys)
+	d.RWUnlocks(keys, keys)
+}
+
+func scanAll(t *testing.T, d *ConcurrentDict, count int, between func(round int)) map[string]struct{} {
+	found := make(map[string]struct{})
+	cursor := 0
+	for round := 0; ; round++ {
+		keys, next := d.DictScan(cursor, count, "*")
+		// 单次返回的数量受count约束，只会因同一个桶的链表稍有超出
+		assert.Less(t, len(keys), count*4)
+		for _, key := range keys {
+			found[string(key)] = struct{}{}
+		}
+		if next == 0 {
+			return found
+		}
+		cursor = next
+		between(round)
+	}
+}
+
+func assertAllFound(t *testing.T, found map[string]struct{}, n int) {
+	for i := 0; i < n; i++ {
+		if _, ok := found["key:"+strconv.Itoa(i)]; !ok {
+			t.Fatalf("key:%d missing from scan", i)
+		}
+	}
+}
+
+func TestDictScanSurvivesResize(t *testing.T) {
+	d := NewConcurrentDict(16)
+	for i := 0; i < 1000; i++ {
+		d.Put("key:"+strconv.Itoa(i), i)
+	}
+
+	// 扫描过程中扩容
+	found := scanAll(t, d, 10, func(round int) {
+		if round == 5 {
+			for i := 0; i < 20000; i++ {
+				d.Put("grow:"+strconv.Itoa(i), i)
+			}
+		}
+	})
+	assertAllFound(t, found, 1000)
+
+	// 扫描过程中缩容
+	found = scanAll(t, d, 50, func(round int) {
+		if round == 20 {
+			for i := 0; i < 20000; i++ {
+				d.Remove("grow:" + strconv.Itoa(i))
+			}
+		}
+	})
+	assertAllFound(t, found, 1000)
+}
+
+func TestDictScanPattern(t *testing.T) {
+	d := NewConcurrentDict(16)
+	for i := 0; i < 100; i++ {
+		d.Put("a:"+strconv.Itoa(i), i)
+		d.Put("b:"+strconv.Itoa(i), i)
+	}
+	found := make(map[string]struct{})
+	cursor := 0
+	for {
+		keys, next := d.DictScan(cursor, 10, "a:*")
+		for _, key := range keys {
+			found[string(key)] = struct{}{}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	assert.Len(t, found, 100)
+
+	_, next := d.DictScan(0, 10, "\\")
+	assert.Equal(t, -1, next)
+}
+
+func TestDictScanEmptyShards(t *testing.T) {
+	d := NewConcurrentDict(1024)
+	keys, next := d.DictScan(0, 10, "*")
+	assert.Empty(t, keys)
+	assert.Equal(t, 0, next)
+
+	// 空的分片不计入单次访问的桶数，一次调用即可扫描完
+	d.Put("a", 1)
+	keys, next = d.DictScan(0, 10, "*")
+	assert.Equal(t, [][]byte{[]byte("a")}, keys)
+	assert.Equal(t, 0, next)
+}
+
+func TestForEachInShard(t *testing.T) {
+	d := NewConcurrentDict(16)
+	for i := 0; i < 100; i++ {
+		d.Put(strconv.Itoa(i), i)
+	}
+	seen := 0
+	for i := 0; i < d.ShardCount(); i++ {
+		d.RLockShard(i)
+		d.ForEachInShardWithoutLock(i, func(key string, _ any) bool {
+			assert.Equal(t, i, d.ShardIndex(key))
+			seen++
+			return true
+		})
+		d.RUnlockShard(i)
+	}
+	assert.Equal(t, 100, seen)
+}
+
+func TestRandomEmptyKey(t *testing.T) {
+	d := NewConcurrentDict(16)
+	_, ok := d.RandomKey()
+	assert.False(t, ok)
+	// 空字符串是唯一的key时也能随机到
+	d.Put("", 1)
+	key, ok := d.RandomKey()
+	assert.True(t, ok)
+	assert.Equal(t, "", key)
+	assert.Equal(t, []string{""}, d.RandomKeys(1))
+	assert.Equal(t, []string{""}, d.RandomDistinctKeys(1))
+}
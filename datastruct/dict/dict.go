@@ -11,6 +11,7 @@ type Dict interface {
 	Remove(key string) (val any, result int)
 	ForEach(consumer Consumer)
 	Keys() []string
+	RandomKey() (string, bool)
 	RandomKeys(limit int) []string
 	RandomDistinctKeys(limit int) []string
 	Clear()
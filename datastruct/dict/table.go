@@ -0,0 +1,161 @@
+package dict
+
+import (
+	"math/bits"
+	"math/rand"
+)
+
+const (
+	minTableSize = 4
+	// 扩容前允许的最大负载因子
+	maxLoadFactor = 1
+	// 负载因子低于 1/minFillRatio 时缩容
+	minFillRatio = 10
+)
+
+type entry struct {
+	key  string
+	val  any
+	hash uint32
+	next *entry
+}
+
+// hashTable is a chained hash table with a power of two bucket count. Unlike the builtin map
+// its buckets can be addressed by hash bits, which is what a stable SCAN cursor needs.
+// Keys are spread to buckets by the hash bits above the shard bits, so every bucket
+// of a shard holds keys sharing the same low hash bits.
+type hashTable struct {
+	buckets []*entry
+	mask    uint32
+	// 低位已用于选择分片，桶下标从该位开始取
+	shift int
+	count int
+}
+
+func newHashTable(shift int) *hashTable {
+	return &hashTable{
+		buckets: make([]*entry, minTableSize),
+		mask:    minTableSize - 1,
+		shift:   shift,
+	}
+}
+
+func (t *hashTable) bucketIndex(hash uint32) uint32 {
+	return (hash >> t.shift) & t.mask
+}
+
+func (t *hashTable) find(key string, hash uint32) *entry {
+	for e := t.buckets[t.bucketIndex(hash)]; e != nil; e = e.next {
+		if e.hash == hash && e.key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+func (t *hashTable) get(key string, hash uint32) (any, bool) {
+	if e := t.find(key, hash); e != nil {
+		return e.val, true
+	}
+	return nil, false
+}
+
+// put sets the value of key, returns true if key is newly inserted
+func (t *hashTable) put(key string, hash uint32, val any) bool {
+	if e := t.find(key, hash); e != nil {
+		e.val = val
+		return false
+	}
+	index := t.bucketIndex(hash)
+	t.buckets[index] = &entry{key: key, val: val, hash: hash, next: t.buckets[index]}
+	t.count++
+	if t.count > len(t.buckets)*maxLoadFactor {
+		t.resize(len(t.buckets) * 2)
+	}
+	return true
+}
+
+func (t *hashTable) remove(key string, hash uint32) (any, bool) {
+	index := t.bucketIndex(hash)
+	var prev *entry
+	for e := t.buckets[index]; e != nil; e = e.next {
+		if e.hash == hash && e.key == key {
+			if prev == nil {
+				t.buckets[index] = e.next
+			} else {
+				prev.next = e.next
+			}
+			t.count--
+			if len(t.buckets) > minTableSize && t.count*minFillRatio < len(t.buckets) {
+				t.resize(len(t.buckets) / 2)
+			}
+			return e.val, true
+		}
+		prev = e
+	}
+	return nil, false
+}
+
+func (t *hashTable) resize(size int) {
+	// 桶下标只能使用分片位以上的hash位
+	if maxSize := 1 << (32 - t.shift); size > maxSize {
+		size = maxSize
+	}
+	if size == len(t.buckets) {
+		return
+	}
+	buckets := make([]*entry, size)
+	mask := uint32(size - 1)
+	for _, e := range t.buckets {
+		for e != nil {
+			next := e.next
+			index := (e.hash >> t.shift) & mask
+			e.next = buckets[index]
+			buckets[index] = e
+			e = next
+		}
+	}
+	t.buckets = buckets
+	t.mask = mask
+}
+
+func (t *hashTable) forEach(consumer Consumer) bool {
+	for _, e := range t.buckets {
+		for ; e != nil; e = e.next {
+			if !consumer(e.key, e.val) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// randomKey picks a random non empty bucket then a random key of its chain
+func (t *hashTable) randomKey() (string, bool) {
+	if t.count == 0 {
+		return "", false
+	}
+	var head *entry
+	for head == nil {
+		head = t.buckets[rand.Intn(len(t.buckets))]
+	}
+	length := 0
+	for e := head; e != nil; e = e.next {
+		length++
+	}
+	e := head
+	for i := rand.Intn(length); i > 0; i-- {
+		e = e.next
+	}
+	return e.key, true
+}
+
+// nextCursor increments the cursor in reverse binary order within mask, the way redis dictScan does.
+// Buckets are visited from the high bits of the mask down, so when the table grows or shrinks
+// between two calls the buckets already visited map to a prefix of the new order.
+func nextCursor(cursor uint32, mask uint32) uint32 {
+	cursor |= ^mask
+	cursor = bits.Reverse32(cursor)
+	cursor++
+	return bits.Reverse32(cursor)
+}
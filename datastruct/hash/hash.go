@@ -0,0 +1,342 @@
+package hash
+
+import (
+	"godis/datastruct/dict"
+	"godis/pkg/wildcard"
+	"math/rand"
+	"time"
+)
+
+// Hash is a field-value map. Small hashes are kept as a flat listpack-like slice of
+// alternating fields and values searched linearly, they are converted to a dict once
+// they grow past maxEntries fields or hold a field or value longer than maxValue bytes.
+// Fields may carry their own expire time, expired fields are invisible to readers and
+// removed by RemoveExpired.
+//
+// Values are never modified in place, callers may keep referencing returned values.
+type Hash struct {
+	listpack [][]byte
+	table    *dict.ConcurrentDict
+	// field -> 过期时间(unix毫秒)，没有字段设置过期时间时为nil
+	expires map[string]int64
+
+	maxEntries int
+	maxValue   int
+}
+
+func New(maxEntries, maxValue int) *Hash {
+	return &Hash{
+		maxEntries: maxEntries,
+		maxValue:   maxValue,
+	}
+}
+
+func now() int64 {
+	return time.Now().UnixMilli()
+}
+
+// Encoding returns the name of the internal encoding: listpack, listpackex while a listpack hash
+// has field expire times, or hashtable once the hash grows past hash-max-listpack-entries or
+// hash-max-listpack-value
+func (h *Hash) Encoding() string {
+	if h.table != nil {
+		return "hashtable"
+	}
+	if len(h.expires) > 0 {
+		return "listpackex"
+	}
+	return "listpack"
+}
+
+func (h *Hash) rawLen() int {
+	if h.table != nil {
+		return h.table.Len()
+	}
+	return len(h.listpack) / 2
+}
+
+// Len returns the number of fields not expired yet
+func (h *Hash) Len() int {
+	n := h.rawLen()
+	if len(h.expires) > 0 {
+		ts := now()
+		for _, at := range h.expires {
+			if at <= ts {
+				n--
+			}
+		}
+	}
+	return n
+}
+
+func (h *Hash) isExpired(field string) bool {
+	if len(h.expires) == 0 {
+		return false
+	}
+	at, ok := h.expires[field]
+	return ok && at <= now()
+}
+
+func (h *Hash) indexOf(field string) int {
+	for i := 0; i < len(h.listpack); i += 2 {
+		if string(h.listpack[i]) == field {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *Hash) rawGet(field string) ([]byte, bool) {
+	if h.table != nil {
+		val, ok := h.table.GetWithoutLock(field)
+		if !ok {
+			return nil, false
+		}
+		return val.([]byte), true
+	}
+	i := h.indexOf(field)
+	if i < 0 {
+		return nil, false
+	}
+	return h.listpack[i+1], true
+}
+
+// Get returns the value of field, expired fields are treated as absent
+func (h *Hash) Get(field string) ([]byte, bool) {
+	val, ok := h.rawGet(field)
+	if !ok || h.isExpired(field) {
+		return nil, false
+	}
+	return val, true
+}
+
+func (h *Hash) convert() {
+	table := dict.NewConcurrentDict(1)
+	for i := 0; i < len(h.listpack); i += 2 {
+		table.PutWithoutLock(string(h.listpack[i]), h.listpack[i+1])
+	}
+	h.table = table
+	h.listpack = nil
+}
+
+// SetKeepTTL sets the value of field and keeps its expire time, returns true if field is new
+func (h *Hash) SetKeepTTL(field string, val []byte) bool {
+	if h.isExpired(field) {
+		h.Remove(field)
+	}
+	if h.table == nil && (len(field) > h.maxValue || len(val) > h.maxValue) {
+		h.convert()
+	}
+	if h.table != nil {
+		return h.table.PutWithoutLock(field, val) > 0
+	}
+	if i := h.indexOf(field); i >= 0 {
+		h.listpack[i+1] = val
+		return false
+	}
+	h.listpack = append(h.listpack, []byte(field), val)
+	if len(h.listpack)/2 > h.maxEntries {
+		h.convert()
+	}
+	return true
+}
+
+// Set sets the value of field and discards its expire time like HSET does, returns true if field is new
+func (h *Hash) Set(field string, val []byte) bool {
+	created := h.SetKeepTTL(field, val)
+	delete(h.expires, field)
+	return created
+}
+
+// Remove deletes field, returns false if field doesn't exist or has expired
+func (h *Hash) Remove(field string) bool {
+	expired := h.isExpired(field)
+	delete(h.expires, field)
+	if h.table != nil {
+		_, ok := h.table.RemoveWithoutLock(field)
+		return ok && !expired
+	}
+	i := h.indexOf(field)
+	if i < 0 {
+		return false
+	}
+	h.listpack = append(h.listpack[:i], h.listpack[i+2:]...)
+	return !expired
+}
+
+// ForEach visits the live fields, stops when consumer returns false
+func (h *Hash) ForEach(consumer func(field string, val []byte) bool) {
+	ts := now()
+	live := func(field string) bool {
+		at, ok := h.expires[field]
+		return !ok || at > ts
+	}
+	if h.table != nil {
+		h.table.ForEach(func(field string, val any) bool {
+			if !live(field) {
+				return true
+			}
+			return consumer(field, val.([]byte))
+		})
+		return
+	}
+	for i := 0; i < len(h.listpack); i += 2 {
+		field := string(h.listpack[i])
+		if !live(field) {
+			continue
+		}
+		if !consumer(field, h.listpack[i+1]) {
+			return
+		}
+	}
+}
+
+// Scan returns the live fields of about count buckets matching pattern starting at cursor,
+// a listpack is returned at once. The cursor semantics are the same as dict.DictScan.
+func (h *Hash) Scan(cursor int, count int, pattern string) ([]string, int) {
+	var fields []string
+	if h.table != nil {
+		keys, next := h.table.DictScan(cursor, count, pattern)
+		if next < 0 {
+			return nil, next
+		}
+		for _, key := range keys {
+			if !h.isExpired(string(key)) {
+				fields = append(fields, string(key))
+			}
+		}
+		return fields, next
+	}
+
+	var exp *wildcard.Pattern
+	if pattern != "*" {
+		var err error
+		exp, err = wildcard.Compile(pattern)
+		if err != nil {
+			return nil, -1
+		}
+	}
+	h.ForEach(func(field string, _ []byte) bool {
+		if exp == nil || exp.Match(field) {
+			fields = append(fields, field)
+		}
+		return true
+	})
+	return fields, 0
+}
+
+// RandomField returns a random live field, false if the hash is empty
+func (h *Hash) RandomField() (string, bool) {
+	if h.Len() == 0 {
+		return "", false
+	}
+	for {
+		var field string
+		if h.table != nil {
+			field, _ = h.table.RandomKey()
+		} else {
+			field = string(h.listpack[rand.Intn(len(h.listpack)/2)*2])
+		}
+		if !h.isExpired(field) {
+			return field, true
+		}
+	}
+}
+
+// RandomDistinctFields returns up to count distinct random fields
+func (h *Hash) RandomDistinctFields(count int) []string {
+	size := h.Len()
+	// 与redis相同，需要的字段数接近总数时取出全部字段再随机删除，否则逐个随机选取
+	if count*3 > size {
+		fields := make([]string, 0, size)
+		h.ForEach(func(field string, _ []byte) bool {
+			fields = append(fields, field)
+			return true
+		})
+		rand.Shuffle(len(fields), func(i, j int) {
+			fields[i], fields[j] = fields[j], fields[i]
+		})
+		return fields[:min(count, size)]
+	}
+
+	picked := make(map[string]struct{}, count)
+	fields := make([]string, 0, count)
+	for len(fields) < count {
+		field, _ := h.RandomField()
+		if _, ok := picked[field]; ok {
+			continue
+		}
+		picked[field] = struct{}{}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// SetExpire sets the expire time of an existing field in unix milliseconds
+func (h *Hash) SetExpire(field string, at int64) {
+	if h.expires == nil {
+		h.expires = make(map[string]int64)
+	}
+	h.expires[field] = at
+}
+
+// ExpireTime returns the expire time of field in unix milliseconds, false if it has none
+func (h *Hash) ExpireTime(field string) (int64, bool) {
+	at, ok := h.expires[field]
+	return at, ok
+}
+
+// Persist removes the expire time of field, returns false if it has none
+func (h *Hash) Persist(field string) bool {
+	if _, ok := h.expires[field]; !ok {
+		return false
+	}
+	delete(h.expires, field)
+	return true
+}
+
+// HasExpires reports whether some field has an expire time
+func (h *Hash) HasExpires() bool {
+	return len(h.expires) > 0
+}
+
+// RemoveExpired deletes the expired fields, returns the number of fields removed
+func (h *Hash) RemoveExpired() int {
+	if len(h.expires) == 0 {
+		return 0
+	}
+	ts := now()
+	removed := 0
+	for field, at := range h.expires {
+		if at <= ts {
+			h.Remove(field)
+			removed++
+		}
+	}
+	if len(h.expires) == 0 {
+		h.expires = nil
+	}
+	return removed
+}
+
+// Clone returns a copy of the hash, values are shared since they're never modified in place
+func (h *Hash) Clone() *Hash {
+	c := New(h.maxEntries, h.maxValue)
+	if h.table != nil {
+		c.table = dict.NewConcurrentDict(1)
+		h.table.ForEach(func(field string, val any) bool {
+			c.table.PutWithoutLock(field, val)
+			return true
+		})
+	} else {
+		c.listpack = make([][]byte, len(h.listpack))
+		copy(c.listpack, h.listpack)
+	}
+	if len(h.expires) > 0 {
+		c.expires = make(map[string]int64, len(h.expires))
+		for field, at := range h.expires {
+			c.expires[field] = at
+		}
+	}
+	return c
+}
@@ -0,0 +1,103 @@
+package hash
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashConvert(t *testing.T) {
+	h := New(4, 8)
+	for i := 0; i < 4; i++ {
+		assert.True(t, h.Set("f"+strconv.Itoa(i), []byte("v")))
+	}
+	assert.False(t, h.Set("f0", []byte("v0")))
+	assert.Equal(t, "listpack", h.Encoding())
+
+	assert.True(t, h.Set("f4", []byte("v4")))
+	assert.Equal(t, "hashtable", h.Encoding())
+	assert.Equal(t, 5, h.Len())
+	val, ok := h.Get("f0")
+	assert.True(t, ok)
+	assert.Equal(t, "v0", string(val))
+
+	long := New(4, 8)
+	long.Set("f", []byte(strings.Repeat("x", 9)))
+	assert.Equal(t, "hashtable", long.Encoding())
+}
+
+func TestHashScan(t *testing.T) {
+	for _, h := range []*Hash{New(128, 64), New(1, 64)} {
+		for i := 0; i < 100; i++ {
+			h.Set(strconv.Itoa(i), []byte("v"))
+		}
+		found := make(map[string]struct{})
+		cursor := 0
+		for {
+			var fields []string
+			fields, cursor = h.Scan(cursor, 10, "*")
+			for _, f := range fields {
+				found[f] = struct{}{}
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+		assert.Equal(t, 100, len(found), h.Encoding())
+
+		fields, _ := h.Scan(0, 1000, "9?")
+		assert.Equal(t, 10, len(fields), h.Encoding())
+	}
+}
+
+func TestHashExpire(t *testing.T) {
+	for _, h := range []*Hash{New(128, 64), New(1, 64)} {
+		h.Set("a", []byte("1"))
+		h.Set("b", []byte("2"))
+		h.SetExpire("a", now()-1)
+		h.SetExpire("b", now()+100000)
+
+		_, ok := h.Get("a")
+		assert.False(t, ok)
+		assert.Equal(t, 1, h.Len())
+		assert.Equal(t, 1, h.RemoveExpired())
+		assert.True(t, h.HasExpires())
+
+		h.Set("b", []byte("3"))
+		_, ok = h.ExpireTime("b")
+		assert.False(t, ok)
+
+		c := h.Clone()
+		c.Remove("b")
+		assert.Equal(t, 1, h.Len())
+		assert.Equal(t, 0, c.Len())
+	}
+}
+
+func TestHashRandom(t *testing.T) {
+	h := New(128, 64)
+	for i := 0; i < 50; i++ {
+		h.Set(strconv.Itoa(i), []byte("v"))
+	}
+	for _, count := range []int{1, 5, 20, 50, 100} {
+		fields := h.RandomDistinctFields(count)
+		assert.Equal(t, min(count, 50), len(fields))
+		seen := make(map[string]struct{})
+		for _, f := range fields {
+			seen[f] = struct{}{}
+		}
+		assert.Equal(t, len(fields), len(seen))
+	}
+}
+
+func TestHashRandomEmptyField(t *testing.T) {
+	h := New(128, 64)
+	h.Set("", []byte(strings.Repeat("x", 100)))
+	assert.Equal(t, "hashtable", h.Encoding())
+	field, ok := h.RandomField()
+	assert.True(t, ok)
+	assert.Equal(t, "", field)
+	assert.Equal(t, []string{""}, h.RandomDistinctFields(1))
+}
@@ -0,0 +1,518 @@
+package hll
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 与redis完全相同的参数与内存布局，生成的字符串可以与redis互相读取
+const (
+	P         = 14
+	Registers = 1 << P
+	// 哈希值中用于计算连续0个数的位数
+	Q       = 64 - P
+	bits    = 6
+	maxVal  = 1<<bits - 1
+	hdrSize = 16
+
+	// DenseSize is the length of a dense HyperLogLog including the header
+	DenseSize = hdrSize + (Registers*bits+7)/8
+
+	encodingDense  = 0
+	encodingSparse = 1
+
+	sparseZeroMaxLen  = 64
+	sparseXZeroMaxLen = 16384
+	sparseValMaxValue = 32
+	sparseValMaxLen   = 4
+
+	alphaInf = 0.721347520444481703680
+	hashSeed = 0xadc83b19
+)
+
+var (
+	// ErrInvalid means the string isn't a HyperLogLog at all
+	ErrInvalid = errors.New("not a valid HyperLogLog string value")
+	// ErrCorrupted means the header is fine but the sparse representation is broken
+	ErrCorrupted = errors.New("corrupted HLL object detected")
+)
+
+// HyperLogLog operates in place on a redis compatible HLL string: a 16 bytes header
+// ("HYLL", encoding, 3 unused bytes, cached cardinality in little endian whose highest bit
+// marks it as stale) followed by 16384 6-bit registers, either densely packed or run length
+// encoded with the ZERO, XZERO and VAL opcodes of the sparse representation.
+type HyperLogLog struct {
+	data           []byte
+	sparseMaxBytes int
+}
+
+// New returns an empty HyperLogLog in sparse representation, sparse ones larger than
+// sparseMaxBytes are converted to the dense representation
+func New(sparseMaxBytes int) *HyperLogLog {
+	data := make([]byte, hdrSize, hdrSize+2)
+	copy(data, "HYLL")
+	data[4] = encodingSparse
+	// 一个XZERO覆盖全部寄存器
+	data = append(data, 0x40|byte((Registers-1)>>8), byte((Registers-1)&0xff))
+	return &HyperLogLog{data: data, sparseMaxBytes: sparseMaxBytes}
+}
+
+// Load wraps data without copying it, data is modified by the writing methods
+func Load(data []byte, sparseMaxBytes int) (*HyperLogLog, error) {
+	if len(data) < hdrSize || string(data[:4]) != "HYLL" || data[4] > encodingSparse {
+		return nil, ErrInvalid
+	}
+	if data[4] == encodingDense && len(data) != DenseSize {
+		return nil, ErrInvalid
+	}
+	return &HyperLogLog{data: data, sparseMaxBytes: sparseMaxBytes}, nil
+}
+
+func (h *HyperLogLog) Bytes() []byte {
+	return h.data
+}
+
+func (h *HyperLogLog) Encoding() string {
+	if h.data[4] == encodingSparse {
+		return "sparse"
+	}
+	return "dense"
+}
+
+// CachedCount returns the cached cardinality, false if it's stale
+func (h *HyperLogLog) CachedCount() (uint64, bool) {
+	if h.data[15]&(1<<7) != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(h.data[8:16]), true
+}
+
+func (h *HyperLogLog) SetCachedCount(card uint64) {
+	binary.LittleEndian.PutUint64(h.data[8:16], card)
+}
+
+func (h *HyperLogLog) invalidateCache() {
+	h.data[15] |= 1 << 7
+}
+
+// murmurHash64A is the hash function used by redis for HyperLogLog, reading the key in little endian
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ (uint64(len(key)) * m)
+	n := len(key) / 8
+	for i := 0; i < n; i++ {
+		k := binary.LittleEndian.Uint64(key[i*8:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	tail := key[n*8:]
+	if len(tail) > 0 {
+		for i := len(tail) - 1; i >= 0; i-- {
+			h ^= uint64(tail[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// patLen returns the register index of ele and the length of the 000..1 pattern after the index bits
+func patLen(ele []byte) (int, uint8) {
+	hash := murmurHash64A(ele, hashSeed)
+	index := int(hash & (Registers - 1))
+	hash >>= P
+	// 保证循环能结束，count最大为Q+1
+	hash |= 1 << Q
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+func denseGet(registers []byte, index int) uint8 {
+	byteIndex := index * bits / 8
+	fb := uint(index * bits & 7)
+	b0 := registers[byteIndex]
+	var b1 byte
+	if byteIndex+1 < len(registers) {
+		b1 = registers[byteIndex+1]
+	}
+	return uint8((uint16(b0)>>fb | uint16(b1)<<(8-fb)) & maxVal)
+}
+
+func denseSet(registers []byte, index int, val uint8) {
+	byteIndex := index * bits / 8
+	fb := uint(index * bits & 7)
+	registers[byteIndex] &^= byte(maxVal << fb)
+	registers[byteIndex] |= val << fb
+	if byteIndex+1 < len(registers) {
+		registers[byteIndex+1] &^= byte(maxVal >> (8 - fb))
+		registers[byteIndex+1] |= val >> (8 - fb)
+	}
+}
+
+// sparse opcodes: ZERO 00xxxxxx, XZERO 01xxxxxx yyyyyyyy, VAL 1vvvvvxx
+func isZero(b byte) bool  { return b&0xc0 == 0 }
+func isXZero(b byte) bool { return b&0xc0 == 0x40 }
+func isVal(b byte) bool   { return b&0x80 != 0 }
+
+func zeroLen(b byte) int            { return int(b&0x3f) + 1 }
+func xzeroLen(b0, b1 byte) int      { return (int(b0&0x3f)<<8 | int(b1)) + 1 }
+func valValue(b byte) uint8         { return (b>>2)&0x1f + 1 }
+func valLen(b byte) int             { return int(b&0x3) + 1 }
+func valOpcode(v uint8, n int) byte { return byte(int(v-1)<<2|(n-1)) | 0x80 }
+
+// appendZeros appends the opcode of a run of n zero registers
+func appendZeros(seq []byte, n int) []byte {
+	if n > sparseZeroMaxLen {
+		return append(seq, 0x40|byte((n-1)>>8), byte((n-1)&0xff))
+	}
+	return append(seq, byte(n-1))
+}
+
+// forEachRun visits the runs of the sparse representation, returns ErrCorrupted
+// if the runs don't cover exactly all registers
+func (h *HyperLogLog) forEachRun(consumer func(first int, n int, val uint8)) error {
+	sparse := h.data[hdrSize:]
+	index := 0
+	for p := 0; p < len(sparse); {
+		switch {
+		case isZero(sparse[p]):
+			n := zeroLen(sparse[p])
+			consumer(index, n, 0)
+			index += n
+			p++
+		case isXZero(sparse[p]):
+			if p+1 >= len(sparse) {
+				return ErrCorrupted
+			}
+			n := xzeroLen(sparse[p], sparse[p+1])
+			consumer(index, n, 0)
+			index += n
+			p += 2
+		default:
+			n := valLen(sparse[p])
+			if index+n > Registers {
+				return ErrCorrupted
+			}
+			consumer(index, n, valValue(sparse[p]))
+			index += n
+			p++
+		}
+	}
+	if index != Registers {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// ToDense converts the sparse representation to the dense one, returns false if it's already dense
+func (h *HyperLogLog) ToDense() (bool, error) {
+	if h.data[4] == encodingDense {
+		return false, nil
+	}
+	dense := make([]byte, DenseSize)
+	copy(dense, h.data[:hdrSize])
+	dense[4] = encodingDense
+	registers := dense[hdrSize:]
+	err := h.forEachRun(func(first int, n int, val uint8) {
+		if val == 0 {
+			return
+		}
+		for i := first; i < first+n; i++ {
+			denseSet(registers, i, val)
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	h.data = dense
+	return true, nil
+}
+
+// set raises register index to count, returns true if the register changed
+func (h *HyperLogLog) set(index int, count uint8) (bool, error) {
+	if h.data[4] == encodingDense {
+		registers := h.data[hdrSize:]
+		if denseGet(registers, index) >= count {
+			return false, nil
+		}
+		denseSet(registers, index, count)
+		return true, nil
+	}
+	if count > sparseValMaxValue {
+		return h.promoteAndSet(index, count)
+	}
+	return h.sparseSet(index, count)
+}
+
+func (h *HyperLogLog) promoteAndSet(index int, count uint8) (bool, error) {
+	if _, err := h.ToDense(); err != nil {
+		return false, err
+	}
+	return h.set(index, count)
+}
+
+// sparseSet follows hllSparseSet of redis step by step, so the produced opcodes are byte
+// for byte the same as the ones redis would write
+func (h *HyperLogLog) sparseSet(index int, count uint8) (bool, error) {
+	data := h.data
+	end := len(data)
+
+	// 找到覆盖index的操作码
+	p, prev := hdrSize, -1
+	first, span := 0, 0
+	for p < end {
+		oplen := 1
+		switch {
+		case isZero(data[p]):
+			span = zeroLen(data[p])
+		case isVal(data[p]):
+			span = valLen(data[p])
+		default:
+			if p+1 >= end {
+				return false, ErrCorrupted
+			}
+			span = xzeroLen(data[p], data[p+1])
+			oplen = 2
+		}
+		if index <= first+span-1 {
+			break
+		}
+		prev = p
+		p += oplen
+		first += span
+	}
+	if span == 0 || p >= end {
+		return false, ErrCorrupted
+	}
+
+	op := data[p]
+	updated := false
+	switch {
+	case isVal(op):
+		if valValue(op) >= count {
+			return false, nil
+		}
+		if span == 1 {
+			data[p] = valOpcode(count, 1)
+			updated = true
+		}
+	case isZero(op):
+		if span == 1 {
+			data[p] = valOpcode(count, 1)
+			updated = true
+		}
+	}
+
+	if !updated {
+		// 把原操作码拆成最多三段：左侧、新的VAL、右侧
+		last := first + span - 1
+		seq := make([]byte, 0, 5)
+		if isVal(op) {
+			curVal := valValue(op)
+			if index != first {
+				seq = append(seq, valOpcode(curVal, index-first))
+			}
+			seq = append(seq, valOpcode(count, 1))
+			if index != last {
+				seq = append(seq, valOpcode(curVal, last-index))
+			}
+		} else {
+			if index != first {
+				seq = appendZeros(seq, index-first)
+			}
+			seq = append(seq, valOpcode(count, 1))
+			if index != last {
+				seq = appendZeros(seq, last-index)
+			}
+		}
+		oldLen := 1
+		if isXZero(op) {
+			oldLen = 2
+		}
+		delta := len(seq) - oldLen
+		if delta > 0 && len(data)+delta > h.sparseMaxBytes {
+			return h.promoteAndSet(index, count)
+		}
+		newData := make([]byte, 0, len(data)+delta)
+		newData = append(newData, data[:p]...)
+		newData = append(newData, seq...)
+		newData = append(newData, data[p+oldLen:]...)
+		data = newData
+		end = len(data)
+	}
+
+	// 合并相邻且值相同的VAL操作码，从被修改位置的前一个操作码开始最多检查5个
+	p = hdrSize
+	if prev >= 0 {
+		p = prev
+	}
+	for scan := 5; p < end && scan > 0; scan-- {
+		if isXZero(data[p]) {
+			p += 2
+			continue
+		}
+		if isZero(data[p]) {
+			p++
+			continue
+		}
+		if p+1 < end && isVal(data[p+1]) && valValue(data[p]) == valValue(data[p+1]) {
+			n := valLen(data[p]) + valLen(data[p+1])
+			if n <= sparseValMaxLen {
+				data[p+1] = valOpcode(valValue(data[p]), n)
+				data = append(data[:p], data[p+1:]...)
+				end--
+				// 不移动p，尝试与左侧继续合并
+				continue
+			}
+		}
+		p++
+	}
+	h.data = data
+	return true, nil
+}
+
+// Add adds an element, returns true if some register changed
+func (h *HyperLogLog) Add(ele []byte) (bool, error) {
+	index, count := patLen(ele)
+	changed, err := h.set(index, count)
+	if changed {
+		h.invalidateCache()
+	}
+	return changed, err
+}
+
+// MergeInto raises every register of max to the value of the same register of h
+func (h *HyperLogLog) MergeInto(max []uint8) error {
+	if h.data[4] == encodingDense {
+		registers := h.data[hdrSize:]
+		for i := 0; i < Registers; i++ {
+			if val := denseGet(registers, i); val > max[i] {
+				max[i] = val
+			}
+		}
+		return nil
+	}
+	return h.forEachRun(func(first int, n int, val uint8) {
+		for i := first; i < first+n; i++ {
+			if val > max[i] {
+				max[i] = val
+			}
+		}
+	})
+}
+
+// SetRegisters raises the registers of h to the values of registers, used by PFMERGE
+func (h *HyperLogLog) SetRegisters(registers []uint8) error {
+	for i, val := range registers {
+		if val == 0 {
+			continue
+		}
+		if _, err := h.set(i, val); err != nil {
+			return err
+		}
+	}
+	h.invalidateCache()
+	return nil
+}
+
+// Registers returns the value of every register
+func (h *HyperLogLog) Registers() ([]uint8, error) {
+	registers := make([]uint8, Registers)
+	if err := h.MergeInto(registers); err != nil {
+		return nil, err
+	}
+	return registers, nil
+}
+
+// Count estimates the cardinality from the registers
+func (h *HyperLogLog) Count() (uint64, error) {
+	registers, err := h.Registers()
+	if err != nil {
+		return 0, err
+	}
+	return CountRegisters(registers), nil
+}
+
+// Decode describes the opcodes of the sparse representation like PFDEBUG DECODE
+func (h *HyperLogLog) Decode() (string, error) {
+	var sb strings.Builder
+	sparse := h.data[hdrSize:]
+	for p := 0; p < len(sparse); {
+		switch {
+		case isZero(sparse[p]):
+			sb.WriteString("Z:" + strconv.Itoa(zeroLen(sparse[p])) + " ")
+			p++
+		case isXZero(sparse[p]):
+			if p+1 >= len(sparse) {
+				return "", ErrCorrupted
+			}
+			sb.WriteString("XZ:" + strconv.Itoa(xzeroLen(sparse[p], sparse[p+1])) + " ")
+			p += 2
+		default:
+			sb.WriteString("v:" + strconv.Itoa(int(valValue(sparse[p]))) + "," + strconv.Itoa(valLen(sparse[p])) + " ")
+			p++
+		}
+	}
+	return strings.TrimSuffix(sb.String(), " "), nil
+}
+
+// tau and sigma are the correction functions of the estimator by Otmar Ertl used by redis
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+// CountRegisters estimates the cardinality of raw registers
+func CountRegisters(registers []uint8) uint64 {
+	var histogram [64]int
+	for _, val := range registers {
+		histogram[val]++
+	}
+	m := float64(Registers)
+	z := m * tau((m-float64(histogram[Q+1]))/m)
+	for j := Q; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * sigma(float64(histogram[0])/m)
+	return uint64(math.Round(alphaInf * m * m / z))
+}
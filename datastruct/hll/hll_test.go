@@ -0,0 +1,165 @@
+package hll
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	h := New(3000)
+	assert.Equal(t, "sparse", h.Encoding())
+	assert.Equal(t, "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff", string(h.Bytes()))
+	card, ok := h.CachedCount()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0), card)
+	decoded, err := h.Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, "XZ:16384", decoded)
+}
+
+func TestLoad(t *testing.T) {
+	_, err := Load([]byte("HYLL"), 3000)
+	assert.Equal(t, ErrInvalid, err)
+	_, err = Load([]byte("HYLX\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff"), 3000)
+	assert.Equal(t, ErrInvalid, err)
+	_, err = Load([]byte("HYLL\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff"), 3000)
+	assert.Equal(t, ErrInvalid, err)
+
+	// 寄存器数量不对的稀疏编码
+	h, err := Load([]byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xfe"), 3000)
+	assert.Nil(t, err)
+	_, err = h.Count()
+	assert.Equal(t, ErrCorrupted, err)
+}
+
+func TestAdd(t *testing.T) {
+	h := New(3000)
+	changed, err := h.Add([]byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	_, ok := h.CachedCount()
+	assert.False(t, ok)
+	changed, _ = h.Add([]byte("a"))
+	assert.False(t, changed)
+
+	count, err := h.Count()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), count)
+	h.SetCachedCount(count)
+	card, ok := h.CachedCount()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), card)
+
+	index, val := patLen([]byte("a"))
+	registers, _ := h.Registers()
+	assert.Equal(t, val, registers[index])
+}
+
+// 同样的元素分别写入稀疏和密集编码，寄存器必须完全一致
+func TestSparseDenseConsistency(t *testing.T) {
+	sparse := New(math.MaxInt)
+	dense := New(math.MaxInt)
+	_, _ = dense.ToDense()
+	for i := 0; i < 20000; i++ {
+		ele := []byte(strconv.Itoa(i))
+		c1, err := sparse.Add(ele)
+		assert.Nil(t, err)
+		c2, _ := dense.Add(ele)
+		assert.Equal(t, c2, c1)
+	}
+	assert.Equal(t, "sparse", sparse.Encoding())
+	r1, err := sparse.Registers()
+	assert.Nil(t, err)
+	r2, _ := dense.Registers()
+	assert.Equal(t, r2, r1)
+
+	converted, err := sparse.ToDense()
+	assert.Nil(t, err)
+	assert.True(t, converted)
+	assert.Equal(t, dense.Bytes(), sparse.Bytes())
+}
+
+func TestDenseRegisters(t *testing.T) {
+	h := New(0)
+	_, _ = h.ToDense()
+	assert.Equal(t, DenseSize, len(h.Bytes()))
+	for i := 0; i < Registers; i++ {
+		changed, err := h.set(i, uint8(i%63+1))
+		assert.Nil(t, err)
+		assert.True(t, changed)
+	}
+	registers, _ := h.Registers()
+	for i, val := range registers {
+		assert.Equal(t, uint8(i%63+1), val)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	h := New(100)
+	for i := 0; h.Encoding() == "sparse"; i++ {
+		_, err := h.Add([]byte(strconv.Itoa(i)))
+		assert.Nil(t, err)
+		if h.Encoding() == "sparse" {
+			assert.LessOrEqual(t, len(h.Bytes()), 100)
+		}
+	}
+	assert.Equal(t, DenseSize, len(h.Bytes()))
+
+	// 稀疏编码无法表示大于32的值
+	h = New(3000)
+	_, _ = h.set(7, 33)
+	assert.Equal(t, "dense", h.Encoding())
+	registers, _ := h.Registers()
+	assert.Equal(t, uint8(33), registers[7])
+}
+
+func TestSparseMergeAdjacent(t *testing.T) {
+	h := New(3000)
+	_, _ = h.set(0, 3)
+	_, _ = h.set(2, 3)
+	decoded, _ := h.Decode()
+	assert.Equal(t, "v:3,1 Z:1 v:3,1 XZ:16381", decoded)
+	_, _ = h.set(1, 3)
+	decoded, _ = h.Decode()
+	assert.Equal(t, "v:3,3 XZ:16381", decoded)
+	_, _ = h.set(1, 5)
+	decoded, _ = h.Decode()
+	assert.Equal(t, "v:3,1 v:5,1 v:3,1 XZ:16381", decoded)
+	count, _ := h.Count()
+	assert.Equal(t, uint64(3), count)
+}
+
+func TestAccuracy(t *testing.T) {
+	h := New(3000)
+	for _, n := range []int{10, 1000, 100000, 1000000} {
+		for i := 0; i < n; i++ {
+			_, _ = h.Add([]byte("ele:" + strconv.Itoa(i)))
+		}
+		count, err := h.Count()
+		assert.Nil(t, err)
+		// 标准误差为0.81%，允许5倍标准误差
+		assert.InDelta(t, float64(n), float64(count), float64(n)*0.0405+1)
+	}
+}
+
+func TestMergeInto(t *testing.T) {
+	h1 := New(3000)
+	h2 := New(3000)
+	_, _ = h2.ToDense()
+	for i := 0; i < 1000; i++ {
+		_, _ = h1.Add([]byte(strconv.Itoa(i)))
+		_, _ = h2.Add([]byte(strconv.Itoa(i + 500)))
+	}
+	registers := make([]uint8, Registers)
+	assert.Nil(t, h1.MergeInto(registers))
+	assert.Nil(t, h2.MergeInto(registers))
+	assert.InDelta(t, 1500, float64(CountRegisters(registers)), 1500*0.0405)
+
+	h3 := New(3000)
+	assert.Nil(t, h3.SetRegisters(registers))
+	count, _ := h3.Count()
+	assert.Equal(t, CountRegisters(registers), count)
+}
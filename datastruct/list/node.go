@@ -0,0 +1,188 @@
+package list
+
+import (
+	"encoding/binary"
+)
+
+// node 是quicklist中的一个紧凑节点，元素依次编码在buf中:
+//
+//	uvarint(len) | data | backlen
+//
+// backlen为前两部分的总长度，从后往前按7位一组编码，最高位表示前面还有字节，用于反向遍历
+type node struct {
+	buf   []byte
+	count int
+	prev  *node
+	next  *node
+}
+
+func backlenSize(l int) int {
+	size := 1
+	for l >= 0x80 {
+		l >>= 7
+		size++
+	}
+	return size
+}
+
+// entrySize returns the encoded size of an element
+func entrySize(val []byte) int {
+	l := uvarintSize(len(val)) + len(val)
+	return l + backlenSize(l)
+}
+
+func uvarintSize(n int) int {
+	size := 1
+	for n >= 0x80 {
+		n >>= 7
+		size++
+	}
+	return size
+}
+
+func appendEntry(buf []byte, val []byte) []byte {
+	start := len(buf)
+	buf = binary.AppendUvarint(buf, uint64(len(val)))
+	buf = append(buf, val...)
+	l := len(buf) - start
+
+	// backlen 最后一个字节存放最低7位，除最前面的字节外都设置最高位
+	size := backlenSize(l)
+	var tmp [10]byte
+	for i := 0; i < size; i++ {
+		b := byte(l>>(7*i)) & 0x7f
+		if i != size-1 {
+			b |= 0x80
+		}
+		tmp[size-1-i] = b
+	}
+	return append(buf, tmp[:size]...)
+}
+
+// readEntry decodes the element starting at offset, returns the element and the offset of the next one
+func readEntry(buf []byte, offset int) ([]byte, int) {
+	n, size := binary.Uvarint(buf[offset:])
+	start := offset + size
+	end := start + int(n)
+	return buf[start:end], end + backlenSize(end-offset)
+}
+
+// prevEntryOffset returns the offset of the element that ends at end
+func prevEntryOffset(buf []byte, end int) int {
+	l := 0
+	shift := 0
+	i := end - 1
+	for {
+		b := buf[i]
+		l |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+		i--
+	}
+	return i - l
+}
+
+func newNode(entries [][]byte) *node {
+	size := 0
+	for _, val := range entries {
+		size += entrySize(val)
+	}
+	n := &node{
+		buf:   make([]byte, 0, size),
+		count: len(entries),
+	}
+	for _, val := range entries {
+		n.buf = appendEntry(n.buf, val)
+	}
+	return n
+}
+
+// fits reports whether the element can be added to the node without exceeding the node limits
+func (n *node) fits(val []byte) bool {
+	if n.count == 0 {
+		return true
+	}
+	return n.count < maxNodeEntries && len(n.buf)+entrySize(val) <= maxNodeBytes
+}
+
+func (n *node) pushBack(val []byte) {
+	n.buf = appendEntry(n.buf, val)
+	n.count++
+}
+
+func (n *node) pushFront(val []byte) {
+	buf := make([]byte, 0, len(n.buf)+entrySize(val))
+	buf = appendEntry(buf, val)
+	n.buf = append(buf, n.buf...)
+	n.count++
+}
+
+// popFront removes the first element, the returned slice is a copy
+func (n *node) popFront() []byte {
+	val, next := readEntry(n.buf, 0)
+	result := append([]byte{}, val...)
+	n.buf = n.buf[next:]
+	n.count--
+	return result
+}
+
+// popBack removes the last element, the returned slice is a copy
+func (n *node) popBack() []byte {
+	offset := prevEntryOffset(n.buf, len(n.buf))
+	val, _ := readEntry(n.buf, offset)
+	result := append([]byte{}, val...)
+	n.buf = n.buf[:offset]
+	n.count--
+	return result
+}
+
+// forEach visits the elements from head to tail, val is only valid during the call
+func (n *node) forEach(fn func(i int, val []byte) bool) bool {
+	offset := 0
+	for i := 0; i < n.count; i++ {
+		var val []byte
+		val, offset = readEntry(n.buf, offset)
+		if !fn(i, val) {
+			return false
+		}
+	}
+	return true
+}
+
+// reverseForEach visits the elements from tail to head, val is only valid during the call
+func (n *node) reverseForEach(fn func(i int, val []byte) bool) bool {
+	end := len(n.buf)
+	for i := n.count - 1; i >= 0; i-- {
+		offset := prevEntryOffset(n.buf, end)
+		val, _ := readEntry(n.buf, offset)
+		if !fn(i, val) {
+			return false
+		}
+		end = offset
+	}
+	return true
+}
+
+func (n *node) get(index int) []byte {
+	var result []byte
+	n.forEach(func(i int, val []byte) bool {
+		if i == index {
+			result = append([]byte{}, val...)
+			return false
+		}
+		return true
+	})
+	return result
+}
+
+// entries decodes all elements of the node into copies
+func (n *node) entries() [][]byte {
+	result := make([][]byte, 0, n.count)
+	n.forEach(func(_ int, val []byte) bool {
+		result = append(result, append([]byte{}, val...))
+		return true
+	})
+	return result
+}
@@ -0,0 +1,355 @@
+package list
+
+import (
+	"bytes"
+)
+
+// 与redis list-max-listpack-size -2 相同，单个节点最多8KB
+const (
+	maxNodeBytes   = 8 * 1024
+	maxNodeEntries = 128
+)
+
+// QuickList is a doubly linked list of compact nodes, each node packs up to maxNodeEntries
+// elements into a single byte slice. Push and pop at both ends are O(1), elements in the
+// middle are modified by rewriting only the node holding them.
+type QuickList struct {
+	head *node
+	tail *node
+	size int
+}
+
+func NewQuickList() *QuickList {
+	return &QuickList{}
+}
+
+func (ql *QuickList) Len() int {
+	return ql.size
+}
+
+func (ql *QuickList) linkBefore(n *node, at *node) {
+	n.next = at
+	if at == nil {
+		n.prev = ql.tail
+		if ql.tail != nil {
+			ql.tail.next = n
+		} else {
+			ql.head = n
+		}
+		ql.tail = n
+		return
+	}
+	n.prev = at.prev
+	if at.prev != nil {
+		at.prev.next = n
+	} else {
+		ql.head = n
+	}
+	at.prev = n
+}
+
+func (ql *QuickList) unlink(n *node) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		ql.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		ql.tail = n.prev
+	}
+	n.prev, n.next = nil, nil
+}
+
+func (ql *QuickList) PushBack(val []byte) {
+	if ql.tail == nil || !ql.tail.fits(val) {
+		ql.linkBefore(newNode(nil), nil)
+	}
+	ql.tail.pushBack(val)
+	ql.size++
+}
+
+func (ql *QuickList) PushFront(val []byte) {
+	if ql.head == nil || !ql.head.fits(val) {
+		ql.linkBefore(newNode(nil), ql.head)
+	}
+	ql.head.pushFront(val)
+	ql.size++
+}
+
+// PopFront removes and returns the first element, nil if the list is empty
+func (ql *QuickList) PopFront() []byte {
+	if ql.head == nil {
+		return nil
+	}
+	n := ql.head
+	val := n.popFront()
+	if n.count == 0 {
+		ql.unlink(n)
+	}
+	ql.size--
+	return val
+}
+
+// PopBack removes and returns the last element, nil if the list is empty
+func (ql *QuickList) PopBack() []byte {
+	if ql.tail == nil {
+		return nil
+	}
+	n := ql.tail
+	val := n.popBack()
+	if n.count == 0 {
+		ql.unlink(n)
+	}
+	ql.size--
+	return val
+}
+
+// locate returns the node holding the element at index and the offset of the element in the node
+func (ql *QuickList) locate(index int) (*node, int) {
+	if index < ql.size/2 {
+		for n := ql.head; n != nil; n = n.next {
+			if index < n.count {
+				return n, index
+			}
+			index -= n.count
+		}
+		return nil, 0
+	}
+	index = ql.size - 1 - index
+	for n := ql.tail; n != nil; n = n.prev {
+		if index < n.count {
+			return n, n.count - 1 - index
+		}
+		index -= n.count
+	}
+	return nil, 0
+}
+
+// Get returns a copy of the element at index, 0 <= index < Len
+func (ql *QuickList) Get(index int) []byte {
+	n, offset := ql.locate(index)
+	if n == nil {
+		return nil
+	}
+	return n.get(offset)
+}
+
+// replaceNode rewrites n with the given elements, splitting it if they exceed the node limits
+func (ql *QuickList) replaceNode(n *node, entries [][]byte) {
+	at := n.next
+	ql.unlink(n)
+	var cur *node
+	for _, val := range entries {
+		if cur == nil || !cur.fits(val) {
+			cur = newNode(nil)
+			ql.linkBefore(cur, at)
+		}
+		cur.pushBack(val)
+	}
+}
+
+// Set replaces the element at index, 0 <= index < Len
+func (ql *QuickList) Set(index int, val []byte) {
+	n, offset := ql.locate(index)
+	if n == nil {
+		return
+	}
+	entries := n.entries()
+	entries[offset] = val
+	ql.replaceNode(n, entries)
+}
+
+// Insert inserts val before the element at index, index == Len appends to the tail
+func (ql *QuickList) Insert(index int, val []byte) {
+	if index <= 0 {
+		ql.PushFront(val)
+		return
+	}
+	if index >= ql.size {
+		ql.PushBack(val)
+		return
+	}
+	n, offset := ql.locate(index)
+	entries := n.entries()
+	entries = append(entries, nil)
+	copy(entries[offset+1:], entries[offset:])
+	entries[offset] = val
+	ql.replaceNode(n, entries)
+	ql.size++
+}
+
+// ForEach visits the elements from head to tail with their index,
+// val is only valid during the call and must not be modified
+func (ql *QuickList) ForEach(fn func(i int, val []byte) bool) {
+	base := 0
+	for n := ql.head; n != nil; n = n.next {
+		if !n.forEach(func(i int, val []byte) bool {
+			return fn(base+i, val)
+		}) {
+			return
+		}
+		base += n.count
+	}
+}
+
+// ReverseForEach visits the elements from tail to head with their index,
+// val is only valid during the call and must not be modified
+func (ql *QuickList) ReverseForEach(fn func(i int, val []byte) bool) {
+	base := ql.size
+	for n := ql.tail; n != nil; n = n.prev {
+		base -= n.count
+		if !n.reverseForEach(func(i int, val []byte) bool {
+			return fn(base+i, val)
+		}) {
+			return
+		}
+	}
+}
+
+// Range returns copies of the elements in [start, stop)
+func (ql *QuickList) Range(start, stop int) [][]byte {
+	if start < 0 {
+		start = 0
+	}
+	if stop > ql.size {
+		stop = ql.size
+	}
+	if start >= stop {
+		return [][]byte{}
+	}
+	result := make([][]byte, 0, stop-start)
+	n, offset := ql.locate(start)
+	for ; n != nil && len(result) < stop-start; n = n.next {
+		n.forEach(func(i int, val []byte) bool {
+			if i >= offset {
+				result = append(result, append([]byte{}, val...))
+			}
+			return len(result) < stop-start
+		})
+		offset = 0
+	}
+	return result
+}
+
+// RemoveByVal removes elements equal to val, count > 0 removes at most count from head to tail,
+// count < 0 removes at most -count from tail to head, count == 0 removes all. Returns the number removed.
+func (ql *QuickList) RemoveByVal(val []byte, count int) int {
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	removed := 0
+	n := ql.head
+	if count < 0 {
+		n = ql.tail
+	}
+	for n != nil && (limit == 0 || removed < limit) {
+		next := n.next
+		if count < 0 {
+			next = n.prev
+		}
+
+		matched := 0
+		n.forEach(func(_ int, e []byte) bool {
+			if bytes.Equal(e, val) {
+				matched++
+			}
+			return true
+		})
+		if matched > 0 {
+			entries := n.entries()
+			kept := make([][]byte, 0, len(entries))
+			if count >= 0 {
+				for _, e := range entries {
+					if bytes.Equal(e, val) && (limit == 0 || removed < limit) {
+						removed++
+						continue
+					}
+					kept = append(kept, e)
+				}
+			} else {
+				for i := len(entries) - 1; i >= 0; i-- {
+					e := entries[i]
+					if bytes.Equal(e, val) && removed < limit {
+						removed++
+						continue
+					}
+					kept = append(kept, e)
+				}
+				for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+					kept[i], kept[j] = kept[j], kept[i]
+				}
+			}
+			ql.size -= len(entries) - len(kept)
+			ql.replaceNode(n, kept)
+		}
+		n = next
+	}
+	return removed
+}
+
+// Trim keeps only the elements in [start, stop)
+func (ql *QuickList) Trim(start, stop int) {
+	if start < 0 {
+		start = 0
+	}
+	if stop > ql.size {
+		stop = ql.size
+	}
+	if start >= stop {
+		ql.head, ql.tail, ql.size = nil, nil, 0
+		return
+	}
+	ql.removeFront(start)
+	ql.removeBack(ql.size - (stop - start))
+}
+
+// removeFront removes the first n elements, whole nodes are dropped without decoding them
+func (ql *QuickList) removeFront(count int) {
+	for count > 0 && ql.head != nil {
+		n := ql.head
+		if n.count <= count {
+			count -= n.count
+			ql.size -= n.count
+			ql.unlink(n)
+			continue
+		}
+		for ; count > 0; count-- {
+			n.popFront()
+			ql.size--
+		}
+	}
+}
+
+// removeBack removes the last n elements
+func (ql *QuickList) removeBack(count int) {
+	for count > 0 && ql.tail != nil {
+		n := ql.tail
+		if n.count <= count {
+			count -= n.count
+			ql.size -= n.count
+			ql.unlink(n)
+			continue
+		}
+		for ; count > 0; count-- {
+			n.popBack()
+			ql.size--
+		}
+	}
+}
+
+// Clone returns a deep copy of the list
+func (ql *QuickList) Clone() *QuickList {
+	c := NewQuickList()
+	for n := ql.head; n != nil; n = n.next {
+		cn := &node{
+			buf:   append([]byte{}, n.buf...),
+			count: n.count,
+		}
+		c.linkBefore(cn, nil)
+	}
+	c.size = ql.size
+	return c
+}
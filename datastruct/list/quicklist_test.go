@@ -0,0 +1,131 @@
+package list
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func toStrings(vals [][]byte) []string {
+	result := make([]string, len(vals))
+	for i, val := range vals {
+		result[i] = string(val)
+	}
+	return result
+}
+
+func assertList(t *testing.T, expected []string, ql *QuickList) {
+	t.Helper()
+	assert.Equal(t, len(expected), ql.Len())
+	assert.Equal(t, expected, toStrings(ql.Range(0, ql.Len())))
+
+	reversed := make([]string, 0, ql.Len())
+	ql.ReverseForEach(func(i int, val []byte) bool {
+		assert.Equal(t, expected[i], string(val))
+		reversed = append(reversed, string(val))
+		return true
+	})
+	assert.Len(t, reversed, len(expected))
+}
+
+func TestQuickListPushPop(t *testing.T) {
+	ql := NewQuickList()
+	expected := make([]string, 0)
+	for i := 0; i < 1000; i++ {
+		val := strconv.Itoa(i)
+		if i%2 == 0 {
+			ql.PushBack([]byte(val))
+			expected = append(expected, val)
+		} else {
+			ql.PushFront([]byte(val))
+			expected = append([]string{val}, expected...)
+		}
+	}
+	assertList(t, expected, ql)
+
+	assert.Equal(t, expected[0], string(ql.PopFront()))
+	assert.Equal(t, expected[len(expected)-1], string(ql.PopBack()))
+	expected = expected[1 : len(expected)-1]
+	assertList(t, expected, ql)
+	assert.Equal(t, expected[500], string(ql.Get(500)))
+
+	for ql.Len() > 0 {
+		ql.PopBack()
+	}
+	assert.Nil(t, ql.PopFront())
+	assert.Nil(t, ql.head)
+	assert.Nil(t, ql.tail)
+}
+
+func TestQuickListLargeElements(t *testing.T) {
+	ql := NewQuickList()
+	big := strings.Repeat("x", maxNodeBytes*2)
+	ql.PushBack([]byte("a"))
+	ql.PushBack([]byte(big))
+	ql.PushFront([]byte(big))
+	ql.PushBack([]byte("b"))
+	assertList(t, []string{big, "a", big, "b"}, ql)
+	// 超过300字节的长度需要多字节的backlen
+	ql.Set(1, bytes.Repeat([]byte("y"), 300))
+	assert.Equal(t, 300, len(ql.Get(1)))
+}
+
+func TestQuickListRandomOps(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ql := NewQuickList()
+	expected := make([]string, 0)
+	for i := 0; i < 5000; i++ {
+		val := strconv.Itoa(r.Intn(20))
+		switch op := r.Intn(7); {
+		case op <= 1:
+			ql.PushBack([]byte(val))
+			expected = append(expected, val)
+		case op == 2:
+			ql.PushFront([]byte(val))
+			expected = append([]string{val}, expected...)
+		case op == 3:
+			index := r.Intn(len(expected) + 1)
+			ql.Insert(index, []byte(val))
+			expected = append(expected[:index], append([]string{val}, expected[index:]...)...)
+		case op == 4 && len(expected) > 0:
+			index := r.Intn(len(expected))
+			ql.Set(index, []byte(val))
+			expected[index] = val
+		case op == 5:
+			count := r.Intn(5) - 2
+			removed := ql.RemoveByVal([]byte(val), count)
+			kept := make([]string, 0, len(expected))
+			n := 0
+			if count >= 0 {
+				for _, e := range expected {
+					if e == val && (count == 0 || n < count) {
+						n++
+						continue
+					}
+					kept = append(kept, e)
+				}
+			} else {
+				for j := len(expected) - 1; j >= 0; j-- {
+					if expected[j] == val && n < -count {
+						n++
+						continue
+					}
+					kept = append([]string{expected[j]}, kept...)
+				}
+			}
+			assert.Equal(t, n, removed)
+			expected = kept
+		case op == 6 && len(expected) > 10 && r.Intn(10) == 0:
+			start := r.Intn(5)
+			stop := len(expected) - r.Intn(5)
+			ql.Trim(start, stop)
+			expected = expected[start:stop]
+		}
+	}
+	assertList(t, expected, ql)
+	assertList(t, expected, ql.Clone())
+}
@@ -0,0 +1,212 @@
+package set
+
+import (
+	"godis/datastruct/dict"
+	"godis/pkg/wildcard"
+	"math/rand"
+	"slices"
+	"strconv"
+)
+
+// Set is a set of strings. While all members are integers and there are at most maxIntsetEntries
+// of them the set is a sorted slice of int64 searched by binary search, otherwise a dict.
+type Set struct {
+	intset []int64
+	table  *dict.ConcurrentDict
+
+	maxIntsetEntries int
+}
+
+func New(maxIntsetEntries int) *Set {
+	return &Set{
+		maxIntsetEntries: maxIntsetEntries,
+	}
+}
+
+// parseInt accepts canonical integers only, so that converting back yields the same member
+func parseInt(member string) (int64, bool) {
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+	return n, true
+}
+
+// Encoding returns the name of the internal encoding: intset while all members are integers and
+// there are at most set-max-intset-entries of them, or hashtable after that
+func (s *Set) Encoding() string {
+	if s.table != nil {
+		return "hashtable"
+	}
+	return "intset"
+}
+
+func (s *Set) Len() int {
+	if s.table != nil {
+		return s.table.Len()
+	}
+	return len(s.intset)
+}
+
+func (s *Set) convert() {
+	table := dict.NewConcurrentDict(1)
+	for _, n := range s.intset {
+		table.PutWithoutLock(strconv.FormatInt(n, 10), nil)
+	}
+	s.table = table
+	s.intset = nil
+}
+
+// Add adds member, returns true if it wasn't in the set
+func (s *Set) Add(member string) bool {
+	if s.table == nil {
+		n, ok := parseInt(member)
+		if ok {
+			i, found := slices.BinarySearch(s.intset, n)
+			if found {
+				return false
+			}
+			if len(s.intset) < s.maxIntsetEntries {
+				s.intset = slices.Insert(s.intset, i, n)
+				return true
+			}
+		}
+		s.convert()
+	}
+	return s.table.PutWithoutLock(member, nil) > 0
+}
+
+// Remove removes member, returns true if it was in the set
+func (s *Set) Remove(member string) bool {
+	if s.table != nil {
+		_, ok := s.table.RemoveWithoutLock(member)
+		return ok
+	}
+	n, ok := parseInt(member)
+	if !ok {
+		return false
+	}
+	i, found := slices.BinarySearch(s.intset, n)
+	if !found {
+		return false
+	}
+	s.intset = slices.Delete(s.intset, i, i+1)
+	return true
+}
+
+func (s *Set) Has(member string) bool {
+	if s.table != nil {
+		_, ok := s.table.GetWithoutLock(member)
+		return ok
+	}
+	n, ok := parseInt(member)
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(s.intset, n)
+	return found
+}
+
+// ForEach visits all members, stops when consumer returns false
+func (s *Set) ForEach(consumer func(member string) bool) {
+	if s.table != nil {
+		s.table.ForEach(func(member string, _ any) bool {
+			return consumer(member)
+		})
+		return
+	}
+	for _, n := range s.intset {
+		if !consumer(strconv.FormatInt(n, 10)) {
+			return
+		}
+	}
+}
+
+// Members returns all members, an intset returns them in ascending order
+func (s *Set) Members() []string {
+	members := make([]string, 0, s.Len())
+	s.ForEach(func(member string) bool {
+		members = append(members, member)
+		return true
+	})
+	return members
+}
+
+// RandomMember returns a random member, false if the set is empty
+func (s *Set) RandomMember() (string, bool) {
+	if s.Len() == 0 {
+		return "", false
+	}
+	if s.table != nil {
+		return s.table.RandomKey()
+	}
+	return strconv.FormatInt(s.intset[rand.Intn(len(s.intset))], 10), true
+}
+
+// RandomDistinctMembers returns up to count distinct random members
+func (s *Set) RandomDistinctMembers(count int) []string {
+	size := s.Len()
+	// 与redis相同，需要的成员数接近总数时取出全部成员后打乱，否则逐个随机选取
+	if count*3 > size {
+		members := s.Members()
+		rand.Shuffle(len(members), func(i, j int) {
+			members[i], members[j] = members[j], members[i]
+		})
+		return members[:min(count, size)]
+	}
+	picked := make(map[string]struct{}, count)
+	members := make([]string, 0, count)
+	for len(members) < count {
+		member, _ := s.RandomMember()
+		if _, ok := picked[member]; ok {
+			continue
+		}
+		picked[member] = struct{}{}
+		members = append(members, member)
+	}
+	return members
+}
+
+// Scan returns the members of about count buckets matching pattern starting at cursor,
+// an intset is returned at once. The cursor semantics are the same as dict.DictScan.
+func (s *Set) Scan(cursor int, count int, pattern string) ([]string, int) {
+	if s.table != nil {
+		keys, next := s.table.DictScan(cursor, count, pattern)
+		members := make([]string, len(keys))
+		for i, key := range keys {
+			members[i] = string(key)
+		}
+		return members, next
+	}
+
+	var exp *wildcard.Pattern
+	if pattern != "*" {
+		var err error
+		exp, err = wildcard.Compile(pattern)
+		if err != nil {
+			return nil, -1
+		}
+	}
+	var members []string
+	s.ForEach(func(member string) bool {
+		if exp == nil || exp.Match(member) {
+			members = append(members, member)
+		}
+		return true
+	})
+	return members, 0
+}
+
+func (s *Set) Clone() *Set {
+	c := New(s.maxIntsetEntries)
+	if s.table != nil {
+		c.table = dict.NewConcurrentDict(1)
+		s.table.ForEach(func(member string, _ any) bool {
+			c.table.PutWithoutLock(member, nil)
+			return true
+		})
+	} else {
+		c.intset = slices.Clone(s.intset)
+	}
+	return c
+}
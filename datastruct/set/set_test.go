@@ -0,0 +1,77 @@
+package set
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetIntset(t *testing.T) {
+	s := New(4)
+	for _, m := range []string{"3", "1", "2", "-5"} {
+		assert.True(t, s.Add(m))
+	}
+	assert.False(t, s.Add("2"))
+	assert.Equal(t, "intset", s.Encoding())
+	assert.Equal(t, []string{"-5", "1", "2", "3"}, s.Members())
+	// 非规范整数不能放入intset
+	assert.False(t, s.Has("01"))
+	assert.True(t, s.Remove("1"))
+	assert.False(t, s.Remove("1"))
+
+	assert.True(t, s.Add("01"))
+	assert.Equal(t, "hashtable", s.Encoding())
+	assert.True(t, s.Has("2"))
+	assert.True(t, s.Has("01"))
+	assert.Equal(t, 4, s.Len())
+
+	big := New(4)
+	for i := 0; i < 5; i++ {
+		big.Add(strconv.Itoa(i))
+	}
+	assert.Equal(t, "hashtable", big.Encoding())
+	assert.Equal(t, 5, big.Len())
+}
+
+func TestSetScan(t *testing.T) {
+	for _, s := range []*Set{New(512), New(1)} {
+		for i := 0; i < 100; i++ {
+			s.Add(strconv.Itoa(i))
+		}
+		found := make(map[string]struct{})
+		cursor := 0
+		for {
+			var members []string
+			members, cursor = s.Scan(cursor, 10, "*")
+			for _, m := range members {
+				found[m] = struct{}{}
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+		assert.Equal(t, 100, len(found), s.Encoding())
+
+		c := s.Clone()
+		c.Remove("1")
+		assert.True(t, s.Has("1"))
+		assert.Equal(t, 99, c.Len())
+	}
+}
+
+func TestSetRandomEmptyMember(t *testing.T) {
+	s := New(512)
+	s.Add("")
+	assert.Equal(t, "hashtable", s.Encoding())
+	member, ok := s.RandomMember()
+	assert.True(t, ok)
+	assert.Equal(t, "", member)
+
+	// 逐个随机选取时也能选到空字符串
+	for i := 0; i < 9; i++ {
+		s.Add("m" + strconv.Itoa(i))
+	}
+	assert.ElementsMatch(t, s.Members(), s.RandomDistinctMembers(10))
+	assert.Len(t, s.RandomDistinctMembers(1), 1)
+}
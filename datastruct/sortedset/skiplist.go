@@ -0,0 +1,171 @@
+package sortedset
+
+import (
+	"math/rand"
+)
+
+// 与redis相同的跳表参数
+const (
+	maxLevel    = 32
+	levelFactor = 0.25
+)
+
+type level struct {
+	forward *node
+	// 到forward之间跨越的节点数，用于按排名查找
+	span int
+}
+
+type node struct {
+	Element
+	backward *node
+	levels   []level
+}
+
+// skiplist orders elements by score then member, every level keeps the span to the next node
+// so the rank of an element is found in O(log n) on the way down
+type skiplist struct {
+	header *node
+	tail   *node
+	length int
+	level  int
+}
+
+func newNode(lvl int, member string, score float64) *node {
+	return &node{
+		Element: Element{Member: member, Score: score},
+		levels:  make([]level, lvl),
+	}
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: newNode(maxLevel, "", 0),
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	lvl := 1
+	for lvl < maxLevel && rand.Float64() < levelFactor {
+		lvl++
+	}
+	return lvl
+}
+
+func (sl *skiplist) insert(member string, score float64) *node {
+	var update [maxLevel]*node
+	var rank [maxLevel]int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].forward != nil && x.levels[i].forward.less(member, score) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	lvl := randomLevel()
+	if lvl > sl.level {
+		for i := sl.level; i < lvl; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].levels[i].span = sl.length
+		}
+		sl.level = lvl
+	}
+
+	x = newNode(lvl, member, score)
+	for i := 0; i < lvl; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	// 更高层跨过了新节点
+	for i := lvl; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+	return x
+}
+
+func (sl *skiplist) removeNode(x *node, update []*node) {
+	for i := 0; i < sl.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.levels[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+func (sl *skiplist) remove(member string, score float64) bool {
+	update := make([]*node, maxLevel)
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.less(member, score) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	x = x.levels[0].forward
+	if x == nil || x.Score != score || x.Member != member {
+		return false
+	}
+	sl.removeNode(x, update)
+	return true
+}
+
+// countWhile returns the number of leading elements matching pred,
+// pred must hold for a prefix of the list and fail for the rest
+func (sl *skiplist) countWhile(pred func(e *Element) bool) int {
+	count := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && pred(&x.levels[i].forward.Element) {
+			count += x.levels[i].span
+			x = x.levels[i].forward
+		}
+	}
+	return count
+}
+
+// getByRank returns the node at rank, rank starts from 1
+func (sl *skiplist) getByRank(rank int) *node {
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
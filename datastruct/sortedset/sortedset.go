@@ -0,0 +1,381 @@
+package sortedset
+
+import (
+	"godis/datastruct/dict"
+	"godis/pkg/wildcard"
+	"math/rand"
+	"slices"
+	"sort"
+)
+
+type Element struct {
+	Member string
+	Score  float64
+}
+
+// less reports whether e sorts before (member, score), ties on score are ordered by member
+func (e *Element) less(member string, score float64) bool {
+	return e.Score < score || (e.Score == score && e.Member < member)
+}
+
+// ScoreBorder is a bound of a score range, Exclude means the bound itself is not in the range
+type ScoreBorder struct {
+	Value   float64
+	Exclude bool
+}
+
+// Above reports whether score lies above the border taken as a minimum
+func (b ScoreBorder) Above(score float64) bool {
+	return score > b.Value || (!b.Exclude && score == b.Value)
+}
+
+// Below reports whether score lies below the border taken as a maximum
+func (b ScoreBorder) Below(score float64) bool {
+	return score < b.Value || (!b.Exclude && score == b.Value)
+}
+
+// LexBorder is a bound of a lexicographical range, Inf is -1 for "-" and 1 for "+"
+type LexBorder struct {
+	Value   string
+	Exclude bool
+	Inf     int
+}
+
+// Above reports whether member lies above the border taken as a minimum
+func (b LexBorder) Above(member string) bool {
+	switch b.Inf {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	return member > b.Value || (!b.Exclude && member == b.Value)
+}
+
+// Below reports whether member lies below the border taken as a maximum
+func (b LexBorder) Below(member string) bool {
+	switch b.Inf {
+	case -1:
+		return false
+	case 1:
+		return true
+	}
+	return member < b.Value || (!b.Exclude && member == b.Value)
+}
+
+// SortedSet is a set of members ordered by score. Small sets are a slice sorted by (score, member)
+// like a listpack, larger ones a skiplist with spans for ranking plus a dict from member to score.
+// Every range query is reduced to a range of ranks, found by binary search or by walking down
+// the skiplist.
+type SortedSet struct {
+	listpack []Element
+
+	skiplist *skiplist
+	// member -> score
+	dict *dict.ConcurrentDict
+
+	maxEntries int
+	maxValue   int
+}
+
+func New(maxEntries, maxValue int) *SortedSet {
+	return &SortedSet{
+		maxEntries: maxEntries,
+		maxValue:   maxValue,
+	}
+}
+
+// Encoding returns the name of the internal encoding: listpack, or skiplist once the sorted set
+// grows past zset-max-listpack-entries or zset-max-listpack-value
+func (z *SortedSet) Encoding() string {
+	if z.skiplist != nil {
+		return "skiplist"
+	}
+	return "listpack"
+}
+
+func (z *SortedSet) Len() int {
+	if z.skiplist != nil {
+		return z.skiplist.length
+	}
+	return len(z.listpack)
+}
+
+func (z *SortedSet) convert() {
+	z.skiplist = newSkiplist()
+	z.dict = dict.NewConcurrentDict(1)
+	for _, e := range z.listpack {
+		z.skiplist.insert(e.Member, e.Score)
+		z.dict.PutWithoutLock(e.Member, e.Score)
+	}
+	z.listpack = nil
+}
+
+// Get returns the score of member
+func (z *SortedSet) Get(member string) (float64, bool) {
+	if z.skiplist != nil {
+		score, ok := z.dict.GetWithoutLock(member)
+		if !ok {
+			return 0, false
+		}
+		return score.(float64), true
+	}
+	for _, e := range z.listpack {
+		if e.Member == member {
+			return e.Score, true
+		}
+	}
+	return 0, false
+}
+
+// Add sets the score of member, returns true if member is new
+func (z *SortedSet) Add(member string, score float64) bool {
+	old, exists := z.Get(member)
+	if exists {
+		if old == score {
+			return false
+		}
+		z.remove(member, old)
+	}
+	if z.skiplist == nil && (len(z.listpack) >= z.maxEntries || len(member) > z.maxValue) {
+		z.convert()
+	}
+	if z.skiplist != nil {
+		z.skiplist.insert(member, score)
+		z.dict.PutWithoutLock(member, score)
+	} else {
+		i := sort.Search(len(z.listpack), func(i int) bool {
+			return !z.listpack[i].less(member, score)
+		})
+		z.listpack = slices.Insert(z.listpack, i, Element{Member: member, Score: score})
+	}
+	return !exists
+}
+
+func (z *SortedSet) remove(member string, score float64) {
+	if z.skiplist != nil {
+		z.skiplist.remove(member, score)
+		z.dict.RemoveWithoutLock(member)
+		return
+	}
+	i := z.countWhile(func(e *Element) bool {
+		return e.less(member, score)
+	})
+	z.listpack = slices.Delete(z.listpack, i, i+1)
+}
+
+// Remove removes member, returns true if it was in the set
+func (z *SortedSet) Remove(member string) bool {
+	score, ok := z.Get(member)
+	if !ok {
+		return false
+	}
+	z.remove(member, score)
+	return true
+}
+
+// countWhile returns the number of leading elements matching pred,
+// pred must hold for a prefix of the set and fail for the rest
+func (z *SortedSet) countWhile(pred func(e *Element) bool) int {
+	if z.skiplist != nil {
+		return z.skiplist.countWhile(pred)
+	}
+	return sort.Search(len(z.listpack), func(i int) bool {
+		return !pred(&z.listpack[i])
+	})
+}
+
+// Rank returns the 0 based rank of member, in descending order if desc is set
+func (z *SortedSet) Rank(member string, desc bool) (int, bool) {
+	score, ok := z.Get(member)
+	if !ok {
+		return 0, false
+	}
+	rank := z.countWhile(func(e *Element) bool {
+		return e.less(member, score)
+	})
+	if desc {
+		rank = z.Len() - 1 - rank
+	}
+	return rank, true
+}
+
+// ScoreRange returns the ranks [start, stop) of the elements with scores between min and max
+func (z *SortedSet) ScoreRange(min, max ScoreBorder) (int, int) {
+	start := z.countWhile(func(e *Element) bool {
+		return !min.Above(e.Score)
+	})
+	stop := z.countWhile(func(e *Element) bool {
+		return max.Below(e.Score)
+	})
+	return start, stop
+}
+
+// LexRange returns the ranks [start, stop) of the elements with members between min and max,
+// it's only meaningful when all elements have the same score
+func (z *SortedSet) LexRange(min, max LexBorder) (int, int) {
+	start := z.countWhile(func(e *Element) bool {
+		return !min.Above(e.Member)
+	})
+	stop := z.countWhile(func(e *Element) bool {
+		return max.Below(e.Member)
+	})
+	return start, stop
+}
+
+// ForEachInRange visits the elements of ranks [start, stop) in ascending order,
+// or in descending order from rank stop-1 down to start if desc is set
+func (z *SortedSet) ForEachInRange(start, stop int, desc bool, consumer func(e Element) bool) {
+	start = max(start, 0)
+	stop = min(stop, z.Len())
+	if start >= stop {
+		return
+	}
+	if z.skiplist == nil {
+		if desc {
+			for i := stop - 1; i >= start; i-- {
+				if !consumer(z.listpack[i]) {
+					return
+				}
+			}
+			return
+		}
+		for i := start; i < stop; i++ {
+			if !consumer(z.listpack[i]) {
+				return
+			}
+		}
+		return
+	}
+
+	if desc {
+		x := z.skiplist.getByRank(stop)
+		for i := stop; i > start && x != nil; i-- {
+			if !consumer(x.Element) {
+				return
+			}
+			x = x.backward
+		}
+		return
+	}
+	x := z.skiplist.getByRank(start + 1)
+	for i := start; i < stop && x != nil; i++ {
+		if !consumer(x.Element) {
+			return
+		}
+		x = x.levels[0].forward
+	}
+}
+
+// Range returns the elements of ranks [start, stop), see ForEachInRange
+func (z *SortedSet) Range(start, stop int, desc bool) []Element {
+	result := make([]Element, 0, max(min(stop, z.Len())-max(start, 0), 0))
+	z.ForEachInRange(start, stop, desc, func(e Element) bool {
+		result = append(result, e)
+		return true
+	})
+	return result
+}
+
+// RemoveRange removes the elements of ranks [start, stop), returns the number of elements removed
+func (z *SortedSet) RemoveRange(start, stop int) int {
+	start = max(start, 0)
+	stop = min(stop, z.Len())
+	if start >= stop {
+		return 0
+	}
+	if z.skiplist == nil {
+		z.listpack = slices.Delete(z.listpack, start, stop)
+		return stop - start
+	}
+	for _, e := range z.Range(start, stop, false) {
+		z.remove(e.Member, e.Score)
+	}
+	return stop - start
+}
+
+// ForEach visits all elements in ascending order
+func (z *SortedSet) ForEach(consumer func(e Element) bool) {
+	z.ForEachInRange(0, z.Len(), false, consumer)
+}
+
+// RandomElement returns a random element, false if the set is empty
+func (z *SortedSet) RandomElement() (Element, bool) {
+	if z.Len() == 0 {
+		return Element{}, false
+	}
+	if z.skiplist != nil {
+		return z.skiplist.getByRank(rand.Intn(z.skiplist.length) + 1).Element, true
+	}
+	return z.listpack[rand.Intn(len(z.listpack))], true
+}
+
+// RandomDistinctElements returns up to count distinct random elements
+func (z *SortedSet) RandomDistinctElements(count int) []Element {
+	size := z.Len()
+	// 与redis相同，需要的元素数接近总数时取出全部元素后打乱，否则逐个随机选取
+	if count*3 > size {
+		elements := z.Range(0, size, false)
+		rand.Shuffle(len(elements), func(i, j int) {
+			elements[i], elements[j] = elements[j], elements[i]
+		})
+		return elements[:min(count, size)]
+	}
+	picked := make(map[string]struct{}, count)
+	elements := make([]Element, 0, count)
+	for len(elements) < count {
+		e, _ := z.RandomElement()
+		if _, ok := picked[e.Member]; ok {
+			continue
+		}
+		picked[e.Member] = struct{}{}
+		elements = append(elements, e)
+	}
+	return elements
+}
+
+// Scan returns the elements of about count buckets whose member matches pattern starting at cursor,
+// a listpack is returned at once. The cursor semantics are the same as dict.DictScan.
+func (z *SortedSet) Scan(cursor int, count int, pattern string) ([]Element, int) {
+	if z.skiplist != nil {
+		keys, next := z.dict.DictScan(cursor, count, pattern)
+		elements := make([]Element, len(keys))
+		for i, key := range keys {
+			score, _ := z.Get(string(key))
+			elements[i] = Element{Member: string(key), Score: score}
+		}
+		return elements, next
+	}
+
+	var exp *wildcard.Pattern
+	if pattern != "*" {
+		var err error
+		exp, err = wildcard.Compile(pattern)
+		if err != nil {
+			return nil, -1
+		}
+	}
+	var elements []Element
+	for _, e := range z.listpack {
+		if exp == nil || exp.Match(e.Member) {
+			elements = append(elements, e)
+		}
+	}
+	return elements, 0
+}
+
+func (z *SortedSet) Clone() *SortedSet {
+	c := New(z.maxEntries, z.maxValue)
+	if z.skiplist == nil {
+		c.listpack = slices.Clone(z.listpack)
+		return c
+	}
+	c.skiplist = newSkiplist()
+	c.dict = dict.NewConcurrentDict(1)
+	z.ForEach(func(e Element) bool {
+		c.skiplist.insert(e.Member, e.Score)
+		c.dict.PutWithoutLock(e.Member, e.Score)
+		return true
+	})
+	return c
+}
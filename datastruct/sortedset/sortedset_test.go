@@ -0,0 +1,96 @@
+package sortedset
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// naive keeps the expected content sorted by (score, member)
+func naive(m map[string]float64) []Element {
+	result := make([]Element, 0, len(m))
+	for member, score := range m {
+		result = append(result, Element{Member: member, Score: score})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].less(result[j].Member, result[j].Score)
+	})
+	return result
+}
+
+func TestSortedSetRandomOps(t *testing.T) {
+	for _, z := range []*SortedSet{New(128, 64), New(4, 64)} {
+		expected := make(map[string]float64)
+		for i := 0; i < 2000; i++ {
+			member := strconv.Itoa(rand.Intn(200))
+			if rand.Intn(3) == 0 {
+				_, ok := expected[member]
+				assert.Equal(t, ok, z.Remove(member))
+				delete(expected, member)
+				continue
+			}
+			score := float64(rand.Intn(50))
+			_, ok := expected[member]
+			assert.Equal(t, !ok, z.Add(member, score))
+			expected[member] = score
+		}
+
+		sorted := naive(expected)
+		assert.Equal(t, len(sorted), z.Len())
+		assert.Equal(t, sorted, z.Range(0, z.Len(), false))
+		for rank, e := range sorted {
+			r, ok := z.Rank(e.Member, false)
+			assert.True(t, ok)
+			assert.Equal(t, rank, r)
+			r, _ = z.Rank(e.Member, true)
+			assert.Equal(t, len(sorted)-1-rank, r)
+		}
+
+		start, stop := z.ScoreRange(ScoreBorder{Value: 10}, ScoreBorder{Value: 20, Exclude: true})
+		for i, e := range sorted {
+			inRange := e.Score >= 10 && e.Score < 20
+			assert.Equal(t, inRange, i >= start && i < stop)
+		}
+
+		desc := z.Range(2, 7, true)
+		for i, e := range desc {
+			assert.Equal(t, sorted[6-i], e)
+		}
+
+		assert.Equal(t, 5, z.RemoveRange(0, 5))
+		assert.Equal(t, sorted[5:], z.Range(0, z.Len(), false))
+	}
+}
+
+func TestSortedSetLex(t *testing.T) {
+	z := New(128, 64)
+	for _, m := range []string{"a", "b", "c", "d", "e"} {
+		z.Add(m, 0)
+	}
+	start, stop := z.LexRange(LexBorder{Value: "b"}, LexBorder{Value: "d", Exclude: true})
+	assert.Equal(t, 1, start)
+	assert.Equal(t, 3, stop)
+	start, stop = z.LexRange(LexBorder{Inf: -1}, LexBorder{Inf: 1})
+	assert.Equal(t, 0, start)
+	assert.Equal(t, 5, stop)
+}
+
+func TestSortedSetEncoding(t *testing.T) {
+	z := New(2, 4)
+	z.Add("a", 1)
+	z.Add("b", 2)
+	assert.Equal(t, "listpack", z.Encoding())
+	z.Add("c", 3)
+	assert.Equal(t, "skiplist", z.Encoding())
+
+	z = New(2, 4)
+	z.Add("longer", 1)
+	assert.Equal(t, "skiplist", z.Encoding())
+	c := z.Clone()
+	c.Add("x", 0)
+	assert.Equal(t, 1, z.Len())
+	assert.Equal(t, 2, c.Len())
+}
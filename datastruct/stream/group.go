@@ -0,0 +1,249 @@
+package stream
+
+import (
+	"slices"
+	"sort"
+)
+
+// PendingEntry is an entry delivered to a consumer but not acknowledged yet
+type PendingEntry struct {
+	ID       ID
+	Consumer *Consumer
+	// 最近一次投递的时间，unix毫秒
+	DeliveryTime  int64
+	DeliveryCount int64
+}
+
+type Consumer struct {
+	Name string
+	// 最近一次尝试交互(读取/认领)的时间与最近一次成功读取或认领到条目的时间，unix毫秒
+	SeenTime   int64
+	ActiveTime int64
+	pending    map[ID]*PendingEntry
+}
+
+func (c *Consumer) PendingCount() int {
+	return len(c.pending)
+}
+
+// Group is a consumer group. The pending entries list is kept ordered by ID for range queries,
+// consumers only index the entries they own.
+type Group struct {
+	Name   string
+	LastID ID
+	// 组内已读取的条目数，-1表示因删除无法得知
+	EntriesRead int64
+
+	pel       []*PendingEntry
+	pending   map[ID]*PendingEntry
+	consumers map[string]*Consumer
+}
+
+func newGroup(name string, lastID ID, entriesRead int64) *Group {
+	return &Group{
+		Name:        name,
+		LastID:      lastID,
+		EntriesRead: entriesRead,
+		pending:     make(map[ID]*PendingEntry),
+		consumers:   make(map[string]*Consumer),
+	}
+}
+
+// Group returns the consumer group of name, nil if there is no such group
+func (s *Stream) Group(name string) *Group {
+	return s.groups[name]
+}
+
+// CreateGroup creates a consumer group, returns nil if the group already exists
+func (s *Stream) CreateGroup(name string, lastID ID, entriesRead int64) *Group {
+	if _, ok := s.groups[name]; ok {
+		return nil
+	}
+	g := newGroup(name, lastID, entriesRead)
+	s.groups[name] = g
+	return g
+}
+
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Groups returns all consumer groups ordered by name
+func (s *Stream) Groups() []*Group {
+	groups := make([]*Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+func (g *Group) Consumer(name string) *Consumer {
+	return g.consumers[name]
+}
+
+// CreateConsumer creates a consumer seen at now, returns nil if the consumer already exists
+func (g *Group) CreateConsumer(name string, now int64) *Consumer {
+	if _, ok := g.consumers[name]; ok {
+		return nil
+	}
+	c := &Consumer{
+		Name:       name,
+		SeenTime:   now,
+		ActiveTime: -1,
+		pending:    make(map[ID]*PendingEntry),
+	}
+	g.consumers[name] = c
+	return c
+}
+
+// GetOrCreateConsumer returns the consumer of name, creating it if needed
+func (g *Group) GetOrCreateConsumer(name string, now int64) *Consumer {
+	if c := g.consumers[name]; c != nil {
+		return c
+	}
+	return g.CreateConsumer(name, now)
+}
+
+// DeleteConsumer removes the consumer together with its pending entries,
+// returns the number of pending entries it had or -1 if there is no such consumer
+func (g *Group) DeleteConsumer(name string) int {
+	c, ok := g.consumers[name]
+	if !ok {
+		return -1
+	}
+	count := len(c.pending)
+	for id := range c.pending {
+		g.removePending(id)
+	}
+	delete(g.consumers, name)
+	return count
+}
+
+// Consumers returns all consumers ordered by name
+func (g *Group) Consumers() []*Consumer {
+	consumers := make([]*Consumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		consumers = append(consumers, c)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].Name < consumers[j].Name
+	})
+	return consumers
+}
+
+func (g *Group) PendingCount() int {
+	return len(g.pel)
+}
+
+func (g *Group) Pending(id ID) *PendingEntry {
+	return g.pending[id]
+}
+
+func (g *Group) pelIndex(id ID) int {
+	return sort.Search(len(g.pel), func(i int) bool {
+		return !g.pel[i].ID.Less(id)
+	})
+}
+
+// Deliver records that the entry of id was delivered to consumer at now. An entry already pending
+// is moved to consumer and its delivery counter is increased.
+func (g *Group) Deliver(id ID, consumer *Consumer, now int64) *PendingEntry {
+	if pe := g.pending[id]; pe != nil {
+		g.Transfer(pe, consumer)
+		pe.DeliveryTime = now
+		pe.DeliveryCount++
+		return pe
+	}
+	pe := &PendingEntry{
+		ID:            id,
+		Consumer:      consumer,
+		DeliveryTime:  now,
+		DeliveryCount: 1,
+	}
+	// 新投递的ID通常大于所有待确认ID，直接追加
+	if n := len(g.pel); n == 0 || g.pel[n-1].ID.Less(id) {
+		g.pel = append(g.pel, pe)
+	} else {
+		g.pel = slices.Insert(g.pel, g.pelIndex(id), pe)
+	}
+	g.pending[id] = pe
+	consumer.pending[id] = pe
+	return pe
+}
+
+// Transfer moves a pending entry to consumer
+func (g *Group) Transfer(pe *PendingEntry, consumer *Consumer) {
+	if pe.Consumer == consumer {
+		return
+	}
+	delete(pe.Consumer.pending, pe.ID)
+	pe.Consumer = consumer
+	consumer.pending[pe.ID] = pe
+}
+
+func (g *Group) removePending(id ID) bool {
+	pe, ok := g.pending[id]
+	if !ok {
+		return false
+	}
+	delete(g.pending, id)
+	delete(pe.Consumer.pending, id)
+	i := g.pelIndex(id)
+	g.pel = slices.Delete(g.pel, i, i+1)
+	return true
+}
+
+// Ack removes the entry of id from the pending entries list, returns false if it wasn't pending
+func (g *Group) Ack(id ID) bool {
+	return g.removePending(id)
+}
+
+// PendingRange returns the pending entries with IDs in [start, end] ordered by ID,
+// owned by consumer if it's not nil, at most count entries if count > 0
+func (g *Group) PendingRange(start, end ID, count int, consumer *Consumer) []*PendingEntry {
+	var result []*PendingEntry
+	for i := g.pelIndex(start); i < len(g.pel); i++ {
+		pe := g.pel[i]
+		if end.Less(pe.ID) || (count > 0 && len(result) >= count) {
+			break
+		}
+		if consumer != nil && pe.Consumer != consumer {
+			continue
+		}
+		result = append(result, pe)
+	}
+	return result
+}
+
+func (g *Group) clone() *Group {
+	c := newGroup(g.Name, g.LastID, g.EntriesRead)
+	for name, consumer := range g.consumers {
+		c.consumers[name] = &Consumer{
+			Name:       name,
+			SeenTime:   consumer.SeenTime,
+			ActiveTime: consumer.ActiveTime,
+			pending:    make(map[ID]*PendingEntry, len(consumer.pending)),
+		}
+	}
+	c.pel = make([]*PendingEntry, len(g.pel))
+	for i, pe := range g.pel {
+		owner := c.consumers[pe.Consumer.Name]
+		cpe := &PendingEntry{
+			ID:            pe.ID,
+			Consumer:      owner,
+			DeliveryTime:  pe.DeliveryTime,
+			DeliveryCount: pe.DeliveryCount,
+		}
+		c.pel[i] = cpe
+		c.pending[pe.ID] = cpe
+		owner.pending[pe.ID] = cpe
+	}
+	return c
+}
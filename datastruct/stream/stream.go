@@ -0,0 +1,416 @@
+package stream
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ID identifies an entry of a stream, entries are ordered by milliseconds then sequence number
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	MinID = ID{}
+	MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+var ErrInvalidID = errors.New("invalid stream ID")
+
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id ID) Compare(other ID) int {
+	switch {
+	case id.Ms < other.Ms:
+		return -1
+	case id.Ms > other.Ms:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	}
+	return 0
+}
+
+func (id ID) Less(other ID) bool {
+	return id.Compare(other) < 0
+}
+
+func (id ID) IsZero() bool {
+	return id == MinID
+}
+
+// Next returns the smallest ID greater than id, false if id is already the maximum
+func (id ID) Next() (ID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return ID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Prev returns the greatest ID smaller than id, false if id is already the minimum
+func (id ID) Prev() (ID, bool) {
+	if id.Seq > 0 {
+		return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseID parses "ms-seq" or "ms", missingSeq is used as sequence number in the latter form
+func ParseID(s string, missingSeq uint64) (ID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return ID{}, ErrInvalidID
+	}
+	if !hasSeq {
+		return ID{Ms: ms, Seq: missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return ID{}, ErrInvalidID
+	}
+	return ID{Ms: ms, Seq: seq}, nil
+}
+
+// Entry is a stream entry, Fields holds alternating field names and values
+type Entry struct {
+	ID     ID
+	Fields [][]byte
+}
+
+// block is a listpack-like node holding consecutive entries
+type block struct {
+	entries []Entry
+}
+
+func (b *block) first() ID {
+	return b.entries[0].ID
+}
+
+func (b *block) last() ID {
+	return b.entries[len(b.entries)-1].ID
+}
+
+// Stream is an append only log of entries. Entries are packed into blocks of at most
+// nodeMaxEntries entries, blocks are kept in a slice ordered by ID and located by binary search,
+// the same two level layout as the redis radix tree of listpacks.
+type Stream struct {
+	blocks []*block
+	length int
+
+	nodeMaxEntries int
+
+	lastID ID
+	// 被XDEL删除的最大ID，用于判断消费组的entries-read是否仍然准确
+	maxDeletedID ID
+	// 历史上添加过的条目总数，包括已删除的
+	entriesAdded uint64
+
+	groups map[string]*Group
+}
+
+func New(nodeMaxEntries int) *Stream {
+	return &Stream{
+		nodeMaxEntries: max(nodeMaxEntries, 1),
+		groups:         make(map[string]*Group),
+	}
+}
+
+func (s *Stream) Len() int {
+	return s.length
+}
+
+func (s *Stream) LastID() ID {
+	return s.lastID
+}
+
+// SetLastID sets the last generated ID, used when the stream is restored
+func (s *Stream) SetLastID(id ID) {
+	s.lastID = id
+}
+
+func (s *Stream) MaxDeletedID() ID {
+	return s.maxDeletedID
+}
+
+func (s *Stream) EntriesAdded() uint64 {
+	return s.entriesAdded
+}
+
+// SetMaxDeletedID and SetEntriesAdded restore the counters of a stream, used by XSETID
+func (s *Stream) SetMaxDeletedID(id ID) {
+	s.maxDeletedID = id
+}
+
+func (s *Stream) SetEntriesAdded(n uint64) {
+	s.entriesAdded = n
+}
+
+// BlockCount returns the number of blocks, reported as radix-tree-keys by XINFO
+func (s *Stream) BlockCount() int {
+	return len(s.blocks)
+}
+
+// FirstID returns the ID of the first entry, zero if the stream is empty
+func (s *Stream) FirstID() ID {
+	if len(s.blocks) == 0 {
+		return MinID
+	}
+	return s.blocks[0].first()
+}
+
+func (s *Stream) FirstEntry() (Entry, bool) {
+	if len(s.blocks) == 0 {
+		return Entry{}, false
+	}
+	return s.blocks[0].entries[0], true
+}
+
+func (s *Stream) LastEntry() (Entry, bool) {
+	if len(s.blocks) == 0 {
+		return Entry{}, false
+	}
+	b := s.blocks[len(s.blocks)-1]
+	return b.entries[len(b.entries)-1], true
+}
+
+// NextID generates an ID greater than the last one for an entry added at ms, false if the ID space is exhausted
+func (s *Stream) NextID(ms uint64) (ID, bool) {
+	if ms > s.lastID.Ms {
+		return ID{Ms: ms}, true
+	}
+	return s.lastID.Next()
+}
+
+// NextSeqID generates the ID with the given milliseconds and the next sequence number,
+// false if no such ID is greater than the last one
+func (s *Stream) NextSeqID(ms uint64) (ID, bool) {
+	if ms > s.lastID.Ms {
+		return ID{Ms: ms}, true
+	}
+	if ms < s.lastID.Ms || s.lastID.Seq == math.MaxUint64 {
+		return ID{}, false
+	}
+	return ID{Ms: ms, Seq: s.lastID.Seq + 1}, true
+}
+
+// Append adds an entry, id must be greater than the last ID
+func (s *Stream) Append(id ID, fields [][]byte) {
+	if len(s.blocks) == 0 || len(s.blocks[len(s.blocks)-1].entries) >= s.nodeMaxEntries {
+		s.blocks = append(s.blocks, &block{entries: make([]Entry, 0, s.nodeMaxEntries)})
+	}
+	b := s.blocks[len(s.blocks)-1]
+	b.entries = append(b.entries, Entry{ID: id, Fields: fields})
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// seek returns the position of the first entry with an ID not less than id
+func (s *Stream) seek(id ID) (int, int) {
+	bi := sort.Search(len(s.blocks), func(i int) bool {
+		return !s.blocks[i].last().Less(id)
+	})
+	if bi == len(s.blocks) {
+		return bi, 0
+	}
+	entries := s.blocks[bi].entries
+	ei := sort.Search(len(entries), func(i int) bool {
+		return !entries[i].ID.Less(id)
+	})
+	return bi, ei
+}
+
+func (s *Stream) Get(id ID) (Entry, bool) {
+	bi, ei := s.seek(id)
+	if bi == len(s.blocks) {
+		return Entry{}, false
+	}
+	e := s.blocks[bi].entries[ei]
+	if e.ID != id {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Range returns the entries with IDs in [start, end], at most count entries if count > 0,
+// from end down to start if rev is set
+func (s *Stream) Range(start, end ID, count int, rev bool) []Entry {
+	var result []Entry
+	if end.Less(start) {
+		return result
+	}
+	full := func() bool {
+		return count > 0 && len(result) >= count
+	}
+	if !rev {
+		bi, ei := s.seek(start)
+		for ; bi < len(s.blocks); bi, ei = bi+1, 0 {
+			for _, e := range s.blocks[bi].entries[ei:] {
+				if end.Less(e.ID) || full() {
+					return result
+				}
+				result = append(result, e)
+			}
+		}
+		return result
+	}
+
+	// seek定位到第一个不小于end的条目，从它的前一个开始反向遍历，等于end时包含该条目
+	bi, ei := s.seek(end)
+	if bi == len(s.blocks) {
+		if bi == 0 {
+			return result
+		}
+		bi--
+		ei = len(s.blocks[bi].entries)
+	} else if s.blocks[bi].entries[ei].ID == end {
+		ei++
+	}
+	for ; bi >= 0; bi-- {
+		entries := s.blocks[bi].entries
+		if ei < 0 {
+			ei = len(entries)
+		}
+		for i := ei - 1; i >= 0; i-- {
+			if entries[i].ID.Less(start) || full() {
+				return result
+			}
+			result = append(result, entries[i])
+		}
+		ei = -1
+	}
+	return result
+}
+
+func (s *Stream) removeAt(bi, ei int) {
+	b := s.blocks[bi]
+	b.entries = append(b.entries[:ei], b.entries[ei+1:]...)
+	if len(b.entries) == 0 {
+		s.blocks = append(s.blocks[:bi], s.blocks[bi+1:]...)
+	}
+	s.length--
+}
+
+// Delete removes the entry of id, returns false if there is no such entry
+func (s *Stream) Delete(id ID) bool {
+	bi, ei := s.seek(id)
+	if bi == len(s.blocks) || s.blocks[bi].entries[ei].ID != id {
+		return false
+	}
+	s.removeAt(bi, ei)
+	if s.maxDeletedID.Less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+// trim removes entries from the head while shouldRemove holds for the first entry.
+// An approximate trim only removes whole blocks like redis does, limit caps the number of
+// entries removed if it's greater than 0.
+func (s *Stream) trim(approx bool, limit int, shouldRemove func(first ID, length int, blockLen int) bool) int {
+	removed := 0
+	for len(s.blocks) > 0 {
+		b := s.blocks[0]
+		if approx {
+			if !shouldRemove(b.last(), s.length, len(b.entries)) {
+				break
+			}
+			if limit > 0 && removed+len(b.entries) > limit {
+				break
+			}
+			removed += len(b.entries)
+			s.length -= len(b.entries)
+			s.blocks = s.blocks[1:]
+			continue
+		}
+		if !shouldRemove(b.first(), s.length, 1) {
+			break
+		}
+		s.removeAt(0, 0)
+		removed++
+	}
+	return removed
+}
+
+// TrimMaxLen removes the oldest entries until at most maxLen entries are left, returns the number removed
+func (s *Stream) TrimMaxLen(maxLen int, approx bool, limit int) int {
+	return s.trim(approx, limit, func(_ ID, length int, n int) bool {
+		return length-n >= maxLen
+	})
+}
+
+// TrimMinID removes the entries with IDs less than minID, returns the number removed
+func (s *Stream) TrimMinID(minID ID, approx bool, limit int) int {
+	return s.trim(approx, limit, func(id ID, _ int, _ int) bool {
+		return id.Less(minID)
+	})
+}
+
+// HasTombstones reports whether entries with IDs not less than start were deleted by XDEL
+func (s *Stream) HasTombstones(start ID) bool {
+	if s.length == 0 || s.maxDeletedID.IsZero() {
+		return false
+	}
+	return !s.maxDeletedID.Less(start)
+}
+
+// EstimateEntriesRead estimates how many entries were ever added up to id,
+// -1 if it can't be known because of deletions
+func (s *Stream) EstimateEntriesRead(id ID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if s.length == 0 && !s.lastID.Less(id) {
+		return int64(s.entriesAdded)
+	}
+	switch id.Compare(s.lastID) {
+	case 0:
+		return int64(s.entriesAdded)
+	case 1:
+		return -1
+	}
+	// 第一个条目之后没有被删除的条目时，可以由添加总数和长度推算
+	first := s.FirstID()
+	if s.maxDeletedID.IsZero() || s.maxDeletedID.Less(first) {
+		switch id.Compare(first) {
+		case -1:
+			return int64(s.entriesAdded) - int64(s.length)
+		case 0:
+			return int64(s.entriesAdded) - int64(s.length) + 1
+		}
+	}
+	return -1
+}
+
+func (s *Stream) Clone() *Stream {
+	c := New(s.nodeMaxEntries)
+	c.length = s.length
+	c.lastID = s.lastID
+	c.maxDeletedID = s.maxDeletedID
+	c.entriesAdded = s.entriesAdded
+	c.blocks = make([]*block, len(s.blocks))
+	for i, b := range s.blocks {
+		entries := make([]Entry, len(b.entries), cap(b.entries))
+		copy(entries, b.entries)
+		c.blocks[i] = &block{entries: entries}
+	}
+	for name, g := range s.groups {
+		c.groups[name] = g.clone()
+	}
+	return c
+}
@@ -0,0 +1,129 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ids(entries []Entry) []ID {
+	result := make([]ID, len(entries))
+	for i, e := range entries {
+		result[i] = e.ID
+	}
+	return result
+}
+
+func TestParseID(t *testing.T) {
+	id, err := ParseID("5-3", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, ID{Ms: 5, Seq: 3}, id)
+	id, err = ParseID("5", 7)
+	assert.Nil(t, err)
+	assert.Equal(t, ID{Ms: 5, Seq: 7}, id)
+	for _, s := range []string{"", "a-1", "1-", "-1", "1-2-3"} {
+		_, err = ParseID(s, 0)
+		assert.Equal(t, ErrInvalidID, err, s)
+	}
+	assert.Equal(t, "5-3", ID{Ms: 5, Seq: 3}.String())
+
+	next, ok := ID{Ms: 1, Seq: MaxID.Seq}.Next()
+	assert.True(t, ok)
+	assert.Equal(t, ID{Ms: 2}, next)
+	_, ok = MaxID.Next()
+	assert.False(t, ok)
+	prev, ok := ID{Ms: 2}.Prev()
+	assert.True(t, ok)
+	assert.Equal(t, ID{Ms: 1, Seq: MaxID.Seq}, prev)
+}
+
+func TestStreamRange(t *testing.T) {
+	s := New(3)
+	for i := uint64(1); i <= 10; i++ {
+		s.Append(ID{Ms: i}, [][]byte{[]byte("f"), []byte("v")})
+	}
+	assert.Equal(t, 10, s.Len())
+	assert.Equal(t, 4, s.BlockCount())
+
+	assert.Equal(t, []ID{{Ms: 3}, {Ms: 4}, {Ms: 5}}, ids(s.Range(ID{Ms: 3}, ID{Ms: 5}, 0, false)))
+	assert.Equal(t, []ID{{Ms: 5}, {Ms: 4}, {Ms: 3}}, ids(s.Range(ID{Ms: 3}, ID{Ms: 5}, 0, true)))
+	assert.Equal(t, []ID{{Ms: 10}, {Ms: 9}}, ids(s.Range(MinID, MaxID, 2, true)))
+	assert.Equal(t, []ID{{Ms: 1}, {Ms: 2}}, ids(s.Range(MinID, MaxID, 2, false)))
+	assert.Len(t, s.Range(ID{Ms: 11}, MaxID, 0, false), 0)
+	assert.Len(t, s.Range(ID{Ms: 5}, ID{Ms: 3}, 0, true), 0)
+	assert.Equal(t, []ID{{Ms: 4}, {Ms: 3}}, ids(s.Range(MinID, ID{Ms: 4, Seq: 5}, 2, true)))
+
+	assert.True(t, s.Delete(ID{Ms: 4}))
+	assert.False(t, s.Delete(ID{Ms: 4}))
+	assert.Equal(t, ID{Ms: 4}, s.MaxDeletedID())
+	_, ok := s.Get(ID{Ms: 4})
+	assert.False(t, ok)
+	e, ok := s.Get(ID{Ms: 5})
+	assert.True(t, ok)
+	assert.Equal(t, ID{Ms: 5}, e.ID)
+	assert.Equal(t, []ID{{Ms: 3}, {Ms: 5}}, ids(s.Range(ID{Ms: 3}, ID{Ms: 5}, 0, false)))
+	assert.Equal(t, uint64(10), s.EntriesAdded())
+	assert.Equal(t, 9, s.Len())
+}
+
+func TestStreamTrim(t *testing.T) {
+	s := New(3)
+	for i := uint64(1); i <= 10; i++ {
+		s.Append(ID{Ms: i}, nil)
+	}
+	// 近似裁剪只删除整个节点
+	assert.Equal(t, 6, s.TrimMaxLen(3, true, 0))
+	assert.Equal(t, 4, s.Len())
+	assert.Equal(t, ID{Ms: 7}, s.FirstID())
+	assert.Equal(t, 1, s.TrimMaxLen(3, false, 0))
+	assert.Equal(t, ID{Ms: 8}, s.FirstID())
+	assert.Equal(t, 2, s.TrimMinID(ID{Ms: 10}, false, 0))
+	assert.Equal(t, 1, s.Len())
+	assert.Equal(t, ID{Ms: 10}, s.LastID())
+
+	s = New(3)
+	for i := uint64(1); i <= 10; i++ {
+		s.Append(ID{Ms: i}, nil)
+	}
+	assert.Equal(t, 3, s.TrimMinID(ID{Ms: 9}, true, 5))
+}
+
+func TestStreamGroup(t *testing.T) {
+	s := New(100)
+	for i := uint64(1); i <= 5; i++ {
+		s.Append(ID{Ms: i}, nil)
+	}
+	g := s.CreateGroup("g", MinID, 0)
+	assert.NotNil(t, g)
+	assert.Nil(t, s.CreateGroup("g", MinID, 0))
+
+	alice := g.CreateConsumer("alice", 0)
+	bob := g.GetOrCreateConsumer("bob", 0)
+	assert.Nil(t, g.CreateConsumer("alice", 0))
+	for i := uint64(5); i >= 1; i-- {
+		g.Deliver(ID{Ms: i}, alice, 100)
+	}
+	assert.Equal(t, 5, g.PendingCount())
+	assert.Equal(t, []*PendingEntry{g.Pending(ID{Ms: 2}), g.Pending(ID{Ms: 3})}, g.PendingRange(ID{Ms: 2}, MaxID, 2, nil))
+
+	pe := g.Deliver(ID{Ms: 3}, bob, 200)
+	assert.Equal(t, int64(2), pe.DeliveryCount)
+	assert.Equal(t, 4, alice.PendingCount())
+	assert.Equal(t, 1, bob.PendingCount())
+	assert.Len(t, g.PendingRange(MinID, MaxID, 0, bob), 1)
+
+	c := s.Clone()
+	assert.True(t, g.Ack(ID{Ms: 1}))
+	assert.False(t, g.Ack(ID{Ms: 1}))
+	assert.Equal(t, 3, alice.PendingCount())
+	assert.Equal(t, 3, g.DeleteConsumer("alice"))
+	assert.Equal(t, -1, g.DeleteConsumer("alice"))
+	assert.Equal(t, 1, g.PendingCount())
+
+	cg := c.Group("g")
+	assert.Equal(t, 5, cg.PendingCount())
+	assert.Equal(t, 4, cg.Consumer("alice").PendingCount())
+	assert.Same(t, cg.Consumer("bob"), cg.Pending(ID{Ms: 3}).Consumer)
+	assert.True(t, c.DestroyGroup("g"))
+	assert.NotNil(t, s.Group("g"))
+}
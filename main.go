@@ -3,7 +3,11 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"godis/config"
+	"godis/database"
 	"godis/pkg/logx"
+	"godis/resp/handler"
 	"godis/tcp"
 	"log"
 	"net"
@@ -23,7 +27,7 @@ func Run(addr string, handler tcp.Handler) {
 	log.Println("Listening on", addr)
 
 	closeChan := make(chan struct{})
-	signChan := make(chan os.Signal)
+	signChan := make(chan os.Signal, 1)
 	signal.Notify(signChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
 	go func() {
 		<-signChan
@@ -59,8 +63,21 @@ func ListenAndServe(l net.Listener, handler tcp.Handler, closeChan chan struct{}
 		}()
 	}
 	wg.Wait()
+	// 等待关闭流程结束，保证AOF等数据在退出前落盘
+	_ = handler.Close()
+}
+
+const configFile = "redis.conf"
+
+func fileExists(filename string) bool {
+	info, err := os.Stat(filename)
+	return err == nil && !info.IsDir()
 }
 
 func main() {
-	Run(":8888", tcp.NewEchoHandler())
+	if fileExists(configFile) {
+		config.SetupConfig(configFile)
+	}
+	addr := fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port)
+	Run(addr, handler.NewRespHandler(database.NewServer()))
 }
@@ -0,0 +1,345 @@
+package geohash
+
+import (
+	"math"
+)
+
+// 与redis相同，纬度限制在墨卡托投影可表示的范围内
+const (
+	LongMin = -180.0
+	LongMax = 180.0
+	LatMin  = -85.05112878
+	LatMax  = 85.05112878
+
+	// StepMax is the precision of stored hashes, 26 steps give a 52 bits integer exactly representable by a float64 score
+	StepMax = 26
+
+	earthRadiusInMeters = 6372797.560856
+	mercatorMax         = 20037726.37
+
+	alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+type Range struct {
+	Min float64
+	Max float64
+}
+
+// Bits is a hash of step bits per coordinate, latitude bits at even positions and longitude bits at odd ones
+type Bits struct {
+	Bits uint64
+	Step uint
+}
+
+func (b Bits) IsZero() bool {
+	return b.Bits == 0 && b.Step == 0
+}
+
+// Align52 returns the hash as a 52 bits integer, the score stored in the sorted set
+func (b Bits) Align52() uint64 {
+	return b.Bits << (52 - b.Step*2)
+}
+
+type Area struct {
+	Hash      Bits
+	Longitude Range
+	Latitude  Range
+}
+
+var (
+	longRange = Range{Min: LongMin, Max: LongMax}
+	latRange  = Range{Min: LatMin, Max: LatMax}
+)
+
+// interleave64 spreads the bits of x to the even positions and the bits of y to the odd ones
+func interleave64(x, y uint32) uint64 {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF}
+	s := [...]uint{1, 2, 4, 8, 16}
+	xx, yy := uint64(x), uint64(y)
+	for i := len(b) - 1; i >= 0; i-- {
+		xx = (xx | xx<<s[i]) & b[i]
+		yy = (yy | yy<<s[i]) & b[i]
+	}
+	return xx | yy<<1
+}
+
+// deinterleave64 reverses interleave64, x is returned in the low 32 bits and y in the high ones
+func deinterleave64(interleaved uint64) uint64 {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	s := [...]uint{0, 1, 2, 4, 8, 16}
+	x, y := interleaved, interleaved>>1
+	for i := range b {
+		x = (x | x>>s[i]) & b[i]
+		y = (y | y>>s[i]) & b[i]
+	}
+	return x | y<<32
+}
+
+func encode(longRange, latRange Range, longitude, latitude float64, step uint) (Bits, bool) {
+	if step == 0 || step > 32 {
+		return Bits{}, false
+	}
+	if longitude > LongMax || longitude < LongMin || latitude > LatMax || latitude < LatMin {
+		return Bits{}, false
+	}
+	if latitude < latRange.Min || latitude > latRange.Max || longitude < longRange.Min || longitude > longRange.Max {
+		return Bits{}, false
+	}
+	latOffset := (latitude - latRange.Min) / (latRange.Max - latRange.Min)
+	longOffset := (longitude - longRange.Min) / (longRange.Max - longRange.Min)
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return Bits{Bits: interleave64(uint32(latOffset), uint32(longOffset)), Step: step}, true
+}
+
+// Encode returns the hash of the coordinates with step bits per coordinate, false if they're out of range
+func Encode(longitude, latitude float64, step uint) (Bits, bool) {
+	return encode(longRange, latRange, longitude, latitude, step)
+}
+
+func decode(longRange, latRange Range, hash Bits) Area {
+	sep := deinterleave64(hash.Bits)
+	latScale := latRange.Max - latRange.Min
+	longScale := longRange.Max - longRange.Min
+	ilato := uint32(sep)
+	ilono := uint32(sep >> 32)
+	cells := float64(uint64(1) << hash.Step)
+	return Area{
+		Hash: hash,
+		Latitude: Range{
+			Min: latRange.Min + (float64(ilato)/cells)*latScale,
+			Max: latRange.Min + (float64(ilato)+1)/cells*latScale,
+		},
+		Longitude: Range{
+			Min: longRange.Min + (float64(ilono)/cells)*longScale,
+			Max: longRange.Min + (float64(ilono)+1)/cells*longScale,
+		},
+	}
+}
+
+// Decode returns the area covered by hash
+func Decode(hash Bits) Area {
+	return decode(longRange, latRange, hash)
+}
+
+// Center returns the coordinates of the center of the area
+func (a Area) Center() (float64, float64) {
+	longitude := (a.Longitude.Min + a.Longitude.Max) / 2
+	latitude := (a.Latitude.Min + a.Latitude.Max) / 2
+	return min(max(longitude, LongMin), LongMax), min(max(latitude, LatMin), LatMax)
+}
+
+// DecodeScore returns the coordinates stored as a sorted set score
+func DecodeScore(score float64) (float64, float64) {
+	return Decode(Bits{Bits: uint64(score), Step: StepMax}).Center()
+}
+
+// String returns the standard 11 characters geohash of the coordinates stored as score,
+// which uses the full [-90, 90] latitude range unlike the stored hash
+func String(score float64) string {
+	longitude, latitude := DecodeScore(score)
+	hash, _ := encode(Range{Min: -180, Max: 180}, Range{Min: -90, Max: 90}, longitude, latitude, StepMax)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		if i < 10 {
+			idx = int(hash.Bits>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = alphabet[idx]
+	}
+	return string(buf)
+}
+
+func degRad(ang float64) float64 {
+	return ang * (math.Pi / 180)
+}
+
+func radDeg(ang float64) float64 {
+	return ang / (math.Pi / 180)
+}
+
+func latDistance(lat1, lat2 float64) float64 {
+	return earthRadiusInMeters * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+// Distance returns the haversine distance in meters
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	lon1r, lon2r := degRad(lon1), degRad(lon2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	// 经度相同时只需计算纬度距离
+	if v == 0 {
+		return latDistance(lat1, lat2)
+	}
+	lat1r, lat2r := degRad(lat1), degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadiusInMeters * math.Asin(math.Sqrt(a))
+}
+
+// Shape is the search area of GEOSEARCH, a circle of Radius or a box of Width and Height in meters
+type Shape struct {
+	Longitude float64
+	Latitude  float64
+	IsBox     bool
+	Radius    float64
+	Width     float64
+	Height    float64
+}
+
+// Contains reports whether the point lies in the shape and returns its distance to the center in meters
+func (s *Shape) Contains(longitude, latitude float64) (float64, bool) {
+	if !s.IsBox {
+		distance := Distance(s.Longitude, s.Latitude, longitude, latitude)
+		return distance, distance <= s.Radius
+	}
+	// 纬度距离计算更快，先检查纬度
+	if latDistance(latitude, s.Latitude) > s.Height/2 {
+		return 0, false
+	}
+	if Distance(longitude, latitude, s.Longitude, latitude) > s.Width/2 {
+		return 0, false
+	}
+	return Distance(s.Longitude, s.Latitude, longitude, latitude), true
+}
+
+// boundingBox returns min longitude, min latitude, max longitude and max latitude of the shape
+func (s *Shape) boundingBox() (float64, float64, float64, float64) {
+	height, width := s.Radius, s.Radius
+	if s.IsBox {
+		height, width = s.Height/2, s.Width/2
+	}
+	latDelta := radDeg(height / earthRadiusInMeters)
+	longDeltaTop := radDeg(width / earthRadiusInMeters / math.Cos(degRad(s.Latitude+latDelta)))
+	longDeltaBottom := radDeg(width / earthRadiusInMeters / math.Cos(degRad(s.Latitude-latDelta)))
+	// 南半球时靠近赤道的底边更宽
+	longDelta := longDeltaTop
+	if s.Latitude < 0 {
+		longDelta = longDeltaBottom
+	}
+	return s.Longitude - longDelta, s.Latitude - latDelta, s.Longitude + longDelta, s.Latitude + latDelta
+}
+
+// estimateSteps returns the precision whose cells are large enough for the radius
+func estimateSteps(rangeMeters float64, latitude float64) uint {
+	if rangeMeters == 0 {
+		return StepMax
+	}
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	step -= 2
+	// 越靠近两极经度方向的跨度越大
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), StepMax))
+}
+
+func moveX(hash Bits, d int) Bits {
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - hash.Step*2)
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+	x &= 0xaaaaaaaaaaaaaaaa >> (64 - hash.Step*2)
+	return Bits{Bits: x | y, Step: hash.Step}
+}
+
+func moveY(hash Bits, d int) Bits {
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.Step*2)
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+	y &= 0x5555555555555555 >> (64 - hash.Step*2)
+	return Bits{Bits: x | y, Step: hash.Step}
+}
+
+// 邻居的顺序与redis相同
+const (
+	center = iota
+	north
+	south
+	east
+	west
+	northEast
+	northWest
+	southEast
+	southWest
+)
+
+func neighbors(hash Bits) [9]Bits {
+	var n [9]Bits
+	n[center] = hash
+	n[east] = moveX(hash, 1)
+	n[west] = moveX(hash, -1)
+	n[south] = moveY(hash, -1)
+	n[north] = moveY(hash, 1)
+	n[northEast] = moveY(moveX(hash, 1), 1)
+	n[northWest] = moveY(moveX(hash, -1), 1)
+	n[southEast] = moveY(moveX(hash, 1), -1)
+	n[southWest] = moveY(moveX(hash, -1), -1)
+	return n
+}
+
+// SearchAreas returns the hash of the cell containing the center of the shape followed by its
+// 8 neighbors, together they cover the shape. Neighbors not needed are zero.
+func (s *Shape) SearchAreas() [9]Bits {
+	minLon, minLat, maxLon, maxLat := s.boundingBox()
+	radius := s.Radius
+	if s.IsBox {
+		radius = math.Sqrt((s.Width/2)*(s.Width/2) + (s.Height/2)*(s.Height/2))
+	}
+	steps := estimateSteps(radius, s.Latitude)
+	hash, _ := Encode(s.Longitude, s.Latitude, steps)
+	n := neighbors(hash)
+	area := Decode(hash)
+
+	// 估算的精度在边界处可能不够，此时降低一级精度
+	decreaseStep := Decode(n[north]).Latitude.Max < maxLat ||
+		Decode(n[south]).Latitude.Min > minLat ||
+		Decode(n[east]).Longitude.Max < maxLon ||
+		Decode(n[west]).Longitude.Min > minLon
+	if steps > 1 && decreaseStep {
+		steps--
+		hash, _ = Encode(s.Longitude, s.Latitude, steps)
+		n = neighbors(hash)
+		area = Decode(hash)
+	}
+
+	// 排除不需要搜索的邻居
+	if steps >= 2 {
+		if area.Latitude.Min < minLat {
+			n[south], n[southWest], n[southEast] = Bits{}, Bits{}, Bits{}
+		}
+		if area.Latitude.Max > maxLat {
+			n[north], n[northEast], n[northWest] = Bits{}, Bits{}, Bits{}
+		}
+		if area.Longitude.Min < minLon {
+			n[west], n[southWest], n[northWest] = Bits{}, Bits{}, Bits{}
+		}
+		if area.Longitude.Max > maxLon {
+			n[east], n[southEast], n[northEast] = Bits{}, Bits{}, Bits{}
+		}
+	}
+	return n
+}
+
+// ScoreRange returns the scores [min, max) of the points inside the cell of hash
+func (b Bits) ScoreRange() (float64, float64) {
+	next := Bits{Bits: b.Bits + 1, Step: b.Step}
+	return float64(b.Align52()), float64(next.Align52())
+}
@@ -0,0 +1,88 @@
+package geohash
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInterleave(t *testing.T) {
+	for _, tc := range [][2]uint32{{0, 0}, {1, 0}, {0, 1}, {0x3ffffff, 0x1234567}, {math.MaxUint32, 7}} {
+		sep := deinterleave64(interleave64(tc[0], tc[1]))
+		if uint32(sep) != tc[0] || uint32(sep>>32) != tc[1] {
+			t.Errorf("deinterleave64(interleave64(%d, %d)) = %x", tc[0], tc[1], sep)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	hash, ok := Encode(13.361389, 38.115556, StepMax)
+	if !ok {
+		t.Fatal("encode failed")
+	}
+	longitude, latitude := DecodeScore(float64(hash.Align52()))
+	if math.Abs(longitude-13.361389) > 1e-5 || math.Abs(latitude-38.115556) > 1e-5 {
+		t.Errorf("decoded %f,%f", longitude, latitude)
+	}
+	if _, ok := Encode(0, 86, StepMax); ok {
+		t.Error("latitude out of range should fail")
+	}
+	if s := String(float64(hash.Align52())); s != "sqc8b49rny0" {
+		t.Errorf("geohash string %s", s)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	d := Distance(13.361389, 38.115556, 15.087269, 37.502669)
+	if math.Abs(d-166274.15) > 1 {
+		t.Errorf("distance %f", d)
+	}
+	if d := Distance(10, 10, 10, 11); math.Abs(d-111226.29) > 1 {
+		t.Errorf("distance on the same meridian %f", d)
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	hash, _ := Encode(10, 10, 10)
+	n := neighbors(hash)
+	area := Decode(hash)
+	east := Decode(n[east])
+	if east.Longitude.Min != area.Longitude.Max || east.Latitude != area.Latitude {
+		t.Errorf("east neighbor %+v of %+v", east, area)
+	}
+	north := Decode(n[north])
+	if north.Latitude.Min != area.Latitude.Max || north.Longitude != area.Longitude {
+		t.Errorf("north neighbor %+v of %+v", north, area)
+	}
+	southWest := Decode(n[southWest])
+	if southWest.Latitude.Max != area.Latitude.Min || southWest.Longitude.Max != area.Longitude.Min {
+		t.Errorf("south west neighbor %+v of %+v", southWest, area)
+	}
+}
+
+// 搜索区域必须覆盖整个形状
+func TestSearchAreas(t *testing.T) {
+	shapes := []Shape{
+		{Longitude: 15, Latitude: 37, Radius: 200000},
+		{Longitude: -70, Latitude: -30, IsBox: true, Width: 50000, Height: 10000},
+		{Longitude: 0, Latitude: 75, Radius: 1000},
+	}
+	for _, s := range shapes {
+		areas := s.SearchAreas()
+		minLon, minLat, maxLon, maxLat := s.boundingBox()
+		for _, p := range [][2]float64{{minLon, s.Latitude}, {maxLon, s.Latitude}, {s.Longitude, minLat}, {s.Longitude, maxLat}} {
+			hash, _ := Encode(p[0], p[1], StepMax)
+			covered := false
+			for _, area := range areas {
+				if area.IsZero() {
+					continue
+				}
+				lo, hi := area.ScoreRange()
+				score := float64(hash.Align52())
+				covered = covered || (score >= lo && score < hi)
+			}
+			if !covered {
+				t.Errorf("point %v of shape %+v not covered", p, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package rdb
+
+import (
+	"hash/crc64"
+)
+
+// redis使用Jones多项式的CRC64，输入输出均反转，初始值与结果异或值都是0。
+// 标准库的实现对初始值和结果各取反一次，调用前后再取反即可抵消
+const jonesPoly = 0x95ac9329ac4bc9b5
+
+var crcTable = crc64.MakeTable(jonesPoly)
+
+// crc64Update returns the CRC64 of redis of p continuing from crc
+func crc64Update(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
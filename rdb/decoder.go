@@ -0,0 +1,871 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"godis/config"
+	"godis/datastruct/hash"
+	"godis/datastruct/list"
+	"godis/datastruct/set"
+	"godis/datastruct/sortedset"
+	"godis/datastruct/stream"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Entry is a key read from an RDB file
+type Entry struct {
+	DB    int
+	Key   string
+	Value any
+	// 过期时间，零值表示没有过期时间
+	ExpireAt time.Time
+}
+
+// Decoder reads a file in the RDB format written by godis or by redis
+type Decoder struct {
+	r       *bufio.Reader
+	crc     uint64
+	offset  int64
+	version int
+	// 读取到的辅助字段，如redis-ver
+	Aux map[string]string
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:   bufio.NewReader(r),
+		Aux: make(map[string]string),
+	}
+}
+
+// Offset returns the number of bytes consumed, after a failed Decode it's where the error was found
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+func (d *Decoder) read(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, ErrCorrupted
+	}
+	buf := make([]byte, n)
+	read, err := io.ReadFull(d.r, buf)
+	d.crc = crc64Update(d.crc, buf[:read])
+	d.offset += int64(read)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: unexpected end of file", ErrCorrupted)
+	}
+	return buf, err
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	buf, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// readLen reads a length, encoded is set if it's the encoding of a special string instead
+func (d *Decoder) readLen() (n uint64, encoded bool, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case len6Bit:
+		return uint64(b & 0x3f), false, nil
+	case len14Bit:
+		next, err := d.readByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case lenEnc:
+		return uint64(b & 0x3f), true, nil
+	}
+	switch b {
+	case len32Bit:
+		buf, err := d.read(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case len64Bit:
+		buf, err := d.read(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	}
+	return 0, false, ErrCorrupted
+}
+
+// readCount reads a length used as the number of elements
+func (d *Decoder) readCount() (int, error) {
+	n, encoded, err := d.readLen()
+	if err != nil {
+		return 0, err
+	}
+	if encoded || n > math.MaxInt32 {
+		return 0, ErrCorrupted
+	}
+	return int(n), nil
+}
+
+func (d *Decoder) readUint() (uint64, error) {
+	n, encoded, err := d.readLen()
+	if err == nil && encoded {
+		err = ErrCorrupted
+	}
+	return n, err
+}
+
+func (d *Decoder) readString() ([]byte, error) {
+	n, encoded, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		if n > math.MaxInt32 {
+			return nil, ErrCorrupted
+		}
+		return d.read(int(n))
+	}
+	var v int64
+	switch n {
+	case encInt8:
+		buf, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		v = int64(int8(buf[0]))
+	case encInt16:
+		buf, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		v = int64(int16(binary.LittleEndian.Uint16(buf)))
+	case encInt32:
+		buf, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		v = int64(int32(binary.LittleEndian.Uint32(buf)))
+	case encLZF:
+		clen, err := d.readCount()
+		if err != nil {
+			return nil, err
+		}
+		length, err := d.readCount()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := d.read(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, length)
+	default:
+		return nil, ErrCorrupted
+	}
+	return strconv.AppendInt(nil, v, 10), nil
+}
+
+func (d *Decoder) readMillis() (int64, error) {
+	buf, err := d.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+func (d *Decoder) readDouble() (float64, error) {
+	buf, err := d.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// readStringDouble reads a score of RDB_TYPE_ZSET, saved as a string with a one byte length
+func (d *Decoder) readStringDouble() (float64, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := d.read(int(n))
+	if err != nil {
+		return 0, err
+	}
+	return parseScore(buf)
+}
+
+func parseScore(buf []byte) (float64, error) {
+	f, err := strconv.ParseFloat(string(buf), 64)
+	if err != nil {
+		return 0, ErrCorrupted
+	}
+	return f, nil
+}
+
+// Decode reads the whole file and calls fn with each key, the checksum is verified at the end
+// unless it was written as zero. Expired keys are passed on as well.
+func (d *Decoder) Decode(fn func(e *Entry) error) error {
+	header, err := d.read(len(Magic) + 4)
+	if err != nil {
+		return err
+	}
+	if string(header[:len(Magic)]) != Magic {
+		return fmt.Errorf("%w: wrong signature", ErrCorrupted)
+	}
+	d.version, err = strconv.Atoi(string(header[len(Magic):]))
+	if err != nil || d.version < 1 || d.version > Version {
+		return fmt.Errorf("can't handle RDB format version %s", header[len(Magic):])
+	}
+
+	db := 0
+	var expireAt time.Time
+	for {
+		op, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opEOF:
+			return d.verifyChecksum()
+		case opSelectDB:
+			n, err := d.readCount()
+			if err != nil {
+				return err
+			}
+			db = n
+		case opResizeDB:
+			if _, err := d.readUint(); err != nil {
+				return err
+			}
+			if _, err := d.readUint(); err != nil {
+				return err
+			}
+		case opAux:
+			key, err := d.readString()
+			if err != nil {
+				return err
+			}
+			val, err := d.readString()
+			if err != nil {
+				return err
+			}
+			d.Aux[string(key)] = string(val)
+		case opExpireTimeMs:
+			ms, err := d.readMillis()
+			if err != nil {
+				return err
+			}
+			expireAt = time.UnixMilli(ms)
+		case opExpireTime:
+			buf, err := d.read(4)
+			if err != nil {
+				return err
+			}
+			expireAt = time.Unix(int64(int32(binary.LittleEndian.Uint32(buf))), 0)
+		case opIdle:
+			if _, err := d.readUint(); err != nil {
+				return err
+			}
+		case opFreq:
+			if _, err := d.readByte(); err != nil {
+				return err
+			}
+		case opSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, err := d.readUint(); err != nil {
+					return err
+				}
+			}
+		case opFunction2:
+			// godis没有函数，跳过函数库
+			if _, err := d.readString(); err != nil {
+				return err
+			}
+		case opFunctionPre, opModuleAux:
+			return fmt.Errorf("%w: unsupported opcode %d", ErrCorrupted, op)
+		default:
+			key, err := d.readString()
+			if err != nil {
+				return err
+			}
+			val, err := d.readObject(op)
+			if err != nil {
+				return err
+			}
+			if err := fn(&Entry{DB: db, Key: string(key), Value: val, ExpireAt: expireAt}); err != nil {
+				return err
+			}
+			expireAt = time.Time{}
+		}
+	}
+}
+
+func (d *Decoder) verifyChecksum() error {
+	if d.version < 5 {
+		return nil
+	}
+	expected := d.crc
+	buf, err := d.read(8)
+	if err != nil {
+		return err
+	}
+	// 保存时关闭了校验和的文件校验和为0
+	if sum := binary.LittleEndian.Uint64(buf); sum != 0 && sum != expected {
+		return ErrChecksum
+	}
+	return nil
+}
+
+func newSet() *set.Set {
+	return set.New(config.Properties.SetMaxIntsetEntries)
+}
+
+func newZSet() *sortedset.SortedSet {
+	return sortedset.New(config.Properties.ZSetMaxListpackEntries, config.Properties.ZSetMaxListpackValue)
+}
+
+func newHash() *hash.Hash {
+	return hash.New(config.Properties.HashMaxListpackEntries, config.Properties.HashMaxListpackValue)
+}
+
+// readObject reads a value of type typ
+func (d *Decoder) readObject(typ byte) (any, error) {
+	switch typ {
+	case typeString:
+		return d.readString()
+	case typeList:
+		ql := list.NewQuickList()
+		err := d.readStrings(func(val []byte) error {
+			ql.PushBack(val)
+			return nil
+		})
+		return ql, err
+	case typeSet:
+		s := newSet()
+		err := d.readStrings(func(member []byte) error {
+			s.Add(string(member))
+			return nil
+		})
+		return s, err
+	case typeZSet, typeZSet2:
+		return d.readZSet(typ)
+	case typeHash:
+		h := newHash()
+		n, err := d.readCount()
+		for i := 0; i < n && err == nil; i++ {
+			var field, val []byte
+			if field, err = d.readString(); err == nil {
+				if val, err = d.readString(); err == nil {
+					h.Set(string(field), val)
+				}
+			}
+		}
+		return h, err
+	case typeHashMetadata:
+		return d.readHashMetadata()
+	case typeListZiplist, typeListQuicklist, typeListQuicklist2:
+		return d.readList(typ)
+	case typeSetIntset, typeSetListpack:
+		entries, err := d.readPacked(typ)
+		if err != nil {
+			return nil, err
+		}
+		s := newSet()
+		for _, member := range entries {
+			s.Add(string(member))
+		}
+		return s, nil
+	case typeZSetZiplist, typeZSetListpack:
+		entries, err := d.readPacked(typ)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries)%2 != 0 {
+			return nil, ErrCorrupted
+		}
+		z := newZSet()
+		for i := 0; i < len(entries); i += 2 {
+			score, err := parseScore(entries[i+1])
+			if err != nil {
+				return nil, err
+			}
+			z.Add(string(entries[i]), score)
+		}
+		return z, nil
+	case typeHashZiplist, typeHashListpack:
+		entries, err := d.readPacked(typ)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries)%2 != 0 {
+			return nil, ErrCorrupted
+		}
+		h := newHash()
+		for i := 0; i < len(entries); i += 2 {
+			h.Set(string(entries[i]), entries[i+1])
+		}
+		return h, nil
+	case typeHashListpackEx:
+		return d.readHashListpackEx()
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		return d.readStream(typ)
+	}
+	return nil, fmt.Errorf("%w: unsupported object type %d", ErrCorrupted, typ)
+}
+
+// readStrings reads a length followed by that many strings
+func (d *Decoder) readStrings(fn func([]byte) error) error {
+	n, err := d.readCount()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		s, err := d.readString()
+		if err != nil {
+			return err
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPacked reads a value saved as a single ziplist, listpack or intset blob
+func (d *Decoder) readPacked(typ byte) ([][]byte, error) {
+	buf, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case typeSetIntset:
+		return decodeIntset(buf)
+	case typeListZiplist, typeZSetZiplist, typeHashZiplist:
+		return decodeZiplist(buf)
+	}
+	return decodeListpack(buf)
+}
+
+func (d *Decoder) readList(typ byte) (*list.QuickList, error) {
+	ql := list.NewQuickList()
+	if typ == typeListZiplist {
+		entries, err := d.readPacked(typ)
+		for _, val := range entries {
+			ql.PushBack(val)
+		}
+		return ql, err
+	}
+	n, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		container := uint64(quicklistNodePacked)
+		if typ == typeListQuicklist2 {
+			if container, err = d.readUint(); err != nil {
+				return nil, err
+			}
+		}
+		buf, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		// 超大的元素单独存放在plain节点中
+		if container == quicklistNodePlain {
+			ql.PushBack(buf)
+			continue
+		}
+		var entries [][]byte
+		if typ == typeListQuicklist {
+			entries, err = decodeZiplist(buf)
+		} else {
+			entries, err = decodeListpack(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, val := range entries {
+			ql.PushBack(val)
+		}
+	}
+	return ql, nil
+}
+
+func (d *Decoder) readZSet(typ byte) (*sortedset.SortedSet, error) {
+	z := newZSet()
+	n, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		member, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if typ == typeZSet2 {
+			score, err = d.readDouble()
+		} else {
+			score, err = d.readStringDouble()
+		}
+		if err != nil {
+			return nil, err
+		}
+		z.Add(string(member), score)
+	}
+	return z, nil
+}
+
+// readHashMetadata reads a hash with field expire times saved relative to the smallest one
+func (d *Decoder) readHashMetadata() (*hash.Hash, error) {
+	minExpire, err := d.readMillis()
+	if err != nil {
+		return nil, err
+	}
+	n, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	h := newHash()
+	for i := 0; i < n; i++ {
+		ttl, err := d.readUint()
+		if err != nil {
+			return nil, err
+		}
+		field, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		val, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		h.Set(string(field), val)
+		if ttl != 0 {
+			h.SetExpire(string(field), minExpire+int64(ttl)-1)
+		}
+	}
+	return h, nil
+}
+
+// readHashListpackEx reads a small hash with field expire times, a listpack of
+// field, value and absolute expire time triplets where 0 means no expire time
+func (d *Decoder) readHashListpackEx() (*hash.Hash, error) {
+	if _, err := d.readMillis(); err != nil {
+		return nil, err
+	}
+	buf, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := decodeListpack(buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries)%3 != 0 {
+		return nil, ErrCorrupted
+	}
+	h := newHash()
+	for i := 0; i < len(entries); i += 3 {
+		at, err := strconv.ParseInt(string(entries[i+2]), 10, 64)
+		if err != nil {
+			return nil, ErrCorrupted
+		}
+		h.Set(string(entries[i]), entries[i+1])
+		if at != 0 {
+			h.SetExpire(string(entries[i]), at)
+		}
+	}
+	return h, nil
+}
+
+func (d *Decoder) readStreamID() (stream.ID, error) {
+	ms, err := d.readUint()
+	if err != nil {
+		return stream.ID{}, err
+	}
+	seq, err := d.readUint()
+	return stream.ID{Ms: ms, Seq: seq}, err
+}
+
+func (d *Decoder) readRawStreamID() (stream.ID, error) {
+	buf, err := d.read(16)
+	if err != nil {
+		return stream.ID{}, err
+	}
+	return parseStreamID(buf)
+}
+
+func parseStreamID(buf []byte) (stream.ID, error) {
+	if len(buf) != 16 {
+		return stream.ID{}, ErrCorrupted
+	}
+	return stream.ID{Ms: binary.BigEndian.Uint64(buf), Seq: binary.BigEndian.Uint64(buf[8:])}, nil
+}
+
+// readStream reads the listpack nodes, the metadata and the consumer groups of a stream,
+// the counters missing in older versions are derived the same way redis does
+func (d *Decoder) readStream(typ byte) (*stream.Stream, error) {
+	s := stream.New(config.Properties.StreamNodeMaxEntries)
+	nodes, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < nodes; i++ {
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		master, err := parseStreamID(key)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if err := readStreamNode(s, master, buf); err != nil {
+			return nil, err
+		}
+	}
+	length, err := d.readUint()
+	if err != nil {
+		return nil, err
+	}
+	if length != uint64(s.Len()) {
+		return nil, ErrCorrupted
+	}
+	lastID, err := d.readStreamID()
+	if err != nil {
+		return nil, err
+	}
+	entriesAdded := length
+	var maxDeletedID stream.ID
+	if typ >= typeStreamListpacks2 {
+		if _, err := d.readStreamID(); err != nil {
+			return nil, err
+		}
+		if maxDeletedID, err = d.readStreamID(); err != nil {
+			return nil, err
+		}
+		if entriesAdded, err = d.readUint(); err != nil {
+			return nil, err
+		}
+	}
+	s.SetLastID(lastID)
+	s.SetMaxDeletedID(maxDeletedID)
+	s.SetEntriesAdded(entriesAdded)
+
+	groups, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < groups; i++ {
+		if err := d.readStreamGroup(s, typ); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (d *Decoder) readStreamGroup(s *stream.Stream, typ byte) error {
+	name, err := d.readString()
+	if err != nil {
+		return err
+	}
+	lastID, err := d.readStreamID()
+	if err != nil {
+		return err
+	}
+	var entriesRead int64
+	if typ >= typeStreamListpacks2 {
+		n, err := d.readUint()
+		if err != nil {
+			return err
+		}
+		entriesRead = int64(n)
+	} else {
+		entriesRead = s.EstimateEntriesRead(lastID)
+	}
+	g := s.CreateGroup(string(name), lastID, entriesRead)
+	if g == nil {
+		return fmt.Errorf("%w: duplicated consumer group %s", ErrCorrupted, name)
+	}
+
+	// 消费组的PEL保存投递信息，消费者的PEL只保存ID
+	type nack struct {
+		deliveryTime  int64
+		deliveryCount int64
+	}
+	pel := make(map[stream.ID]nack)
+	n, err := d.readCount()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		id, err := d.readRawStreamID()
+		if err != nil {
+			return err
+		}
+		deliveryTime, err := d.readMillis()
+		if err != nil {
+			return err
+		}
+		deliveryCount, err := d.readUint()
+		if err != nil {
+			return err
+		}
+		pel[id] = nack{deliveryTime, int64(deliveryCount)}
+	}
+
+	consumers, err := d.readCount()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < consumers; i++ {
+		name, err := d.readString()
+		if err != nil {
+			return err
+		}
+		seenTime, err := d.readMillis()
+		if err != nil {
+			return err
+		}
+		activeTime := int64(-1)
+		if typ >= typeStreamListpacks3 {
+			if activeTime, err = d.readMillis(); err != nil {
+				return err
+			}
+		}
+		c := g.CreateConsumer(string(name), seenTime)
+		if c == nil {
+			return fmt.Errorf("%w: duplicated consumer %s", ErrCorrupted, name)
+		}
+		c.ActiveTime = activeTime
+		owned, err := d.readCount()
+		if err != nil {
+			return err
+		}
+		for j := 0; j < owned; j++ {
+			id, err := d.readRawStreamID()
+			if err != nil {
+				return err
+			}
+			info, ok := pel[id]
+			if !ok {
+				return fmt.Errorf("%w: consumer pending entry not found in group pending entries", ErrCorrupted)
+			}
+			pe := g.Deliver(id, c, info.deliveryTime)
+			pe.DeliveryCount = info.deliveryCount
+		}
+	}
+	return nil
+}
+
+// readStreamNode appends the live entries of a stream listpack whose master ID is master
+func readStreamNode(s *stream.Stream, master stream.ID, buf []byte) error {
+	lp, err := decodeListpack(buf)
+	if err != nil {
+		return err
+	}
+	p := 0
+	next := func() ([]byte, bool) {
+		if p >= len(lp) {
+			return nil, false
+		}
+		p++
+		return lp[p-1], true
+	}
+	nextInt := func() (int64, bool) {
+		b, ok := next()
+		if !ok {
+			return 0, false
+		}
+		v, err := strconv.ParseInt(string(b), 10, 64)
+		return v, err == nil
+	}
+
+	// 主条目: 有效条目数、已删除条目数、主字段数、主字段、结束标记0
+	count, ok1 := nextInt()
+	deleted, ok2 := nextInt()
+	numFields, ok3 := nextInt()
+	if !ok1 || !ok2 || !ok3 || numFields < 0 || int(numFields) > len(lp) {
+		return ErrCorrupted
+	}
+	masterFields := make([][]byte, numFields)
+	for i := range masterFields {
+		f, ok := next()
+		if !ok {
+			return ErrCorrupted
+		}
+		masterFields[i] = f
+	}
+	if v, ok := nextInt(); !ok || v != 0 {
+		return ErrCorrupted
+	}
+
+	live := int64(0)
+	for i := int64(0); i < count+deleted; i++ {
+		flags, ok1 := nextInt()
+		msDiff, ok2 := nextInt()
+		seqDiff, ok3 := nextInt()
+		if !ok1 || !ok2 || !ok3 {
+			return ErrCorrupted
+		}
+		id := stream.ID{Ms: master.Ms + uint64(msDiff), Seq: master.Seq + uint64(seqDiff)}
+		var fields [][]byte
+		if flags&streamItemSameFields != 0 {
+			for _, f := range masterFields {
+				v, ok := next()
+				if !ok {
+					return ErrCorrupted
+				}
+				fields = append(fields, f, v)
+			}
+		} else {
+			n, ok := nextInt()
+			if !ok || n < 0 || int(n) > len(lp) {
+				return ErrCorrupted
+			}
+			for j := int64(0); j < n*2; j++ {
+				v, ok := next()
+				if !ok {
+					return ErrCorrupted
+				}
+				fields = append(fields, v)
+			}
+		}
+		// lp-count只用于反向遍历
+		if _, ok := nextInt(); !ok {
+			return ErrCorrupted
+		}
+		if flags&streamItemDeleted != 0 {
+			continue
+		}
+		if s.Len() > 0 && !s.LastID().Less(id) {
+			return fmt.Errorf("%w: stream IDs out of order", ErrCorrupted)
+		}
+		s.Append(id, fields)
+		live++
+	}
+	if live != count || p != len(lp) {
+		return ErrCorrupted
+	}
+	return nil
+}
@@ -0,0 +1,480 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"godis/datastruct/hash"
+	"godis/datastruct/list"
+	"godis/datastruct/set"
+	"godis/datastruct/sortedset"
+	"godis/datastruct/stream"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	// 短于该长度的字符串不压缩，与redis相同
+	lzfMinLength = 20
+	// list的每个listpack节点最多容纳的元素数与字节数
+	listpackMaxEntries = 128
+	listpackMaxBytes   = 8 * 1024
+	// stream的每个listpack节点最多容纳的条目数，与stream-node-max-entries的默认值相同
+	streamNodeMaxEntries = 100
+)
+
+// Encoder writes a dataset in the RDB format. WriteHeader starts the file, each keyspace begins
+// with WriteDB followed by its keys, WriteEnd finishes the file with its checksum.
+type Encoder struct {
+	w        io.Writer
+	crc      uint64
+	compress bool
+	checksum bool
+	lzf      *lzfCompressor
+	buf      []byte
+}
+
+// NewEncoder returns an encoder writing to w, compress enables LZF compression of long strings
+// and checksum the CRC64 at the end of the file, which is left zero when disabled like redis does
+func NewEncoder(w io.Writer, compress, checksum bool) *Encoder {
+	e := &Encoder{
+		w:        w,
+		compress: compress,
+		checksum: checksum,
+	}
+	if compress {
+		e.lzf = &lzfCompressor{}
+	}
+	return e
+}
+
+func (e *Encoder) write(p []byte) error {
+	if e.checksum {
+		e.crc = crc64Update(e.crc, p)
+	}
+	_, err := e.w.Write(p)
+	return err
+}
+
+func (e *Encoder) writeByte(b byte) error {
+	e.buf = append(e.buf[:0], b)
+	return e.write(e.buf)
+}
+
+func (e *Encoder) writeLen(n uint64) error {
+	buf := e.buf[:0]
+	switch {
+	case n < 1<<6:
+		buf = append(buf, byte(n))
+	case n < 1<<14:
+		buf = append(buf, byte(n>>8)|len14Bit<<6, byte(n))
+	case n <= math.MaxUint32:
+		buf = append(buf, len32Bit)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+	default:
+		buf = append(buf, len64Bit)
+		buf = binary.BigEndian.AppendUint64(buf, n)
+	}
+	e.buf = buf
+	return e.write(buf)
+}
+
+// writeMillis writes a unix time in milliseconds as 8 little endian bytes
+func (e *Encoder) writeMillis(ms int64) error {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf[:0], uint64(ms))
+	return e.write(e.buf)
+}
+
+func (e *Encoder) writeDouble(f float64) error {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf[:0], math.Float64bits(f))
+	return e.write(e.buf)
+}
+
+// writeString writes s with the most compact encoding: short integers are stored as integers,
+// long strings are compressed if it saves at least 4 bytes
+func (e *Encoder) writeString(s []byte) error {
+	if len(s) <= 11 {
+		if v, ok := parseInt(s); ok && v >= math.MinInt32 && v <= math.MaxInt32 {
+			return e.writeInt(v)
+		}
+	}
+	if e.compress && len(s) > lzfMinLength {
+		if c := e.lzf.compress(s, len(s)-4); c != nil {
+			if err := e.writeByte(lenEnc<<6 | encLZF); err != nil {
+				return err
+			}
+			if err := e.writeLen(uint64(len(c))); err != nil {
+				return err
+			}
+			if err := e.writeLen(uint64(len(s))); err != nil {
+				return err
+			}
+			return e.write(c)
+		}
+	}
+	if err := e.writeLen(uint64(len(s))); err != nil {
+		return err
+	}
+	return e.write(s)
+}
+
+func (e *Encoder) writeInt(v int64) error {
+	buf := e.buf[:0]
+	switch {
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		buf = append(buf, lenEnc<<6|encInt8, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		buf = append(buf, lenEnc<<6|encInt16)
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(v))
+	default:
+		buf = append(buf, lenEnc<<6|encInt32)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(v))
+	}
+	e.buf = buf
+	return e.write(buf)
+}
+
+// WriteHeader writes the Magic string and the version
+func (e *Encoder) WriteHeader() error {
+	return e.write([]byte(fmt.Sprintf("%s%04d", Magic, Version)))
+}
+
+// WriteAux writes an auxiliary field such as redis-ver
+func (e *Encoder) WriteAux(key, value string) error {
+	if err := e.writeByte(opAux); err != nil {
+		return err
+	}
+	if err := e.writeString([]byte(key)); err != nil {
+		return err
+	}
+	return e.writeString([]byte(value))
+}
+
+// WriteDB starts the keyspace of index holding size keys of which expires have an expire time
+func (e *Encoder) WriteDB(index, size, expires int) error {
+	if err := e.writeByte(opSelectDB); err != nil {
+		return err
+	}
+	if err := e.writeLen(uint64(index)); err != nil {
+		return err
+	}
+	if err := e.writeByte(opResizeDB); err != nil {
+		return err
+	}
+	if err := e.writeLen(uint64(size)); err != nil {
+		return err
+	}
+	return e.writeLen(uint64(expires))
+}
+
+// WriteEntry writes key with its value, expireAt is ignored if it's zero
+func (e *Encoder) WriteEntry(key string, val any, expireAt time.Time) error {
+	if !expireAt.IsZero() {
+		if err := e.writeByte(opExpireTimeMs); err != nil {
+			return err
+		}
+		if err := e.writeMillis(expireAt.UnixMilli()); err != nil {
+			return err
+		}
+	}
+	var typ byte
+	var writeValue func() error
+	switch v := val.(type) {
+	case []byte:
+		typ, writeValue = typeString, func() error { return e.writeString(v) }
+	case *list.QuickList:
+		typ, writeValue = typeListQuicklist2, func() error { return e.writeList(v) }
+	case *set.Set:
+		typ, writeValue = typeSet, func() error { return e.writeSet(v) }
+	case *sortedset.SortedSet:
+		typ, writeValue = typeZSet2, func() error { return e.writeZSet(v) }
+	case *hash.Hash:
+		typ = typeHash
+		if v.HasExpires() {
+			typ = typeHashMetadata
+		}
+		writeValue = func() error { return e.writeHash(v) }
+	case *stream.Stream:
+		typ, writeValue = typeStreamListpacks3, func() error { return e.writeStream(v) }
+	default:
+		return fmt.Errorf("unknown value type %T of key %s", val, key)
+	}
+	if err := e.writeByte(typ); err != nil {
+		return err
+	}
+	if err := e.writeString([]byte(key)); err != nil {
+		return err
+	}
+	return writeValue()
+}
+
+// WriteEnd writes the end of file and the checksum
+func (e *Encoder) WriteEnd() error {
+	if err := e.writeByte(opEOF); err != nil {
+		return err
+	}
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], e.crc)
+	_, err := e.w.Write(sum[:])
+	return err
+}
+
+// writeList writes the elements in listpack nodes like a quicklist of redis 7
+func (e *Encoder) writeList(ql *list.QuickList) error {
+	var nodes [][]byte
+	lp := newListpack()
+	ql.ForEach(func(_ int, val []byte) bool {
+		lp.appendString(val)
+		if lp.count >= listpackMaxEntries || len(lp.buf) >= listpackMaxBytes {
+			nodes = append(nodes, lp.bytes())
+			lp = newListpack()
+		}
+		return true
+	})
+	if lp.count > 0 {
+		nodes = append(nodes, lp.bytes())
+	}
+	if err := e.writeLen(uint64(len(nodes))); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := e.writeLen(quicklistNodePacked); err != nil {
+			return err
+		}
+		if err := e.writeString(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) writeSet(s *set.Set) error {
+	if err := e.writeLen(uint64(s.Len())); err != nil {
+		return err
+	}
+	var err error
+	s.ForEach(func(member string) bool {
+		err = e.writeString([]byte(member))
+		return err == nil
+	})
+	return err
+}
+
+func (e *Encoder) writeZSet(z *sortedset.SortedSet) error {
+	if err := e.writeLen(uint64(z.Len())); err != nil {
+		return err
+	}
+	var err error
+	z.ForEach(func(elem sortedset.Element) bool {
+		if err = e.writeString([]byte(elem.Member)); err == nil {
+			err = e.writeDouble(elem.Score)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// writeHash writes the live fields. With field expire times the smallest one comes first and
+// each field is preceded by its expire time relative to it plus one, zero meaning no expire time.
+func (e *Encoder) writeHash(h *hash.Hash) error {
+	type pair struct {
+		field string
+		val   []byte
+	}
+	var pairs []pair
+	var minExpire int64 = math.MaxInt64
+	h.ForEach(func(field string, val []byte) bool {
+		pairs = append(pairs, pair{field, val})
+		if at, ok := h.ExpireTime(field); ok {
+			minExpire = min(minExpire, at)
+		}
+		return true
+	})
+	withTTL := h.HasExpires()
+	if withTTL {
+		// 所有带过期时间的字段都已过期时，最小过期时间取当前时间
+		if minExpire == math.MaxInt64 {
+			minExpire = time.Now().UnixMilli()
+		}
+		if err := e.writeMillis(minExpire); err != nil {
+			return err
+		}
+	}
+	if err := e.writeLen(uint64(len(pairs))); err != nil {
+		return err
+	}
+	for _, p := range pairs {
+		if withTTL {
+			var ttl uint64
+			if at, ok := h.ExpireTime(p.field); ok {
+				ttl = uint64(at-minExpire) + 1
+			}
+			if err := e.writeLen(ttl); err != nil {
+				return err
+			}
+		}
+		if err := e.writeString([]byte(p.field)); err != nil {
+			return err
+		}
+		if err := e.writeString(p.val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStream writes the entries in listpack nodes followed by the metadata of the stream
+// and its consumer groups, the layout of RDB_TYPE_STREAM_LISTPACKS_3
+func (e *Encoder) writeStream(s *stream.Stream) error {
+	nodes := (s.Len() + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	if err := e.writeLen(uint64(nodes)); err != nil {
+		return err
+	}
+	for start := stream.MinID; nodes > 0; nodes-- {
+		entries := s.Range(start, stream.MaxID, streamNodeMaxEntries, false)
+		if len(entries) == 0 {
+			return fmt.Errorf("stream changed while being saved")
+		}
+		if err := e.writeStreamNode(entries); err != nil {
+			return err
+		}
+		next, ok := entries[len(entries)-1].ID.Next()
+		if !ok && nodes > 1 {
+			return fmt.Errorf("stream changed while being saved")
+		}
+		start = next
+	}
+
+	for _, n := range []uint64{
+		uint64(s.Len()), s.LastID().Ms, s.LastID().Seq,
+		s.FirstID().Ms, s.FirstID().Seq,
+		s.MaxDeletedID().Ms, s.MaxDeletedID().Seq,
+		s.EntriesAdded(),
+	} {
+		if err := e.writeLen(n); err != nil {
+			return err
+		}
+	}
+
+	groups := s.Groups()
+	if err := e.writeLen(uint64(len(groups))); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if err := e.writeString([]byte(g.Name)); err != nil {
+			return err
+		}
+		for _, n := range []uint64{g.LastID.Ms, g.LastID.Seq, uint64(g.EntriesRead)} {
+			if err := e.writeLen(n); err != nil {
+				return err
+			}
+		}
+		pel := g.PendingRange(stream.MinID, stream.MaxID, 0, nil)
+		if err := e.writeLen(uint64(len(pel))); err != nil {
+			return err
+		}
+		for _, pe := range pel {
+			if err := e.write(streamIDBytes(pe.ID)); err != nil {
+				return err
+			}
+			if err := e.writeMillis(pe.DeliveryTime); err != nil {
+				return err
+			}
+			if err := e.writeLen(uint64(pe.DeliveryCount)); err != nil {
+				return err
+			}
+		}
+		consumers := g.Consumers()
+		if err := e.writeLen(uint64(len(consumers))); err != nil {
+			return err
+		}
+		for _, c := range consumers {
+			if err := e.writeString([]byte(c.Name)); err != nil {
+				return err
+			}
+			if err := e.writeMillis(c.SeenTime); err != nil {
+				return err
+			}
+			if err := e.writeMillis(c.ActiveTime); err != nil {
+				return err
+			}
+			owned := g.PendingRange(stream.MinID, stream.MaxID, 0, c)
+			if err := e.writeLen(uint64(len(owned))); err != nil {
+				return err
+			}
+			for _, pe := range owned {
+				if err := e.write(streamIDBytes(pe.ID)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeStreamNode writes entries as one listpack keyed by the ID of its first entry.
+// The first entry's fields become the master fields, entries with the same fields only store values.
+func (e *Encoder) writeStreamNode(entries []stream.Entry) error {
+	master := entries[0]
+	lp := newListpack()
+	lp.appendInt(int64(len(entries)))
+	lp.appendInt(0)
+	numFields := len(master.Fields) / 2
+	lp.appendInt(int64(numFields))
+	for i := 0; i < len(master.Fields); i += 2 {
+		lp.appendString(master.Fields[i])
+	}
+	lp.appendInt(0)
+	for _, entry := range entries {
+		same := sameFields(master.Fields, entry.Fields)
+		flags := 0
+		if same {
+			flags = streamItemSameFields
+		}
+		lp.appendInt(int64(flags))
+		lp.appendInt(int64(entry.ID.Ms - master.ID.Ms))
+		lp.appendInt(int64(entry.ID.Seq - master.ID.Seq))
+		n := len(entry.Fields) / 2
+		if same {
+			for i := 1; i < len(entry.Fields); i += 2 {
+				lp.appendString(entry.Fields[i])
+			}
+		} else {
+			lp.appendInt(int64(n))
+			for _, f := range entry.Fields {
+				lp.appendString(f)
+			}
+		}
+		// lp-count是条目除自身外的元素数，用于反向遍历
+		lpCount := n + 3
+		if !same {
+			lpCount += n + 1
+		}
+		lp.appendInt(int64(lpCount))
+	}
+	if err := e.writeString(streamIDBytes(master.ID)); err != nil {
+		return err
+	}
+	return e.writeString(lp.bytes())
+}
+
+// sameFields reports whether two field-value lists have the same field names in the same order
+func sameFields(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i += 2 {
+		if string(a[i]) != string(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// streamIDBytes returns the 128 bit big endian form of id used as radix tree keys by redis
+func streamIDBytes(id stream.ID) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, id.Ms)
+	binary.BigEndian.PutUint64(buf[8:], id.Seq)
+	return buf
+}
@@ -0,0 +1,214 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// listpack的编码与redis listpack.c相同:
+// 6字节头(总字节数uint32、元素数uint16)，每个元素为编码+数据+反向长度，以0xFF结尾
+const (
+	lpHeaderSize = 6
+	lpEOF        = 0xff
+	// 元素数超过uint16时记为该值，需要遍历才能得到
+	lpCountUnknown = 0xffff
+)
+
+// listpack builds a listpack blob entry by entry
+type listpack struct {
+	buf   []byte
+	count int
+}
+
+func newListpack() *listpack {
+	return &listpack{buf: make([]byte, lpHeaderSize, 64)}
+}
+
+// appendString appends s, strings that are canonical integers are stored as integers like redis does
+func (lp *listpack) appendString(s []byte) {
+	if v, ok := parseInt(s); ok {
+		lp.appendInt(v)
+		return
+	}
+	start := len(lp.buf)
+	n := len(s)
+	switch {
+	case n < 64:
+		lp.buf = append(lp.buf, 0x80|byte(n))
+	case n < 4096:
+		lp.buf = append(lp.buf, 0xe0|byte(n>>8), byte(n))
+	default:
+		lp.buf = append(lp.buf, 0xf0)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(n))
+	}
+	lp.buf = append(lp.buf, s...)
+	lp.finishEntry(start)
+}
+
+func (lp *listpack) appendInt(v int64) {
+	start := len(lp.buf)
+	switch {
+	case v >= 0 && v <= 127:
+		lp.buf = append(lp.buf, byte(v))
+	case v >= -4096 && v <= 4095:
+		u := uint64(v) & 0x1fff
+		lp.buf = append(lp.buf, 0xc0|byte(u>>8), byte(u))
+	case v >= -1<<15 && v < 1<<15:
+		lp.buf = append(lp.buf, 0xf1)
+		lp.buf = binary.LittleEndian.AppendUint16(lp.buf, uint16(v))
+	case v >= -1<<23 && v < 1<<23:
+		u := uint32(v)
+		lp.buf = append(lp.buf, 0xf2, byte(u), byte(u>>8), byte(u>>16))
+	case v >= -1<<31 && v < 1<<31:
+		lp.buf = append(lp.buf, 0xf3)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(v))
+	default:
+		lp.buf = append(lp.buf, 0xf4)
+		lp.buf = binary.LittleEndian.AppendUint64(lp.buf, uint64(v))
+	}
+	lp.finishEntry(start)
+}
+
+// finishEntry appends the backlen of the entry starting at start
+func (lp *listpack) finishEntry(start int) {
+	l := len(lp.buf) - start
+	// 反向长度的每个字节保存7位，高位在前，除第一个字节外最高位为1
+	size := backlenSize(l)
+	for i := size - 1; i >= 0; i-- {
+		b := byte(l>>(7*i)) & 0x7f
+		if i != size-1 {
+			b |= 0x80
+		}
+		lp.buf = append(lp.buf, b)
+	}
+	lp.count++
+}
+
+func backlenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// bytes terminates the listpack and returns it
+func (lp *listpack) bytes() []byte {
+	buf := append(lp.buf, lpEOF)
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)))
+	binary.LittleEndian.PutUint16(buf[4:], uint16(min(lp.count, lpCountUnknown)))
+	return buf
+}
+
+// parseInt parses s if it's an integer written the way redis formats it, so the conversion is lossless
+func parseInt(s []byte) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(string(s), 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != string(s) {
+		return 0, false
+	}
+	return v, true
+}
+
+// decodeListpack returns the entries of a listpack, integers are formatted as strings
+func decodeListpack(buf []byte) ([][]byte, error) {
+	if len(buf) < lpHeaderSize+1 || int(binary.LittleEndian.Uint32(buf)) != len(buf) || buf[len(buf)-1] != lpEOF {
+		return nil, ErrCorrupted
+	}
+	var entries [][]byte
+	for p := lpHeaderSize; buf[p] != lpEOF; {
+		entry, size, err := decodeListpackEntry(buf[p : len(buf)-1])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		p += size + backlenSize(size)
+		if p >= len(buf) {
+			return nil, ErrCorrupted
+		}
+	}
+	if count := int(binary.LittleEndian.Uint16(buf[4:])); count != lpCountUnknown && count != len(entries) {
+		return nil, ErrCorrupted
+	}
+	return entries, nil
+}
+
+// decodeListpackEntry decodes the entry at the start of buf, returns it and its size without the backlen
+func decodeListpackEntry(buf []byte) ([]byte, int, error) {
+	need := func(n int) bool {
+		return len(buf) >= n
+	}
+	b := buf[0]
+	var v int64
+	var size int
+	switch {
+	case b&0x80 == 0:
+		return strconv.AppendInt(nil, int64(b), 10), 1, nil
+	case b&0xc0 == 0x80:
+		n := int(b & 0x3f)
+		if !need(1 + n) {
+			return nil, 0, ErrCorrupted
+		}
+		return buf[1 : 1+n], 1 + n, nil
+	case b&0xe0 == 0xc0:
+		if !need(2) {
+			return nil, 0, ErrCorrupted
+		}
+		u := int64(b&0x1f)<<8 | int64(buf[1])
+		if u >= 1<<12 {
+			u -= 1 << 13
+		}
+		v, size = u, 2
+	case b&0xf0 == 0xe0:
+		if !need(2) {
+			return nil, 0, ErrCorrupted
+		}
+		n := int(b&0x0f)<<8 | int(buf[1])
+		if !need(2 + n) {
+			return nil, 0, ErrCorrupted
+		}
+		return buf[2 : 2+n], 2 + n, nil
+	case b == 0xf0:
+		if !need(5) {
+			return nil, 0, ErrCorrupted
+		}
+		n := int(binary.LittleEndian.Uint32(buf[1:]))
+		if n < 0 || !need(5+n) {
+			return nil, 0, ErrCorrupted
+		}
+		return buf[5 : 5+n], 5 + n, nil
+	case b == 0xf1:
+		if !need(3) {
+			return nil, 0, ErrCorrupted
+		}
+		v, size = int64(int16(binary.LittleEndian.Uint16(buf[1:]))), 3
+	case b == 0xf2:
+		if !need(4) {
+			return nil, 0, ErrCorrupted
+		}
+		u := int32(uint32(buf[1])<<8|uint32(buf[2])<<16|uint32(buf[3])<<24) >> 8
+		v, size = int64(u), 4
+	case b == 0xf3:
+		if !need(5) {
+			return nil, 0, ErrCorrupted
+		}
+		v, size = int64(int32(binary.LittleEndian.Uint32(buf[1:]))), 5
+	case b == 0xf4:
+		if !need(9) {
+			return nil, 0, ErrCorrupted
+		}
+		v, size = int64(binary.LittleEndian.Uint64(buf[1:])), 9
+	default:
+		return nil, 0, ErrCorrupted
+	}
+	return strconv.AppendInt(nil, v, 10), size, nil
+}
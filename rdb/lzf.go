@@ -0,0 +1,130 @@
+package rdb
+
+// LZF压缩格式，与redis使用的liblzf兼容:
+// 控制字节高3位为0时表示之后有(低5位+1)个字面字节，
+// 否则为回溯引用，高3位为长度-2(为7时下一字节继续累加)，低5位与下一字节组成偏移-1
+const (
+	lzfHashLog = 14
+	lzfMaxLit  = 1 << 5
+	lzfMaxOff  = 1 << 13
+	lzfMaxRef  = 1<<8 + 1<<3
+)
+
+// lzfCompressor keeps its hash table between calls, stale positions are verified before use
+type lzfCompressor struct {
+	htab [1 << lzfHashLog]int32
+}
+
+func lzfHash(in []byte, i int) int {
+	v := uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])
+	return int((v * 2654435761) >> (32 - lzfHashLog))
+}
+
+// compress compresses in, returns nil if the result would be longer than maxOut
+func (c *lzfCompressor) compress(in []byte, maxOut int) []byte {
+	if len(in) == 0 || maxOut <= 0 {
+		return nil
+	}
+	out := make([]byte, 1, maxOut+1)
+	ctrl, lit := 0, 0
+	// 结束当前的字面段，没有字面字节时去掉预留的控制字节
+	closeLiteral := func() {
+		if lit > 0 {
+			out[ctrl] = byte(lit - 1)
+		} else {
+			out = out[:len(out)-1]
+		}
+	}
+	for ip := 0; ip < len(in); {
+		if ip+2 < len(in) {
+			h := lzfHash(in, ip)
+			ref := int(c.htab[h]) - 1
+			c.htab[h] = int32(ip + 1)
+			if ref >= 0 && ref < ip && ip-ref <= lzfMaxOff &&
+				in[ref] == in[ip] && in[ref+1] == in[ip+1] && in[ref+2] == in[ip+2] {
+				maxLen := min(len(in)-ip, lzfMaxRef)
+				n := 3
+				for n < maxLen && in[ref+n] == in[ip+n] {
+					n++
+				}
+				closeLiteral()
+				off := ip - ref - 1
+				if n-2 < 7 {
+					out = append(out, byte((n-2)<<5|off>>8))
+				} else {
+					out = append(out, byte(7<<5|off>>8), byte(n-2-7))
+				}
+				out = append(out, byte(off))
+				for p := ip + 1; p < ip+n && p+2 < len(in); p++ {
+					c.htab[lzfHash(in, p)] = int32(p + 1)
+				}
+				ip += n
+				ctrl, lit = len(out), 0
+				out = append(out, 0)
+				if len(out) > maxOut {
+					return nil
+				}
+				continue
+			}
+		}
+		out = append(out, in[ip])
+		ip++
+		lit++
+		if lit == lzfMaxLit && ip < len(in) {
+			out[ctrl] = byte(lit - 1)
+			ctrl, lit = len(out), 0
+			out = append(out, 0)
+		}
+		if len(out) > maxOut+1 {
+			return nil
+		}
+	}
+	closeLiteral()
+	if len(out) > maxOut {
+		return nil
+	}
+	return out
+}
+
+// lzfDecompress decompresses in, which must expand to exactly outLen bytes
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < lzfMaxLit {
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > outLen {
+				return nil, ErrCorrupted
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, ErrCorrupted
+			}
+			n += int(in[i])
+			i++
+		}
+		n += 2
+		if i >= len(in) {
+			return nil, ErrCorrupted
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 || len(out)+n > outLen {
+			return nil, ErrCorrupted
+		}
+		// 引用可能与输出重叠，逐字节复制
+		for k := 0; k < n; k++ {
+			out = append(out, out[ref+k])
+		}
+	}
+	if len(out) != outLen {
+		return nil, ErrCorrupted
+	}
+	return out, nil
+}
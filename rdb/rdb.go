@@ -0,0 +1,90 @@
+// Package rdb reads and writes snapshots in the RDB format of redis, so a dump.rdb can be moved
+// between godis and redis in both directions.
+package rdb
+
+import (
+	"errors"
+)
+
+// Version is the RDB version written, the same as redis 7.4. Files of any version up to it can be read.
+const Version = 12
+
+// Magic starts every RDB file, an AOF starting with it has an RDB preamble
+const Magic = "REDIS"
+
+// 特殊操作码，与redis rdb.h中的RDB_OPCODE_*相同
+const (
+	opSlotInfo     = 244
+	opFunction2    = 245
+	opFunctionPre  = 246
+	opModuleAux    = 247
+	opIdle         = 248
+	opFreq         = 249
+	opAux          = 250
+	opResizeDB     = 251
+	opExpireTimeMs = 252
+	opExpireTime   = 253
+	opSelectDB     = 254
+	opEOF          = 255
+)
+
+// 对象类型，与redis rdb.h中的RDB_TYPE_*相同
+const (
+	typeString           = 0
+	typeList             = 1
+	typeSet              = 2
+	typeZSet             = 3
+	typeHash             = 4
+	typeZSet2            = 5
+	typeHashZipmap       = 9
+	typeListZiplist      = 10
+	typeSetIntset        = 11
+	typeZSetZiplist      = 12
+	typeHashZiplist      = 13
+	typeListQuicklist    = 14
+	typeStreamListpacks  = 15
+	typeHashListpack     = 16
+	typeZSetListpack     = 17
+	typeListQuicklist2   = 18
+	typeStreamListpacks2 = 19
+	typeSetListpack      = 20
+	typeStreamListpacks3 = 21
+	typeHashMetadata     = 24
+	typeHashListpackEx   = 25
+)
+
+// 长度编码的前两位
+const (
+	len6Bit  = 0
+	len14Bit = 1
+	len32Bit = 0x80
+	len64Bit = 0x81
+	lenEnc   = 3
+)
+
+// 特殊编码的字符串，长度编码前两位为11时低6位的取值
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// quicklist节点的容器类型
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// stream listpack中条目的标志
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+var (
+	// ErrCorrupted means the file is truncated or doesn't follow the RDB format
+	ErrCorrupted = errors.New("rdb file is corrupted")
+	// ErrChecksum means the content doesn't match the checksum at the end of the file
+	ErrChecksum = errors.New("rdb checksum mismatch")
+)
@@ -0,0 +1,350 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"godis/datastruct/hash"
+	"godis/datastruct/list"
+	"godis/datastruct/set"
+	"godis/datastruct/sortedset"
+	"godis/datastruct/stream"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCRC64(t *testing.T) {
+	// redis crc64.c中的测试向量
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), crc64Update(0, []byte("123456789")))
+	crc := crc64Update(0, []byte("1234"))
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), crc64Update(crc, []byte("56789")))
+}
+
+func TestLZF(t *testing.T) {
+	c := &lzfCompressor{}
+	inputs := [][]byte{
+		[]byte(strings.Repeat("abc", 100)),
+		[]byte(strings.Repeat("a", 1000)),
+		[]byte("hello hello hello hello world world world world"),
+	}
+	random := make([]byte, 10000)
+	for i := range random {
+		// 小字母表的随机数据仍然可以压缩
+		random[i] = byte('a' + rand.Intn(4))
+	}
+	inputs = append(inputs, random)
+	for _, in := range inputs {
+		out := c.compress(in, len(in)-4)
+		require.NotNil(t, out)
+		assert.Less(t, len(out), len(in))
+		decompressed, err := lzfDecompress(out, len(in))
+		require.NoError(t, err)
+		assert.Equal(t, in, decompressed)
+	}
+
+	noise := make([]byte, 1000)
+	rand.Read(noise)
+	assert.Nil(t, c.compress(noise, len(noise)-4))
+
+	_, err := lzfDecompress([]byte{0x20, 0x00}, 10)
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestListpack(t *testing.T) {
+	lp := newListpack()
+	lp.appendString([]byte("a"))
+	assert.Equal(t, []byte{10, 0, 0, 0, 1, 0, 0x81, 'a', 2, 0xff}, lp.bytes())
+
+	values := []string{"", "0", "127", "128", "-1", "-4096", "4095", "4096", "-32768", "32767",
+		"8388607", "-8388608", "2147483647", "-2147483648", "9223372036854775807", "-9223372036854775808",
+		"007", "1.5", "+1", strings.Repeat("x", 63), strings.Repeat("x", 64), strings.Repeat("x", 4095),
+		strings.Repeat("x", 4096), strings.Repeat("x", 20000)}
+	lp = newListpack()
+	for _, v := range values {
+		lp.appendString([]byte(v))
+	}
+	entries, err := decodeListpack(lp.bytes())
+	require.NoError(t, err)
+	require.Len(t, entries, len(values))
+	for i, v := range values {
+		assert.Equal(t, v, string(entries[i]))
+	}
+
+	buf := lp.bytes()
+	_, err = decodeListpack(buf[:len(buf)-2])
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestZiplist(t *testing.T) {
+	// "ab", 5, -2, 1000, 70000, 1<<40
+	var body []byte
+	body = append(body, 0, 0x02, 'a', 'b')
+	body = append(body, 4, 0xf6)
+	body = append(body, 2, 0xfe, 0xfe)
+	body = append(body, 3, 0xc0, 0xe8, 0x03)
+	body = append(body, 4, 0xf0, 0x70, 0x11, 0x01)
+	body = append(body, 5, 0xe0)
+	body = binary.LittleEndian.AppendUint64(body, 1<<40)
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(zlHeaderSize+len(body)+1))
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+	buf = binary.LittleEndian.AppendUint16(buf, 6)
+	buf = append(append(buf, body...), zlEnd)
+
+	entries, err := decodeZiplist(buf)
+	require.NoError(t, err)
+	var got []string
+	for _, e := range entries {
+		got = append(got, string(e))
+	}
+	assert.Equal(t, []string{"ab", "5", "-2", "1000", "70000", strconv.FormatInt(1<<40, 10)}, got)
+
+	intset := binary.LittleEndian.AppendUint32(nil, 2)
+	intset = binary.LittleEndian.AppendUint32(intset, 2)
+	intset = binary.LittleEndian.AppendUint16(intset, uint16(0xffff))
+	intset = binary.LittleEndian.AppendUint16(intset, 7)
+	members, err := decodeIntset(intset)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("-1"), []byte("7")}, members)
+}
+
+// decodeAll decodes a file into a map of db:key -> entry
+func decodeAll(t *testing.T, data []byte) map[string]*Entry {
+	t.Helper()
+	result := make(map[string]*Entry)
+	err := NewDecoder(bytes.NewReader(data)).Decode(func(e *Entry) error {
+		result[strconv.Itoa(e.DB)+":"+e.Key] = e
+		return nil
+	})
+	require.NoError(t, err)
+	return result
+}
+
+func TestRoundTrip(t *testing.T) {
+	ql := list.NewQuickList()
+	for i := 0; i < 300; i++ {
+		ql.PushBack([]byte(strconv.Itoa(i)))
+	}
+	ql.PushBack([]byte(strings.Repeat("long", 100)))
+
+	s := set.New(512)
+	s.Add("a")
+	s.Add("100")
+
+	z := sortedset.New(128, 64)
+	z.Add("a", 1.5)
+	z.Add("b", -3)
+
+	h := hash.New(128, 64)
+	h.Set("f1", []byte("v1"))
+	h.Set("f2", []byte("v2"))
+	h.Set("f3", []byte("v3"))
+	future := time.Now().Add(time.Hour).UnixMilli()
+	h.SetExpire("f1", future)
+	h.SetExpire("f2", future+10)
+
+	st := stream.New(100)
+	for i := 1; i <= 250; i++ {
+		fields := [][]byte{[]byte("f"), []byte(strconv.Itoa(i))}
+		if i%7 == 0 {
+			fields = append(fields, []byte("g"), []byte("x"))
+		}
+		st.Append(stream.ID{Ms: uint64(i / 3), Seq: uint64(i % 3)}, fields)
+	}
+	st.SetMaxDeletedID(stream.ID{Ms: 1})
+	g := st.CreateGroup("grp", stream.ID{Ms: 10}, 30)
+	alice := g.CreateConsumer("alice", 1000)
+	alice.ActiveTime = 2000
+	g.CreateConsumer("bob", 3000)
+	pe := g.Deliver(stream.ID{Ms: 1, Seq: 1}, alice, 5000)
+	pe.DeliveryCount = 3
+	st.CreateGroup("empty", stream.ID{}, -1)
+
+	expireAt := time.UnixMilli(time.Now().Add(time.Minute).UnixMilli())
+	for _, compress := range []bool{true, false} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf, compress, true)
+		require.NoError(t, e.WriteHeader())
+		require.NoError(t, e.WriteAux("redis-ver", "7.4.0"))
+		require.NoError(t, e.WriteAux("redis-bits", "64"))
+		require.NoError(t, e.WriteDB(0, 3, 1))
+		require.NoError(t, e.WriteEntry("str", []byte(strings.Repeat("value", 20)), expireAt))
+		require.NoError(t, e.WriteEntry("int", []byte("-12345"), time.Time{}))
+		require.NoError(t, e.WriteEntry("list", ql, time.Time{}))
+		require.NoError(t, e.WriteDB(3, 4, 0))
+		require.NoError(t, e.WriteEntry("set", s, time.Time{}))
+		require.NoError(t, e.WriteEntry("zset", z, time.Time{}))
+		require.NoError(t, e.WriteEntry("hash", h, time.Time{}))
+		require.NoError(t, e.WriteEntry("stream", st, time.Time{}))
+		require.NoError(t, e.WriteEnd())
+
+		dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+		entries := make(map[string]*Entry)
+		require.NoError(t, dec.Decode(func(e *Entry) error {
+			entries[strconv.Itoa(e.DB)+":"+e.Key] = e
+			return nil
+		}))
+		assert.Equal(t, "7.4.0", dec.Aux["redis-ver"])
+		assert.Equal(t, "64", dec.Aux["redis-bits"])
+		assert.Equal(t, int64(buf.Len()), dec.Offset())
+		require.Len(t, entries, 7)
+
+		assert.Equal(t, strings.Repeat("value", 20), string(entries["0:str"].Value.([]byte)))
+		assert.True(t, expireAt.Equal(entries["0:str"].ExpireAt))
+		assert.Equal(t, "-12345", string(entries["0:int"].Value.([]byte)))
+		assert.True(t, entries["0:int"].ExpireAt.IsZero())
+		assert.Equal(t, ql.Range(0, ql.Len()), entries["0:list"].Value.(*list.QuickList).Range(0, ql.Len()))
+
+		gotSet := entries["3:set"].Value.(*set.Set)
+		assert.ElementsMatch(t, []string{"a", "100"}, gotSet.Members())
+		gotZSet := entries["3:zset"].Value.(*sortedset.SortedSet)
+		assert.Equal(t, z.Range(0, z.Len(), false), gotZSet.Range(0, z.Len(), false))
+
+		gotHash := entries["3:hash"].Value.(*hash.Hash)
+		assert.Equal(t, 3, gotHash.Len())
+		v, _ := gotHash.Get("f3")
+		assert.Equal(t, "v3", string(v))
+		at, ok := gotHash.ExpireTime("f2")
+		assert.True(t, ok)
+		assert.Equal(t, future+10, at)
+		_, ok = gotHash.ExpireTime("f3")
+		assert.False(t, ok)
+
+		gotStream := entries["3:stream"].Value.(*stream.Stream)
+		assert.Equal(t, st.Range(stream.MinID, stream.MaxID, 0, false), gotStream.Range(stream.MinID, stream.MaxID, 0, false))
+		assert.Equal(t, st.LastID(), gotStream.LastID())
+		assert.Equal(t, st.EntriesAdded(), gotStream.EntriesAdded())
+		assert.Equal(t, st.MaxDeletedID(), gotStream.MaxDeletedID())
+		gotGroup := gotStream.Group("grp")
+		require.NotNil(t, gotGroup)
+		assert.Equal(t, int64(30), gotGroup.EntriesRead)
+		assert.Equal(t, stream.ID{Ms: 10}, gotGroup.LastID)
+		gotAlice := gotGroup.Consumer("alice")
+		assert.Equal(t, int64(1000), gotAlice.SeenTime)
+		assert.Equal(t, int64(2000), gotAlice.ActiveTime)
+		assert.Equal(t, int64(-1), gotGroup.Consumer("bob").ActiveTime)
+		gotPE := gotGroup.Pending(stream.ID{Ms: 1, Seq: 1})
+		require.NotNil(t, gotPE)
+		assert.Equal(t, gotAlice, gotPE.Consumer)
+		assert.Equal(t, int64(5000), gotPE.DeliveryTime)
+		assert.Equal(t, int64(3), gotPE.DeliveryCount)
+		assert.Equal(t, int64(-1), gotStream.Group("empty").EntriesRead)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, false, true)
+	require.NoError(t, e.WriteHeader())
+	require.NoError(t, e.WriteDB(0, 1, 0))
+	require.NoError(t, e.WriteEntry("k", []byte("value"), time.Time{}))
+	require.NoError(t, e.WriteEnd())
+	data := buf.Bytes()
+
+	corrupted := bytes.Clone(data)
+	corrupted[bytes.Index(corrupted, []byte("value"))] = 'V'
+	err := NewDecoder(bytes.NewReader(corrupted)).Decode(func(*Entry) error { return nil })
+	assert.ErrorIs(t, err, ErrChecksum)
+
+	truncated := data[:len(data)-10]
+	dec := NewDecoder(bytes.NewReader(truncated))
+	err = dec.Decode(func(*Entry) error { return nil })
+	assert.ErrorIs(t, err, ErrCorrupted)
+	assert.Equal(t, int64(len(truncated)), dec.Offset())
+
+	// 关闭校验和时写入0，读取时不校验
+	buf.Reset()
+	e = NewEncoder(&buf, false, false)
+	require.NoError(t, e.WriteHeader())
+	require.NoError(t, e.WriteEnd())
+	assert.Equal(t, make([]byte, 8), buf.Bytes()[buf.Len()-8:])
+	assert.Empty(t, decodeAll(t, buf.Bytes()))
+}
+
+// TestDecodeRedisEncodings reads the compact encodings that redis writes but godis doesn't
+func TestDecodeRedisEncodings(t *testing.T) {
+	var body []byte
+	str := func(s string) {
+		body = append(body, byte(len(s)))
+		body = append(body, s...)
+	}
+	listpackOf := func(values ...string) string {
+		lp := newListpack()
+		for _, v := range values {
+			lp.appendString([]byte(v))
+		}
+		return string(lp.bytes())
+	}
+	body = append(body, "REDIS0011"...)
+	body = append(body, opAux)
+	str("redis-ver")
+	str("7.2.4")
+	body = append(body, opSelectDB, 0, opResizeDB, 5, 1)
+
+	body = append(body, typeHashListpack)
+	str("h")
+	str(listpackOf("f", "v", "n", "1"))
+
+	body = append(body, typeSetIntset)
+	str("s")
+	intset := binary.LittleEndian.AppendUint32(nil, 2)
+	intset = binary.LittleEndian.AppendUint32(intset, 2)
+	intset = binary.LittleEndian.AppendUint16(intset, 1)
+	intset = binary.LittleEndian.AppendUint16(intset, 2)
+	str(string(intset))
+
+	body = append(body, typeZSetListpack)
+	str("z")
+	str(listpackOf("a", "1", "b", "2.5"))
+
+	// 秒级过期时间与LRU信息
+	body = append(body, opExpireTime)
+	body = binary.LittleEndian.AppendUint32(body, uint32(time.Now().Add(time.Hour).Unix()))
+	body = append(body, opIdle, 10)
+	body = append(body, typeListQuicklist2)
+	str("l")
+	body = append(body, 2, quicklistNodePacked)
+	str(listpackOf("x", "y"))
+	body = append(body, quicklistNodePlain)
+	str("plain")
+
+	body = append(body, typeZSet)
+	str("oldz")
+	body = append(body, 2)
+	str("m1")
+	str("3.5")
+	str("m2")
+	body = append(body, 254)
+
+	body = append(body, typeHashListpackEx)
+	str("hx")
+	body = binary.LittleEndian.AppendUint64(body, 0)
+	str(listpackOf("f", "v", "0", "g", "w", strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)))
+
+	body = append(body, opEOF)
+	body = binary.LittleEndian.AppendUint64(body, crc64Update(0, body))
+
+	entries := decodeAll(t, body)
+	require.Len(t, entries, 6)
+	h := entries["0:h"].Value.(*hash.Hash)
+	v, _ := h.Get("n")
+	assert.Equal(t, "1", string(v))
+	assert.ElementsMatch(t, []string{"1", "2"}, entries["0:s"].Value.(*set.Set).Members())
+	score, _ := entries["0:z"].Value.(*sortedset.SortedSet).Get("b")
+	assert.Equal(t, 2.5, score)
+	l := entries["0:l"]
+	assert.False(t, l.ExpireAt.IsZero())
+	assert.Equal(t, [][]byte{[]byte("x"), []byte("y"), []byte("plain")}, l.Value.(*list.QuickList).Range(0, 3))
+	oldz := entries["0:oldz"].Value.(*sortedset.SortedSet)
+	score, _ = oldz.Get("m2")
+	assert.True(t, score > 1e308)
+	hx := entries["0:hx"].Value.(*hash.Hash)
+	_, ok := hx.ExpireTime("f")
+	assert.False(t, ok)
+	_, ok = hx.ExpireTime("g")
+	assert.True(t, ok)
+}
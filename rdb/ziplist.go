@@ -0,0 +1,116 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// 旧版本redis使用的ziplist与intset编码，只需要读取
+const (
+	zlHeaderSize = 10
+	zlEnd        = 0xff
+	zlBigPrevLen = 0xfe
+)
+
+// decodeZiplist returns the entries of a ziplist, integers are formatted as strings
+func decodeZiplist(buf []byte) ([][]byte, error) {
+	if len(buf) < zlHeaderSize+1 || int(binary.LittleEndian.Uint32(buf)) != len(buf) || buf[len(buf)-1] != zlEnd {
+		return nil, ErrCorrupted
+	}
+	var entries [][]byte
+	p := zlHeaderSize
+	for buf[p] != zlEnd {
+		// 跳过前一个元素的长度
+		if buf[p] == zlBigPrevLen {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(buf)-1 {
+			return nil, ErrCorrupted
+		}
+		entry, size, err := decodeZiplistEntry(buf[p : len(buf)-1])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		p += size
+		if p >= len(buf) {
+			return nil, ErrCorrupted
+		}
+	}
+	return entries, nil
+}
+
+// decodeZiplistEntry decodes the entry at the start of buf following its prevlen, returns it and its size
+func decodeZiplistEntry(buf []byte) ([]byte, int, error) {
+	b := buf[0]
+	intEntry := func(n int, parse func([]byte) int64) ([]byte, int, error) {
+		if len(buf) < 1+n {
+			return nil, 0, ErrCorrupted
+		}
+		return strconv.AppendInt(nil, parse(buf[1:1+n]), 10), 1 + n, nil
+	}
+	strEntry := func(header, n int) ([]byte, int, error) {
+		if n < 0 || len(buf) < header+n {
+			return nil, 0, ErrCorrupted
+		}
+		return buf[header : header+n], header + n, nil
+	}
+	switch {
+	case b>>6 == 0:
+		return strEntry(1, int(b&0x3f))
+	case b>>6 == 1:
+		if len(buf) < 2 {
+			return nil, 0, ErrCorrupted
+		}
+		return strEntry(2, int(b&0x3f)<<8|int(buf[1]))
+	case b == 0x80:
+		if len(buf) < 5 {
+			return nil, 0, ErrCorrupted
+		}
+		return strEntry(5, int(binary.BigEndian.Uint32(buf[1:])))
+	case b == 0xc0:
+		return intEntry(2, func(p []byte) int64 { return int64(int16(binary.LittleEndian.Uint16(p))) })
+	case b == 0xd0:
+		return intEntry(4, func(p []byte) int64 { return int64(int32(binary.LittleEndian.Uint32(p))) })
+	case b == 0xe0:
+		return intEntry(8, func(p []byte) int64 { return int64(binary.LittleEndian.Uint64(p)) })
+	case b == 0xf0:
+		return intEntry(3, func(p []byte) int64 {
+			return int64(int32(uint32(p[0])<<8|uint32(p[1])<<16|uint32(p[2])<<24) >> 8)
+		})
+	case b == 0xfe:
+		return intEntry(1, func(p []byte) int64 { return int64(int8(p[0])) })
+	case b >= 0xf1 && b <= 0xfd:
+		return strconv.AppendInt(nil, int64(b&0x0f)-1, 10), 1, nil
+	}
+	return nil, 0, ErrCorrupted
+}
+
+// decodeIntset returns the members of an intset formatted as strings
+func decodeIntset(buf []byte) ([][]byte, error) {
+	if len(buf) < 8 {
+		return nil, ErrCorrupted
+	}
+	width := int(binary.LittleEndian.Uint32(buf))
+	n := int(binary.LittleEndian.Uint32(buf[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(buf) != 8+width*n {
+		return nil, ErrCorrupted
+	}
+	members := make([][]byte, n)
+	for i := range members {
+		p := buf[8+i*width:]
+		var v int64
+		switch width {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(p)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(p)))
+		default:
+			v = int64(binary.LittleEndian.Uint64(p))
+		}
+		members[i] = strconv.AppendInt(nil, v, 10)
+	}
+	return members, nil
+}
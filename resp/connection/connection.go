@@ -0,0 +1,154 @@
+package connection
+
+import (
+	"godis/tcp"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Connection is the per-client session the database engine executes commands on
+type Connection interface {
+	Write(b []byte) (int, error)
+	// Push writes a message pushed by another client without waiting for the socket
+	Push(b []byte) error
+	Close() error
+	RemoteAddr() string
+	// ID is unique among all connections of the process, as reported by CLIENT ID
+	ID() int64
+	Name() string
+	SetName(name string)
+	// Done is closed once the connection is closed or the peer goes away
+	Done() <-chan struct{}
+
+	GetDBIndex() int
+	SelectDB(index int)
+
+	// MULTI/EXEC state, see multiState
+	InMultiState() bool
+	SetMultiState(state bool)
+	IsTxRollback() bool
+	SetTxRollback(rollback bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd(cmdLine [][]byte)
+	AddTxError(err error)
+	GetTxErrors() []error
+	GetWatching() map[WatchKey]uint64
+
+	// Pub/Sub state, see pubsubState
+	Subscribe(channel string) bool
+	Unsubscribe(channel string) bool
+	PSubscribe(pattern string) bool
+	PUnsubscribe(pattern string) bool
+	SSubscribe(channel string) bool
+	SUnsubscribe(channel string) bool
+	GetChannels() []string
+	GetPatterns() []string
+	GetShardChannels() []string
+	SubsCount() int
+	ShardSubsCount() int
+}
+
+var idGenerator atomic.Int64
+
+func nextID() int64 {
+	return idGenerator.Add(1)
+}
+
+// Conn is a Connection backed by a live tcp client
+type Conn struct {
+	client *tcp.Client
+	id     int64
+	name   string
+	// serializes writes, replies may come from other goroutines later on
+	mu sync.Mutex
+
+	done     chan struct{}
+	doneOnce sync.Once
+
+	selectedDB int
+
+	// 异步写出的推送消息，见Push
+	out outputBuffer
+
+	multiState
+	pubsubState
+}
+
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		client: &tcp.Client{Conn: conn},
+		id:     nextID(),
+		done:   make(chan struct{}),
+	}
+}
+
+// Read reads from the socket, a read error means the peer is gone so Done is closed.
+// The parser reads ahead of the command being executed, which lets a blocked command
+// notice the disconnection.
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.client.Conn.Read(p)
+	if err != nil {
+		c.markDone()
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	// 还有推送消息未写出时排在其后
+	if queued, err := c.enqueue(b, false); queued {
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	// 让Close等待正在写出的回复
+	c.client.AddWaiting()
+	defer c.client.Done()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client.Conn.Write(b)
+}
+
+func (c *Conn) markDone() {
+	c.doneOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *Conn) Close() error {
+	c.markDone()
+	return c.client.Close()
+}
+
+func (c *Conn) RemoteAddr() string {
+	return c.client.Conn.RemoteAddr().String()
+}
+
+func (c *Conn) ID() int64 {
+	return c.id
+}
+
+func (c *Conn) Name() string {
+	return c.name
+}
+
+func (c *Conn) SetName(name string) {
+	c.name = name
+}
+
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Conn) GetDBIndex() int {
+	return c.selectedDB
+}
+
+func (c *Conn) SelectDB(index int) {
+	c.selectedDB = index
+}
@@ -0,0 +1,83 @@
+package connection
+
+import (
+	"bytes"
+	"sync"
+)
+
+// FakeConn is a Connection without a socket, used to execute commands internally and in tests
+type FakeConn struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+
+	id       int64
+	name     string
+	done     chan struct{}
+	doneOnce sync.Once
+
+	selectedDB int
+
+	multiState
+	pubsubState
+}
+
+func NewFakeConn() *FakeConn {
+	return &FakeConn{
+		id:   nextID(),
+		done: make(chan struct{}),
+	}
+}
+
+func (c *FakeConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(b)
+}
+
+// Push writes b right away, a FakeConn never falls behind
+func (c *FakeConn) Push(b []byte) error {
+	_, err := c.Write(b)
+	return err
+}
+
+func (c *FakeConn) Close() error {
+	c.doneOnce.Do(func() {
+		close(c.done)
+	})
+	return nil
+}
+
+func (c *FakeConn) RemoteAddr() string {
+	return ""
+}
+
+func (c *FakeConn) ID() int64 {
+	return c.id
+}
+
+func (c *FakeConn) Name() string {
+	return c.name
+}
+
+func (c *FakeConn) SetName(name string) {
+	c.name = name
+}
+
+func (c *FakeConn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *FakeConn) GetDBIndex() int {
+	return c.selectedDB
+}
+
+func (c *FakeConn) SelectDB(index int) {
+	c.selectedDB = index
+}
+
+// Bytes returns everything written to the connection so far
+func (c *FakeConn) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Clone(c.buf.Bytes())
+}
@@ -0,0 +1,64 @@
+package connection
+
+// WatchKey identifies a key watched by WATCH, keys of different dbs are watched separately
+type WatchKey struct {
+	DBIndex int
+	Key     string
+}
+
+// multiState holds the transaction state of a connection, it's only accessed by the
+// goroutine serving the connection
+type multiState struct {
+	inMulti bool
+	// MULTI ROLLBACK开启的事务在命令出错时回滚
+	rollback bool
+	queue    [][][]byte
+	// 排队时出现的错误，EXEC时存在错误则放弃整个事务
+	txErrors []error
+	// key -> WATCH时的版本号
+	watching map[WatchKey]uint64
+}
+
+func (m *multiState) InMultiState() bool {
+	return m.inMulti
+}
+
+// SetMultiState enters or leaves MULTI, the queued commands, errors and rollback mode are dropped either way
+func (m *multiState) SetMultiState(state bool) {
+	m.inMulti = state
+	m.rollback = false
+	m.queue = nil
+	m.txErrors = nil
+}
+
+func (m *multiState) IsTxRollback() bool {
+	return m.rollback
+}
+
+func (m *multiState) SetTxRollback(rollback bool) {
+	m.rollback = rollback
+}
+
+func (m *multiState) GetQueuedCmdLine() [][][]byte {
+	return m.queue
+}
+
+func (m *multiState) EnqueueCmd(cmdLine [][]byte) {
+	m.queue = append(m.queue, cmdLine)
+}
+
+func (m *multiState) AddTxError(err error) {
+	m.txErrors = append(m.txErrors, err)
+}
+
+func (m *multiState) GetTxErrors() []error {
+	return m.txErrors
+}
+
+// GetWatching returns the watched keys and their versions, callers may modify the map
+func (m *multiState) GetWatching() map[WatchKey]uint64 {
+	if m.watching == nil {
+		m.watching = make(map[WatchKey]uint64)
+	}
+	return m.watching
+}
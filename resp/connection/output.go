@@ -0,0 +1,103 @@
+package connection
+
+import (
+	"errors"
+	"godis/config"
+	"net"
+	"sync"
+)
+
+// ErrOutputBufferOverflow is returned by Push when the client is disconnected because it doesn't
+// read its pushed messages fast enough
+var ErrOutputBufferOverflow = errors.New("output buffer limit reached")
+
+// outputBuffer queues the bytes written asynchronously to a Conn. Messages pushed by other
+// clients never wait for the socket, a single goroutine flushes the queue in order.
+type outputBuffer struct {
+	mu      sync.Mutex
+	pending [][]byte
+	// 排队和正在写出的字节数
+	size     int
+	flushing bool
+	closed   bool
+}
+
+// Push queues a message pushed by another client, e.g. a Pub/Sub message. The connection is
+// closed once the queued bytes exceed pubsub-output-buffer-limit.
+func (c *Conn) Push(b []byte) error {
+	_, err := c.enqueue(b, true)
+	return err
+}
+
+// enqueue appends b to the output buffer and starts flushing it. Unless force is set, b is
+// only queued if the buffer is being flushed, so a reply is never written before the messages
+// pushed earlier.
+func (c *Conn) enqueue(b []byte, force bool) (bool, error) {
+	out := &c.out
+	out.mu.Lock()
+	if out.closed {
+		out.mu.Unlock()
+		return true, net.ErrClosed
+	}
+	if !out.flushing && !force {
+		out.mu.Unlock()
+		return false, nil
+	}
+	out.pending = append(out.pending, b)
+	out.size += len(b)
+	if limit := config.Properties.PubSubOutputBufferLimit; limit > 0 && out.size > limit {
+		out.closed = true
+		out.pending = nil
+		out.mu.Unlock()
+		c.abort()
+		return true, ErrOutputBufferOverflow
+	}
+	if !out.flushing {
+		out.flushing = true
+		c.client.AddWaiting()
+		go c.flush()
+	}
+	out.mu.Unlock()
+	return true, nil
+}
+
+func (c *Conn) flush() {
+	defer c.client.Done()
+	out := &c.out
+	for {
+		out.mu.Lock()
+		batch := out.pending
+		out.pending = nil
+		if len(batch) == 0 || out.closed {
+			out.flushing = false
+			out.mu.Unlock()
+			return
+		}
+		out.mu.Unlock()
+
+		for _, b := range batch {
+			c.mu.Lock()
+			_, err := c.client.Conn.Write(b)
+			c.mu.Unlock()
+
+			out.mu.Lock()
+			out.size -= len(b)
+			if err != nil {
+				out.closed = true
+				out.pending = nil
+				out.flushing = false
+				out.mu.Unlock()
+				c.abort()
+				return
+			}
+			out.mu.Unlock()
+		}
+	}
+}
+
+// abort closes the socket without waiting for pending writes, the goroutine serving the
+// connection then fails to read and tears the client down
+func (c *Conn) abort() {
+	c.markDone()
+	_ = c.client.Conn.Close()
+}
@@ -0,0 +1,105 @@
+package connection
+
+import "sort"
+
+// pubsubState holds the channels and patterns subscribed by a connection, it's only accessed
+// by the goroutine serving the connection
+type pubsubState struct {
+	channels      map[string]struct{}
+	patterns      map[string]struct{}
+	shardChannels map[string]struct{}
+}
+
+// Subscribe reports whether channel wasn't subscribed before
+func (p *pubsubState) Subscribe(channel string) bool {
+	if p.channels == nil {
+		p.channels = make(map[string]struct{})
+	}
+	if _, ok := p.channels[channel]; ok {
+		return false
+	}
+	p.channels[channel] = struct{}{}
+	return true
+}
+
+// Unsubscribe reports whether channel was subscribed
+func (p *pubsubState) Unsubscribe(channel string) bool {
+	if _, ok := p.channels[channel]; !ok {
+		return false
+	}
+	delete(p.channels, channel)
+	return true
+}
+
+func (p *pubsubState) PSubscribe(pattern string) bool {
+	if p.patterns == nil {
+		p.patterns = make(map[string]struct{})
+	}
+	if _, ok := p.patterns[pattern]; ok {
+		return false
+	}
+	p.patterns[pattern] = struct{}{}
+	return true
+}
+
+func (p *pubsubState) PUnsubscribe(pattern string) bool {
+	if _, ok := p.patterns[pattern]; !ok {
+		return false
+	}
+	delete(p.patterns, pattern)
+	return true
+}
+
+func (p *pubsubState) SSubscribe(channel string) bool {
+	if p.shardChannels == nil {
+		p.shardChannels = make(map[string]struct{})
+	}
+	if _, ok := p.shardChannels[channel]; ok {
+		return false
+	}
+	p.shardChannels[channel] = struct{}{}
+	return true
+}
+
+func (p *pubsubState) SUnsubscribe(channel string) bool {
+	if _, ok := p.shardChannels[channel]; !ok {
+		return false
+	}
+	delete(p.shardChannels, channel)
+	return true
+}
+
+// GetChannels returns the subscribed channels in order
+func (p *pubsubState) GetChannels() []string {
+	return sortedKeys(p.channels)
+}
+
+// GetPatterns returns the subscribed patterns in order
+func (p *pubsubState) GetPatterns() []string {
+	return sortedKeys(p.patterns)
+}
+
+// GetShardChannels returns the subscribed shard channels in order
+func (p *pubsubState) GetShardChannels() []string {
+	return sortedKeys(p.shardChannels)
+}
+
+// SubsCount is the number of channels and patterns subscribed, a connection is in subscriber
+// mode as long as it or ShardSubsCount is positive
+func (p *pubsubState) SubsCount() int {
+	return len(p.channels) + len(p.patterns)
+}
+
+// ShardSubsCount is the number of shard channels subscribed
+func (p *pubsubState) ShardSubsCount() int {
+	return len(p.shardChannels)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"godis/database"
+	"godis/pkg/logx"
+	"godis/resp/connection"
+	"godis/resp/parser"
+	"godis/resp/protocol"
+	"godis/tcp"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+var unknownErrReplyBytes = []byte("-ERR unknown\r\n")
+
+// RespHandler speaks RESP with clients and dispatches commands to a database engine
+type RespHandler struct {
+	db     database.Engine
+	closed atomic.Bool
+
+	connMap sync.Map // map[*connection.Conn]struct{}
+	once    sync.Once
+}
+
+func NewRespHandler(db database.Engine) tcp.Handler {
+	return &RespHandler{
+		db: db,
+	}
+}
+
+func (h *RespHandler) closeClient(client *connection.Conn) {
+	_ = client.Close()
+	h.db.AfterClientClose(client)
+	h.connMap.Delete(client)
+}
+
+func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
+	if h.closed.Load() {
+		_ = conn.Close()
+		return
+	}
+
+	client := connection.NewConn(conn)
+	h.connMap.Store(client, struct{}{})
+
+	ch := parser.ParseStream(client)
+	defer func() {
+		h.closeClient(client)
+		// 连接关闭后解析协程可能阻塞在发送上，排空channel让其退出
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+
+	for payload := range ch {
+		if payload.Err != nil {
+			if errors.Is(payload.Err, io.EOF) ||
+				errors.Is(payload.Err, io.ErrUnexpectedEOF) ||
+				errors.Is(payload.Err, net.ErrClosed) {
+				logx.L().Info("connection closed: " + client.RemoteAddr())
+				return
+			}
+			// 协议错误，回复后继续读取
+			errReply := protocol.NewProtocolErrReply(payload.Err.Error())
+			if _, err := client.Write(errReply.ToBytes()); err != nil {
+				logx.L().Warn(err)
+				return
+			}
+			continue
+		}
+		if payload.Data == nil {
+			continue
+		}
+
+		r, ok := payload.Data.(*protocol.MultiBulkReply)
+		if !ok {
+			logx.L().Error("require multi bulk protocol")
+			continue
+		}
+		if len(r.Values) == 0 {
+			continue
+		}
+
+		result := h.db.Exec(client, r.Values)
+		var err error
+		if result != nil {
+			_, err = client.Write(result.ToBytes())
+		} else {
+			_, err = client.Write(unknownErrReplyBytes)
+		}
+		if err != nil {
+			logx.L().Warn(err)
+			return
+		}
+	}
+}
+
+func (h *RespHandler) Close() error {
+	h.once.Do(func() {
+		logx.L().Info("handler shutting down...")
+		h.closed.Store(true)
+		wg := sync.WaitGroup{}
+		h.connMap.Range(func(key, value interface{}) bool {
+			client := key.(*connection.Conn)
+			wg.Add(1)
+			go func() {
+				_ = client.Close()
+				wg.Done()
+			}()
+			return true
+		})
+		wg.Wait()
+		h.db.Close()
+	})
+	return nil
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"godis/config"
+	"godis/database"
+	"godis/resp/connection"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRespHandler(t *testing.T) {
+	h := NewRespHandler(database.NewServer())
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		h.Handle(context.Background(), server)
+		close(done)
+	}()
+
+	reader := bufio.NewReader(client)
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"ping", "*1\r\n$4\r\nPING\r\n", "+PONG\r\n"},
+		{"ping with message", "*2\r\n$4\r\nping\r\n$2\r\nhi\r\n", "$2\r\nhi\r\n"},
+		{"echo binary", "*2\r\n$4\r\necho\r\n$4\r\na\r\nb\r\n", "$4\r\na\r\nb\r\n"},
+		{"unknown", "*1\r\n$3\r\nfoo\r\n", "-ERR unknown command 'foo'\r\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := client.Write([]byte(tc.input))
+			assert.NoError(t, err)
+			buf := make([]byte, len(tc.expected))
+			_, err = io.ReadFull(reader, buf)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(buf))
+		})
+	}
+
+	_ = client.Close()
+	<-done
+	assert.NoError(t, h.Close())
+}
+
+// 订阅者不读取消息时发布不会阻塞，输出缓冲区超过限制后断开订阅者并清理订阅
+func TestSlowSubscriber(t *testing.T) {
+	config.Properties.PubSubOutputBufferLimit = 1024
+	defer func() { config.Properties.PubSubOutputBufferLimit = 32 * 1024 * 1024 }()
+
+	db := database.NewServer()
+	h := NewRespHandler(db)
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		h.Handle(context.Background(), server)
+		close(done)
+	}()
+
+	reader := bufio.NewReader(client)
+	_, err := client.Write([]byte("*2\r\n$9\r\nsubscribe\r\n$2\r\nch\r\n"))
+	assert.NoError(t, err)
+	expected := "*3\r\n$9\r\nsubscribe\r\n$2\r\nch\r\n:1\r\n"
+	buf := make([]byte, len(expected))
+	_, err = io.ReadFull(reader, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(buf))
+
+	pub := connection.NewFakeConn()
+	message := []byte(strings.Repeat("x", 100))
+	for i := 0; i < 20; i++ {
+		reply := db.Exec(pub, [][]byte{[]byte("publish"), []byte("ch"), message})
+		assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow subscriber is not disconnected")
+	}
+	reply := db.Exec(pub, [][]byte{[]byte("pubsub"), []byte("numsub"), []byte("ch")})
+	assert.Equal(t, "*2\r\n$2\r\nch\r\n:0\r\n", string(reply.ToBytes()))
+	_ = client.Close()
+	assert.NoError(t, h.Close())
+}
@@ -0,0 +1,31 @@
+package protocol
+
+// NewArgNumErrReply 参数个数错误
+func NewArgNumErrReply(cmd string) *ErrReply {
+	return NewErrReply("ERR wrong number of arguments for '" + cmd + "' command")
+}
+
+// NewUnknownCommandErrReply 未知命令
+func NewUnknownCommandErrReply(cmd string) *ErrReply {
+	return NewErrReply("ERR unknown command '" + cmd + "'")
+}
+
+// NewSyntaxErrReply 语法错误
+func NewSyntaxErrReply() *ErrReply {
+	return NewErrReply("ERR syntax error")
+}
+
+// NewProtocolErrReply 协议错误
+func NewProtocolErrReply(msg string) *ErrReply {
+	return NewErrReply("ERR Protocol error: " + msg)
+}
+
+// NewNotIntegerErrReply 参数不是合法整数
+func NewNotIntegerErrReply() *ErrReply {
+	return NewErrReply("ERR value is not an integer or out of range")
+}
+
+// NewWrongTypeErrReply key对应的值类型与命令不匹配
+func NewWrongTypeErrReply() *ErrReply {
+	return NewErrReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+}
@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"bytes"
 	"errors"
 	"strconv"
 )
@@ -44,7 +45,8 @@ func (r *ErrReply) Error() error {
 }
 
 func IsErrorReply(reply Reply) bool {
-	return reply.ToBytes()[0] == '-'
+	b := reply.ToBytes()
+	return len(b) > 0 && b[0] == '-'
 }
 
 const nullBulkBytes = "$-1" + CRLF
@@ -79,7 +81,12 @@ func (r *BulkReply) ToBytes() []byte {
 	if len(r.Value) == 0 {
 		return []byte(emptyBulkBytes)
 	}
-	return []byte("$" + strconv.Itoa(len(r.Value)) + CRLF + string(r.Value) + CRLF)
+	buf := make([]byte, 0, len(r.Value)+16)
+	buf = append(buf, '$')
+	buf = strconv.AppendInt(buf, int64(len(r.Value)), 10)
+	buf = append(buf, CRLF...)
+	buf = append(buf, r.Value...)
+	return append(buf, CRLF...)
 }
 
 type IntReply struct {
@@ -118,9 +125,92 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	if r.Values == nil || len(r.Values) == 0 {
 		return emptyMultiBulkBytes
 	}
-	buf := "*" + strconv.Itoa(len(r.Values)) + CRLF
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Values)) + CRLF)
 	for _, value := range r.Values {
-		buf += "$" + strconv.Itoa(len(value)) + CRLF + string(value) + CRLF
+		// nil元素表示空值，例如MGET中不存在的key
+		if value == nil {
+			buf.WriteString(nullBulkBytes)
+			continue
+		}
+		buf.WriteString("$" + strconv.Itoa(len(value)) + CRLF)
+		buf.Write(value)
+		buf.WriteString(CRLF)
 	}
-	return []byte(buf)
+	return buf.Bytes()
+}
+
+func NewOkReply() *StatusReply {
+	return NewStatusReply("OK")
+}
+
+func NewQueuedReply() *StatusReply {
+	return NewStatusReply("QUEUED")
+}
+
+func NewPongReply() *StatusReply {
+	return NewStatusReply("PONG")
+}
+
+// ArrayReply 嵌套数组，元素可以是任意类型的回复
+type ArrayReply struct {
+	Replies []Reply
+}
+
+var nullArrayBytes = []byte("*-1" + CRLF)
+
+func NewArrayReply(replies []Reply) *ArrayReply {
+	return &ArrayReply{
+		Replies: replies,
+	}
+}
+
+func (r *ArrayReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Replies)) + CRLF)
+	for _, reply := range r.Replies {
+		buf.Write(reply.ToBytes())
+	}
+	return buf.Bytes()
+}
+
+// NullArrayReply 空数组 *-1
+type NullArrayReply struct{}
+
+func NewNullArrayReply() *NullArrayReply {
+	return &NullArrayReply{}
+}
+
+func (r *NullArrayReply) ToBytes() []byte {
+	return nullArrayBytes
+}
+
+// SequenceReply 依次发送的多个回复，例如SUBSCRIBE对每个频道各回复一次
+type SequenceReply struct {
+	Replies []Reply
+}
+
+func NewSequenceReply(replies []Reply) *SequenceReply {
+	return &SequenceReply{
+		Replies: replies,
+	}
+}
+
+func (r *SequenceReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	for _, reply := range r.Replies {
+		buf.Write(reply.ToBytes())
+	}
+	return buf.Bytes()
+}
+
+// NoReply 不写出任何内容，用于自行写出回复或不回复的命令，例如PSYNC和REPLCONF ACK
+type NoReply struct{}
+
+func NewNoReply() *NoReply {
+	return &NoReply{}
+}
+
+func (r *NoReply) ToBytes() []byte {
+	return nil
 }